@@ -2,32 +2,103 @@ package barrel
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+	"github.com/tidwall/btree"
 	"github.com/zerodha/logf"
 )
 
 const (
 	LOCKFILE   = "barrel.lock"
-	HINTS_FILE = "barrel.hints"
+	HINTS_FILE = "barrel_%d.hints"
 )
 
 type Barrel struct {
-	sync.Mutex
+	sync.RWMutex
 
 	lo      logf.Logger
 	bufPool sync.Pool // Pool of byte buffers used for writing.
 	opts    *Options
 
-	keydir KeyDir                     // In-memory hashmap of all active keys.
-	df     *datafile.DataFile         // Active datafile.
-	stale  map[int]*datafile.DataFile // Map of older datafiles with their IDs.
-	flockF *os.File                   //Lockfile to prevent multiple write access to same datafile.
+	keydir *keyDir                  // In-memory hashmap of all active keys.
+	sorted *btree.Set[string]       // Keys in lexicographic order, if maintained.
+	df     datafile.Storage         // Active datafile.
+	stale  map[int]datafile.Storage // Map of older datafiles with their IDs.
+	pool   *datafile.Pool           // Pool of open file descriptors of the older datafiles.
+	ring   *datafile.Ring           // Ring which the reads of GetMulti are submitted to, if enabled.
+	flockF *os.File                 //Lockfile to prevent multiple write access to same datafile.
+
+	activeHints *Hints                   // Hints for the records written in the active datafile.
+	merged      map[int]datafile.Storage // Merged datafiles till they're swapped in, whose keys are read by the compact keydir.
+
+	written atomic.Uint64 // Number of records written.
+	commit  *groupCommit  // Batches the fsync of concurrent writers.
+
+	cache     *valueCache   // Cache of the recently read records, if enabled.
+	keyMisses atomic.Uint64 // Number of lookups for keys which aren't present in the keydir.
+	oversized atomic.Uint64 // Number of writes rejected since the key or the value is too large.
+
+	quarantined map[string]Meta // Keys whose latest record is found to be corrupt.
+	corrupt     atomic.Uint64   // Number of corrupt records found while scrubbing.
+	healed      atomic.Uint64   // Number of keys healed from an older record.
+
+	liveBytes int               // Size of the latest records of all the keys in the keydir.
+	diskBytes int               // Size of all the datafiles, including the stale records.
+	unsynced  int               // Bytes written to the active datafile since it was last synced, for SyncEveryNBytes.
+	evicted   atomic.Uint64     // Number of keys evicted to stay within the max data size or the quotas.
+	accessed  map[string]uint64 // Logical time of the last access of each key, if evicting the LRU keys.
+	clock     uint64            // Logical time incremented on every access.
+
+	readers sync.RWMutex   // Held for reading by the reads of the older datafiles outside the barrel lock, and for writing while closing them.
+	touchMu sync.Mutex     // Guards the accesses pending to be recorded in the keydir.
+	touched []pendingTouch // Keys read under the read lock of the barrel, recorded in the keydir by the next touch.
+
+	quotas        map[string]*QuotaUsage // Usage of the quotas, by the prefixes of their namespaces.
+	quotaRejected atomic.Uint64          // Number of writes rejected since they exceed the quota of their namespace.
+
+	storageFull bool          // Whether the writes are paused since the disk is (almost) full.
+	compactNow  chan struct{} // Triggers a compaction before the next compaction interval.
+
+	appended chan struct{}            // Closed on every append to wake up the tailers waiting for new records.
+	tails    map[*tailCursor]struct{} // Positions of the tails following the log.
+
+	streams map[string][]StreamID // Sorted IDs of the entries of each stream.
+
+	indexes map[string]*index // Secondary indexes on the values, by their names.
+	tags    *index            // Keys of each tag.
+
+	expired      []expiredKey  // Keys purged since they expired, pending the expiry callback.
+	expiredReady chan struct{} // Signals the keys pending the expiry callback.
+
+	mirror  *mirror     // Mirrors the writes to a secondary target, if enabled.
+	changes *changeFeed // Publishes the changes to the change sink, if enabled.
+
+	progress compactProgress // Progress of the merge of the datafiles.
+	hotKeys  *hotKeys        // Accesses of the most accessed keys, if tracked.
+
+	usage      map[int]*segmentUsage // Size of the records and of the live records in each datafile.
+	timeRanges map[int]timeRange     // Time range of the records in each datafile, if known.
+	seqRanges  map[int]seqRange      // Sequence numbers of the records in each datafile, if known.
+	stats      map[int]segmentStats  // Number of the records and range of their keys in each datafile, if known.
+	seq        uint64                // Sequence number of the next record written.
+
+	versionBase uint64 // Version of the keys which aren't written since the keydir is loaded.
+	skewedKeys  int    // Number of keys whose expiry had passed as per the newest record on startup.
+
+	done      chan struct{}  // Closed on shutdown to stop the background goroutines.
+	workers   sync.WaitGroup // Background goroutines spawned by Init.
+	closeOnce sync.Once      // Ensures the barrel is shut down only once.
+	closeErr  error          // Error returned by the shutdown.
 }
 
 // initLogger initializes logger instance.
@@ -53,37 +124,78 @@ func Init(cfg ...Config) (*Barrel, error) {
 		lo     = initLogger(opts.debug)
 		index  = 0
 		flockF *os.File
-		stale  = map[int]*datafile.DataFile{}
+		stale  = map[int]datafile.Storage{}
+		pool   = datafile.NewPool(opts.maxOpenFiles, opts.mmapReads)
 	)
 
-	// Load existing datafiles
-	files, err := getDataFiles(opts.dir)
-	if err != nil {
-		return nil, fmt.Errorf("error loading data files: %w", err)
+	// Guard the timestamps and the expiry against the jumps of the system clock, unless it's replaced.
+	if _, ok := opts.clock.(systemClock); ok && opts.maxClockSkew > 0 {
+		opts.clock = newGuardedClock(lo, opts.clock, opts.maxClockSkew)
 	}
 
-	if len(files) > 0 {
-		// Get the existing ids.
-		ids, err := getIDs(files)
+	// Keep the datafiles in a new temporary directory in memory.
+	if opts.inMemory {
+		if opts.readOnly {
+			return nil, errors.New("in-memory mode cannot be read-only")
+		}
+		dir, err := os.MkdirTemp(memDir(), "barrel-")
 		if err != nil {
-			return nil, fmt.Errorf("error parsing ids for existing files: %w", err)
+			return nil, fmt.Errorf("error creating in-memory directory: %w", err)
 		}
+		opts.dir = dir
+	}
 
-		// Increment the index to write to a new datafile.
-		index = ids[len(ids)-1] + 1
+	// Load the manifest, which lists the live datafiles if present.
+	man, err := loadManifest(opts.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading manifest: %w", err)
+	}
 
-		// Add all older datafiles to the list of stale files.
+	if man != nil {
+		// The manifest is the authoritative list of the live datafiles, so the directory isn't listed.
+		// These are opened lazily when they're read for the first time.
+		for _, seg := range man.Segments {
+			df, err := openListed(opts, seg.ID, pool)
+			if err != nil {
+				return nil, err
+			}
+			stale[seg.ID] = df
+		}
+		index = man.lastID() + 1
+	} else {
+		// Directories without a manifest have all the datafiles in them live.
+		ids, err := listIDs(opts.dir)
+		if err != nil {
+			return nil, err
+		}
 		for _, idx := range ids {
-			df, err := datafile.New(opts.dir, idx)
+			df, err := opts.backend.Open(opts.dir, idx, pool)
 			if err != nil {
 				return nil, err
 			}
 			stale[idx] = df
 		}
+		if len(ids) > 0 {
+			// Increment the index to write to a new datafile.
+			index = ids[len(ids)-1] + 1
+		}
+	}
+
+	// Add the datafiles written by Bitcask to the older datafiles as well.
+	if opts.bitcaskCompat {
+		if err := openBitcaskFiles(opts.dir, stale, pool, man); err != nil {
+			return nil, fmt.Errorf("error loading bitcask files: %w", err)
+		}
+		for id := range stale {
+			if id >= index {
+				index = id + 1
+			}
+		}
 	}
 
 	// If not running in a read only mode then create a lockfile to ensure only one process writes to the db directory.
-	if !opts.readOnly {
+	// The in-memory directory isn't known to the other processes, so it doesn't need one.
+	if !opts.readOnly && !opts.inMemory {
 		// Check if a lockfile already exists.
 		lockPath := filepath.Join(opts.dir, LOCKFILE)
 		if exists(lockPath) {
@@ -94,22 +206,60 @@ func Init(cfg ...Config) (*Barrel, error) {
 				return nil, fmt.Errorf("error creating lockfile: %w", err)
 			}
 		}
+
+		// Finish deleting the files dropped by an interrupted DropAll.
+		if err := removeDroppedFiles(opts.dir); err != nil {
+			return nil, fmt.Errorf("error removing dropped files: %w", err)
+		}
+
+		// Remove the datafiles left over by an interrupted merge or cleanup.
+		if err := removeMergeDirs(opts.dir); err != nil {
+			return nil, fmt.Errorf("error removing merge directories: %w", err)
+		}
+		if err := removeIndexFiles(opts.dir); err != nil {
+			return nil, fmt.Errorf("error removing index files: %w", err)
+		}
+		if man != nil {
+			ids, err := listIDs(opts.dir)
+			if err != nil {
+				return nil, err
+			}
+			if err := removeUnlisted(opts.dir, man, ids); err != nil {
+				return nil, fmt.Errorf("error removing datafiles not listed in manifest: %w", err)
+			}
+		}
 	}
 
-	// Initialise a db store.
-	df, err := datafile.New(opts.dir, index)
-	if err != nil {
-		return nil, err
+	// Initialise a db store. It isn't created in the read-only mode, so that the directory isn't written to.
+	var df datafile.Storage
+	if !opts.readOnly {
+		df, err = opts.backend.Create(opts.dir, index)
+		if err != nil {
+			return nil, err
+		}
+		if err := df.WriteSegmentHeader(recordVersion); err != nil {
+			return nil, err
+		}
+		df.SetWriteBuffer(opts.writeBufferSize)
+		if opts.preallocate {
+			if err := df.Preallocate(opts.maxActiveFileSize); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Initialise an empty keydir.
-	keydir := make(KeyDir, 0)
+	// Populate the hashtable from the hints of each older datafile.
+	keydir, tags, err := loadKeyDir(lo, opts.dir, stale, !opts.readOnly && !opts.inMemory, opts.loadConcurrency, opts.compactKeydir)
+	if err != nil {
+		return nil, fmt.Errorf("error populating hashtable from hints file: %w", err)
+	}
 
-	// Check if a hints file already exists and then use that to populate the hashtable.
-	hintsPath := filepath.Join(opts.dir, HINTS_FILE)
-	if exists(hintsPath) {
-		if err := keydir.Decode(hintsPath); err != nil {
-			return nil, fmt.Errorf("error populating hashtable from hints file: %w", err)
+	// In the read-only mode, the latest datafile is used as the active datafile instead.
+	if opts.readOnly {
+		df = datafile.Empty(opts.dir, index)
+		if ids := sortedIDs(stale); len(ids) > 0 {
+			df = stale[ids[len(ids)-1]]
+			delete(stale, df.ID())
 		}
 	}
 
@@ -119,32 +269,225 @@ func Init(cfg ...Config) (*Barrel, error) {
 		lo:     lo,
 		df:     df,
 		stale:  stale,
+		pool:   pool,
 		flockF: flockF,
-		keydir: keydir,
+
+		activeHints:  newHints(df.ID()),
+		commit:       newGroupCommit(),
+		compactNow:   make(chan struct{}, 1),
+		expiredReady: make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		quarantined:  make(map[string]Meta),
+		tails:        make(map[*tailCursor]struct{}),
+
+		timeRanges: make(map[int]timeRange),
+		seqRanges:  make(map[int]seqRange),
+		stats:      make(map[int]segmentStats),
 		bufPool: sync.Pool{New: func() any {
 			return bytes.NewBuffer([]byte{})
 		}},
 	}
 
-	// Spawn a goroutine which runs in background and compacts all datafiles in a new single datafile.
-	go barrel.RunCompaction(opts.compactInterval)
+	barrel.quotas = make(map[string]*QuotaUsage, len(opts.quotas))
+	for _, q := range opts.quotas {
+		barrel.quotas[q.Prefix] = &QuotaUsage{Quota: q}
+	}
+	barrel.setKeyDir(keydir, tags)
+
+	// Restore the ranges of the datafiles, and list the new active datafile in the manifest before writing to it.
+	if man != nil {
+		if err := barrel.loadSegments(man); err != nil {
+			return nil, fmt.Errorf("error loading manifest: %w", err)
+		}
+	}
+	barrel.versionBase = barrel.seq
+	if !opts.readOnly {
+		if err := barrel.saveManifest(); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range stale {
+		size, err := d.Size()
+		if err != nil {
+			return nil, err
+		}
+		barrel.diskBytes += int(size)
+	}
+	if size, err := df.Size(); err == nil {
+		barrel.diskBytes += int(size)
+	}
+	if err := barrel.loadUsage(); err != nil {
+		return nil, err
+	}
+	barrel.checkClockSkew()
+	if opts.maxDataSize > 0 && opts.evictionPolicy == AllKeysLRU {
+		barrel.accessed = make(map[string]uint64)
+	}
+
+	// Track the accesses of the keys for reporting the hot keys.
+	if opts.hotKeysSample > 0 {
+		barrel.hotKeys = newHotKeys(opts.hotKeysSample, opts.hotKeysCapacity)
+	}
+
+	// Initialise the cache for the recently read values.
+	if opts.valueCacheSize > 0 {
+		barrel.cache = newValueCache(opts.valueCacheSize)
+	}
+
+	// Validate the checksums of all the older records before serving the reads.
+	if opts.verifyOnStartup {
+		for _, id := range sortedIDs(stale) {
+			if _, err := barrel.scrubDF(stale[id], 0); err != nil {
+				return nil, fmt.Errorf("error verifying datafile %d: %w", id, err)
+			}
+		}
+	}
+
+	// Build the index of stream entries.
+	barrel.loadStreams()
+
+	// Build the secondary indexes from the values of all the keys.
+	barrel.buildIndexes()
+
+	// Merge the datafiles before serving the reads and writes if enough of the data is stale.
+	if opts.compactOnStartup && !opts.readOnly {
+		if err := barrel.compactOnStartup(); err != nil {
+			return nil, fmt.Errorf("error compacting on startup: %w", err)
+		}
+	}
+
+	// Spill the entries of the older datafiles if the keydir is larger than the max index memory.
+	barrel.spillKeydir()
+
+	// Start with the writes paused if the disk is already low on space.
+	if opts.minFreeDisk > 0 {
+		if err := barrel.checkDiskSpace(); err != nil {
+			return nil, fmt.Errorf("error checking free disk space: %w", err)
+		}
+	}
+
+	// Resume publishing the changes after the ones acknowledged by the change sink.
+	if opts.changeSink != nil && !opts.readOnly {
+		if barrel.changes, err = newChangeFeed(opts.changeSink, opts.dir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Batch the reads of GetMulti with io_uring, falling back to reading them in parallel if it isn't available.
+	if opts.ioUring {
+		if barrel.ring, err = datafile.NewRing(defaultIOUringEntries); err != nil {
+			lo.Error("error setting up io_uring, reading in parallel instead", "error", err)
+		}
+	}
+
+	// Spawn a goroutine which validates the checksums of the older records periodically.
+	if barrel.opts.scrubInterval > 0 {
+		barrel.spawn(func() { barrel.Scrub(barrel.opts.scrubInterval, barrel.opts.scrubRate) })
+	}
+
+	// The other goroutines only act on the writes, which aren't allowed in the read-only mode.
+	if opts.readOnly {
+		return barrel, nil
+	}
+
+	// The maintenance is run by the embedder with Maintain instead.
+	if !opts.manualMaintenance {
+		// Spawn a goroutine which runs in background and compacts all datafiles in a new single datafile.
+		barrel.spawn(func() { barrel.RunCompaction(opts.compactInterval) })
+
+		// Spawn a goroutine which checks for the file size of the active file at periodic interval.
+		barrel.spawn(func() { barrel.ExamineFileSize(barrel.opts.checkFileSizeInterval) })
+
+		// Spawn a goroutine which flushes the write buffer to the active file periodically.
+		if barrel.opts.writeBufferSize > 0 {
+			barrel.spawn(func() { barrel.FlushBuffer(defaultFlushInterval) })
+		}
+
+		// Spawn a goroutine which flushes the file to disk periodically.
+		if barrel.opts.syncInterval != nil {
+			barrel.spawn(func() { barrel.SyncFile(*opts.syncInterval) })
+		}
+	}
+
+	// Spawn a goroutine which mirrors the writes to the secondary target.
+	if barrel.opts.mirrorTarget != nil {
+		barrel.mirror = newMirror(barrel.opts.mirrorTarget, barrel.opts.mirrorQueueSize, barrel.seq)
+		barrel.spawn(barrel.RunMirror)
+	}
 
-	// Spawn a goroutine which checks for the file size of the active file at periodic interval.
-	go barrel.ExamineFileSize(barrel.opts.checkFileSizeInterval)
+	// Spawn a goroutine which publishes the changes to the change sink.
+	if barrel.changes != nil {
+		barrel.spawn(barrel.RunChangeFeed)
+	}
 
-	// Spawn a goroutine which flushes the file to disk periodically.
-	if barrel.opts.syncInterval != nil {
-		go barrel.SyncFile(*opts.syncInterval)
+	// Spawn a goroutine which calls the expiry callback for the purged keys.
+	if barrel.opts.expiryCallback != nil {
+		barrel.spawn(barrel.RunExpiryCallbacks)
 	}
 
 	return barrel, nil
 }
 
-// Shutdown closes all the open file descriptors and removes any file locks.
+// spawn runs the function in a background goroutine, which is waited for on shutdown.
+// The function should return once the done channel is closed.
+func (b *Barrel) spawn(fn func()) {
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		fn()
+	}()
+}
+
+// withShutdown returns a context which is cancelled on shutdown as well as when the given context is done.
+func (b *Barrel) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-b.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// interrupted returns errInterrupted if the error is due to the cancellation of a context on shutdown.
+func (b *Barrel) interrupted(err error) error {
+	select {
+	case <-b.done:
+		if errors.Is(err, context.Canceled) {
+			return errInterrupted
+		}
+	default:
+	}
+	return err
+}
+
+// Shutdown stops the background goroutines, closes all the open file descriptors and removes any file locks.
 // If non running in a read-only mode, it's essential to call close so that it
 // removes any file locks on the database directory. Not calling close will prevent
 // future startups until it's removed manually.
+// The writes still queued for the mirror and the pending expiry callbacks are dropped.
+// It can be called more than once, and returns the error of the first call.
 func (b *Barrel) Shutdown() error {
+	b.closeOnce.Do(func() {
+		b.closeErr = b.shutdown()
+	})
+	return b.closeErr
+}
+
+// Close is an alias of Shutdown.
+func (b *Barrel) Close() error {
+	return b.Shutdown()
+}
+
+// shutdown stops the background goroutines and closes the datafiles.
+func (b *Barrel) shutdown() error {
+	// Wait for the goroutines to stop before closing the files used by them.
+	// The lock isn't held since they may be waiting for it.
+	close(b.done)
+	b.workers.Wait()
+
 	b.Lock()
 	defer b.Unlock()
 
@@ -154,28 +497,57 @@ func (b *Barrel) Shutdown() error {
 		return err
 	}
 
+	// Remove the index files of the entries spilled from the keydir, which is loaded from the hints on startup.
+	b.keydir.close()
+
 	// Close all active file handlers.
 	if err := b.df.Close(); err != nil {
 		b.lo.Error("error closing active db file", "error", err, "id", b.df.ID())
 		return err
 	}
 
-	// Close all stale datafiles as well.
+	// Close all stale datafiles as well, once the reads outside the barrel lock are over.
+	b.readers.Lock()
+	defer b.readers.Unlock()
 	for _, df := range b.stale {
 		if err := df.Close(); err != nil {
 			b.lo.Error("error closing active db file", "error", err, "id", df.ID())
 			return err
 		}
 	}
+	if b.ring != nil {
+		if err := b.ring.Close(); err != nil {
+			b.lo.Error("error closing io_uring", "error", err)
+			return err
+		}
+	}
 
-	// Cleanup the lock file.
+	// Record the final ranges of the active datafile, after all its records are flushed, so that it isn't scanned on startup.
 	if !b.opts.readOnly {
+		m := b.newManifest(append(sortedIDs(b.stale), b.df.ID()))
+		m.Clean = true
+		if err := writeManifest(b.opts.dir, m); err != nil {
+			b.lo.Error("error writing manifest", "error", err)
+			return err
+		}
+	}
+
+	// Cleanup the lock file.
+	if !b.opts.readOnly && !b.opts.inMemory {
 		if err := destroyFlockFile(b.flockF); err != nil {
 			b.lo.Error("error destroying lock file", "error", err)
 			return err
 		}
 	}
 
+	// Drop the datafiles of the in-memory mode.
+	if b.opts.inMemory {
+		if err := os.RemoveAll(b.opts.dir); err != nil {
+			b.lo.Error("error removing in-memory directory", "error", err)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -183,106 +555,530 @@ func (b *Barrel) Shutdown() error {
 // It also stores the key with some metadata in memory.
 // This metadata helps for faster reads as the last position of the file is recorded so only
 // a single disk seek is required to read value.
-func (b *Barrel) Put(k string, val []byte) error {
+func (b *Barrel) Put(k string, val []byte) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
 	b.Lock()
 	defer b.Unlock()
 
-	if b.opts.readOnly {
-		return ErrReadOnly
+	if err := b.checkWritable(); err != nil {
+		return err
 	}
 
 	// Validate key and value.
-	if err := validateKV(k, val); err != nil {
+	if err = b.validateKV(k, val); err != nil {
 		return err
 	}
 
 	b.lo.Debug("storing data", "key", k, "val", val)
-	return b.put(b.df, k, val, nil)
+	return b.hookedPut(k, val, nil, nil)
 }
 
 // PutEx is same as Put but also takes an additional expiry time.
-func (b *Barrel) PutEx(k string, val []byte, ex time.Duration) error {
+func (b *Barrel) PutEx(k string, val []byte, ex time.Duration) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
 	b.Lock()
 	defer b.Unlock()
 
-	if b.opts.readOnly {
-		return ErrReadOnly
+	if err := b.checkWritable(); err != nil {
+		return err
 	}
 
 	// Validate key and value.
-	if err := validateKV(k, val); err != nil {
+	if err = b.validateKV(k, val); err != nil {
 		return err
 	}
 
 	// Add the expiry to the current time.
-	expiry := time.Now().Add(ex)
+	expiry := b.now().Add(ex)
 
 	b.lo.Debug("storing data with expiry", "key", k, "val", val, "expiry", ex.String())
-	return b.put(b.df, k, val, &expiry)
+	return b.hookedPut(k, val, nil, &expiry)
+}
+
+// PutExAt is same as PutEx but takes the absolute time at which the key expires, like `EXPIREAT` of Redis.
+// Expiries are stored as Unix timestamps in seconds, so the time is truncated to the second.
+// The key expires right away if the time has already passed.
+func (b *Barrel) PutExAt(k string, val []byte, at time.Time) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate key, value and expiry.
+	if err = b.validateKV(k, val); err != nil {
+		return err
+	}
+	if !validExpiry(at) {
+		return ErrInvalidExpiry
+	}
+
+	b.lo.Debug("storing data with expiry", "key", k, "val", val, "expiry", at.String())
+	return b.hookedPut(k, val, nil, &at)
+}
+
+// validExpiry returns true if the absolute expiry fits the expiry in the header of the records.
+func validExpiry(at time.Time) bool {
+	return at.Unix() > 0 && at.Unix() <= math.MaxUint32
+}
+
+// PutWithMeta is same as Put but also attaches the given user-defined metadata to the record,
+// which is returned by GetWithMeta. The metadata can be upto MaxMetaSize bytes.
+func (b *Barrel) PutWithMeta(k string, val []byte, meta []byte) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate key, value and metadata.
+	if err = b.validateKV(k, val); err != nil {
+		return err
+	}
+	if len(meta) > MaxMetaSize {
+		b.oversized.Add(1)
+		return fmt.Errorf("%w: %d bytes", ErrLargeMeta, len(meta))
+	}
+
+	b.lo.Debug("storing data with metadata", "key", k, "val", val, "meta", meta)
+	return b.hookedPut(k, val, encodeMeta(meta, nil), nil)
 }
 
 // Get takes a key and finds the metadata in the in-memory hashtable (Keydir).
 // Using the offset present in metadata it finds the record in the datafile with a single disk seek.
 // It further decodes the record and returns the value as a byte array for the given key.
+// The disk read doesn't block the writes, see readRecord.
 func (b *Barrel) Get(k string) ([]byte, error) {
-	b.Lock()
-	defer b.Unlock()
-
 	b.lo.Debug("fetching data", "key", k)
-	record, err := b.get(k)
+	record, err := b.readRecord(k, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// If expired, then don't return any result.
-	if record.isExpired() {
-		return nil, ErrExpiredKey
+	return record.Value, nil
+}
+
+// GetWithMeta is same as Get but also returns the user-defined metadata of the record,
+// which is nil if the record doesn't have any.
+func (b *Barrel) GetWithMeta(k string) ([]byte, []byte, error) {
+	b.lo.Debug("fetching data with metadata", "key", k)
+	record, err := b.readRecord(k, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return record.Value, record.Meta, nil
+}
+
+// getRecord reads the latest record of the key, healing it if it's corrupt, and
+// validates that it's neither expired nor corrupt.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) getRecord(k string) (Record, error) {
+	record, err := b.get(k)
+	if b.opts.autoHeal && isCorrupt(record, err) {
+		if healed, ok := b.healCorrupt(k); ok {
+			record, err = healed, nil
+		}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	b.touch(k)
+	b.recordAccess(k, false)
+
+	// If expired, then don't return any result and delete the key without waiting for the compaction.
+	if record.isExpired(b.now()) {
+		if !b.opts.readOnly {
+			if err := b.purgeExpired(k, record.Value); err != nil {
+				b.lo.Error("error deleting expired key", "key", k, "error", err)
+			}
+		}
+		return Record{}, ErrExpiredKey
 	}
 
 	// If invalid checksum, return error.
 	if !record.isValidChecksum() {
-		return nil, ErrChecksumMismatch
+		return Record{}, ErrChecksumMismatch
 	}
 
-	return record.Value, nil
+	return record, nil
+}
+
+// readRecord is same as getRecord but doesn't block the writes while reading the record.
+// The keydir is looked up under the read lock and the older datafiles are read outside the barrel lock,
+// since records are never modified once written. The active datafile is read under the read lock since
+// it's being written to. The reads which modify the barrel, to purge an expired key or to heal a corrupt
+// record, are retried under the write lock.
+// Given a ref, the record is read into it, as read does. The reserved keys are missing for the readers.
+func (b *Barrel) readRecord(k string, ref *ValueRef) (Record, error) {
+	if isReserved(k) {
+		return Record{}, ErrKeyNotFound
+	}
+	b.RLock()
+	meta, ok, err := b.keydir.lookup(k)
+	if err != nil {
+		b.RUnlock()
+		return Record{}, err
+	}
+	if !ok || meta.FileID == b.df.ID() {
+		record, err := b.getInto(k, ref)
+		b.RUnlock()
+		return b.checkRead(k, record, err)
+	}
+	df, err := b.reader(meta.FileID)
+	if err != nil {
+		b.RUnlock()
+		return Record{}, err
+	}
+
+	// The datafile isn't closed by a merge until the read is over.
+	b.readers.RLock()
+	b.RUnlock()
+	record, err := b.read(k, df, meta, ref)
+	b.readers.RUnlock()
+
+	return b.checkRead(k, record, err)
+}
+
+// checkRead validates the record read without the write lock, like getRecord.
+func (b *Barrel) checkRead(k string, record Record, err error) (Record, error) {
+	if (b.opts.autoHeal && isCorrupt(record, err)) || (err == nil && record.isExpired(b.now()) && !b.opts.readOnly) {
+		b.Lock()
+		defer b.Unlock()
+		return b.getRecord(k)
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	if b.touchLater(k) {
+		b.Lock()
+		b.applyTouches()
+		b.Unlock()
+	}
+	b.recordAccess(k, false)
+
+	if record.isExpired(b.now()) {
+		return Record{}, ErrExpiredKey
+	}
+	if !record.isValidChecksum() {
+		return Record{}, ErrChecksumMismatch
+	}
+
+	return record, nil
+}
+
+// GetMulti returns the values for the given keys in the same order. The value is nil
+// for the keys which are either deleted or expired or unset. The records are read
+// from the datafiles in parallel, which helps when they aren't present in the page cache.
+// Like Get, the older datafiles are read without blocking the writes, and the records are
+// submitted to an io_uring at once instead if it's enabled with WithIOUring.
+func (b *Barrel) GetMulti(keys []string) ([][]byte, error) {
+	b.lo.Debug("fetching multiple keys", "count", len(keys))
+
+	// Look up the keys and read the records of the active datafile under the read lock, like readRecord.
+	reads := make([]multiRead, len(keys))
+	b.RLock()
+	for pos, k := range keys {
+		r := &reads[pos]
+		if isReserved(k) {
+			r.err = ErrKeyNotFound
+			continue
+		}
+		if r.meta, r.found, r.err = b.keydir.lookup(k); r.err != nil {
+			continue
+		}
+		if !r.found || r.meta.FileID == b.df.ID() {
+			r.record, r.err = b.getInto(k, nil)
+			continue
+		}
+		r.df, r.err = b.reader(r.meta.FileID)
+	}
+
+	// The older datafiles aren't closed by a merge until the reads are over.
+	b.readers.RLock()
+	b.RUnlock()
+	if b.ring != nil {
+		b.readBatch(keys, reads)
+	} else {
+		b.readParallel(keys, reads)
+	}
+	b.readers.RUnlock()
+
+	var (
+		vals  = make([][]byte, len(keys))
+		apply bool
+	)
+	for pos, r := range reads {
+		record, err := r.record, r.err
+		if err == nil && !record.isValidChecksum() {
+			err = ErrChecksumMismatch
+		}
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if b.opts.autoHeal && errors.Is(err, ErrChecksumMismatch) {
+			b.Lock()
+			healed, ok := b.healCorrupt(keys[pos])
+			b.Unlock()
+			if ok {
+				record, err = healed, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.isExpired(b.now()) {
+			continue
+		}
+
+		vals[pos] = record.Value
+		if b.touchLater(keys[pos]) {
+			apply = true
+		}
+		b.recordAccess(keys[pos], false)
+	}
+	if apply {
+		b.Lock()
+		b.applyTouches()
+		b.Unlock()
+	}
+
+	return vals, nil
+}
+
+// multiRead is the read of a key by GetMulti.
+type multiRead struct {
+	meta   Meta
+	found  bool
+	df     datafile.Storage // Older datafile which the record is read from, if it isn't read yet.
+	record Record
+	err    error
+}
+
+// pending returns true if the record is to be read from an older datafile.
+func (r *multiRead) pending() bool {
+	return r.df != nil && r.err == nil
+}
+
+// readParallel reads the pending records of the keys with a pool of goroutines.
+// It doesn't access the barrel except for the cache, so it's safe to call without the barrel lock.
+func (b *Barrel) readParallel(keys []string, reads []multiRead) {
+	var (
+		jobs = make(chan int)
+		wg   sync.WaitGroup
+	)
+
+	workers := defaultReadConcurrency
+	if len(keys) < workers {
+		workers = len(keys)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pos := range jobs {
+				reads[pos].record, reads[pos].err = b.read(keys[pos], reads[pos].df, reads[pos].meta, nil)
+			}
+		}()
+	}
+	for pos := range reads {
+		if reads[pos].pending() {
+			jobs <- pos
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// readBatch reads the pending records of the keys with the ring, except the ones which are cached.
+// It doesn't access the barrel except for the cache, so it's safe to call without the barrel lock.
+func (b *Barrel) readBatch(keys []string, reads []multiRead) {
+	var (
+		batch = make([]datafile.BatchRead, 0, len(reads))
+		index = make([]int, 0, len(reads)) // Position of the key of each read of the batch.
+	)
+	for pos := range reads {
+		r := &reads[pos]
+		if !r.pending() {
+			continue
+		}
+		if data, ok := b.cachedRecord(r.meta); ok {
+			r.record, r.err = decodeRecord(keys[pos], data, r.df.Version())
+			continue
+		}
+		batch = append(batch, datafile.BatchRead{DF: r.df, Buf: make([]byte, r.meta.RecordSize), Pos: r.meta.RecordPos})
+		index = append(index, pos)
+	}
+
+	b.ring.ReadBatch(batch)
+
+	for i, read := range batch {
+		r := &reads[index[i]]
+		if read.Err != nil {
+			r.err = fmt.Errorf("error reading data from file: %w", read.Err)
+			continue
+		}
+		if b.cache != nil {
+			b.cache.add(cacheKey{fileID: r.meta.FileID, pos: r.meta.RecordPos}, read.Data)
+		}
+		r.record, r.err = decodeRecord(keys[index[i]], read.Data, r.df.Version())
+	}
 }
 
 // Delete creates a tombstone record for the given key. The tombstone value is simply an empty byte array.
 // Actual deletes happen in background when merge is called.
 // Since the file is opened in append-only mode, the new value of the key
 // is overwritten both on disk and in memory as a tombstone record.
-func (b *Barrel) Delete(k string) error {
+func (b *Barrel) Delete(k string) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
 	b.Lock()
 	defer b.Unlock()
 
-	if b.opts.readOnly {
-		return ErrReadOnly
+	if err := b.checkWritable(); err != nil {
+		return err
 	}
 
 	b.lo.Debug("deleting key", "key", k)
-	return b.delete(k)
+	return b.hookedDelete(k)
 }
 
-// List iterates over all keys and returns the list of keys.
-func (b *Barrel) List() []string {
+// PutMulti stores the values of the keys atomically, writing all their records with a single append,
+// which is faster than a Put per key for bulk loads. The keys are stored only if all of them are valid.
+// If quotas or the max data size are set, the keys are stored one by one instead, so that each of them
+// is checked against the quotas or evicts other keys like Put, and the keys before the one which is
+// rejected stay stored.
+func (b *Barrel) PutMulti(kvs map[string][]byte) (err error) {
+	// Wait for the writes to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
 	b.Lock()
 	defer b.Unlock()
 
-	keys := make([]string, 0, len(b.keydir))
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+
+	b.lo.Debug("storing multiple keys", "count", len(kvs))
 
-	for k := range b.keydir {
+	// Append the records in the order of the keys, so that the datafile is the same for the same keys.
+	keys := make([]string, 0, len(kvs))
+	for k, val := range kvs {
+		if err := b.validateKV(k, val); err != nil {
+			return err
+		}
 		keys = append(keys, k)
 	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
 
-	return keys
+	if len(b.quotas) > 0 || b.opts.maxDataSize > 0 {
+		for _, k := range keys {
+			if err := b.hookedPut(k, kvs[k], nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	vals := make([][]byte, len(keys))
+	for i, k := range keys {
+		vals[i] = kvs[k]
+	}
+	return b.hookedPutMulti(keys, vals)
 }
 
-// Len iterates over all keys and returns the total number of keys.
-func (b *Barrel) Len() int {
+// DeleteMulti deletes the keys atomically, writing all their tombstones with a single append,
+// and returns the number of keys which were present. Keys which aren't present are skipped,
+// and the expired keys which aren't cleaned up yet are deleted but not counted.
+func (b *Barrel) DeleteMulti(keys []string) (n int, err error) {
+	// Wait for the writes to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
 	b.Lock()
 	defer b.Unlock()
 
-	return len(b.keydir)
+	if err := b.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	b.lo.Debug("deleting multiple keys", "count", len(keys))
+
+	var (
+		now     = int(b.now().Unix())
+		present = make([]string, 0, len(keys))
+		seen    = make(map[string]bool, len(keys))
+	)
+	for _, k := range keys {
+		if isReserved(k) {
+			return 0, ErrReservedKey
+		}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		// Delete the quarantined keys as well, like Delete.
+		meta, ok, err := b.keydir.lookup(k)
+		if err != nil {
+			return 0, err
+		}
+		if _, corrupt := b.quarantined[k]; !ok && !corrupt {
+			continue
+		}
+		present = append(present, k)
+		if ok && (meta.Expiry == 0 || now <= meta.Expiry) {
+			n++
+		}
+	}
+	if len(present) == 0 {
+		return 0, nil
+	}
+
+	if err := b.hookedDeleteMulti(present); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// List iterates over all keys and returns the list of keys.
+// The keys are copied under the read lock, so the reads aren't blocked and the writes
+// are blocked only while copying.
+func (b *Barrel) List() []string {
+	b.RLock()
+	defer b.RUnlock()
+
+	keys := make([]string, 0, b.keydir.keyspaceLen())
+
+	b.eachKey(func(k string, _ Meta) bool {
+		keys = append(keys, k)
+		return true
+	})
+
+	return keys
+}
+
+// Len returns the total number of keys.
+func (b *Barrel) Len() int {
+	b.RLock()
+	defer b.RUnlock()
+
+	return b.keydir.keyspaceLen()
 }
 
 // Fold iterates over all keys and calls the given function for each key.
@@ -291,18 +1087,118 @@ func (b *Barrel) Fold(fn func(k string) error) error {
 	defer b.Unlock()
 
 	// Call fn for each key.
-	for k := range b.keydir {
-		if err := fn(k); err != nil {
+	var err error
+	b.eachKey(func(k string, _ Meta) bool {
+		err = fn(k)
+		return err == nil
+	})
+	return err
+}
+
+// Reload drops the keydir and rebuilds it from the hints and the records of all the datafiles,
+// like `DEBUG RELOAD` of Redis, which helps to verify that they're consistent with the keydir.
+// The secondary indexes, the streams and the quarantined keys are rebuilt as well.
+func (b *Barrel) Reload() error {
+	b.Lock()
+	defer b.Unlock()
+
+	// Persist the hints of the active datafile, so that they aren't rebuilt from its records.
+	if !b.opts.readOnly {
+		if err := b.generateHints(); err != nil {
 			return err
 		}
 	}
+
+	dfs := make(map[int]datafile.Storage, len(b.stale)+1)
+	for id, df := range b.stale {
+		dfs[id] = df
+	}
+	dfs[b.df.ID()] = b.df
+
+	keydir, tags, err := loadKeyDir(b.lo, b.opts.dir, dfs, !b.opts.readOnly && !b.opts.inMemory, b.opts.loadConcurrency, b.opts.compactKeydir)
+	if err != nil {
+		return fmt.Errorf("error populating hashtable from hints file: %w", err)
+	}
+
+	b.lo.Info("reloaded keydir", "keys", keydir.len(), "datafiles", len(dfs))
+	b.setKeyDir(keydir, tags)
+	b.spillKeydir()
+	b.versionBase = b.seq
+	if err := b.loadUsage(); err != nil {
+		return err
+	}
+	b.quarantined = make(map[string]Meta)
+	if b.accessed != nil {
+		b.accessed = make(map[string]uint64)
+	}
+	if b.cache != nil {
+		b.cache.reset()
+	}
+	b.loadStreams()
+	b.buildIndexes()
+
 	return nil
 }
 
+// newKeyDir returns an empty keydir, which is in the compact mode if it's enabled.
+func (b *Barrel) newKeyDir() *keyDir {
+	keydir := newKeyDir(0)
+	if b.opts.compactKeydir {
+		keydir = newCompactKeyDir(0, b.loadKey)
+	}
+	keydir.load = b.loadKey
+	keydir.onError = b.keydirError
+	return keydir
+}
+
+// keydirError logs an error reading the keys or the entries spilled from the keydir, which isn't returned.
+func (b *Barrel) keydirError(err error) {
+	b.lo.Error("error reading keydir", "error", err)
+}
+
+// setKeyDir replaces the keydir and the tags of the keys, and accounts the size of the live data.
+// The index files of the replaced keydir are removed.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) setKeyDir(keydir *keyDir, tags map[string][]string) {
+	if b.keydir != nil {
+		b.keydir.close()
+	}
+	keydir.load = b.loadKey
+	keydir.onError = b.keydirError
+	b.keydir = keydir
+	b.tags = newIndex(nil)
+	b.liveBytes = 0
+	for _, u := range b.quotas {
+		u.Keys, u.Bytes = 0, 0
+	}
+
+	// The keys are only needed for the quotas, which spares reading them in the compact mode.
+	if len(b.quotas) == 0 {
+		keydir.eachMeta(func(meta Meta) bool {
+			b.liveBytes += meta.RecordSize
+			return true
+		})
+	} else {
+		keydir.each(func(k string, meta Meta) bool {
+			b.liveBytes += meta.RecordSize
+			b.account(k, 1, meta.RecordSize)
+			return true
+		})
+	}
+	for k, t := range tags {
+		b.tags.set(k, t)
+	}
+	b.buildSorted()
+}
+
 // Sync calls fsync(2) on the active data file.
 func (b *Barrel) Sync() error {
 	b.Lock()
 	defer b.Unlock()
 
-	return b.df.Sync()
+	if err := b.df.Sync(); err != nil {
+		return err
+	}
+	b.unsynced = 0
+	return nil
 }
@@ -1,15 +1,36 @@
 package barrel
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
 	"github.com/stretchr/testify/assert"
 )
 
+// recordSize returns the size of a record written now with the given key and value.
+func recordSize(k string, val []byte) int {
+	var (
+		buf    bytes.Buffer
+		header = Header{Timestamp: uint32(time.Now().Unix()), KeySize: uint32(len(k)), ValSize: uint32(len(val))}
+	)
+	header.encode(&buf)
+	return buf.Len() + len(k) + len(val)
+}
+
 func TestInitDefaults(t *testing.T) {
 	var (
 		brl    = &Barrel{}
@@ -38,6 +59,8 @@ func TestInitDefaults(t *testing.T) {
 		assert.Equal(defaultCompactInterval, brl.opts.compactInterval, "defaultCompactInterval is wrongly set")
 		assert.Equal(defaultFileSizeInterval, brl.opts.checkFileSizeInterval, "defaultFileSizeInterval is wrongly set")
 		assert.Nil(brl.opts.syncInterval, "syncInterval is wrongly set")
+		assert.Equal(runtime.NumCPU(), brl.opts.loadConcurrency, "loadConcurrency is wrongly set")
+		assert.Equal(defaultMaxOpenFiles, brl.opts.maxOpenFiles, "maxOpenFiles is wrongly set")
 	})
 
 	t.Run("Close", func(t *testing.T) {
@@ -133,6 +156,7 @@ func TestAPI(t *testing.T) {
 		_, err := brl.Get("keywithexpiry")
 		assert.Error(err)
 		assert.ErrorIs(err, ErrExpiredKey)
+		assert.ErrorIs(err, ErrKeyNotFound)
 	})
 
 	t.Run("Delete", func(t *testing.T) {
@@ -140,7 +164,7 @@ func TestAPI(t *testing.T) {
 		assert.NoError(err)
 		_, err = brl.Get("hello")
 		assert.Error(err)
-		assert.ErrorIs(err, ErrNoKey)
+		assert.ErrorIs(err, ErrKeyNotFound)
 	})
 
 	t.Run("Sync", func(t *testing.T) {
@@ -153,3 +177,3598 @@ func TestAPI(t *testing.T) {
 		assert.NoError(err)
 	})
 }
+
+func TestTail(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("a", []byte("1")))
+	assert.NoError(brl.Put("b", []byte("2")))
+	assert.NoError(brl.Delete("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	t.Run("Replay", func(t *testing.T) {
+		ch := brl.Tail(ctx, 1)
+
+		record := <-ch
+		assert.Equal("b", record.Key)
+		assert.Equal("2", string(record.Value))
+		assert.Equal(uint64(1), record.Seq)
+
+		// Tombstone for the deleted key.
+		record = <-ch
+		assert.Equal("a", record.Key)
+		assert.Empty(record.Value)
+		assert.Equal(uint64(2), record.Seq)
+	})
+
+	t.Run("Follow", func(t *testing.T) {
+		ch := brl.Tail(ctx, 3)
+
+		go brl.Put("c", []byte("3"))
+
+		record := <-ch
+		assert.Equal("c", record.Key)
+		assert.Equal("3", string(record.Value))
+		assert.Equal(uint64(3), record.Seq)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		brl, err := Init(WithDir(t.TempDir()))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		assert.NoError(brl.Put("a", []byte("1")))
+		assert.NoError(brl.Put("b", []byte("2")))
+
+		// The offset of the tail is past the last record sent by it.
+		follow, stop := context.WithCancel(ctx)
+		ch := brl.Tail(follow, 0)
+		assert.Equal("a", (<-ch).Key)
+		stats := brl.Stats()
+		assert.Equal(uint64(2), stats.Offset)
+		assert.Equal(1, stats.Tails)
+		assert.Eventually(func() bool { return brl.Stats().TailOffset == 1 }, time.Second, time.Millisecond*10)
+		assert.Equal("b", (<-ch).Key)
+		assert.Eventually(func() bool { return brl.Stats().TailOffset == 2 }, time.Second, time.Millisecond*10)
+
+		stop()
+		for range ch {
+		}
+		assert.Zero(brl.Stats().Tails)
+	})
+
+	t.Run("ResumeAfterCompaction", func(t *testing.T) {
+		dir := t.TempDir()
+		brl, err := Init(WithDir(dir), WithMaxActiveFileSize(1))
+		assert.NoError(err)
+
+		assert.NoError(brl.Put("a", []byte("1")))
+		assert.NoError(brl.Put("b", []byte("2")))
+		assert.NoError(brl.Put("a", []byte("3")))
+		assert.NoError(brl.rotateDF())
+		assert.NoError(brl.Put("c", []byte("4")))
+
+		// Consume the first two records before the datafiles are merged.
+		first, stop := context.WithCancel(ctx)
+		ch := brl.Tail(first, 0)
+		assert.Equal(uint64(0), (<-ch).Seq)
+		assert.Equal(uint64(1), (<-ch).Seq)
+		stop()
+
+		assert.NoError(brl.Maintain(context.Background()))
+		assert.NoError(brl.Compact())
+		assert.NoError(brl.Put("d", []byte("5")))
+
+		// The merged records replace the records from 0 to 3, so they're replayed on resuming from 2,
+		// while the new record retains its sequence number.
+		var (
+			got  = map[string]string{}
+			last Record
+		)
+		ch = brl.Tail(ctx, 2)
+		for last.Key != "d" {
+			var ok bool
+			if last, ok = <-ch; !ok {
+				t.Fatal("tail stopped before the new record")
+			}
+			got[last.Key] = string(last.Value)
+		}
+		assert.Equal(map[string]string{"a": "3", "b": "2", "c": "4", "d": "5"}, got)
+		assert.Equal(uint64(4), last.Seq)
+
+		// Resuming after the merged records skips them, even after a restart.
+		ch = brl.Tail(ctx, 4)
+		assert.Equal("d", (<-ch).Key)
+		assert.NoError(brl.Shutdown())
+
+		brl, err = Init(WithDir(dir))
+		assert.NoError(err)
+		defer brl.Shutdown()
+		record := <-brl.Tail(ctx, 4)
+		assert.Equal("d", record.Key)
+		assert.Equal(uint64(4), record.Seq)
+	})
+}
+
+func TestScanTime(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	start := time.Now()
+	assert.NoError(brl.Put("a", []byte("1")))
+	assert.NoError(brl.Put("b", []byte("2")))
+
+	records, err := brl.ScanTime(start.Add(-time.Second), start.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(records, 2)
+	assert.Equal("a", records[0].Key)
+	assert.Equal("b", records[1].Key)
+
+	records, err = brl.ScanTime(start.Add(time.Hour), start.Add(time.Hour*2))
+	assert.NoError(err)
+	assert.Empty(records)
+
+	// The records retain their write time when they're merged.
+	clock := &testClock{now: time.Date(2020, 1, 1, 2, 0, 0, 0, time.UTC)}
+	brl, err = Init(WithDir(t.TempDir()), WithClock(clock), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	for _, k := range []string{"c", "b", "a"} {
+		assert.NoError(brl.Put(k, []byte(k)))
+		assert.NoError(brl.rotateDF())
+		clock.advance(10 * time.Minute)
+	}
+
+	var (
+		from = time.Date(2020, 1, 1, 2, 5, 0, 0, time.UTC)
+		to   = time.Date(2020, 1, 1, 2, 25, 0, 0, time.UTC)
+	)
+	before, err := brl.ScanTime(from, to)
+	assert.NoError(err)
+	assert.Len(before, 2)
+	assert.NoError(brl.Maintain(context.Background()))
+	assert.NoError(brl.Compact())
+	after, err := brl.ScanTime(from, to)
+	assert.NoError(err)
+	assert.Equal(before, after)
+	info, err := brl.Inspect("c")
+	assert.NoError(err)
+	assert.Equal(time.Date(2020, 1, 1, 2, 0, 0, 0, time.UTC), info.Written.UTC())
+}
+
+func TestHints(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir))
+	assert.NoError(err)
+	assert.NoError(brl.Put("a", []byte("1")))
+	assert.NoError(brl.Put("b", []byte("2")))
+	assert.NoError(brl.Delete("a"))
+	assert.NoError(brl.Shutdown())
+
+	t.Run("Load", func(t *testing.T) {
+		brl, err = Init(WithDir(tmpDir))
+		assert.NoError(err)
+		assert.Equal([]string{"b"}, brl.List())
+
+		// Overwrite and delete keys from the older datafile.
+		assert.NoError(brl.Put("a", []byte("3")))
+		assert.NoError(brl.Delete("b"))
+		assert.NoError(brl.Shutdown())
+
+		brl, err = Init(WithDir(tmpDir))
+		assert.NoError(err)
+		assert.Equal([]string{"a"}, brl.List())
+		val, err := brl.Get("a")
+		assert.NoError(err)
+		assert.Equal("3", string(val))
+		assert.NoError(brl.Shutdown())
+	})
+
+	t.Run("Encode", func(t *testing.T) {
+		hints := newHints(7)
+		hints.add("a", Meta{Timestamp: 1, RecordSize: 26, RecordPos: 26, FileID: 7}, []string{"x", "y"}, false)
+		hints.add("b", Meta{Timestamp: 2, RecordSize: 21, RecordPos: 47, FileID: 7}, nil, true)
+
+		path := filepath.Join(tmpDir, "test.hints")
+		assert.NoError(hints.Encode(path))
+		defer os.Remove(path)
+
+		decoded := newHints(0)
+		assert.NoError(decoded.Decode(path))
+		assert.Equal(hints, decoded)
+
+		// Corrupt the file.
+		data, err := os.ReadFile(path)
+		assert.NoError(err)
+		data[len(data)-5] ^= 0xff
+		assert.NoError(os.WriteFile(path, data, 0644))
+		assert.ErrorIs(decoded.Decode(path), ErrChecksumMismatch)
+	})
+
+	t.Run("Rebuild", func(t *testing.T) {
+		// Remove all the hints files, so that the keydir is built from the datafiles.
+		files, err := filepath.Glob(filepath.Join(tmpDir, "*.hints"))
+		assert.NoError(err)
+		for _, f := range files {
+			assert.NoError(os.Remove(f))
+		}
+
+		brl, err = Init(WithDir(tmpDir))
+		assert.NoError(err)
+		assert.Equal([]string{"a"}, brl.List())
+		val, err := brl.Get("a")
+		assert.NoError(err)
+		assert.Equal("3", string(val))
+		assert.NoError(brl.Shutdown())
+	})
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	// Write each key in a separate datafile.
+	keys := []string{"a", "b", "c"}
+	for _, k := range keys {
+		brl, err := Init(WithDir(tmpDir))
+		assert.NoError(err)
+		assert.NoError(brl.Put(k, []byte(k)))
+		assert.NoError(brl.Shutdown())
+	}
+
+	brl, err := Init(WithDir(tmpDir), WithMaxOpenFiles(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for _, k := range keys {
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(k, string(val))
+		assert.Equal(1, brl.pool.Len())
+	}
+}
+
+func TestMmapReads(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir))
+	assert.NoError(err)
+	assert.NoError(brl.Put("hello", []byte("world")))
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(tmpDir), WithMmapReads())
+	assert.NoError(err)
+
+	val, err := brl.Get("hello")
+	assert.NoError(err)
+	assert.Equal("world", string(val))
+	assert.Equal(0, brl.pool.Len(), "mapped datafiles shouldn't hold a file descriptor")
+	assert.NoError(brl.Shutdown())
+
+	// The values are copied out of the mapping, so they're valid after the datafile is merged and unmapped,
+	// while the refs keep the mapping till they're released.
+	brl, err = Init(WithDir(t.TempDir()), WithMmapReads(), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("hello", []byte("world")))
+	assert.NoError(brl.Put("stale", []byte("v1")))
+	assert.NoError(brl.rotateDF())
+	assert.NoError(brl.Put("stale", []byte("v2")))
+
+	val, err = brl.Get("hello")
+	assert.NoError(err)
+	ref, err := brl.GetRef("hello")
+	assert.NoError(err)
+	assert.NoError(brl.Maintain(context.Background()))
+	assert.NoError(brl.Compact())
+	assert.Equal("world", string(val))
+	assert.Equal("world", string(ref.Value))
+	ref.Release()
+}
+
+func TestFsyncOnPut(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir), WithFsyncOnPut())
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// Write concurrently so that the writers are batched.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.NoError(brl.Put(fmt.Sprintf("key-%d-%d", i, j), []byte("val")))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(400, brl.Len())
+	assert.Equal(uint64(400), brl.commit.synced)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir), WithWriteBuffer(64))
+	assert.NoError(err)
+
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		assert.NoError(brl.Put(k, []byte(k)))
+
+		// Buffered records should be readable right away.
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(k, string(val))
+	}
+	assert.NoError(brl.Shutdown())
+
+	// Buffered records should be flushed on shutdown.
+	brl, err = Init(WithDir(tmpDir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Equal(10, brl.Len())
+	val, err := brl.Get("key-9")
+	assert.NoError(err)
+	assert.Equal("key-9", string(val))
+}
+
+func TestPreallocate(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir), WithPreallocate(), WithMaxActiveFileSize(1<<20))
+	assert.NoError(err)
+	assert.NoError(brl.Put("hello", []byte("world")))
+
+	// Preallocation shouldn't change the size of the file.
+	size, err := brl.df.Size()
+	assert.NoError(err)
+	assert.Equal(int64(datafile.SegmentHeaderSize+recordSize("hello", []byte("world"))), size)
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(tmpDir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	val, err := brl.Get("hello")
+	assert.NoError(err)
+	assert.Equal("world", string(val))
+}
+
+func TestMerge(t *testing.T) {
+	for name, cfg := range map[string][]Config{
+		"Default":  {},
+		"DirectIO": {WithDirectIOCompaction()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				assert = assert.New(t)
+			)
+
+			// Create a temp directory for running tests.
+			tmpDir, err := os.MkdirTemp("", "barreldb")
+			defer os.RemoveAll(tmpDir)
+
+			assert.NoError(err)
+
+			// Write the keys across multiple datafiles.
+			val := []byte(strings.Repeat("v", 5000))
+			for i := 0; i < 3; i++ {
+				brl, err := Init(WithDir(tmpDir))
+				assert.NoError(err)
+				for j := 0; j < 100; j++ {
+					assert.NoError(brl.Put(fmt.Sprintf("key-%d", j), val))
+				}
+				assert.NoError(brl.Delete("key-0"))
+				assert.NoError(brl.Shutdown())
+			}
+
+			brl, err := Init(append(cfg, WithDir(tmpDir))...)
+			assert.NoError(err)
+			defer brl.Shutdown()
+
+			brl.Lock()
+			assert.NoError(brl.merge(context.Background()))
+			brl.Unlock()
+
+			assert.Empty(brl.stale)
+			assert.Equal(99, brl.Len())
+			for j := 1; j < 100; j++ {
+				got, err := brl.Get(fmt.Sprintf("key-%d", j))
+				assert.NoError(err)
+				assert.Equal(val, got)
+			}
+
+			// Only the live records should be present in the merged datafile.
+			size, err := brl.df.Size()
+			assert.NoError(err)
+			want := datafile.SegmentHeaderSize
+			for j := 1; j < 100; j++ {
+				want += recordSize(fmt.Sprintf("key-%d", j), val)
+			}
+			assert.Equal(int64(want), size)
+		})
+	}
+}
+
+func TestCompactionProgress(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		val    = []byte(strings.Repeat("v", 1000))
+	)
+
+	for i := 0; i < 2; i++ {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		for j := 0; j < 100; j++ {
+			assert.NoError(brl.Put(fmt.Sprintf("key-%d-%d", i, j), val))
+		}
+		assert.NoError(brl.Shutdown())
+	}
+
+	brl, err := Init(WithDir(dir), WithManualMaintenance(), WithCompactionRate(100<<10))
+	assert.NoError(err)
+	ids := brl.Segments()
+
+	// waitRunning waits for the merge to rewrite some of the records.
+	waitRunning := func() CompactionProgress {
+		for {
+			if p := brl.CompactionProgress(); p.Running && p.BytesProcessed > 0 {
+				return p
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The merge is stopped once the context is cancelled, and the datafiles are left as they are.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- brl.CompactContext(ctx) }()
+	p := waitRunning()
+	assert.Equal(3, p.SegmentsTotal)
+	assert.Less(p.BytesProcessed, p.BytesTotal)
+	assert.Greater(p.ETA, time.Duration(0))
+	cancel()
+	assert.ErrorIs(<-errCh, context.Canceled)
+	assert.False(brl.CompactionProgress().Running)
+	assert.Equal(ids, brl.Segments())
+	assert.Equal(200, brl.Len())
+
+	// The merge is stopped by the shutdown as well, and merged again on the next run.
+	go func() { errCh <- brl.Compact() }()
+	waitRunning()
+	assert.NoError(brl.Shutdown())
+	assert.ErrorIs(<-errCh, errInterrupted)
+
+	brl, err = Init(WithDir(dir), WithManualMaintenance())
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Equal(200, brl.Len())
+	assert.NoError(brl.Compact())
+	p = brl.Stats().Compaction
+	assert.False(p.Running)
+	assert.Equal(p.SegmentsTotal, p.SegmentsDone)
+	assert.Equal(p.BytesTotal, p.BytesProcessed)
+	assert.Len(brl.Segments(), 1)
+}
+
+func TestParallelMerge(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		val    = []byte(strings.Repeat("v", 1000))
+	)
+
+	// Write the live keys across multiple datafiles.
+	for i := 0; i < 4; i++ {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		for j := 0; j < 50; j++ {
+			assert.NoError(brl.Put(fmt.Sprintf("key-%d-%d", i, j), val))
+		}
+		assert.NoError(brl.Delete(fmt.Sprintf("key-%d-0", i)))
+		assert.NoError(brl.Shutdown())
+	}
+
+	_, err := Init(WithDir(dir), WithCompactionConcurrency(0))
+	assert.Error(err)
+
+	brl, err := Init(WithDir(dir), WithCompactionConcurrency(3), WithCompactionRate(10<<20))
+	assert.NoError(err)
+	assert.NoError(brl.Compact())
+
+	// Each worker writes a merged datafile, the last of which becomes the active datafile.
+	assert.Equal(3, brl.Stats().DataFiles)
+	assert.Equal(196, brl.Len())
+	stats := brl.Stats()
+	assert.Equal(stats.LiveBytes+3*datafile.SegmentHeaderSize, stats.DiskBytes)
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Equal(196, brl.Len())
+	for i := 0; i < 4; i++ {
+		for j := 1; j < 50; j++ {
+			got, err := brl.Get(fmt.Sprintf("key-%d-%d", i, j))
+			assert.NoError(err)
+			assert.Equal(val, got)
+		}
+	}
+}
+
+func TestValueCache(t *testing.T) {
+	var (
+		assert = assert.New(t)
+	)
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(err)
+
+	brl, err := Init(WithDir(tmpDir), WithValueCache(1<<10))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("hello", []byte("world")))
+	for i := 0; i < 3; i++ {
+		val, err := brl.Get("hello")
+		assert.NoError(err)
+		assert.Equal("world", string(val))
+	}
+
+	stats := brl.Stats()
+	assert.Equal(uint64(2), stats.CacheHits)
+	assert.Equal(uint64(1), stats.CacheMisses)
+
+	// Overwriting the key should drop the older record from the cache.
+	assert.NoError(brl.Put("hello", []byte("there")))
+	assert.Equal(0, brl.Stats().CacheBytes)
+	val, err := brl.Get("hello")
+	assert.NoError(err)
+	assert.Equal("there", string(val))
+}
+
+func TestMaxDataSize(t *testing.T) {
+	// Each record is 23 bytes (27 bytes with an expiry), so only 3 records fit in 80 bytes.
+	val := []byte("01234567")
+
+	t.Run("NoEviction", func(t *testing.T) {
+		assert := assert.New(t)
+		brl, err := Init(WithDir(t.TempDir()), WithMaxDataSize(80, NoEviction))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(brl.Put(fmt.Sprintf("k%d", i), val))
+		}
+		assert.ErrorIs(brl.Put("k3", val), ErrMaxDataSize)
+
+		// Overwrites of the same size and deletes are allowed.
+		assert.NoError(brl.Put("k0", val))
+		assert.NoError(brl.Delete("k0"))
+		assert.NoError(brl.Put("k3", val))
+		assert.Equal(69, brl.Stats().LiveBytes)
+	})
+
+	t.Run("AllKeysLRU", func(t *testing.T) {
+		assert := assert.New(t)
+		brl, err := Init(WithDir(t.TempDir()), WithMaxDataSize(80, AllKeysLRU))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(brl.Put(fmt.Sprintf("k%d", i), val))
+		}
+		_, err = brl.Get("k0")
+		assert.NoError(err)
+
+		assert.NoError(brl.Put("k3", val))
+		assert.ElementsMatch([]string{"k0", "k2", "k3"}, brl.List())
+		assert.Equal(uint64(1), brl.Stats().EvictedKeys)
+	})
+
+	t.Run("VolatileTTL", func(t *testing.T) {
+		assert := assert.New(t)
+		brl, err := Init(WithDir(t.TempDir()), WithMaxDataSize(80, VolatileTTL))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		assert.NoError(brl.Put("k0", val))
+		assert.NoError(brl.PutEx("k1", val, time.Hour))
+		assert.NoError(brl.PutEx("k2", val, time.Minute))
+
+		assert.NoError(brl.Put("k3", val))
+		assert.ElementsMatch([]string{"k0", "k1", "k3"}, brl.List())
+		assert.NoError(brl.Put("k4", val))
+		assert.ElementsMatch([]string{"k0", "k3", "k4"}, brl.List())
+
+		// None of the remaining keys have an expiry.
+		assert.ErrorIs(brl.Put("k5", val), ErrMaxDataSize)
+	})
+}
+
+func TestMinFreeDisk(t *testing.T) {
+	assert := assert.New(t)
+
+	// No disk has this much free space, so the writes are paused right away.
+	brl, err := Init(WithDir(t.TempDir()), WithMinFreeDisk(math.MaxInt64))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.True(brl.Stats().StorageFull)
+	assert.ErrorIs(brl.Put("hello", []byte("world")), ErrStorageFull)
+	assert.ErrorIs(brl.Delete("hello"), ErrStorageFull)
+
+	// Writes resume once the free space is above the minimum.
+	brl.Lock()
+	brl.opts.minFreeDisk = 1
+	assert.NoError(brl.checkDiskSpace())
+	brl.Unlock()
+
+	assert.False(brl.Stats().StorageFull)
+	assert.NoError(brl.Put("hello", []byte("world")))
+}
+
+func TestWriteStall(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		val    = []byte("01234567")
+	)
+
+	// Overwrite the key in multiple datafiles, so that most of the data is stale.
+	for i := 0; i < 3; i++ {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		assert.NoError(brl.Put("k0", val))
+		assert.NoError(brl.Shutdown())
+	}
+
+	brl, err := Init(WithDir(dir), WithWriteStall(0.5, 0))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	stats := brl.Stats()
+	// The datafiles are made up of the segment headers of all 4 datafiles and 3 records.
+	assert.Equal(4*datafile.SegmentHeaderSize+3*23, stats.DiskBytes)
+	assert.Equal(23, stats.LiveBytes)
+	assert.ErrorIs(brl.Put("k1", val), ErrWriteStall)
+	assert.ErrorIs(brl.Delete("k0"), ErrWriteStall)
+	_, err = brl.DeleteMulti([]string{"k0"})
+	assert.ErrorIs(err, ErrWriteStall)
+
+	// Writes are allowed once the stale data is merged.
+	brl.Lock()
+	assert.NoError(brl.merge(context.Background()))
+	brl.Unlock()
+
+	assert.Equal(datafile.SegmentHeaderSize+23, brl.Stats().DiskBytes)
+	assert.NoError(brl.Put("k1", val))
+	assert.NoError(brl.Delete("k0"))
+}
+
+func TestMaxKVSize(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()), WithMaxKeySize(4), WithMaxValueSize(8))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("abcd", []byte("01234567")))
+
+	err = brl.Put("abcde", []byte("0"))
+	assert.ErrorIs(err, ErrLargeKey)
+	assert.ErrorIs(err, ErrTooLarge)
+
+	err = brl.Put("a", []byte("012345678"))
+	assert.ErrorIs(err, ErrLargeValue)
+	assert.ErrorIs(err, ErrTooLarge)
+
+	assert.Equal(uint64(2), brl.Stats().Oversized)
+
+	_, err = Init(WithDir(t.TempDir()), WithMaxKeySize(0))
+	assert.Error(err)
+}
+
+func TestChecksum(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		algos  = []ChecksumAlgo{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64}
+	)
+
+	// Write a datafile with each algorithm.
+	for i, algo := range algos {
+		brl, err := Init(WithDir(dir), WithChecksum(algo))
+		assert.NoError(err)
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("val-%d", i))))
+		assert.NoError(brl.Shutdown())
+	}
+
+	// All the records are readable regardless of the algorithm, also after a merge.
+	brl, err := Init(WithDir(dir), WithChecksum(ChecksumXXHash64))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for _, merge := range []bool{false, true} {
+		if merge {
+			brl.Lock()
+			assert.NoError(brl.merge(context.Background()))
+			brl.Unlock()
+		}
+		for i := range algos {
+			val, err := brl.Get(fmt.Sprintf("key-%d", i))
+			assert.NoError(err)
+			assert.Equal(fmt.Sprintf("val-%d", i), string(val))
+		}
+	}
+
+	_, err = Init(WithDir(t.TempDir()), WithChecksum(ChecksumAlgo(3)))
+	assert.Error(err)
+}
+
+func TestScrub(t *testing.T) {
+	// corruptDir writes a few keys and flips a byte of the value of `key-1`.
+	corruptDir := func(t *testing.T) string {
+		dir := t.TempDir()
+		brl, err := Init(WithDir(dir))
+		assert.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, brl.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("val-%d", i))))
+		}
+		assert.NoError(t, brl.Shutdown())
+
+		path := filepath.Join(dir, "barrel_0.db")
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		data[strings.Index(string(data), "val-1")] ^= 0xff
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+
+		return dir
+	}
+
+	check := func(t *testing.T, brl *Barrel) {
+		assert := assert.New(t)
+		assert.Equal([]string{"key-1"}, brl.Quarantined())
+		assert.NotZero(brl.Stats().CorruptRecords)
+
+		_, err := brl.Get("key-1")
+		assert.ErrorIs(err, ErrChecksumMismatch)
+		val, err := brl.Get("key-2")
+		assert.NoError(err)
+		assert.Equal("val-2", string(val))
+
+		// Writing the key again removes it from the quarantine.
+		assert.NoError(brl.Put("key-1", []byte("new")))
+		assert.Empty(brl.Quarantined())
+		val, err = brl.Get("key-1")
+		assert.NoError(err)
+		assert.Equal("new", string(val))
+	}
+
+	t.Run("VerifyOnStartup", func(t *testing.T) {
+		brl, err := Init(WithDir(corruptDir(t)), WithVerifyOnStartup())
+		assert.NoError(t, err)
+		defer brl.Shutdown()
+
+		check(t, brl)
+	})
+
+	t.Run("Scrubber", func(t *testing.T) {
+		brl, err := Init(WithDir(corruptDir(t)), WithScrubber(time.Millisecond*10, 1<<20))
+		assert.NoError(t, err)
+		defer brl.Shutdown()
+
+		assert.Eventually(t, func() bool { return brl.Stats().Quarantined == 1 }, time.Second, time.Millisecond*10)
+		check(t, brl)
+	})
+}
+
+func TestGetMulti(t *testing.T) {
+	for name, opts := range map[string][]Config{
+		"Parallel":   nil,
+		"IOUring":    {WithIOUring()},
+		"IOUringMap": {WithIOUring(), WithMmapReads()},
+		"IOUringLRU": {WithIOUring(), WithValueCache(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			brl, err := Init(append([]Config{WithDir(t.TempDir()), WithMaxActiveFileSize(1)}, opts...)...)
+			assert.NoError(err)
+			defer brl.Shutdown()
+			if _, err := datafile.NewRing(1); err == nil {
+				assert.Equal(len(opts) > 0, brl.ring != nil)
+			}
+
+			// Write more keys than the entries of the ring across the datafiles, and read them along
+			// with the missing, deleted and expired keys.
+			keys := make([]string, 0, 2*defaultIOUringEntries)
+			for i := 0; i < cap(keys); i++ {
+				keys = append(keys, fmt.Sprintf("key-%d", i))
+				assert.NoError(brl.Put(keys[i], []byte(keys[i])))
+				if i%32 == 31 {
+					assert.NoError(brl.rotateDF())
+				}
+			}
+			assert.NoError(brl.Delete("key-1"))
+			assert.NoError(brl.PutEx("key-2", []byte("val"), -time.Second))
+			assert.Greater(brl.Stats().DataFiles, 2)
+
+			check := func() {
+				vals, err := brl.GetMulti(append(keys, "missing"))
+				assert.NoError(err)
+				assert.Len(vals, len(keys)+1)
+				for i, k := range keys {
+					if i == 1 || i == 2 {
+						assert.Nil(vals[i])
+						continue
+					}
+					assert.Equal(k, string(vals[i]))
+				}
+				assert.Nil(vals[len(keys)])
+			}
+			check()
+			check()
+
+			// The reads of the older datafiles don't fail while they're merged.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					check()
+				}
+			}()
+			assert.NoError(brl.Maintain(context.Background()))
+			assert.NoError(brl.Compact())
+			<-done
+			check()
+		})
+	}
+}
+
+func TestAutoHeal(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	// Write two versions of the key in separate datafiles and corrupt the latest one.
+	for _, val := range []string{"old-1", "new-1"} {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		assert.NoError(brl.Put("key-1", []byte(val)))
+		assert.NoError(brl.Shutdown())
+	}
+	path := filepath.Join(dir, "barrel_1.db")
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	data[strings.Index(string(data), "new-1")] ^= 0xff
+	assert.NoError(os.WriteFile(path, data, 0644))
+
+	brl, err := Init(WithDir(dir), WithAutoHeal())
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	vals, err := brl.GetMulti([]string{"key-1"})
+	assert.NoError(err)
+	assert.Equal("old-1", string(vals[0]))
+	assert.Equal(uint64(1), brl.Stats().Healed)
+
+	// The recovered value is written again, so it isn't healed on every read.
+	val, err := brl.Get("key-1")
+	assert.NoError(err)
+	assert.Equal("old-1", string(val))
+	assert.Equal(uint64(1), brl.Stats().Healed)
+}
+
+func TestRecordFormatV1(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		buf    bytes.Buffer
+	)
+
+	// Write a datafile in the version 1 format, which doesn't have a segment header.
+	for i := 0; i < 3; i++ {
+		k, val := fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("val-%d", i))
+		binary.Write(&buf, binary.LittleEndian, []uint32{crc32.ChecksumIEEE(val), uint32(time.Now().Unix()), 0, uint32(len(k)), uint32(len(val))})
+		buf.WriteString(k)
+		buf.Write(val)
+	}
+	assert.NoError(os.WriteFile(filepath.Join(dir, "barrel_0.db"), buf.Bytes(), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "barrel_1.db"), nil, 0644))
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.Equal(1, brl.stale[0].Version())
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+
+	// The records are upgraded to the latest version by a merge.
+	for _, merge := range []bool{false, true} {
+		if merge {
+			brl.Lock()
+			assert.NoError(brl.merge(context.Background()))
+			brl.Unlock()
+			assert.Equal(recordVersion, brl.df.Version())
+		}
+		for i := 0; i < 4; i++ {
+			val, err := brl.Get(fmt.Sprintf("key-%d", i))
+			assert.NoError(err)
+			assert.Equal(fmt.Sprintf("val-%d", i), string(val))
+		}
+	}
+}
+
+func TestPutWithMeta(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	// Write the records in separate datafiles, so that they're merged.
+	for i := 0; i < 2; i++ {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		assert.NoError(brl.PutWithMeta(fmt.Sprintf("key-%d", i), []byte("val"), []byte{byte(i), 0xff}))
+		assert.NoError(brl.Shutdown())
+	}
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("key-2", []byte("val")))
+	assert.ErrorIs(brl.PutWithMeta("key-3", []byte("val"), make([]byte, MaxMetaSize+1)), ErrTooLarge)
+
+	// The metadata is preserved by the merges.
+	for _, merge := range []bool{false, true} {
+		if merge {
+			brl.Lock()
+			assert.NoError(brl.merge(context.Background()))
+			brl.Unlock()
+		}
+		for i := 0; i < 2; i++ {
+			val, meta, err := brl.GetWithMeta(fmt.Sprintf("key-%d", i))
+			assert.NoError(err)
+			assert.Equal("val", string(val))
+			assert.Equal([]byte{byte(i), 0xff}, meta)
+		}
+		val, meta, err := brl.GetWithMeta("key-2")
+		assert.NoError(err)
+		assert.Equal("val", string(val))
+		assert.Nil(meta)
+	}
+}
+
+func TestStore(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(t, err)
+	defer brl.Shutdown()
+
+	for _, codec := range []Codec{nil, JSONCodec{}, GobCodec{}} {
+		store := NewStore[user](brl, codec)
+		assert.NoError(t, store.Put("user-1", user{Name: "foo", Age: 42}))
+
+		u, err := store.Get("user-1")
+		assert.NoError(t, err)
+		assert.Equal(t, user{Name: "foo", Age: 42}, u)
+
+		assert.NoError(t, store.Delete("user-1"))
+		_, err = store.Get("user-1")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// Only the whole document can be set for a new key.
+	assert.ErrorIs(brl.JSONSet("doc", "$.name", []byte(`"foo"`)), ErrJSONPathNotFound)
+	assert.NoError(brl.JSONSet("doc", "$", []byte(`{"name":"foo","tags":["a","b","c"],"n":1.50}`)))
+	assert.ErrorIs(brl.JSONSet("doc", "$", []byte(`{`)), ErrInvalidJSON)
+
+	assert.NoError(brl.JSONSet("doc", "$.name", []byte(`"bar"`)))
+	assert.NoError(brl.JSONSet("doc", "$.user", []byte(`{"id":1}`)))
+	assert.NoError(brl.JSONSet("doc", "user.id", []byte(`2`)))
+	assert.NoError(brl.JSONSet("doc", "$.tags[-1]", []byte(`"d"`)))
+	assert.ErrorIs(brl.JSONSet("doc", "$.tags[5]", []byte(`"e"`)), ErrJSONPathNotFound)
+	assert.ErrorIs(brl.JSONSet("doc", "$.a.b", []byte(`1`)), ErrJSONPathNotFound)
+
+	for path, want := range map[string]string{
+		"$":            `{"n":1.50,"name":"bar","tags":["a","b","d"],"user":{"id":2}}`,
+		"$.name":       `"bar"`,
+		`$["user"].id`: `2`,
+		"$.tags[0]":    `"a"`,
+	} {
+		val, err := brl.JSONGet("doc", path)
+		assert.NoError(err)
+		assert.Equal(want, string(val), path)
+	}
+	_, err = brl.JSONGet("doc", "$.missing")
+	assert.ErrorIs(err, ErrJSONPathNotFound)
+	_, err = brl.JSONGet("doc", "$..")
+	assert.ErrorIs(err, ErrInvalidJSONPath)
+
+	n, err := brl.JSONDel("doc", "$.tags[1]")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	n, err = brl.JSONDel("doc", "$.missing")
+	assert.NoError(err)
+	assert.Equal(0, n)
+	val, err := brl.JSONGet("doc", "$.tags")
+	assert.NoError(err)
+	assert.Equal(`["a","d"]`, string(val))
+
+	n, err = brl.JSONDel("doc", "$")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, err = brl.Get("doc")
+	assert.ErrorIs(err, ErrKeyNotFound)
+}
+
+func TestIndex(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	_, err := Init(WithDir(dir), WithJSONIndex("tags", "$.."))
+	assert.ErrorIs(err, ErrInvalidJSONPath)
+
+	opts := []Config{WithDir(dir), WithJSONIndex("tenant", "$.tenant"), WithJSONIndex("tags", "$.tags")}
+	brl, err := Init(opts...)
+	assert.NoError(err)
+
+	assert.NoError(brl.Put("log-1", []byte(`{"tenant":"foo","tags":["a","b"]}`)))
+	assert.NoError(brl.Put("log-2", []byte(`{"tenant":"foo","tags":["b"]}`)))
+	assert.NoError(brl.Put("log-3", []byte(`{"tenant":"bar"}`)))
+	assert.NoError(brl.Put("log-4", []byte(`not json`)))
+	assert.NoError(brl.PutEx("log-5", []byte(`{"tenant":"foo"}`), -time.Second))
+
+	// Updates and deletes remove the older values.
+	assert.NoError(brl.Put("log-2", []byte(`{"tenant":"bar","tags":["b"]}`)))
+	assert.NoError(brl.Delete("log-3"))
+
+	check := func(brl *Barrel) {
+		for _, tc := range []struct {
+			index, value string
+			keys         []string
+		}{
+			{"tenant", "foo", []string{"log-1"}},
+			{"tenant", "bar", []string{"log-2"}},
+			{"tags", "b", []string{"log-1", "log-2"}},
+			{"tags", "c", []string{}},
+		} {
+			keys, err := brl.Lookup(tc.index, tc.value)
+			assert.NoError(err)
+			assert.Equal(tc.keys, keys, tc.index+"="+tc.value)
+		}
+		_, err := brl.Lookup("missing", "foo")
+		assert.ErrorIs(err, ErrUnknownIndex)
+	}
+	check(brl)
+	assert.NoError(brl.Shutdown())
+
+	// The indexes are rebuilt on startup and by merges.
+	assert.NoError(os.WriteFile(filepath.Join(dir, "barrel_2.db"), nil, 0644))
+	brl, err = Init(opts...)
+	assert.NoError(err)
+	defer brl.Shutdown()
+	check(brl)
+
+	brl.Lock()
+	assert.NoError(brl.merge(context.Background()))
+	brl.Unlock()
+	check(brl)
+}
+
+func TestTags(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+
+	assert.NoError(brl.PutWithTags("session-1", []byte("val"), []string{"tenant:foo", "session"}))
+	assert.NoError(brl.PutWithTags("session-2", []byte("val"), []string{"tenant:foo"}))
+	assert.NoError(brl.PutWithTags("session-3", []byte("val"), []string{"tenant:bar"}))
+	assert.NoError(brl.PutWithTags("session-4", []byte("val"), []string{"tenant:foo"}))
+	assert.ErrorIs(brl.PutWithTags("session-5", []byte("val"), []string{""}), ErrEmptyTag)
+
+	// Writes replace the tags and deletes remove them.
+	assert.NoError(brl.Put("session-2", []byte("val")))
+	assert.NoError(brl.Delete("session-4"))
+
+	check := func(brl *Barrel) {
+		assert.Equal([]string{"session-1"}, brl.KeysByTag("tenant:foo"))
+		assert.Equal([]string{"session-3"}, brl.KeysByTag("tenant:bar"))
+		assert.Equal([]string{"session-1"}, brl.KeysByTag("session"))
+		assert.Empty(brl.KeysByTag("missing"))
+	}
+	check(brl)
+	assert.NoError(brl.Shutdown())
+
+	// The tags are loaded from the hints files on startup, or from the datafiles if they're missing.
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			files, err := filepath.Glob(filepath.Join(dir, "*.hints"))
+			assert.NoError(err)
+			for _, f := range files {
+				assert.NoError(os.Remove(f))
+			}
+		}
+		brl, err = Init(WithDir(dir))
+		assert.NoError(err)
+		check(brl)
+		assert.NoError(brl.Shutdown())
+	}
+
+	// The tags are preserved by the merges.
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	brl.Lock()
+	assert.NoError(brl.merge(context.Background()))
+	brl.Unlock()
+	check(brl)
+
+	// Tags and metadata are stored together.
+	assert.NoError(brl.PutWithMeta("session-1", []byte("val"), []byte("meta")))
+	_, meta, err := brl.GetWithMeta("session-1")
+	assert.NoError(err)
+	assert.Equal([]byte("meta"), meta)
+	assert.Empty(brl.KeysByTag("session"))
+}
+
+func TestExpiryCallback(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		mu      sync.Mutex
+		expired = make(map[string]string)
+		brl     *Barrel
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithExpiryCallback(func(k string, val []byte) {
+		mu.Lock()
+		expired[k] = string(val)
+		mu.Unlock()
+
+		// Cascade the cleanup to the derived key.
+		assert.NoError(brl.Delete(k + ":derived"))
+	}))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for _, k := range []string{"key-1", "key-2"} {
+		assert.NoError(brl.PutEx(k, []byte("val-"+k), -time.Second))
+		assert.NoError(brl.Put(k+":derived", []byte("val")))
+	}
+
+	// The keys are purged by the reads and by the compaction.
+	_, err = brl.Get("key-1")
+	assert.ErrorIs(err, ErrExpiredKey)
+	brl.Lock()
+	assert.NoError(brl.cleanupExpired())
+	brl.Unlock()
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(expired) == 2
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(map[string]string{"key-1": "val-key-1", "key-2": "val-key-2"}, expired)
+	assert.Eventually(func() bool { return brl.Len() == 0 }, time.Second, time.Millisecond*10)
+}
+
+// testHook rejects the writes of the keys with the given prefix and records the other writes.
+type testHook struct {
+	NopHook
+	prefix string
+	writes []string
+}
+
+var errRejected = errors.New("rejected")
+
+func (h *testHook) BeforePut(k string, val []byte) error {
+	if strings.HasPrefix(k, h.prefix) {
+		return errRejected
+	}
+	return nil
+}
+
+func (h *testHook) AfterPut(k string, val []byte) {
+	h.writes = append(h.writes, "put "+k+"="+string(val))
+}
+
+func (h *testHook) AfterDelete(k string) {
+	h.writes = append(h.writes, "del "+k)
+}
+
+func TestHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		first  = &testHook{prefix: "first:"}
+		second = &testHook{prefix: "second:"}
+	)
+	brl, err := Init(WithDir(t.TempDir()), WithHook(first), WithHook(second))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.PutEx("key-2", []byte("val-2"), time.Hour))
+	assert.NoError(brl.Delete("key-1"))
+	assert.NoError(brl.JSONSet("doc", "$", []byte(`{"a":1}`)))
+
+	// Rejected writes aren't stored and the later hooks aren't called.
+	assert.ErrorIs(brl.Put("first:key", []byte("val")), errRejected)
+	assert.ErrorIs(brl.Put("second:key", []byte("val")), errRejected)
+	assert.Equal(2, brl.Len())
+
+	want := []string{"put key-1=val-1", "put key-2=val-2", "del key-1", `put doc={"a":1}`}
+	assert.Equal(want, first.writes)
+	assert.Equal(want, second.writes)
+}
+
+func TestMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	target, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer target.Shutdown()
+
+	brl, err := Init(WithDir(t.TempDir()), WithMirror(target, 10))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.PutEx("key-2", []byte("val-2"), time.Hour))
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+	assert.NoError(brl.Delete("key-3"))
+
+	assert.Eventually(func() bool { return brl.Stats().MirrorWrites == 4 }, time.Second, time.Millisecond*10)
+	assert.ElementsMatch([]string{"key-1", "key-2"}, target.List())
+	val, err := target.Get("key-2")
+	assert.NoError(err)
+	assert.Equal("val-2", string(val))
+	assert.NotZero(target.keydir.meta("key-2").Expiry)
+
+	stats := brl.Stats()
+	assert.True(stats.Mirroring)
+	assert.Equal(stats.Offset, stats.MirrorOffset)
+	assert.Zero(stats.MirrorLag)
+	assert.False(stats.MirrorFailing)
+}
+
+// blockingTarget is a mirror target whose writes wait to be released, and fail once it's closed.
+type blockingTarget struct {
+	MirrorTarget
+	release chan error
+}
+
+func (t *blockingTarget) Put(k string, val []byte) error {
+	if err := <-t.release; err != nil {
+		return err
+	}
+	return t.MirrorTarget.Put(k, val)
+}
+
+func TestMirrorLag(t *testing.T) {
+	assert := assert.New(t)
+
+	target, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer target.Shutdown()
+
+	var (
+		clock   = &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		blocked = &blockingTarget{MirrorTarget: target, release: make(chan error)}
+	)
+	brl, err := Init(WithDir(t.TempDir()), WithClock(clock), WithMirror(blocked, 10))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// The mirror lags behind by the time since the write being mirrored was queued.
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	clock.advance(time.Minute)
+	assert.Eventually(func() bool { return brl.Stats().MirrorLag == time.Minute }, time.Second, time.Millisecond*10)
+	assert.Zero(brl.Stats().MirrorOffset)
+
+	blocked.release <- nil
+	assert.Eventually(func() bool { return brl.Stats().MirrorOffset == 1 }, time.Second, time.Millisecond*10)
+	assert.False(brl.Stats().MirrorFailing)
+
+	// A failing write is reported, and the mirror is caught up once it's processed.
+	blocked.release <- errors.New("unavailable")
+	assert.Eventually(func() bool { return brl.Stats().MirrorOffset == 2 }, time.Second, time.Millisecond*10)
+	stats := brl.Stats()
+	assert.True(stats.MirrorFailing)
+	assert.Zero(stats.MirrorLag)
+	assert.Equal(uint64(2), stats.Offset)
+}
+
+// testSink is a change sink which records the changes, and fails the batches while failing is set.
+type testSink struct {
+	sync.Mutex
+	changes []Change
+	failing bool
+}
+
+func (s *testSink) Publish(changes []Change) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.failing {
+		s.failing = false
+		return errors.New("unavailable")
+	}
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func (s *testSink) ops() []string {
+	s.Lock()
+	defer s.Unlock()
+	ops := make([]string, 0, len(s.changes))
+	for _, c := range s.changes {
+		ops = append(ops, fmt.Sprintf("%d %s %s=%s", c.Seq, c.Op, c.Key, c.Value))
+	}
+	return ops
+}
+
+func TestChangeSink(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		sink   = &testSink{failing: true}
+	)
+
+	_, err := Init(WithDir(dir), WithChangeSink(nil))
+	assert.Error(err)
+
+	brl, err := Init(WithDir(dir), WithChangeSink(sink))
+	assert.NoError(err)
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.PutEx("key-2", []byte("val-2"), time.Hour))
+	assert.NoError(brl.Delete("key-1"))
+
+	// The batch is published again once the sink recovers from the failure.
+	want := []string{"0 set key-1=val-1", "1 set key-2=val-2", "2 del key-1="}
+	assert.Eventually(func() bool { return len(sink.ops()) == 3 }, 3*time.Second, time.Millisecond*10)
+	assert.Equal(want, sink.ops())
+	sink.Lock()
+	assert.NotZero(sink.changes[1].Expiry)
+	assert.NotZero(sink.changes[1].Timestamp)
+	sink.Unlock()
+	stats := brl.Stats()
+	assert.True(stats.ChangeFeed)
+	assert.Equal(uint64(1), stats.ChangesFailed)
+	assert.Equal(uint64(3), stats.ChangeOffset)
+	assert.False(stats.ChangeFailing)
+	assert.NoError(brl.Shutdown())
+
+	// The changes resume after the ones acknowledged before the restart.
+	sink = &testSink{}
+	brl, err = Init(WithDir(dir), WithChangeSink(sink))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+	assert.Eventually(func() bool { return len(sink.ops()) == 1 }, time.Second, time.Millisecond*10)
+	assert.Equal([]string{"3 set key-3=val-3"}, sink.ops())
+	assert.Equal(uint64(4), brl.Stats().ChangeOffset)
+}
+
+func TestQuota(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		size   = recordSize("tenant-a:1", []byte("val"))
+		quotas = []Config{
+			WithDir(dir),
+			WithQuota(Quota{Prefix: "tenant-a:", MaxKeys: 2}),
+			WithQuota(Quota{Prefix: "tenant-b:", MaxBytes: 3 * size, Policy: QuotaEvictOldest}),
+		}
+	)
+
+	_, err := Init(WithDir(dir), WithQuota(Quota{Prefix: "tenant-a:"}))
+	assert.Error(err)
+
+	brl, err := Init(quotas...)
+	assert.NoError(err)
+
+	// Writes exceeding the quota are rejected, but the existing keys can be overwritten.
+	assert.NoError(brl.Put("tenant-a:1", []byte("val")))
+	assert.NoError(brl.Put("tenant-a:2", []byte("val")))
+	assert.ErrorIs(brl.Put("tenant-a:3", []byte("val")), ErrQuotaExceeded)
+	assert.NoError(brl.Put("tenant-a:2", []byte("new")))
+	assert.NoError(brl.Put("other", []byte("val")))
+
+	// The oldest keys are evicted to stay within the quota.
+	for i := 1; i <= 5; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("tenant-b:%d", i), []byte("val")))
+	}
+	_, err = brl.Get("tenant-b:5")
+	assert.NoError(err)
+
+	check := func(brl *Barrel) {
+		stats := brl.Stats()
+		assert.Len(stats.Quotas, 2)
+		assert.Equal(2, stats.Quotas[0].Keys)
+		assert.Equal(2*size, stats.Quotas[0].Bytes)
+		assert.Equal(3, stats.Quotas[1].Keys)
+		assert.Equal(3*size, stats.Quotas[1].Bytes)
+	}
+	check(brl)
+	assert.Equal(uint64(1), brl.Stats().QuotaRejected)
+	assert.Equal(uint64(2), brl.Stats().EvictedKeys)
+	assert.NoError(brl.Shutdown())
+
+	// The usage is accounted on startup.
+	brl, err = Init(quotas...)
+	assert.NoError(err)
+	defer brl.Shutdown()
+	check(brl)
+	assert.NoError(brl.Delete("tenant-a:1"))
+	assert.NoError(brl.Put("tenant-a:3", []byte("val")))
+}
+
+func TestDropAll(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	// Write the keys across multiple datafiles.
+	for i := 0; i < 2; i++ {
+		brl, err := Init(WithDir(dir))
+		assert.NoError(err)
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte("val")))
+		assert.NoError(brl.PutWithTags(fmt.Sprintf("tagged-%d", i), []byte("val"), []string{"tag"}))
+		assert.NoError(brl.Shutdown())
+	}
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.Equal(4, brl.Len())
+
+	assert.NoError(brl.DropAll())
+	assert.Zero(brl.Len())
+	assert.Empty(brl.KeysByTag("tag"))
+	_, err = brl.Get("key-0")
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	// The older datafiles are deleted in background.
+	assert.Eventually(func() bool {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+droppedSuffix))
+		return err == nil && len(files) == 0
+	}, time.Second, time.Millisecond*10)
+	files, err := getDataFiles(dir)
+	assert.NoError(err)
+	assert.Len(files, 1)
+
+	// The new writes are retained after a restart, but not the dropped keys.
+	assert.NoError(brl.Put("key-2", []byte("val")))
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	assert.Equal([]string{"key-2"}, brl.List())
+
+	// Shutdown waits for the dropped files to be deleted.
+	assert.NoError(brl.DropAll())
+	assert.NoError(brl.Shutdown())
+	files, err = filepath.Glob(filepath.Join(dir, "*"+droppedSuffix))
+	assert.NoError(err)
+	assert.Empty(files)
+}
+
+func TestSample(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.Empty(brl.Sample(3))
+	_, err = brl.RandomKey()
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	keys := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+		assert.NoError(brl.Put(keys[i], []byte("val")))
+	}
+	// Expired keys are never sampled.
+	assert.NoError(brl.PutEx("expired", []byte("val"), -time.Second))
+
+	sample := brl.Sample(3)
+	assert.Len(sample, 3)
+	assert.Subset(keys, sample)
+	assert.ElementsMatch(keys, brl.Sample(100))
+
+	// Every key is eventually picked.
+	picked := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		k, err := brl.RandomKey()
+		assert.NoError(err)
+		picked[k] = true
+	}
+	assert.Len(picked, 10)
+}
+
+func TestRename(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.ErrorIs(brl.Rename("missing", "dst"), ErrKeyNotFound)
+
+	// The expiry and the tags are retained.
+	assert.NoError(brl.PutWithTags("src", []byte("val"), []string{"tag"}))
+	assert.NoError(brl.Rename("src", "dst"))
+	_, err = brl.Get("src")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	val, err := brl.Get("dst")
+	assert.NoError(err)
+	assert.Equal("val", string(val))
+	assert.Equal([]string{"dst"}, brl.KeysByTag("tag"))
+
+	assert.NoError(brl.PutEx("ttl", []byte("val"), time.Hour))
+	assert.NoError(brl.Rename("ttl", "ttl-2"))
+	assert.InDelta(time.Now().Add(time.Hour).Unix(), brl.keydir.meta("ttl-2").Expiry, 1)
+
+	// RenameNX doesn't overwrite an existing key.
+	assert.NoError(brl.Put("other", []byte("other")))
+	renamed, err := brl.RenameNX("dst", "other")
+	assert.NoError(err)
+	assert.False(renamed)
+	val, err = brl.Get("other")
+	assert.NoError(err)
+	assert.Equal("other", string(val))
+
+	renamed, err = brl.RenameNX("dst", "new")
+	assert.NoError(err)
+	assert.True(renamed)
+	assert.ElementsMatch([]string{"new", "other", "ttl-2"}, brl.List())
+}
+
+func TestGetSet(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	old, err := brl.GetSet("key", []byte("val-1"))
+	assert.NoError(err)
+	assert.Nil(old)
+
+	// The expiry is removed by GetSet.
+	assert.NoError(brl.PutEx("key", []byte("val-2"), time.Hour))
+	old, err = brl.GetSet("key", []byte("val-3"))
+	assert.NoError(err)
+	assert.Equal("val-2", string(old))
+	assert.Zero(brl.keydir.meta("key").Expiry)
+
+	// GetEx updates the expiry and retains the value and the tags.
+	_, err = brl.GetEx("missing", time.Hour)
+	assert.ErrorIs(err, ErrKeyNotFound)
+	assert.NoError(brl.PutWithTags("tagged", []byte("val"), []string{"tag"}))
+	val, err := brl.GetEx("tagged", time.Hour)
+	assert.NoError(err)
+	assert.Equal("val", string(val))
+	assert.InDelta(time.Now().Add(time.Hour).Unix(), brl.keydir.meta("tagged").Expiry, 1)
+	assert.Equal([]string{"tagged"}, brl.KeysByTag("tag"))
+
+	val, err = brl.GetEx("tagged", 0)
+	assert.NoError(err)
+	assert.Equal("val", string(val))
+	assert.Zero(brl.keydir.meta("tagged").Expiry)
+}
+
+func TestInspect(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	_, err = brl.Inspect("missing")
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	assert.NoError(brl.Put("key", []byte("val")))
+	info, err := brl.Inspect("key")
+	assert.NoError(err)
+	assert.Equal(TypeString, info.Type)
+	assert.Equal(EncodingRaw, info.Encoding)
+	assert.WithinDuration(time.Now(), info.Accessed, time.Second)
+	assert.WithinDuration(time.Now(), info.Written, time.Second)
+	assert.True(info.Expiry.IsZero())
+
+	assert.NoError(brl.PutEx("expiring", []byte("val"), time.Hour))
+	info, err = brl.Inspect("expiring")
+	assert.NoError(err)
+	assert.WithinDuration(time.Now().Add(time.Hour), info.Expiry, time.Second)
+
+	// The access time is that of the last write until the key is read.
+	meta := brl.keydir.meta("key")
+	meta.Timestamp, meta.Accessed = int(time.Now().Add(-time.Hour).Unix()), 0
+	brl.keydir.set("key", meta)
+	info, err = brl.Inspect("key")
+	assert.NoError(err)
+	assert.WithinDuration(time.Now().Add(-time.Hour), info.Accessed, time.Second)
+	_, err = brl.Get("key")
+	assert.NoError(err)
+	info, err = brl.Inspect("key")
+	assert.NoError(err)
+	assert.WithinDuration(time.Now(), info.Accessed, time.Second)
+
+	_, err = brl.StreamAdd("events", nil, []string{"field", "val"})
+	assert.NoError(err)
+	info, err = brl.Inspect("events")
+	assert.NoError(err)
+	assert.Equal(TypeStream, info.Type)
+
+	t.Run("Idle", func(t *testing.T) {
+		clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+		brl, err := Init(WithDir(t.TempDir()), WithClock(clock))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		// The idle time is measured by the clock of the barrel.
+		assert.NoError(brl.Put("key", []byte("val")))
+		clock.advance(time.Hour)
+		info, err := brl.Inspect("key")
+		assert.NoError(err)
+		assert.Equal(time.Hour, info.Idle)
+
+		_, err = brl.Get("key")
+		assert.NoError(err)
+		clock.advance(time.Minute)
+		info, err = brl.Inspect("key")
+		assert.NoError(err)
+		assert.Equal(time.Minute, info.Idle)
+
+		// It's never negative if the clock is set back.
+		clock.advance(-time.Hour)
+		info, err = brl.Inspect("key")
+		assert.NoError(err)
+		assert.Zero(info.Idle)
+	})
+}
+
+func TestSetRange(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()), WithMaxValueSize(16))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// The missing key is padded with zero bytes.
+	n, err := brl.SetRange("key", 2, []byte("ab"))
+	assert.NoError(err)
+	assert.Equal(4, n)
+	val, err := brl.Get("key")
+	assert.NoError(err)
+	assert.Equal([]byte("\x00\x00ab"), val)
+
+	// The value is overwritten in place and the expiry is retained.
+	assert.NoError(brl.PutEx("key", []byte("hello world"), time.Hour))
+	n, err = brl.SetRange("key", 6, []byte("redis"))
+	assert.NoError(err)
+	assert.Equal(11, n)
+	val, err = brl.Get("key")
+	assert.NoError(err)
+	assert.Equal("hello redis", string(val))
+	assert.NotZero(brl.keydir.meta("key").Expiry)
+
+	n, err = brl.SetRange("key", 0, nil)
+	assert.NoError(err)
+	assert.Equal(11, n)
+	n, err = brl.SetRange("missing", 0, nil)
+	assert.NoError(err)
+	assert.Zero(n)
+	assert.NotContains(brl.List(), "missing")
+
+	_, err = brl.SetRange("key", -1, []byte("a"))
+	assert.ErrorIs(err, ErrInvalidOffset)
+	_, err = brl.SetRange("key", 16, []byte("a"))
+	assert.ErrorIs(err, ErrLargeValue)
+
+	// The end of the range is checked without overflowing.
+	_, err = brl.SetRange("key", math.MaxInt64, []byte("x"))
+	assert.ErrorIs(err, ErrLargeValue)
+	_, err = brl.SetRange("key", math.MaxInt64-1, []byte("xy"))
+	assert.ErrorIs(err, ErrLargeValue)
+	_, err = brl.SetRange("key", 0, make([]byte, 17))
+	assert.ErrorIs(err, ErrLargeValue)
+	n, err = brl.SetRange("key", 15, []byte("a"))
+	assert.NoError(err)
+	assert.Equal(16, n)
+}
+
+func TestBitmap(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	bit, err := brl.GetBit("missing", 7)
+	assert.NoError(err)
+	assert.Zero(bit)
+
+	old, err := brl.SetBit("bits", 7, 1)
+	assert.NoError(err)
+	assert.Zero(old)
+	old, err = brl.SetBit("bits", 7, 1)
+	assert.NoError(err)
+	assert.Equal(1, old)
+	_, err = brl.SetBit("bits", 17, 1)
+	assert.NoError(err)
+	val, err := brl.Get("bits")
+	assert.NoError(err)
+	assert.Equal([]byte{0x01, 0x00, 0x40}, val)
+
+	n, err := brl.BitCount("bits", 0, -1)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	n, err = brl.BitCount("bits", 1, -1)
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	// Only the required bytes are read for large values.
+	large := make([]byte, partialReadSize)
+	large[len(large)-1] = 0xff
+	assert.NoError(brl.Put("large", large))
+	bit, err = brl.GetBit("large", len(large)*8-1)
+	assert.NoError(err)
+	assert.Equal(1, bit)
+	bit, err = brl.GetBit("large", len(large)*8)
+	assert.NoError(err)
+	assert.Zero(bit)
+	n, err = brl.BitCount("large", -2, -1)
+	assert.NoError(err)
+	assert.Equal(8, n)
+	n, err = brl.BitCount("large", 0, -2)
+	assert.NoError(err)
+	assert.Zero(n)
+
+	// The offsets are checked against the max value size without overflowing.
+	brl, err = Init(WithDir(t.TempDir()), WithMaxValueSize(2))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	_, err = brl.SetBit("bits", 15, 1)
+	assert.NoError(err)
+	_, err = brl.SetBit("bits", 16, 1)
+	assert.ErrorIs(err, ErrLargeValue)
+	_, err = brl.SetBit("bits", math.MaxInt64, 1)
+	assert.ErrorIs(err, ErrLargeValue)
+}
+
+func TestReload(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.NoError(brl.PutWithTags("key-1", []byte("val-1"), []string{"tag"}))
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(dir), WithQuota(Quota{Prefix: "key-", MaxKeys: 10}))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+	assert.NoError(brl.Delete("key-3"))
+
+	info, err := brl.Inspect("key-2")
+	assert.NoError(err)
+	assert.Equal(brl.df.ID(), info.FileID)
+	assert.Equal(recordSize("key-2", []byte("val-2")), info.Size)
+
+	// The keydir rebuilt from the hints and the datafiles is the same.
+	keydir := brl.keydir
+	assert.NoError(brl.Reload())
+	assert.Equal(keydir.len(), brl.keydir.len())
+	keydir.each(func(k string, meta Meta) bool {
+		meta.Accessed, meta.Version = 0, 0
+		assert.Equal(meta, brl.keydir.meta(k))
+		return true
+	})
+	assert.Equal([]string{"key-1"}, brl.KeysByTag("tag"))
+	assert.Equal(2, brl.Stats().Quotas[0].Keys)
+
+	// The keys are retained by a compaction.
+	assert.NoError(brl.Compact())
+	val, err := brl.Get("key-1")
+	assert.NoError(err)
+	assert.Equal("val-1", string(val))
+}
+
+func TestRebuildHints(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.NoError(brl.PutWithTags("key-1", []byte("val-1"), []string{"tag"}))
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	assert.NoError(brl.Delete("key-2"))
+
+	// The directory can't be rebuilt while it's in use.
+	_, err = RebuildHints(dir)
+	assert.ErrorIs(err, ErrLocked)
+	assert.NoError(brl.Shutdown())
+
+	// Overwrite the hints with ones which can be decoded but miss the keys.
+	fi, err := os.Stat(filepath.Join(dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, brl.df.ID())))
+	assert.NoError(err)
+	hints := newHints(brl.df.ID())
+	hints.Offset = int(fi.Size())
+	assert.NoError(hints.Encode(hintsPath(dir, brl.df.ID())))
+
+	// The datafiles which aren't listed in the manifest, e.g. the leftovers of a merge, are skipped.
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, brl.df.ID())))
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(filepath.Join(dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, brl.df.ID()+1)), data, 0644))
+
+	n, err := RebuildHints(dir)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.False(exists(hintsPath(dir, brl.df.ID()+1)))
+
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	val, err := brl.Get("key-1")
+	assert.NoError(err)
+	assert.Equal("val-1", string(val))
+	assert.Equal([]string{"key-1"}, brl.KeysByTag("tag"))
+	_, err = brl.Get("key-2")
+	assert.ErrorIs(err, ErrKeyNotFound)
+}
+
+func TestScanSegment(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.NoError(brl.PutWithTags("key-1", []byte("val-1"), []string{"tag"}))
+	assert.NoError(brl.Delete("key-1"))
+	id := brl.df.ID()
+	assert.NoError(brl.Shutdown())
+
+	var records []SegmentRecord
+	version, err := ScanSegment(filepath.Join(dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, id)), func(r SegmentRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(recordVersion, version)
+	assert.Len(records, 2)
+	assert.Equal("key-1", records[0].Key)
+	assert.Equal([]string{"tag"}, records[0].Tags)
+	assert.Equal("val-1", string(records[0].Value))
+	assert.True(records[0].Valid)
+	assert.Equal(records[0].Offset+records[0].Size, records[1].Offset)
+	assert.Zero(records[1].Header.ValSize)
+
+	_, err = ScanSegment(filepath.Join(dir, "missing.db"), func(SegmentRecord) error { return nil })
+	assert.Error(err)
+}
+
+// writeBitcaskRecord appends a record in the format of the Erlang Bitcask to the buffer.
+func writeBitcaskRecord(buf *bytes.Buffer, ts uint32, k, val string) {
+	record := make([]byte, 14, 14+len(k)+len(val))
+	binary.BigEndian.PutUint32(record[4:8], ts)
+	binary.BigEndian.PutUint16(record[8:10], uint16(len(k)))
+	binary.BigEndian.PutUint32(record[10:14], uint32(len(val)))
+	record = append(append(record, k...), val...)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	buf.Write(record)
+}
+
+func TestBitcaskCompat(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		ts     = uint32(time.Now().Add(-time.Hour).Unix())
+	)
+
+	var first, second bytes.Buffer
+	writeBitcaskRecord(&first, ts, "key-1", "val-1")
+	writeBitcaskRecord(&first, ts, "key-2", "val-2")
+	writeBitcaskRecord(&first, ts, "key-3", "val-3")
+	writeBitcaskRecord(&second, ts, "key-1", "new-1")
+	writeBitcaskRecord(&second, ts, "key-2", "bitcask_tombstone2\x00\x00\x00\x01")
+	assert.NoError(os.WriteFile(filepath.Join(dir, "1.bitcask.data"), first.Bytes(), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "2.bitcask.data"), second.Bytes(), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "2.bitcask.hint"), []byte("ignored"), 0644))
+
+	// The Bitcask files are ignored unless the compatibility mode is enabled.
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.Equal(0, brl.Len())
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(dir), WithBitcaskCompat())
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"key-1", "key-3"}, brl.List())
+	val, err := brl.Get("key-1")
+	assert.NoError(err)
+	assert.Equal("new-1", string(val))
+	_, err = brl.Get("key-2")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	info, err := brl.Inspect("key-3")
+	assert.NoError(err)
+	assert.Equal(int64(ts), info.Written.Unix())
+
+	// The records of Bitcask pass the checksum validation.
+	corrupt, err := brl.scrubDF(brl.stale[1], 0)
+	assert.NoError(err)
+	assert.Zero(corrupt)
+
+	// The writes go to the datafiles of barrel and a compaction migrates the Bitcask files.
+	assert.NoError(brl.Put("key-4", []byte("val-4")))
+	assert.NoError(brl.Compact())
+	assert.NoError(brl.Shutdown())
+	files, err := filepath.Glob(filepath.Join(dir, "*.bitcask.*"))
+	assert.NoError(err)
+	assert.Empty(files)
+
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.ElementsMatch([]string{"key-1", "key-3", "key-4"}, brl.List())
+	val, err = brl.Get("key-3")
+	assert.NoError(err)
+	assert.Equal("val-3", string(val))
+}
+
+func TestInMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Init(WithInMemory(), WithReadOnly())
+	assert.Error(err)
+
+	brl, err := Init(WithInMemory(), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	dir := brl.opts.dir
+	assert.Equal(memDir(), filepath.Dir(dir))
+
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.Put("key-1", []byte("val-2")))
+	assert.NoError(brl.rotateDF())
+	assert.NoError(brl.Put("key-2", []byte("val-3")))
+	assert.Equal(2, brl.Stats().DataFiles)
+	assert.NoError(brl.Compact())
+	val, err := brl.Get("key-1")
+	assert.NoError(err)
+	assert.Equal("val-2", string(val))
+
+	// Neither a lockfile nor hints are written.
+	assert.NoFileExists(filepath.Join(dir, LOCKFILE))
+	hints, err := filepath.Glob(filepath.Join(dir, "*.hints"))
+	assert.NoError(err)
+	assert.Empty(hints)
+
+	assert.NoError(brl.Shutdown())
+	assert.NoDirExists(dir)
+}
+
+// testClock is a clock which only moves when advanced.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestClock(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		clock  = &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	)
+
+	_, err := Init(WithDir(t.TempDir()), WithClock(nil))
+	assert.Error(err)
+
+	brl, err := Init(WithDir(t.TempDir()), WithClock(clock))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	assert.NoError(brl.PutEx("key-1", []byte("val-1"), time.Hour))
+	assert.NoError(brl.PutEx("key-2", []byte("val-2"), time.Hour*2))
+	info, err := brl.Inspect("key-1")
+	assert.NoError(err)
+	assert.Equal(clock.now.Unix(), info.Written.Unix())
+	assert.Equal(clock.now.Add(time.Hour).Unix(), info.Expiry.Unix())
+
+	// The keys expire as the clock advances, without waiting.
+	clock.advance(time.Hour + time.Second)
+	_, err = brl.Get("key-1")
+	assert.ErrorIs(err, ErrExpiredKey)
+	val, err := brl.Get("key-2")
+	assert.NoError(err)
+	assert.Equal("val-2", string(val))
+
+	// The reaper purges the keys as per the clock too.
+	clock.advance(time.Hour)
+	brl.Lock()
+	assert.NoError(brl.cleanupExpired())
+	brl.Unlock()
+	assert.Equal(0, brl.Len())
+}
+
+func TestReadOnly(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	listDir := func() []string {
+		entries, err := os.ReadDir(dir)
+		assert.NoError(err)
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	// An empty directory is served without creating any file.
+	brl, err := Init(WithDir(dir), WithReadOnly())
+	assert.NoError(err)
+	assert.Equal(0, brl.Len())
+	assert.NoError(brl.Reload())
+	assert.NoError(brl.Shutdown())
+	assert.Empty(listDir())
+
+	brl, err = Init(WithDir(dir), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.rotateDF())
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	assert.NoError(brl.Shutdown())
+	files := listDir()
+
+	// The latest datafile is served as the active datafile, and no file is created or written to.
+	brl, err = Init(WithDir(dir), WithReadOnly())
+	assert.NoError(err)
+	for k, want := range map[string]string{"key-1": "val-1", "key-2": "val-2"} {
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(want, string(val))
+	}
+	assert.ErrorIs(brl.Put("key-3", []byte("val-3")), ErrReadOnly)
+	assert.Equal(2, brl.Stats().DataFiles)
+	assert.NoError(brl.Reload())
+	assert.Equal(2, brl.Len())
+	assert.NoError(brl.Sync())
+	assert.NoError(brl.Shutdown())
+	assert.Equal(files, listDir())
+}
+
+func TestManualMaintenance(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		val    = bytes.Repeat([]byte("v"), 128)
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithManualMaintenance(), WithMaxActiveFileSize(64), WithCheckFileSizeInterval(time.Millisecond))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// The active datafile isn't rotated in the background.
+	assert.NoError(brl.Put("key-1", val))
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(1, brl.Stats().DataFiles)
+
+	assert.NoError(brl.Maintain(context.Background()))
+	assert.Equal(2, brl.Stats().DataFiles)
+
+	// The two older datafiles are merged by the compaction.
+	assert.NoError(brl.Put("key-2", val))
+	assert.NoError(brl.rotateDF())
+	assert.Equal(3, brl.Stats().DataFiles)
+	assert.NoError(brl.CompactOnce())
+	assert.Equal(1, brl.Stats().DataFiles)
+	assert.Equal(2, brl.Len())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(brl.Maintain(ctx), context.Canceled)
+}
+
+func TestShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	before := runtime.NumGoroutine()
+	brl, err := Init(WithDir(t.TempDir()), WithBackgrondSync(time.Millisecond), WithCheckFileSizeInterval(time.Millisecond),
+		WithScrubber(time.Millisecond, 1), WithExpiryCallback(func(string, []byte) {}))
+	assert.NoError(err)
+	assert.NoError(brl.Put("key", []byte("val")))
+	assert.NoError(brl.rotateDF())
+
+	// The background goroutines are stopped by the shutdown, even while scrubbing at a low rate.
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(brl.Shutdown())
+	assert.LessOrEqual(runtime.NumGoroutine(), before)
+
+	// The barrel can be shut down more than once.
+	assert.NoError(brl.Close())
+	assert.NoError(brl.Shutdown())
+}
+
+func TestCompactionSchedule(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		clock  = &testClock{now: time.Date(2020, 1, 1, 0, 30, 0, 0, time.Local)}
+	)
+
+	for _, w := range [][2]string{{"1am", "05:00"}, {"01:00", "01:00"}, {"01:00", "25:00"}} {
+		_, err := Init(WithDir(t.TempDir()), WithCompactionWindow(w[0], w[1]))
+		assert.Error(err, w)
+	}
+	_, err := Init(WithDir(t.TempDir()), WithCompactionAmplification(0.5))
+	assert.Error(err)
+
+	brl, err := Init(WithDir(t.TempDir()), WithClock(clock), WithManualMaintenance(),
+		WithCompactionWindow("01:00", "05:00"), WithCompactionWindow("23:00", "00:15"))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for _, c := range []struct {
+		at  string
+		due bool
+	}{{"00:30", false}, {"01:00", true}, {"04:59", true}, {"05:00", false}, {"23:30", true}, {"00:10", true}} {
+		at, err := time.ParseInLocation("15:04", c.at, time.Local)
+		assert.NoError(err)
+		clock.now = time.Date(2020, 1, 1, at.Hour(), at.Minute(), 0, 0, time.Local)
+		assert.Equal(c.due, brl.compactionDue(), c.at)
+	}
+
+	brl, err = Init(WithDir(t.TempDir()), WithManualMaintenance(), WithCompactionAmplification(2))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// The compaction is due once half of the disk usage is stale records.
+	assert.NoError(brl.Put("key", []byte("val-1")))
+	assert.False(brl.compactionDue())
+	assert.NoError(brl.Put("key", []byte("val-2")))
+	assert.NoError(brl.Put("key", []byte("val-3")))
+	assert.True(brl.compactionDue())
+}
+
+func TestManifest(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	assert.NoError(brl.Delete("key-2"))
+	assert.NoError(brl.Shutdown())
+
+	// Keep a copy of the datafile, which is replaced by the merge.
+	old, err := os.ReadFile(filepath.Join(dir, "barrel_0.db"))
+	assert.NoError(err)
+
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	id := brl.df.ID()
+	assert.NoError(brl.Compact())
+	assert.NoError(brl.Shutdown())
+
+	man, err := loadManifest(dir)
+	assert.NoError(err)
+	assert.Len(man.Segments, 1)
+	assert.Equal(id+1, man.Segments[0].ID)
+
+	// Leave the files of a merge which was interrupted before and after the manifest was replaced.
+	assert.NoError(os.WriteFile(filepath.Join(dir, "barrel_0.db"), old, 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, fmt.Sprintf("barrel_%d.db", id+2)), old, 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(dir, mergeDirPrefix+"1"), 0755))
+
+	// Only the datafiles listed in the manifest are loaded, and the others are removed.
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	assert.Equal(1, brl.Len())
+	_, err = brl.Get("key-2")
+	assert.ErrorIs(err, ErrNoKey)
+	assert.Equal(id+2, brl.df.ID())
+	assert.NoError(brl.Shutdown())
+
+	files, err := getDataFiles(dir)
+	assert.NoError(err)
+	assert.Equal([]string{
+		filepath.Join(dir, fmt.Sprintf("barrel_%d.db", id+1)),
+		filepath.Join(dir, fmt.Sprintf("barrel_%d.db", id+2)),
+	}, files)
+	assert.NoDirExists(filepath.Join(dir, mergeDirPrefix+"1"))
+
+	// A datafile listed in the manifest must be present.
+	assert.NoError(os.Remove(filepath.Join(dir, fmt.Sprintf("barrel_%d.db", id+1))))
+	_, err = Init(WithDir(dir))
+	assert.Error(err)
+}
+
+func TestSegments(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		clock  = &testClock{now: time.Unix(1000, 0)}
+	)
+
+	// ranges returns the segments without their usage, which is tested by TestDeadBytes.
+	ranges := func(brl *Barrel) []SegmentInfo {
+		segments := brl.Segments()
+		for i := range segments {
+			segments[i].LiveBytes, segments[i].DeadBytes = 0, 0
+		}
+		return segments
+	}
+
+	brl, err := Init(WithDir(dir), WithClock(clock), WithManualMaintenance())
+	assert.NoError(err)
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+	clock.advance(time.Minute)
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+	assert.NoError(brl.Shutdown())
+
+	// The records are numbered across the restarts, and the ranges of the older datafiles are loaded from the manifest.
+	brl, err = Init(WithDir(dir), WithClock(clock), WithManualMaintenance())
+	assert.NoError(err)
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+	assert.Equal([]SegmentInfo{
+		{ID: 0, FirstSeq: 0, EndSeq: 2, MinTime: 1000, MaxTime: 1060, Records: 2, MinKey: "key-1", MaxKey: "key-2"},
+		{ID: 1, FirstSeq: 2, EndSeq: 3, MinTime: 1060, MaxTime: 1060, Records: 1, MinKey: "key-3", MaxKey: "key-3"},
+	}, ranges(brl))
+
+	// Without a clean shutdown, the records written to the active datafile after the manifest are counted on startup.
+	assert.NoError(brl.Put("key-4", []byte("val-4")))
+	assert.NoError(brl.Sync())
+	assert.NoError(brl.df.Close())
+	assert.NoError(destroyFlockFile(brl.flockF))
+
+	brl, err = Init(WithDir(dir), WithClock(clock), WithManualMaintenance())
+	assert.NoError(err)
+	segments := ranges(brl)
+	assert.Len(segments, 3)
+	assert.Equal(SegmentInfo{ID: 1, FirstSeq: 2, EndSeq: 4, MinTime: 1060, MaxTime: 1060, Records: 2, MinKey: "key-3", MaxKey: "key-4"}, segments[1])
+
+	// The merged datafiles cover all the sequence numbers so far.
+	assert.NoError(brl.Put("key-5", []byte("val-5")))
+	assert.NoError(brl.Compact())
+	segments = brl.Segments()
+	assert.Len(segments, 1)
+	assert.Equal(uint64(0), segments[0].FirstSeq)
+	assert.Equal(uint64(5), segments[0].EndSeq)
+	assert.Equal(uint64(5), segments[0].Records)
+	assert.Equal("key-1", segments[0].MinKey)
+	assert.Equal("key-5", segments[0].MaxKey)
+	assert.NoError(brl.Shutdown())
+
+	man, err := loadManifest(dir)
+	assert.NoError(err)
+	assert.True(man.Clean)
+	assert.Equal(uint64(5), man.NextSeq)
+}
+
+func TestDeadBytes(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		val    = []byte(strings.Repeat("v", 100))
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithManualMaintenance())
+	assert.NoError(err)
+	for i := 0; i < 4; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), val))
+	}
+	segments := brl.Segments()
+	assert.Equal(brl.Stats().LiveBytes, segments[0].LiveBytes)
+	assert.Equal(0, segments[0].DeadBytes)
+
+	// The overwritten and deleted records are dead, as well as the tombstones.
+	assert.NoError(brl.Put("key-0", val))
+	assert.NoError(brl.Delete("key-1"))
+	stats := brl.Stats()
+	segments = brl.Segments()
+	assert.Equal(stats.LiveBytes, segments[0].LiveBytes)
+	assert.Equal(stats.DiskBytes-stats.LiveBytes-datafile.SegmentHeaderSize, segments[0].DeadBytes)
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(t.TempDir()), WithManualMaintenance(), WithMaxActiveFileSize(1000), WithCompactionDeadRatio(0.5))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// The older datafiles without dead records aren't merged.
+	for i := 0; i < 18; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), val))
+		assert.NoError(brl.Maintain(context.Background()))
+	}
+	assert.Equal(3, brl.Stats().DataFiles)
+
+	// The active datafile is rotated at half the max size once half of it is dead, and merged right away.
+	assert.NoError(brl.Put("key-x", val))
+	for i := 0; i < 4; i++ {
+		assert.NoError(brl.Put("key-x", val))
+	}
+	assert.NoError(brl.Maintain(context.Background()))
+	assert.Equal(1, brl.Stats().DataFiles)
+	segments = brl.Segments()
+	assert.Len(segments, 1)
+	assert.Equal(0, segments[0].DeadBytes)
+	assert.Equal(19, brl.Len())
+}
+
+func TestCompactOnStartup(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	_, err := Init(WithDir(dir), WithCompactOnStartup(1))
+	assert.Error(err)
+
+	// Leave most of the records stale, like a bulk import which overwrites the keys.
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			assert.NoError(brl.Put(fmt.Sprintf("key-%d", j), []byte(fmt.Sprintf("val-%d", i))))
+		}
+	}
+	assert.NoError(brl.Shutdown())
+
+	// The datafiles aren't merged below the stale ratio.
+	brl, err = Init(WithDir(dir), WithCompactOnStartup(0.9))
+	assert.NoError(err)
+	assert.Equal(2, brl.Stats().DataFiles)
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(dir), WithCompactOnStartup(0.5))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	stats := brl.Stats()
+	assert.Equal(1, stats.DataFiles)
+	assert.Equal(stats.LiveBytes+datafile.SegmentHeaderSize, stats.DiskBytes)
+	got, err := brl.Get("key-3")
+	assert.NoError(err)
+	assert.Equal([]byte("val-3"), got)
+}
+
+func TestHotKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	_, _, err = brl.HotKeys(10)
+	assert.ErrorIs(err, ErrHotKeysDisabled)
+	assert.NoError(brl.Shutdown())
+
+	brl, err = Init(WithDir(t.TempDir()), WithHotKeys(1, 3))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(brl.Put("hot", []byte("val")))
+	}
+	assert.NoError(brl.Put("warm", []byte("val")))
+	for i := 0; i < 5; i++ {
+		_, err := brl.Get("hot")
+		assert.NoError(err)
+	}
+	_, err = brl.Get("warm")
+	assert.NoError(err)
+	_, err = brl.GetMulti([]string{"warm", "missing"})
+	assert.NoError(err)
+
+	reads, writes, err := brl.HotKeys(10)
+	assert.NoError(err)
+	assert.Len(reads, 2)
+	assert.Equal("hot", reads[0].Key)
+	assert.Equal(uint64(5), reads[0].Reads)
+	assert.Equal(uint64(2), reads[1].Reads)
+	assert.Len(writes, 2)
+	assert.Equal("hot", writes[0].Key)
+	assert.Equal(uint64(3), writes[0].Writes)
+
+	// Once the capacity is reached, a new key replaces the least accessed key and takes over its counts.
+	assert.NoError(brl.Put("cold", []byte("val")))
+	assert.NoError(brl.Delete("new"))
+	reads, writes, err = brl.HotKeys(1)
+	assert.NoError(err)
+	assert.Len(reads, 1)
+	assert.Equal("hot", reads[0].Key)
+	assert.Len(writes, 1)
+
+	_, writes, err = brl.HotKeys(10)
+	assert.NoError(err)
+	keys := make([]string, 0, len(writes))
+	for _, a := range writes {
+		keys = append(keys, a.Key)
+	}
+	assert.ElementsMatch([]string{"hot", "warm", "new"}, keys)
+}
+
+func TestConcurrentReads(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()), WithMaxActiveFileSize(1024), WithMmapReads(), WithMaxDataSize(1<<20, AllKeysLRU))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte("val")))
+	}
+
+	// Read the older datafiles while they're rewritten and closed by the merges.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				val, err := brl.Get(fmt.Sprintf("key-%d", j%50))
+				assert.NoError(err)
+				assert.Equal([]byte("val"), val)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 50; j += 5 {
+			assert.NoError(brl.Put(fmt.Sprintf("key-%d", j), []byte("val")))
+		}
+		assert.NoError(brl.merge(context.Background()))
+	}
+	wg.Wait()
+
+	// The accesses by the reads are recorded in the keydir.
+	_, err = brl.Inspect("key-1")
+	assert.NoError(err)
+	assert.NotZero(brl.keydir.meta("key-1").Accessed)
+	assert.NotZero(brl.accessed["key-1"])
+}
+
+func TestListDuringReads(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte("val")))
+	}
+
+	// List and Len only take the read lock, so they aren't blocked by the reads in progress.
+	brl.RLock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Len(brl.List(), 10)
+		assert.Equal(10, brl.Len())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("List blocked by a read")
+	}
+	brl.RUnlock()
+	<-done
+}
+
+func TestListPage(t *testing.T) {
+	assert := assert.New(t)
+
+	brl, err := Init(WithDir(t.TempDir()))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	for i := 0; i < 25; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%02d", i), []byte("val")))
+	}
+
+	var (
+		keys   []string
+		cursor string
+		pages  int
+	)
+	for {
+		page, next := brl.ListPage(cursor, 10)
+		keys = append(keys, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		assert.Equal(page[len(page)-1], next)
+		cursor = next
+	}
+	assert.Equal(3, pages)
+	assert.Len(keys, 25)
+	assert.True(sort.StringsAreSorted(keys))
+	assert.Equal("key-00", keys[0])
+
+	// The last page is recognised when it's full as well.
+	page, next := brl.ListPage("key-14", 10)
+	assert.Len(page, 10)
+	assert.Empty(next)
+
+	page, next = brl.ListPage("", 0)
+	assert.Empty(page)
+	assert.Empty(next)
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	_, err = brl.RangeScan("a", "b")
+	assert.ErrorIs(err, ErrSortedKeysDisabled)
+	for _, k := range []string{"user:3", "user:1", "order:1", "user:2", "zone"} {
+		assert.NoError(brl.Put(k, []byte("val")))
+	}
+	assert.NoError(brl.Shutdown())
+
+	// The sorted keys are built from the keydir on startup.
+	clock := &testClock{now: time.Now()}
+	brl, err = Init(WithDir(dir), WithSortedKeys(), WithClock(clock))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	keys, err := brl.RangeScan("user:", "user;")
+	assert.NoError(err)
+	assert.Equal([]string{"user:1", "user:2", "user:3"}, keys)
+
+	assert.NoError(brl.Delete("user:2"))
+	assert.NoError(brl.Put("user:0", []byte("val")))
+	assert.NoError(brl.PutEx("user:4", []byte("val"), time.Second))
+	clock.advance(2 * time.Second)
+	keys, err = brl.RangeScan("user:", "")
+	assert.NoError(err)
+	assert.Equal([]string{"user:0", "user:1", "user:3", "zone"}, keys)
+
+	page, next := brl.ListPage("order:1", 2)
+	assert.Equal([]string{"user:0", "user:1"}, page)
+	assert.Equal("user:1", next)
+	page, next = brl.ListPage(next, 3)
+	assert.Equal([]string{"user:3", "user:4", "zone"}, page)
+	assert.Empty(next)
+
+	assert.NoError(brl.DropAll())
+	keys, err = brl.RangeScan("", "")
+	assert.NoError(err)
+	assert.Empty(keys)
+}
+
+func TestPrefixStats(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, sorted := range []bool{false, true} {
+		cfg := []Config{WithDir(t.TempDir())}
+		if sorted {
+			cfg = append(cfg, WithSortedKeys())
+		}
+		brl, err := Init(cfg...)
+		assert.NoError(err)
+
+		for _, k := range []string{"acme:1", "acme:2", "acmecorp:1", "globex:1"} {
+			assert.NoError(brl.Put(k, []byte("val")))
+		}
+		assert.NoError(brl.Put("acme:1", []byte("longer value")))
+		assert.NoError(brl.Delete("acme:2"))
+
+		stats := brl.PrefixStats("acme:")
+		assert.Equal("acme:", stats.Prefix)
+		assert.Equal(1, stats.Keys)
+		assert.Equal(brl.keydir.meta("acme:1").RecordSize, stats.Bytes)
+
+		stats = brl.PrefixStats("acme")
+		assert.Equal(2, stats.Keys)
+		assert.Equal(brl.keydir.meta("acme:1").RecordSize+brl.keydir.meta("acmecorp:1").RecordSize, stats.Bytes)
+
+		assert.Equal(3, brl.PrefixStats("").Keys)
+		assert.Zero(brl.PrefixStats("initech:").Keys)
+		assert.NoError(brl.Shutdown())
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	clock := &testClock{now: time.Now()}
+	brl, err := Init(WithDir(dir), WithClock(clock))
+	assert.NoError(err)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		assert.NoError(brl.Put(k, []byte("val")))
+	}
+	assert.NoError(brl.PutEx("e", []byte("val"), time.Second))
+	clock.advance(2 * time.Second)
+
+	// Missing and repeated keys aren't counted, and neither is the expired key.
+	written := brl.written.Load()
+	n, err := brl.DeleteMulti([]string{"a", "b", "a", "missing", "e"})
+	assert.NoError(err)
+	assert.Equal(2, n)
+	assert.Equal(written+3, brl.written.Load())
+	assert.ElementsMatch([]string{"c", "d"}, brl.List())
+
+	n, err = brl.DeleteMulti([]string{"missing"})
+	assert.NoError(err)
+	assert.Zero(n)
+
+	// The tombstones are persisted.
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.ElementsMatch([]string{"c", "d"}, brl.List())
+	_, err = brl.Get("b")
+	assert.ErrorIs(err, ErrKeyNotFound)
+}
+
+func TestPutMulti(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+
+	assert.NoError(brl.Put("a", []byte("old")))
+
+	// The keys are written with a single append.
+	size, err := brl.df.Size()
+	assert.NoError(err)
+	assert.NoError(brl.PutMulti(map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}))
+	grown, err := brl.df.Size()
+	assert.NoError(err)
+	assert.Equal(int64(recordSize("a", []byte("1"))+recordSize("b", []byte("2"))+recordSize("c", []byte("3"))), grown-size)
+	assert.ElementsMatch([]string{"a", "b", "c"}, brl.List())
+
+	// None of the keys are stored if any of them is invalid.
+	assert.ErrorIs(brl.PutMulti(map[string][]byte{"d": []byte("4"), "": []byte("5")}), ErrEmptyKey)
+	assert.ErrorIs(brl.PutMulti(map[string][]byte{"d": []byte("4"), tokenKeyPrefix + "x": []byte("5")}), ErrReservedKey)
+	assert.Equal(3, brl.Len())
+
+	// The keys are persisted.
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	for k, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(want, string(val))
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	clock := &testClock{now: time.Unix(1000, 0)}
+	brl, err := Init(WithDir(dir), WithClock(clock))
+	assert.NoError(err)
+
+	assert.NoError(brl.PutExAt("a", []byte("val"), time.Unix(1010, 500)))
+	assert.ErrorIs(brl.PutExAt("b", []byte("val"), time.Unix(0, 0)), ErrInvalidExpiry)
+	assert.ErrorIs(brl.PutExAt("b", []byte("val"), time.Unix(math.MaxUint32+1, 0)), ErrInvalidExpiry)
+
+	assert.NoError(brl.PutWithMeta("b", []byte("val"), []byte("meta")))
+	assert.NoError(brl.ExpireAt("b", time.Unix(1020, 0)))
+	val, meta, err := brl.GetWithMeta("b")
+	assert.NoError(err)
+	assert.Equal([]byte("val"), val)
+	assert.Equal([]byte("meta"), meta)
+	assert.ErrorIs(brl.ExpireAt("missing", time.Unix(1020, 0)), ErrKeyNotFound)
+
+	// The expiry is absolute, so it's the same after a restart.
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir), WithClock(clock))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	info, err := brl.Inspect("a")
+	assert.NoError(err)
+	assert.Equal(time.Unix(1010, 0), info.Expiry)
+	clock.advance(15 * time.Second)
+	_, err = brl.Get("a")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	_, err = brl.Get("b")
+	assert.NoError(err)
+
+	// An expiry in the past deletes the key.
+	assert.NoError(brl.ExpireAt("b", time.Unix(1000, 0)))
+	assert.Empty(brl.List())
+}
+
+func TestClockSkew(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		wall    = &testClock{now: time.Unix(1000, 0)}
+		elapsed time.Duration
+		clock   = newGuardedClock(initLogger(false), wall, time.Minute)
+	)
+	clock.mono = func() time.Duration { return elapsed }
+
+	// Small corrections of the wall clock are followed.
+	elapsed += 10 * time.Second
+	wall.advance(10*time.Second + 5*time.Second)
+	assert.Equal(time.Unix(1015, 0), clock.Now())
+
+	// Jumps are ignored, both ahead and back.
+	elapsed += 10 * time.Second
+	wall.advance(10*time.Second + time.Hour)
+	assert.Equal(time.Unix(1025, 0), clock.Now())
+	elapsed += 10 * time.Second
+	wall.advance(10 * time.Second)
+	assert.Equal(time.Unix(1035, 0), clock.Now())
+	skew, jumps := clock.status()
+	assert.Equal(time.Hour, skew)
+	assert.Equal(uint64(1), jumps)
+
+	elapsed += 10 * time.Second
+	wall.advance(10*time.Second - 3*time.Hour)
+	assert.Equal(time.Unix(1045, 0), clock.Now())
+	skew, jumps = clock.status()
+	assert.Equal(-2*time.Hour, skew)
+	assert.Equal(uint64(2), jumps)
+
+	// The clock is followed again once it's corrected back.
+	elapsed += 10 * time.Second
+	wall.advance(10*time.Second + 2*time.Hour)
+	assert.Equal(time.Unix(1055, 0), clock.Now())
+	skew, _ = clock.status()
+	assert.Zero(skew)
+
+	// Keys whose expiry has passed as per the newest record are flagged if the clock is set back.
+	dir := t.TempDir()
+	wall = &testClock{now: time.Unix(10000, 0)}
+	brl, err := Init(WithDir(dir), WithClock(wall))
+	assert.NoError(err)
+	assert.NoError(brl.PutEx("short", []byte("val"), time.Second))
+	assert.NoError(brl.PutEx("long", []byte("val"), 3*time.Hour))
+	wall.advance(time.Hour)
+	assert.NoError(brl.Put("latest", []byte("val")))
+	assert.NoError(brl.Shutdown())
+
+	wall.advance(-2 * time.Hour)
+	brl, err = Init(WithDir(dir), WithClock(wall))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Equal(1, brl.Stats().SkewedKeys)
+}
+
+func TestPutIdempotent(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	clock := &testClock{now: time.Unix(1000, 0)}
+	brl, err := Init(WithDir(dir), WithClock(clock), WithIdempotencyWindow(time.Minute))
+	assert.NoError(err)
+
+	_, err = brl.PutIdempotent("k", []byte("v1"), 0, "")
+	assert.ErrorIs(err, ErrEmptyToken)
+
+	applied, err := brl.PutIdempotent("k", []byte("v1"), 0, "req-1")
+	assert.NoError(err)
+	assert.True(applied)
+	assert.NoError(brl.Put("k", []byte("v2")))
+
+	// A replay of the write within the window is skipped, even after a restart.
+	applied, err = brl.PutIdempotent("k", []byte("v1"), 0, "req-1")
+	assert.NoError(err)
+	assert.False(applied)
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir), WithClock(clock), WithIdempotencyWindow(time.Minute))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	applied, err = brl.PutIdempotent("k", []byte("v1"), 0, "req-1")
+	assert.NoError(err)
+	assert.False(applied)
+	val, err := brl.Get("k")
+	assert.NoError(err)
+	assert.Equal([]byte("v2"), val)
+
+	// The tokens are kept out of the keyspace, and can't be read, written or deleted as keys.
+	assert.Equal([]string{"k"}, brl.List())
+	assert.Equal(1, brl.Len())
+	keys, _ := brl.ListPage("", 10)
+	assert.Equal([]string{"k"}, keys)
+	assert.Equal([]string{"k"}, brl.Sample(10))
+	assert.Equal(1, brl.Stats().Keys)
+	_, err = brl.Get(tokenKeyPrefix + "req-1")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	assert.ErrorIs(brl.Put(tokenKeyPrefix+"req-1", []byte("k")), ErrReservedKey)
+	assert.ErrorIs(brl.Delete(tokenKeyPrefix+"req-1"), ErrReservedKey)
+
+	// Once the window is over, the token can be used again.
+	clock.advance(2 * time.Minute)
+	applied, err = brl.PutIdempotent("k", []byte("v3"), time.Hour, "req-1")
+	assert.NoError(err)
+	assert.True(applied)
+	val, err = brl.Get("k")
+	assert.NoError(err)
+	assert.Equal([]byte("v3"), val)
+}
+
+func TestVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	brl, err := Init(WithDir(dir), WithMaxActiveFileSize(256))
+	assert.NoError(err)
+
+	v1, err := brl.PutVersioned("k", []byte("v1"))
+	assert.NoError(err)
+	val, v, err := brl.GetVersioned("k")
+	assert.NoError(err)
+	assert.Equal([]byte("v1"), val)
+	assert.Equal(v1, v)
+
+	// The key isn't read again while it's unchanged.
+	_, v, err = brl.GetIfModified("k", v1)
+	assert.ErrorIs(err, ErrNotModified)
+	assert.Equal(v1, v)
+
+	// Every write changes the version, even of the same value, but the merge doesn't.
+	assert.NoError(brl.Put("k", []byte("v1")))
+	val, v2, err := brl.GetIfModified("k", v1)
+	assert.NoError(err)
+	assert.Equal([]byte("v1"), val)
+	assert.NotEqual(v1, v2)
+	for i := 0; i < 5; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("other-%d", i), []byte("val")))
+	}
+	assert.NoError(brl.rotateDF())
+	assert.NoError(brl.merge(context.Background()))
+	_, _, err = brl.GetIfModified("k", v2)
+	assert.ErrorIs(err, ErrNotModified)
+
+	assert.NoError(brl.Delete("k"))
+	_, _, err = brl.GetIfModified("k", v2)
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	// The versions from before a restart don't match, but the new ones differ from those of the later writes.
+	assert.NoError(brl.Put("k", []byte("v3")))
+	_, v3, err := brl.GetVersioned("k")
+	assert.NoError(err)
+	assert.NoError(brl.Put("other", []byte("val")))
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	_, v4, err := brl.GetIfModified("k", v3)
+	assert.NoError(err)
+	assert.NotEqual(v3, v4)
+	v5, err := brl.PutVersioned("k", []byte("v5"))
+	assert.NoError(err)
+	assert.NotEqual(v4, v5)
+	_, _, err = brl.GetIfModified("k", v4)
+	assert.NoError(err)
+}
+
+func TestKeyVersion(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		clock  = &testClock{now: time.Now()}
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithClock(clock))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	_, err = brl.KeyVersion("k")
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	v1, err := brl.PutVersioned("k", []byte("v1"))
+	assert.NoError(err)
+	v, err := brl.KeyVersion("k")
+	assert.NoError(err)
+	assert.Equal(v1, v)
+
+	// Writing the same value changes the version.
+	assert.NoError(brl.Put("k", []byte("v1")))
+	v, err = brl.KeyVersion("k")
+	assert.NoError(err)
+	assert.NotEqual(v1, v)
+
+	// Expired keys don't have a version.
+	assert.NoError(brl.PutEx("k", []byte("v2"), time.Minute))
+	clock.advance(2 * time.Minute)
+	_, err = brl.KeyVersion("k")
+	assert.ErrorIs(err, ErrKeyNotFound)
+}
+
+// countingBackend creates and opens the datafiles in files, counting the datafiles, the writes and the syncs.
+type countingBackend struct {
+	created, opened int
+	writes, syncs   *int
+}
+
+func (c *countingBackend) Create(dir string, index int) (datafile.Storage, error) {
+	df, err := datafile.Files.Create(dir, index)
+	if err != nil {
+		return nil, err
+	}
+	c.created++
+	return countingStorage{Storage: df, writes: c.writes, syncs: c.syncs}, nil
+}
+
+func (c *countingBackend) Open(dir string, index int, pool *datafile.Pool) (datafile.Storage, error) {
+	c.opened++
+	return datafile.Files.Open(dir, index, pool)
+}
+
+type countingStorage struct {
+	datafile.Storage
+	writes, syncs *int
+}
+
+func (s countingStorage) Write(data []byte) (int, error) {
+	*s.writes++
+	return s.Storage.Write(data)
+}
+
+func (s countingStorage) Sync() error {
+	if s.syncs != nil {
+		*s.syncs++
+	}
+	return s.Storage.Sync()
+}
+
+func TestBackend(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		dir     = t.TempDir()
+		writes  int
+		backend = &countingBackend{writes: &writes}
+	)
+
+	_, err := Init(WithDir(dir), WithBackend(nil))
+	assert.Error(err)
+
+	brl, err := Init(WithDir(dir), WithBackend(backend), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	assert.NoError(brl.Put("k1", []byte("v1")))
+	assert.NoError(brl.Put("k2", []byte("v2")))
+	assert.Equal(1, backend.created)
+	assert.Equal(2, writes)
+
+	// Rotated and merged datafiles are created by the backend as well.
+	assert.NoError(brl.rotateDF())
+	assert.Equal(2, backend.created)
+	assert.NoError(brl.Compact())
+	assert.Greater(backend.created, 2)
+	assert.NoError(brl.Shutdown())
+
+	// The older datafiles are opened by the backend on startup.
+	brl, err = Init(WithDir(dir), WithBackend(backend))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Greater(backend.opened, 0)
+	val, err := brl.Get("k1")
+	assert.NoError(err)
+	assert.Equal("v1", string(val))
+}
+
+func TestDecodeRecord(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl, err := Init(WithDir(dir))
+	assert.NoError(err)
+	assert.NoError(brl.PutWithTags("k1", []byte("v1"), []string{"tag"}))
+	assert.NoError(brl.Put("k2", []byte("v2")))
+	assert.NoError(brl.Delete("k2"))
+	path := brl.df.Path()
+	assert.NoError(brl.Shutdown())
+
+	// The version is read from the segment header.
+	info, err := ReadSegmentHeader(path)
+	assert.NoError(err)
+	assert.Equal(RecordVersion, info.Version)
+	assert.Equal(datafile.SegmentHeaderSize, info.HeaderSize)
+
+	// The reference decoder matches the records scanned from the datafile.
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	pos := info.HeaderSize
+	_, err = ScanSegment(path, func(want SegmentRecord) error {
+		r, err := DecodeRecord(data[pos:], info.Version)
+		assert.NoError(err)
+		assert.Equal(want.Key, r.Key)
+		assert.Equal(want.Value, r.Value)
+		assert.Equal(want.Tags, r.Tags)
+		assert.Equal(want.Size, r.Size)
+		assert.True(r.Valid)
+		pos += r.Size
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(len(data), pos)
+
+	// Truncated records and unknown versions aren't decoded.
+	r, err := DecodeRecord(data[info.HeaderSize:], info.Version)
+	assert.NoError(err)
+	_, err = DecodeRecord(data[info.HeaderSize:info.HeaderSize+r.Size-1], info.Version)
+	assert.ErrorIs(err, ErrInvalidRecord)
+	_, err = DecodeRecord(data[info.HeaderSize:], 3)
+	assert.Error(err)
+
+	// All the readable versions are described.
+	versions := make([]int, 0)
+	for _, f := range RecordFormats() {
+		versions = append(versions, f.Version)
+	}
+	assert.Equal([]int{datafile.VersionBitcask, 1, 2}, versions)
+	format, err := GetRecordFormat(RecordVersion)
+	assert.NoError(err)
+	assert.Equal(datafile.SegmentHeaderSize, format.SegmentHeader[0].Size+format.SegmentHeader[1].Size)
+}
+
+func TestSyncPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Init(WithDir(t.TempDir()), WithSyncPolicy(SyncInterval(0)))
+	assert.Error(err)
+	_, err = Init(WithDir(t.TempDir()), WithSyncPolicy(SyncEveryNBytes(0)))
+	assert.Error(err)
+
+	// syncs returns the number of syncs of the active datafile by the given number of writes.
+	syncs := func(policy SyncPolicy, writes int) int {
+		var (
+			n, synced int
+			backend   = &countingBackend{writes: &n, syncs: &synced}
+		)
+		brl, err := Init(WithDir(t.TempDir()), WithBackend(backend), WithSyncPolicy(policy))
+		assert.NoError(err)
+		defer brl.Shutdown()
+
+		for i := 0; i < writes; i++ {
+			assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte("val")))
+		}
+		return synced
+	}
+
+	assert.Equal(0, syncs(SyncNever, 10))
+	assert.Equal(0, syncs(SyncInterval(time.Hour), 10))
+	assert.Equal(10, syncs(SyncEveryWrite, 10))
+
+	// The datafile is synced once the writes since the last sync add up to the given bytes.
+	size := recordSize("key-0", []byte("val"))
+	assert.Equal(0, syncs(SyncEveryNBytes(3*size), 2))
+	assert.Equal(1, syncs(SyncEveryNBytes(3*size), 5))
+	assert.Equal(2, syncs(SyncEveryNBytes(3*size), 6))
+}
+
+func TestRotationCheckpoint(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		dir     = t.TempDir()
+		writes  int
+		syncs   int
+		backend = &countingBackend{writes: &writes, syncs: &syncs}
+	)
+
+	brl, err := Init(WithDir(dir), WithBackend(backend), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("k1", []byte("v1")))
+	assert.NoError(brl.Put("k2", []byte("v2")))
+
+	oldID := brl.df.ID()
+	size, err := brl.df.Size()
+	assert.NoError(err)
+	assert.NoError(brl.rotateDF())
+	assert.Equal(1, syncs)
+
+	// The hints of the sealed datafile cover all of its records.
+	hints := newHints(oldID)
+	assert.NoError(hints.Decode(hintsPath(dir, oldID)))
+	assert.Equal(int(size), hints.Offset)
+	assert.Len(hints.Keys, 2)
+
+	// The manifest records the final sequence range of the sealed datafile, along with the new datafile.
+	man, err := loadManifest(dir)
+	assert.NoError(err)
+	assert.Equal(uint64(2), man.NextSeq)
+	assert.Len(man.Segments, 2)
+	assert.Equal(oldID+1, man.lastID())
+	assert.Equal(uint64(2), man.Segments[0].EndSeq-man.Segments[0].FirstSeq)
+}
+
+func TestKeyDir(t *testing.T) {
+	assert := assert.New(t)
+
+	// All the keys collide, so that they're chained in the same slot of the index.
+	keydir := newKeyDir(0)
+	keydir.hash = func(string) uint64 { return 0 }
+	for i := 0; i < 4; i++ {
+		keydir.set(fmt.Sprintf("key-%d", i), Meta{RecordSize: i})
+	}
+	assert.Equal(4, keydir.len())
+	keydir.delete("key-2") // Middle of the chain.
+	keydir.delete("key-3") // Head of the chain.
+	keydir.delete("key-0") // Tail of the chain.
+	keydir.delete("missing")
+	assert.Equal(1, keydir.len())
+	_, ok := keydir.get("key-2")
+	assert.False(ok)
+	meta, ok := keydir.get("key-1")
+	assert.True(ok)
+	assert.Equal(1, meta.RecordSize)
+
+	// The slots of the deleted keys are reused.
+	keydir.set("key-4", Meta{RecordSize: 4})
+	assert.Len(keydir.entries, 4)
+	assert.Equal(4, keydir.meta("key-4").RecordSize)
+	keydir.set("key-4", Meta{RecordSize: 5})
+	assert.Equal(2, keydir.len())
+	assert.Equal(5, keydir.meta("key-4").RecordSize)
+
+	// The chunks are compacted once most of the keys are deleted, and the keys returned earlier stay intact.
+	keydir = newKeyDir(0)
+	var retained []string
+	for i := 0; i < 10000; i++ {
+		k, err := keydir.set(fmt.Sprintf("key-%05d-%s", i, strings.Repeat("x", 20)), Meta{RecordSize: i})
+		assert.NoError(err)
+		if i%1000 == 0 {
+			retained = append(retained, k)
+		}
+	}
+	chunks := len(keydir.chunks)
+	for i := 0; i < 10000; i++ {
+		if i%100 != 0 {
+			keydir.delete(fmt.Sprintf("key-%05d-%s", i, strings.Repeat("x", 20)))
+		}
+	}
+	assert.Less(len(keydir.chunks), chunks)
+	assert.Equal(100, keydir.len())
+	for i, k := range retained {
+		assert.Equal(fmt.Sprintf("key-%05d-%s", i*1000, strings.Repeat("x", 20)), k)
+		assert.Equal(i*1000, keydir.meta(k).RecordSize)
+	}
+
+	seen := 0
+	keydir.each(func(k string, meta Meta) bool {
+		assert.Equal(fmt.Sprintf("key-%05d-%s", meta.RecordSize, strings.Repeat("x", 20)), k)
+		seen++
+		return true
+	})
+	assert.Equal(100, seen)
+}
+
+func TestCompactKeyDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	brl, err := Init(WithDir(dir), WithCompactKeydir(), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+
+	// All the keys collide, so that every lookup of a key in the chain has to tell the keys apart.
+	brl.keydir.hash = func(string) uint64 { return 0 }
+	for i := 0; i < 40; i++ {
+		assert.NoError(brl.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("val-%d", i))))
+		if i%10 == 9 {
+			assert.NoError(brl.rotateDF())
+		}
+	}
+	assert.Empty(brl.keydir.chunks)
+	assert.Len(brl.keydir.blooms, 4)
+
+	check := func(brl *Barrel) {
+		for i := 0; i < 40; i++ {
+			val, err := brl.Get(fmt.Sprintf("key-%d", i))
+			if i == 3 {
+				assert.ErrorIs(err, ErrKeyNotFound)
+				continue
+			}
+			assert.NoError(err)
+			assert.Equal(fmt.Sprintf("val-%d", i), string(val))
+		}
+		assert.Len(brl.List(), 39)
+	}
+	assert.NoError(brl.Delete("key-3"))
+	assert.NoError(brl.Put("key-30", []byte("val-30")))
+	check(brl)
+
+	// The keys which aren't present are ruled out by the bloom filters without reading the keys of the chain.
+	stats := brl.Stats()
+	assert.Greater(stats.KeyProbes, uint64(0))
+	for i := 10; i < 100; i++ {
+		_, err := brl.Get(fmt.Sprintf("kex-%d", i))
+		assert.ErrorIs(err, ErrKeyNotFound)
+	}
+	assert.Equal(stats.KeyProbes, brl.Stats().KeyProbes)
+	assert.Greater(brl.Stats().BloomSkips, stats.BloomSkips)
+
+	// The keys are read from the merged datafiles, and the filters of the removed datafiles are dropped.
+	assert.NoError(brl.Maintain(context.Background()))
+	assert.NoError(brl.Compact())
+	assert.Len(brl.keydir.blooms, 1)
+	check(brl)
+	assert.NoError(brl.Reload())
+	check(brl)
+
+	// The keydir is loaded from the hints on startup.
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir), WithCompactKeydir(), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.True(brl.keydir.compact)
+	check(brl)
+
+	// A key which can't be read fails the lookups instead of looking missing, and isn't added again.
+	errDisk := errors.New("disk error")
+	brl.keydir.load = func(Meta, int) (string, error) { return "", errDisk }
+	_, err = brl.Get("key-1")
+	assert.ErrorIs(err, errDisk)
+	assert.ErrorIs(brl.Put("key-1", []byte("val")), errDisk)
+	assert.Equal(39, brl.Len())
+}
+
+func TestMaxIndexMemory(t *testing.T) {
+	for name, opts := range map[string][]Config{
+		"Default": nil,
+		"Compact": {WithCompactKeydir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// The index files left over by a crash are removed on startup.
+			dir := t.TempDir()
+			assert.NoError(os.WriteFile(indexPath(dir, 99), nil, 0644))
+			opts := append([]Config{WithDir(dir), WithMaxActiveFileSize(1), WithMaxIndexMemory(1)}, opts...)
+			brl, err := Init(opts...)
+			assert.NoError(err)
+			assert.NoFileExists(indexPath(dir, 99))
+
+			// All the keys collide, so that the entries of a hash span several blocks of the index files.
+			brl.keydir.hash = func(string) uint64 { return 0 }
+			for i := 0; i < 300; i++ {
+				assert.NoError(brl.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("val-%d", i))))
+				if i%100 == 99 {
+					assert.NoError(brl.rotateDF())
+				}
+			}
+			assert.Equal(300, brl.Stats().SpilledKeys)
+			assert.Len(brl.keydir.entries, 0)
+			assert.FileExists(indexPath(dir, 0))
+
+			// The spilled keys which are deleted or written again are removed from the index files.
+			assert.NoError(brl.Delete("key-003"))
+			assert.NoError(brl.Put("key-150", []byte("new")))
+			check := func(brl *Barrel) {
+				for i := 0; i < 300; i++ {
+					val, err := brl.Get(fmt.Sprintf("key-%03d", i))
+					switch i {
+					case 3:
+						assert.ErrorIs(err, ErrKeyNotFound)
+					case 150:
+						assert.NoError(err)
+						assert.Equal("new", string(val))
+					default:
+						assert.NoError(err)
+						assert.Equal(fmt.Sprintf("val-%d", i), string(val))
+					}
+				}
+				assert.Equal(299, brl.Len())
+				assert.Len(brl.List(), 299)
+			}
+			check(brl)
+			assert.Equal(298, brl.Stats().SpilledKeys)
+
+			// The keys which aren't present are ruled out by the bloom filters of the index files.
+			skips := brl.Stats().BloomSkips
+			_, err = brl.Get("kex-001")
+			assert.ErrorIs(err, ErrKeyNotFound)
+			assert.Greater(brl.Stats().BloomSkips, skips)
+
+			// The keys read since are spilled again once a datafile is sealed.
+			brl.applyTouches()
+			assert.NoError(brl.Put("key-300", []byte("val-300")))
+			assert.NoError(brl.rotateDF())
+			assert.Less(len(brl.keydir.entries), 10)
+
+			// The index files of the merged datafiles are removed, and the merged datafiles are spilled instead.
+			assert.NoError(brl.Maintain(context.Background()))
+			assert.NoError(brl.Compact())
+			assert.NoFileExists(indexPath(dir, 0))
+			assert.NoError(brl.Delete("key-300"))
+			check(brl)
+			assert.NoError(brl.Reload())
+			check(brl)
+
+			// The index files are removed on shutdown, and the keydir is loaded from the hints on startup.
+			assert.NoError(brl.Shutdown())
+			files, err := filepath.Glob(filepath.Join(dir, "*.index"))
+			assert.NoError(err)
+			assert.Empty(files)
+			brl, err = Init(opts...)
+			assert.NoError(err)
+			defer brl.Shutdown()
+			check(brl)
+		})
+	}
+}
+
+func TestGetRef(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		val    = []byte(strings.Repeat("v", 8192))
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("k1", val))
+	assert.NoError(brl.rotateDF())
+	assert.NoError(brl.Put("k2", []byte("v2")))
+
+	// The values are read from both the older and the active datafiles, growing the buffer if needed.
+	ref, err := brl.GetRef("k1")
+	assert.NoError(err)
+	assert.Equal(val, ref.Value)
+	assert.GreaterOrEqual(cap(*ref.buf), len(val))
+	ref.Release()
+	assert.Nil(ref.Value)
+	ref.Release()
+
+	ref, err = brl.GetRef("k2")
+	assert.NoError(err)
+	assert.Equal("v2", string(ref.Value))
+	ref.Release()
+
+	_, err = brl.GetRef("missing")
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	// The values are returned from the cache without being copied to the buffer.
+	brl, err = Init(WithDir(t.TempDir()), WithValueCache(1<<20))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.NoError(brl.Put("k1", []byte("v1")))
+	for i := 0; i < 2; i++ {
+		ref, err := brl.GetRef("k1")
+		assert.NoError(err)
+		assert.Equal("v1", string(ref.Value))
+		ref.Release()
+	}
+}
+
+func TestFoldKV(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		large  = []byte(strings.Repeat("l", foldReadAhead+1))
+		want   = map[string]string{}
+	)
+
+	brl, err := Init(WithDir(t.TempDir()), WithMaxActiveFileSize(1))
+	assert.NoError(err)
+	defer brl.Shutdown()
+
+	// Spread the keys over several datafiles, with enough records to refill the read-ahead window.
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 2000; j++ {
+			k, v := fmt.Sprintf("k%d", j), fmt.Sprintf("%d-%s", i, strings.Repeat("v", j))
+			assert.NoError(brl.Put(k, []byte(v)))
+			want[k] = v
+		}
+		assert.NoError(brl.rotateDF())
+	}
+	assert.NoError(brl.Put("large", large))
+	want["large"] = string(large)
+	assert.NoError(brl.Delete("k1"))
+	delete(want, "k1")
+	assert.NoError(brl.PutExAt("expired", []byte("v"), time.Now().Add(-time.Minute)))
+
+	got := map[string]string{}
+	assert.NoError(brl.FoldKV(func(k string, val []byte) error {
+		got[k] = string(val)
+		return nil
+	}))
+	assert.Equal(want, got)
+
+	// The records carry their headers.
+	assert.NoError(brl.FoldRecords(func(r Record) error {
+		assert.NotZero(r.Header.Timestamp)
+		assert.Zero(r.Header.Expiry)
+		return nil
+	}))
+
+	// The fold stops at the first error.
+	var n int
+	errStop := errors.New("stop")
+	assert.ErrorIs(brl.FoldKV(func(string, []byte) error {
+		n++
+		return errStop
+	}), errStop)
+	assert.Equal(1, n)
+}
+
+func TestStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	clock := &testClock{now: time.UnixMilli(1000)}
+	brl, err := Init(WithDir(dir), WithClock(clock))
+	assert.NoError(err)
+
+	_, err = brl.StreamAdd("events", nil, []string{"field"})
+	assert.ErrorIs(err, ErrInvalidStreamFields)
+
+	// The IDs are generated from the clock, and keep increasing even if the clock doesn't.
+	id1, err := brl.StreamAdd("events", nil, []string{"n", "1"})
+	assert.NoError(err)
+	assert.Equal(StreamID{Ms: 1000}, id1)
+	id2, err := brl.StreamAdd("events", nil, []string{"n", "2"})
+	assert.NoError(err)
+	assert.Equal(StreamID{Ms: 1000, Seq: 1}, id2)
+
+	// The explicit IDs must be greater than the last one.
+	_, err = brl.StreamAdd("events", &id2, []string{"n", "3"})
+	assert.ErrorIs(err, ErrSmallStreamID)
+	_, err = brl.StreamAdd("events", &StreamID{Ms: 999}, []string{"n", "3"})
+	assert.ErrorIs(err, ErrSmallStreamID)
+	id3, err := brl.StreamAdd("events", &StreamID{Ms: 2000, Seq: 5}, []string{"n", "3"})
+	assert.NoError(err)
+	clock.advance(time.Millisecond)
+	id4, err := brl.StreamAdd("events", nil, []string{"n", "4"})
+	assert.NoError(err)
+	assert.Equal(StreamID{Ms: 2000, Seq: 6}, id4)
+
+	assert.Equal(4, brl.StreamLen("events"))
+	assert.Equal(id4, brl.StreamLastID("events"))
+	assert.Equal(MinStreamID, brl.StreamLastID("other"))
+
+	// The range is inclusive, and limited by the count.
+	entries, err := brl.StreamRange("events", id2, MaxStreamID, 2)
+	assert.NoError(err)
+	assert.Equal([]StreamEntry{{ID: id2, Fields: []string{"n", "2"}}, {ID: id3, Fields: []string{"n", "3"}}}, entries)
+	entries, err = brl.StreamRange("events", MinStreamID, id1, 0)
+	assert.NoError(err)
+	assert.Equal([]StreamEntry{{ID: id1, Fields: []string{"n", "1"}}}, entries)
+
+	// The entries aren't keys of the keyspace, so they can't be deleted.
+	assert.Empty(brl.List())
+	assert.ErrorIs(brl.Delete(streamKey("events", id1)), ErrReservedKey)
+
+	// The streams are loaded on startup.
+	assert.NoError(brl.Shutdown())
+	brl, err = Init(WithDir(dir), WithClock(clock))
+	assert.NoError(err)
+	defer brl.Shutdown()
+	assert.Equal(4, brl.StreamLen("events"))
+	assert.Equal(id4, brl.StreamLastID("events"))
+	entries, err = brl.StreamRange("events", MinStreamID, MaxStreamID, 0)
+	assert.NoError(err)
+	assert.Equal([]StreamID{id1, id2, id3, id4}, []StreamID{entries[0].ID, entries[1].ID, entries[2].ID, entries[3].ID})
+	_, err = brl.StreamAdd("events", &id4, []string{"n", "5"})
+	assert.ErrorIs(err, ErrSmallStreamID)
+}
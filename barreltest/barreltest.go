@@ -0,0 +1,46 @@
+// Package barreltest provides helpers for the tests of the packages using barrel.
+package barreltest
+
+import (
+	"testing"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+// New returns a barrel in a new temporary directory, which is shut down and removed when the test
+// and all its subtests complete. The given options are applied after the directory is set.
+// It fails the test if the barrel can't be initialised.
+func New(t testing.TB, cfg ...barrel.Config) *barrel.Barrel {
+	t.Helper()
+	return Open(t, t.TempDir(), cfg...)
+}
+
+// Open returns a barrel in the given directory, which is shut down when the test and all its subtests
+// complete. It helps to reopen the directory of a barrel which is shut down with Close.
+func Open(t testing.TB, dir string, cfg ...barrel.Config) *barrel.Barrel {
+	t.Helper()
+
+	brl, err := barrel.Init(append([]barrel.Config{barrel.WithDir(dir)}, cfg...)...)
+	if err != nil {
+		t.Fatalf("error initialising barrel: %v", err)
+	}
+
+	// Shutting down a barrel closed by Close again is a no-op.
+	t.Cleanup(func() {
+		if err := brl.Shutdown(); err != nil {
+			t.Errorf("error shutting down barrel: %v", err)
+		}
+	})
+
+	return brl
+}
+
+// Close shuts down the barrel before the test completes, e.g. to reopen its directory with Open.
+// It fails the test if the shutdown fails.
+func Close(t testing.TB, brl *barrel.Barrel) {
+	t.Helper()
+
+	if err := brl.Shutdown(); err != nil {
+		t.Fatalf("error shutting down barrel: %v", err)
+	}
+}
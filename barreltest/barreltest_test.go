@@ -0,0 +1,42 @@
+package barreltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+func TestNew(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl := Open(t, dir)
+	assert.NoError(brl.Put("key", []byte("val")))
+	Close(t, brl)
+
+	// The directory is reopened with the keys written earlier.
+	brl = Open(t, dir)
+	val, err := brl.Get("key")
+	assert.NoError(err)
+	assert.Equal("val", string(val))
+
+	assert.Equal(0, New(t).Len())
+}
+
+func TestClock(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		clock  = NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		brl    = New(t, barrel.WithClock(clock))
+	)
+
+	assert.NoError(brl.PutEx("key", []byte("val"), time.Minute))
+	clock.Advance(time.Minute + time.Second)
+	_, err := brl.Get("key")
+	assert.ErrorIs(err, barrel.ErrExpiredKey)
+}
@@ -0,0 +1,47 @@
+//go:build faultinject
+
+package barreltest
+
+import (
+	"testing"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// ErrInjected is returned by the operations failed by the injected faults.
+var ErrInjected = datafile.ErrInjected
+
+// FailNextSync makes the next fsync of the datafiles fail.
+// The faults are global to the process, so the tests injecting them shouldn't run in parallel.
+func FailNextSync(t testing.TB) {
+	inject(t, datafile.FaultSync)
+}
+
+// FailNextDirSync makes the next fsync of the directory of the datafiles fail, e.g. the one which
+// makes a new datafile, a rotated hints file or a removed datafile durable.
+func FailNextDirSync(t testing.TB) {
+	inject(t, datafile.FaultSyncDir)
+}
+
+// FailNextRead makes the next read of a record from the datafiles fail.
+func FailNextRead(t testing.TB) {
+	inject(t, datafile.FaultRead)
+}
+
+// ShortNextWrite makes the next write to the datafiles stop halfway through the record and fail.
+func ShortNextWrite(t testing.TB) {
+	inject(t, datafile.FaultShortWrite)
+}
+
+// CorruptNextWrite flips the last byte of the next record written to the datafiles.
+func CorruptNextWrite(t testing.TB) {
+	inject(t, datafile.FaultCorruptWrite)
+}
+
+// inject injects the fault, which is cleared when the test completes if it isn't triggered.
+func inject(t testing.TB, fault datafile.Fault) {
+	t.Helper()
+
+	datafile.InjectFault(fault, 1)
+	t.Cleanup(datafile.ResetFaults)
+}
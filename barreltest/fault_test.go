@@ -0,0 +1,88 @@
+//go:build faultinject
+
+package barreltest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+func TestFaults(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+	)
+
+	brl := Open(t, dir)
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+
+	// The torn record is discarded and the next record is written after the previous one.
+	ShortNextWrite(t)
+	assert.ErrorIs(brl.Put("key-2", []byte("val-2")), ErrInjected)
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+
+	FailNextSync(t)
+	assert.ErrorIs(brl.Sync(), ErrInjected)
+	assert.NoError(brl.Sync())
+
+	FailNextRead(t)
+	_, err := brl.Get("key-1")
+	assert.ErrorIs(err, ErrInjected)
+
+	CorruptNextWrite(t)
+	assert.NoError(brl.Put("key-4", []byte("val-4")))
+	_, err = brl.Get("key-4")
+	assert.ErrorIs(err, barrel.ErrChecksumMismatch)
+	Close(t, brl)
+
+	// The records survive the restart.
+	brl = Open(t, dir)
+	for k, want := range map[string]string{"key-1": "val-1", "key-3": "val-3"} {
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(want, string(val))
+	}
+	_, err = brl.Get("key-2")
+	assert.ErrorIs(err, barrel.ErrNoKey)
+}
+
+func TestDirSyncFaults(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		dir    = t.TempDir()
+		ctx    = context.Background()
+	)
+
+	dataFiles := func() int {
+		files, err := filepath.Glob(filepath.Join(dir, "*.db"))
+		assert.NoError(err)
+		return len(files)
+	}
+
+	brl := Open(t, dir, barrel.WithManualMaintenance(), barrel.WithMaxActiveFileSize(1))
+	assert.NoError(brl.Put("key-1", []byte("val-1")))
+
+	// The active datafile isn't rotated unless the new datafile is durable.
+	FailNextDirSync(t)
+	assert.ErrorIs(brl.Maintain(ctx), ErrInjected)
+	assert.Equal(1, dataFiles())
+	assert.NoError(brl.Put("key-2", []byte("val-2")))
+
+	assert.NoError(brl.Maintain(ctx))
+	assert.NoError(brl.Put("key-3", []byte("val-3")))
+	assert.NoError(brl.Compact())
+	Close(t, brl)
+
+	// The records survive the restart.
+	brl = Open(t, dir)
+	for k, want := range map[string]string{"key-1": "val-1", "key-2": "val-2", "key-3": "val-3"} {
+		val, err := brl.Get(k)
+		assert.NoError(err)
+		assert.Equal(want, string(val))
+	}
+}
@@ -1,7 +1,9 @@
 package barrel_test
 
 import (
+	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -18,6 +20,7 @@ func BenchmarkPut(b *testing.B) {
 
 	scenarios := map[string][]barrel.Config{
 		"AlwaysSync":  {barrel.WithDir(tmpDir), barrel.WithAlwaysSync()},
+		"FsyncOnPut":  {barrel.WithDir(tmpDir), barrel.WithFsyncOnPut()},
 		"DisableSync": {barrel.WithDir(tmpDir)},
 	}
 
@@ -90,3 +93,198 @@ func BenchmarkGet(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func BenchmarkGetRef(b *testing.B) {
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	brl, err := barrel.Init(barrel.WithDir(tmpDir))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer brl.Shutdown()
+
+	// Size of each value -> 4kb.
+	b.SetBytes(int64(4096))
+	b.ReportAllocs()
+
+	var (
+		key = "hello"
+		val = []byte(strings.Repeat(" ", 4096))
+	)
+
+	if err := brl.Put(key, val); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ref, err := brl.GetRef(key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ref.Release()
+	}
+	b.StopTimer()
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	brl, err := barrel.Init(barrel.WithDir(tmpDir))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var (
+		keys = make([]string, 64)
+		val  = []byte(strings.Repeat(" ", 4096))
+	)
+
+	// Put dummy keys.
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := brl.Put(keys[i], val); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// Reopen the barrel, so that the records are read from an older datafile, which io_uring is used for.
+	if err := brl.Shutdown(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bench := range []struct {
+		name  string
+		opts  []barrel.Config
+		batch bool
+	}{
+		{name: "Get"},
+		{name: "GetMulti", batch: true},
+		{name: "GetMulti_IOUring", opts: []barrel.Config{barrel.WithIOUring()}, batch: true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			brl, err := barrel.Init(append([]barrel.Config{barrel.WithDir(tmpDir)}, bench.opts...)...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer brl.Shutdown()
+
+			// Size of each batch -> 64 * 4kb.
+			b.SetBytes(int64(len(keys) * 4096))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if bench.batch {
+					if _, err := brl.GetMulti(keys); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				for _, k := range keys {
+					if _, err := brl.Get(k); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkKeydir reports the heap used per key and the number of the heap objects per key by the keydir
+// of a million keys loaded on startup, and the time taken by a garbage collection with it in the heap.
+func BenchmarkKeydir(b *testing.B) {
+	const keys = 1 << 20
+
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Write the keys in a barrel which is released before the keydir is loaded again.
+	func() {
+		brl, err := barrel.Init(barrel.WithDir(tmpDir), barrel.WithManualMaintenance())
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer brl.Shutdown()
+
+		val := []byte("v")
+		for i := 0; i < keys; i++ {
+			if err := brl.Put(fmt.Sprintf("user:%08d", i), val); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}()
+
+	// Load the keydir from the hints written on shutdown. The garbage is collected twice, since the
+	// buffer pool keeps the barrel written to alive till the second collection.
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	brl, err := barrel.Init(barrel.WithDir(tmpDir), barrel.WithManualMaintenance())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer brl.Shutdown()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+
+	// The metrics are reported after the timer is reset, which clears them.
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/keys, "heap-B/key")
+	b.ReportMetric(float64(int64(after.HeapObjects)-int64(before.HeapObjects))/keys, "objects/key")
+}
+
+func BenchmarkFoldKV(b *testing.B) {
+	// Create a temp directory for running tests.
+	tmpDir, err := os.MkdirTemp("", "barreldb")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	brl, err := barrel.Init(barrel.WithDir(tmpDir))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer brl.Shutdown()
+
+	// 10k keys of 1kb each.
+	const keys = 10000
+	val := []byte(strings.Repeat(" ", 1024))
+	for i := 0; i < keys; i++ {
+		if err := brl.Put(fmt.Sprintf("key-%d", i), val); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.SetBytes(int64(keys * len(val)))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := brl.FoldKV(func(string, []byte) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
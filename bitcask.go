@@ -0,0 +1,112 @@
+package barrel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+const (
+	// bitcaskHeaderSize is the size of the header of the records written by Bitcask in bytes.
+	bitcaskHeaderSize = 14
+	// bitcaskTombstone is the prefix of the values which Bitcask writes for the deleted keys.
+	bitcaskTombstone = "bitcask_tombstone"
+)
+
+// decodeBitcask decodes the header of a record written by Bitcask from the start of the data.
+// Bitcask records don't have an expiry or metadata. The checksum covers the entire record
+// after itself, unlike the checksum of the value of the records written by barrel.
+func (h *Header) decodeBitcask(data []byte) (int, error) {
+	if len(data) < bitcaskHeaderSize {
+		return 0, ErrInvalidRecord
+	}
+	h.Checksum = binary.BigEndian.Uint32(data[0:4])
+	h.Flags = flagBitcask
+	h.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	h.Expiry = 0
+	h.KeySize = uint32(binary.BigEndian.Uint16(data[8:10]))
+	h.ValSize = binary.BigEndian.Uint32(data[10:14])
+	h.MetaSize = 0
+	return bitcaskHeaderSize, nil
+}
+
+// bitcaskChecksum returns the checksum of a record written by Bitcask.
+func (r *Record) bitcaskChecksum() uint32 {
+	header := make([]byte, bitcaskHeaderSize-4)
+	binary.BigEndian.PutUint32(header[0:4], r.Header.Timestamp)
+	binary.BigEndian.PutUint16(header[4:6], uint16(r.Header.KeySize))
+	binary.BigEndian.PutUint32(header[6:10], r.Header.ValSize)
+
+	sum := crc32.Update(0, crc32.IEEETable, header)
+	sum = crc32.Update(sum, crc32.IEEETable, []byte(r.Key))
+	return crc32.Update(sum, crc32.IEEETable, r.Value)
+}
+
+// isBitcaskTombstone returns true if the value is written by Bitcask for a deleted key.
+// The newer versions of Bitcask append the ID of a datafile to the tombstone.
+func isBitcaskTombstone(val []byte) bool {
+	return bytes.HasPrefix(val, []byte(bitcaskTombstone))
+}
+
+// getBitcaskFiles returns the list of datafiles written by Bitcask in the given directory.
+func getBitcaskFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*.bitcask.data"))
+}
+
+// getBitcaskIDs returns the IDs of the datafiles written by Bitcask from their names.
+func getBitcaskIDs(files []string) ([]int, error) {
+	ids := make([]int, 0, len(files))
+	for _, f := range files {
+		id, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(f), ".bitcask.data"), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(id))
+	}
+	return ids, nil
+}
+
+// openBitcaskFiles adds the datafiles written by Bitcask in the directory to the given datafiles.
+func openBitcaskFiles(dir string, dfs map[int]datafile.Storage, pool *datafile.Pool, man *manifest) error {
+	files, err := getBitcaskFiles(dir)
+	if err != nil {
+		return err
+	}
+	ids, err := getBitcaskIDs(files)
+	if err != nil {
+		return fmt.Errorf("error parsing ids for bitcask files: %w", err)
+	}
+
+	for _, id := range ids {
+		// The datafiles listed in the manifest are already opened. The others are adopted if they're
+		// written by Bitcask after the manifest, otherwise they're left over by an interrupted merge.
+		if man != nil && (man.lists(id) || id < man.lastID()) {
+			continue
+		}
+		if _, ok := dfs[id]; ok {
+			return fmt.Errorf("datafile %d is present in both the barrel and the bitcask formats", id)
+		}
+		df, err := datafile.OpenBitcask(dir, id, pool)
+		if err != nil {
+			return err
+		}
+		dfs[id] = df
+	}
+	return nil
+}
+
+// isBitcaskFile returns true if the file is a datafile or a hints file written by Bitcask.
+func isBitcaskFile(path string) bool {
+	return strings.HasSuffix(path, ".bitcask.data") || strings.HasSuffix(path, ".bitcask.hint")
+}
+
+// bitcaskHintsPath returns the path of the hints file written by Bitcask for the given datafile ID.
+func bitcaskHintsPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.bitcask.hint", id))
+}
@@ -0,0 +1,184 @@
+package barrel
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// partialReadSize is the min size of a record above which the bitmap reads only read the
+// required part of the value. Smaller records are read entirely, which validates their checksum.
+const partialReadSize = 4096
+
+// GetBit returns the bit at the given offset of the value of the key, like `GETBIT` of Redis.
+// The bits of each byte are numbered from the most significant bit. It returns 0 if the offset
+// is beyond the value or the key doesn't exist.
+func (b *Barrel) GetBit(k string, offset int) (int, error) {
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	data, err := b.valueRange(k, offset/8, offset/8)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	return int(data[0]>>(7-offset%8)) & 1, nil
+}
+
+// SetBit sets the bit at the given offset of the value of the key to 0 or 1, like `SETBIT` of Redis,
+// and returns its old value. The value is padded with zero bytes if it's shorter than the offset, and an
+// empty value is used if the key doesn't exist. The expiry, the metadata and the tags of the key are retained.
+func (b *Barrel) SetBit(k string, offset int, bit int) (old int, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+	if bit != 0 && bit != 1 {
+		return 0, fmt.Errorf("invalid bit: %d", bit)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	var (
+		pos  = offset / 8
+		mask = byte(1) << (7 - offset%8)
+	)
+	if pos > b.opts.maxValueSize-1 {
+		b.oversized.Add(1)
+		return 0, fmt.Errorf("%w: %d bytes", ErrLargeValue, pos+1)
+	}
+
+	b.lo.Debug("setting bit", "key", k, "offset", offset, "bit", bit)
+	_, err = b.modify(k, func(val []byte) []byte {
+		size := len(val)
+		if pos >= size {
+			size = pos + 1
+		}
+		// Copy the old value since it may point to the cache or the mapped memory of the datafile.
+		updated := make([]byte, size)
+		copy(updated, val)
+		if updated[pos]&mask != 0 {
+			old = 1
+		}
+		if bit == 1 {
+			updated[pos] |= mask
+		} else {
+			updated[pos] &^= mask
+		}
+		return updated
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return old, nil
+}
+
+// BitCount returns the number of bits set to 1 in the bytes of the value of the key from start to end,
+// both inclusive, like `BITCOUNT` of Redis. Negative offsets are counted from the end of the value,
+// so 0 and -1 count all the bits. It returns 0 if the key doesn't exist.
+func (b *Barrel) BitCount(k string, start, end int) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	data, err := b.valueRange(k, start, end)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, c := range data {
+		count += bits.OnesCount8(c)
+	}
+	return count, nil
+}
+
+// valueRange returns the bytes of the value of the key from start to end, both inclusive. Negative offsets
+// are counted from the end of the value and the range is clamped to the value. For large records, only the
+// header and the range are read from the datafile, in which case the checksum of the record isn't validated.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) valueRange(k string, start, end int) ([]byte, error) {
+	// Read the entire record if it's small or cached, or if the key doesn't exist.
+	meta, ok := b.keydir.get(k)
+	_, cached := b.cachedRecord(meta)
+	if !ok || cached || meta.RecordSize < partialReadSize {
+		record, err := b.getRecord(k)
+		if err != nil {
+			return nil, err
+		}
+		start, end = clampRange(start, end, len(record.Value))
+		return record.Value[start:end], nil
+	}
+
+	reader, err := b.reader(meta.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the header, which is at most maxHeaderSizeV2 bytes, to find the position of the value.
+	var (
+		header      Header
+		recordStart = meta.RecordPos - meta.RecordSize
+	)
+	data, err := reader.Read(recordStart+maxHeaderSizeV2, maxHeaderSizeV2)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data from file: %w", err)
+	}
+	if _, err := header.decode(data, reader.Version()); err != nil {
+		return nil, fmt.Errorf("error decoding header: %w", err)
+	}
+	if (&Record{Header: header}).isExpired(b.now()) {
+		return nil, ErrExpiredKey
+	}
+	b.touch(k)
+	b.recordAccess(k, false)
+
+	start, end = clampRange(start, end, int(header.ValSize))
+	if start == end {
+		return nil, nil
+	}
+	valStart := meta.RecordPos - int(header.ValSize)
+	data, err = reader.Read(valStart+end, end-start)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data from file: %w", err)
+	}
+
+	return data, nil
+}
+
+// clampRange converts the inclusive range with negative offsets counted from the end
+// to a half-open range within the given size.
+func clampRange(start, end, size int) (int, int) {
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end {
+		return 0, 0
+	}
+	return start, end + 1
+}
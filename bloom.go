@@ -0,0 +1,98 @@
+package barrel
+
+import "unsafe"
+
+const (
+	// bloomBitsPerKey and bloomHashes make for about 1% false positives once a filter is full.
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// bloomMinKeys is the capacity of the first filter of a segment, unless the previous segments are larger.
+	bloomMinKeys = 1024
+)
+
+// bloom is the bloom filter of the keys of a segment. Since the number of keys of the active segment isn't
+// known upfront, a filter twice as large is added once the last one is full, so that the false positives
+// stay bounded as it grows. The keys are added by their hashes, which are independent of the hashes of the keydir.
+type bloom struct {
+	filters []bloomFilter
+	keys    int // Number of keys added.
+}
+
+// bloomFilter is one of the filters of a bloom, which takes the keys till it's full.
+type bloomFilter struct {
+	bits []uint64
+	cap  int // Number of keys the filter is sized for.
+}
+
+// newBloom returns an empty bloom whose first filter is sized for the given number of keys.
+func newBloom(keys int) *bloom {
+	if keys < bloomMinKeys {
+		keys = bloomMinKeys
+	}
+	return &bloom{filters: []bloomFilter{newBloomFilter(keys)}}
+}
+
+// newBloomFilter returns an empty filter sized for the given number of keys.
+func newBloomFilter(keys int) bloomFilter {
+	return bloomFilter{bits: make([]uint64, (keys*bloomBitsPerKey+63)/64), cap: keys}
+}
+
+// add adds the hash of a key to the last filter, adding a larger one if it's full.
+func (f *bloom) add(h uint64) {
+	last := &f.filters[len(f.filters)-1]
+	if f.keys >= f.total() {
+		f.filters = append(f.filters, newBloomFilter(2*last.cap))
+		last = &f.filters[len(f.filters)-1]
+	}
+	last.add(h)
+	f.keys++
+}
+
+// has returns false if the key with the hash is definitely not added, true if it may be.
+func (f *bloom) has(h uint64) bool {
+	for i := range f.filters {
+		if f.filters[i].has(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// total returns the number of keys the filters are sized for.
+func (f *bloom) total() int {
+	total := 0
+	for _, filter := range f.filters {
+		total += filter.cap
+	}
+	return total
+}
+
+// size returns the memory used by the filters.
+func (f *bloom) size() int {
+	size := 0
+	for _, filter := range f.filters {
+		size += cap(filter.bits) * int(unsafe.Sizeof(uint64(0)))
+	}
+	return size
+}
+
+// add sets the bits of the hash, which are derived from its two halves by double hashing.
+func (f *bloomFilter) add(h uint64) {
+	m := uint64(len(f.bits) * 64)
+	for i, delta := 0, h>>32|1; i < bloomHashes; i, h = i+1, h+delta {
+		bit := h % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// has returns true if all the bits of the hash are set.
+func (f *bloomFilter) has(h uint64) bool {
+	m := uint64(len(f.bits) * 64)
+	for i, delta := 0, h>>32|1; i < bloomHashes; i, h = i+1, h+delta {
+		bit := h % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
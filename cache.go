@@ -0,0 +1,106 @@
+package barrel
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cacheKey identifies a record by its position in the datafiles.
+type cacheKey struct {
+	fileID int
+	pos    int
+}
+
+// cacheEntry is an encoded record stored in the cache.
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// valueCache is an LRU cache of the recently read records, bounded by the total size of the records.
+// Since records are never modified once written, an entry is valid as long as the datafile exists.
+type valueCache struct {
+	sync.Mutex
+
+	maxBytes int
+	size     int
+	lru      *list.List // Most recently used entries first.
+	items    map[cacheKey]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+// newValueCache returns a cache which holds at most maxBytes of records.
+func newValueCache(maxBytes int) *valueCache {
+	return &valueCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+// get returns a copy of the cached record.
+func (c *valueCache) get(k cacheKey) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.items[k]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(e)
+
+	return append([]byte(nil), e.Value.(*cacheEntry).data...), true
+}
+
+// add stores a copy of the record and evicts the least recently used records if the cache is full.
+func (c *valueCache) add(k cacheKey, data []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(data) > c.maxBytes {
+		return
+	}
+	if _, ok := c.items[k]; ok {
+		return
+	}
+
+	c.items[k] = c.lru.PushFront(&cacheEntry{key: k, data: append([]byte(nil), data...)})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove removes the record from the cache.
+func (c *valueCache) remove(k cacheKey) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[k]; ok {
+		c.removeElement(e)
+	}
+}
+
+// reset removes all the records from the cache.
+func (c *valueCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.lru.Init()
+	c.items = make(map[cacheKey]*list.Element)
+	c.size = 0
+}
+
+// removeElement removes the entry from the cache.
+// Caller of this function should ensure to lock/unlock the cache.
+func (c *valueCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= len(entry.data)
+}
@@ -0,0 +1,186 @@
+package barrel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// CHANGES_FILE is the file with the high-water mark of the changes acknowledged by the change sink.
+	CHANGES_FILE = "barrel.changes"
+
+	// changeBatch is the max number of changes published at once.
+	changeBatch = 256
+	// changeRetryInterval is the time after which the changes are published again if the sink fails.
+	changeRetryInterval = time.Second
+)
+
+// Change is a committed write or delete of a key, which is published to the change sink.
+// The value is empty for the deletes.
+type Change struct {
+	Op        string `json:"op"` // "set" or "del".
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Seq       uint64 `json:"seq"`              // Sequence number of the write, as sent by Tail.
+	Timestamp int64  `json:"ts"`               // Unix timestamp of the write.
+	Expiry    int64  `json:"expiry,omitempty"` // Unix timestamp at which the key expires, 0 if it never expires.
+}
+
+// ChangeSink is the target to which the changes are published, e.g. a message broker.
+type ChangeSink interface {
+	// Publish publishes the changes in order, and returns once all of them are acknowledged by the target.
+	Publish(changes []Change) error
+}
+
+// changeFeed publishes the changes from the log to the change sink.
+type changeFeed struct {
+	sink ChangeSink
+
+	offset    atomic.Uint64 // Offset of the log upto which the changes are acknowledged, which is persisted.
+	published atomic.Uint64 // Number of changes published to the sink.
+	failed    atomic.Uint64 // Number of failed attempts to publish the changes.
+	failing   atomic.Bool   // Whether the last attempt failed.
+}
+
+// newChangeFeed returns a feed which resumes after the changes acknowledged as per the high-water mark
+// persisted in the directory, or publishes all the changes in the log if there's none.
+func newChangeFeed(sink ChangeSink, dir string) (*changeFeed, error) {
+	f := &changeFeed{sink: sink}
+	data, err := os.ReadFile(filepath.Join(dir, CHANGES_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading changes offset: %w", err)
+	}
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing changes offset: %w", err)
+	}
+	f.offset.Store(offset)
+	return f, nil
+}
+
+// RunChangeFeed publishes every record in the log to the change sink, in batches in the order they're written.
+// A batch is published till it's acknowledged by the sink, after which the offset of the log upto it is persisted,
+// so the changes are delivered at least once: the changes published since the offset was last persisted are
+// published again after a restart, and so are the merged records if the merge interrupts a batch, like Tail.
+func (b *Barrel) RunChangeFeed() {
+	ctx, cancel := b.withShutdown(context.Background())
+	defer cancel()
+
+	for ctx.Err() == nil {
+		// The tail is closed if the datafile being read is merged, in which case it resumes from the offset.
+		ch := b.Tail(ctx, b.changes.offset.Load())
+		for {
+			changes, open := receiveChanges(ch)
+			if len(changes) > 0 && !b.publishChanges(ctx, changes) {
+				return
+			}
+			if !open {
+				break
+			}
+		}
+	}
+}
+
+// receiveChanges waits for a record from the tail and returns it along with the records following it
+// without waiting, upto a batch. The records of the reserved keys aren't changes of the keyspace,
+// so they're skipped. It returns false once the tail is closed.
+func receiveChanges(ch <-chan Record) ([]Change, bool) {
+	record, ok := <-ch
+	if !ok {
+		return nil, false
+	}
+	changes := make([]Change, 0, 1)
+	for {
+		if !isReserved(record.Key) {
+			changes = append(changes, newChange(record))
+		}
+		if len(changes) == changeBatch {
+			return changes, true
+		}
+		select {
+		case record, ok = <-ch:
+			if !ok {
+				return changes, false
+			}
+		default:
+			return changes, true
+		}
+	}
+}
+
+// newChange returns the change of the record sent by Tail.
+func newChange(r Record) Change {
+	c := Change{Op: "set", Key: r.Key, Value: r.Value, Seq: r.Seq, Timestamp: int64(r.Header.Timestamp), Expiry: int64(r.Header.Expiry)}
+	if r.isTombstone() {
+		c.Op, c.Value = "del", nil
+	}
+	return c
+}
+
+// publishChanges publishes the changes till they're acknowledged by the sink, and persists the offset of the log
+// upto them. It returns false if it's interrupted by the shutdown.
+func (b *Barrel) publishChanges(ctx context.Context, changes []Change) bool {
+	for {
+		err := b.changes.sink.Publish(changes)
+		b.changes.failing.Store(err != nil)
+		if err == nil {
+			break
+		}
+		b.lo.Error("error publishing changes", "count", len(changes), "seq", changes[0].Seq, "error", err)
+		b.changes.failed.Add(1)
+
+		select {
+		case <-time.After(changeRetryInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	b.changes.published.Add(uint64(len(changes)))
+
+	offset := changes[len(changes)-1].Seq + 1
+	if offset <= b.changes.offset.Load() {
+		return true
+	}
+	b.changes.offset.Store(offset)
+	if err := writeChangesOffset(b.opts.dir, offset); err != nil {
+		b.lo.Error("error persisting changes offset", "offset", offset, "error", err)
+	}
+	return true
+}
+
+// writeChangesOffset replaces the high-water mark of the changes acknowledged by the change sink.
+func writeChangesOffset(dir string, offset uint64) error {
+	var (
+		path    = filepath.Join(dir, CHANGES_FILE)
+		tmpPath = path + ".tmp"
+	)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(offset, 10)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,133 @@
+package barrel
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zerodha/logf"
+)
+
+// Clock is the source of the current time used for the timestamps and the expiry of the records.
+// It can be replaced with WithClock to test the expiry without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default clock, which returns the current time of the system.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time as per the clock of the barrel.
+func (b *Barrel) now() time.Time {
+	return b.opts.clock.Now()
+}
+
+const (
+	// defaultMaxClockSkew is the largest jump of the system clock followed by the barrel by default.
+	defaultMaxClockSkew = time.Minute
+	// maxLoggedSkewedKeys is the max number of keys affected by the clock skew which are logged on startup.
+	maxLoggedSkewedKeys = 10
+)
+
+// guardedClock is the clock of the barrel if the system clock is used. It follows the wall clock as long
+// as it agrees with a monotonic clock within the max skew, which allows the gradual corrections by NTP.
+// Once the wall clock jumps by more than the max skew, e.g. on a step correction, the time is derived
+// from the monotonic clock instead, so that the keys don't expire early or live longer than their TTL.
+// The jump is ignored until the barrel is reopened.
+type guardedClock struct {
+	sync.Mutex
+
+	lo      logf.Logger
+	wall    Clock
+	mono    func() time.Duration // Time elapsed on the monotonic clock since the start.
+	maxSkew time.Duration
+
+	base     time.Time     // Time at the last agreement of the clocks.
+	baseMono time.Duration // Monotonic time at the last agreement of the clocks.
+	skew     time.Duration // Difference of the wall clock from the time of the barrel.
+	jumps    uint64        // Number of jumps of the wall clock larger than the max skew.
+}
+
+func newGuardedClock(lo logf.Logger, wall Clock, maxSkew time.Duration) *guardedClock {
+	start := time.Now()
+	return &guardedClock{
+		lo:      lo,
+		wall:    wall,
+		mono:    func() time.Duration { return time.Since(start) },
+		maxSkew: maxSkew,
+		base:    wall.Now(),
+	}
+}
+
+func (c *guardedClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	var (
+		wall    = c.wall.Now()
+		elapsed = c.mono()
+		now     = c.base.Add(elapsed - c.baseMono)
+		skew    = wall.Sub(now)
+	)
+	if skew.Abs() <= c.maxSkew {
+		c.base, c.baseMono, c.skew = wall, elapsed, 0
+		return wall
+	}
+
+	// Count every jump once, the skew stays the same until the wall clock jumps again.
+	if (skew - c.skew).Abs() > c.maxSkew {
+		c.jumps++
+		c.lo.Warn("ignoring jump of the system clock for the timestamps and the expiry of the keys", "skew", skew.String())
+	}
+	c.skew = skew
+
+	return now
+}
+
+// status returns the current skew of the wall clock and the number of jumps of it.
+func (c *guardedClock) status() (time.Duration, uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.skew, c.jumps
+}
+
+// checkClockSkew warns if the clock is behind the timestamps of the records written earlier by more than
+// the max skew, e.g. if the clock was set back while the barrel was closed. The keys whose expiry has passed
+// as per the newest record, which would live longer than their TTL, are counted and logged.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) checkClockSkew() {
+	var newest uint32
+	for _, r := range b.timeRanges {
+		if r.max > newest {
+			newest = r.max
+		}
+	}
+
+	var (
+		now  = b.now()
+		skew = time.Unix(int64(newest), 0).Sub(now)
+	)
+	if newest == 0 || skew <= b.opts.maxClockSkew {
+		return
+	}
+
+	var keys []string
+	b.eachKey(func(k string, meta Meta) bool {
+		if meta.Expiry != 0 && int64(meta.Expiry) > now.Unix() && meta.Expiry <= int(newest) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	b.skewedKeys = len(keys)
+
+	sort.Strings(keys)
+	if len(keys) > maxLoggedSkewedKeys {
+		keys = keys[:maxLoggedSkewedKeys]
+	}
+	b.lo.Warn("clock is behind the newest record, keys may live longer than their ttl", "skew", skew.String(), "skewed_keys", b.skewedKeys, "keys", keys)
+}
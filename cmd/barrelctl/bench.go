@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// benchTimeout is the timeout for each command sent to the server.
+const benchTimeout = time.Second * 10
+
+// benchTarget is a server or a directory being benchmarked. Each client uses its own target.
+type benchTarget interface {
+	Get(k string) error
+	Set(k string, val []byte) error
+	Close() error
+}
+
+// dirTarget benchmarks a barrel opened on a directory, which is shared by all the clients.
+type dirTarget struct {
+	brl *barrel.Barrel
+}
+
+func (t dirTarget) Get(k string) error {
+	if _, err := t.brl.Get(k); err != nil && !errors.Is(err, barrel.ErrKeyNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (t dirTarget) Set(k string, val []byte) error {
+	return t.brl.Put(k, val)
+}
+
+func (t dirTarget) Close() error {
+	return nil
+}
+
+// serverTarget benchmarks a server over its own connection.
+type serverTarget struct {
+	c *respClient
+}
+
+func (t serverTarget) Get(k string) error {
+	_, err := t.c.Do([]byte("GET"), []byte(k))
+	return err
+}
+
+func (t serverTarget) Set(k string, val []byte) error {
+	_, err := t.c.Do([]byte("SET"), []byte(k), val)
+	return err
+}
+
+func (t serverTarget) Close() error {
+	return t.c.Close()
+}
+
+// runBench benchmarks a server or a directory like redis-benchmark, with a mix of
+// reads and writes of random keys, and reports the throughput and the latency percentiles.
+func runBench(args []string) error {
+	var (
+		f         = flag.NewFlagSet("bench", flag.ContinueOnError)
+		addr      = f.String("addr", "", "Address of the server to benchmark.")
+		dir       = f.String("dir", "", "Directory to benchmark in embedded mode, if no address is given.")
+		requests  = f.IntP("requests", "n", 100000, "Total number of requests.")
+		clients   = f.IntP("clients", "c", 50, "Number of concurrent clients.")
+		keys      = f.Int("keys", 10000, "Number of distinct keys.")
+		keySize   = f.Int("key-size", 16, "Size of the keys in bytes.")
+		valSize   = f.Int("value-size", 128, "Size of the values in bytes.")
+		readRatio = f.Float64("read-ratio", 0.8, "Ratio of the reads to all the requests, between 0 and 1.")
+		populate  = f.Bool("populate", true, "Write all the keys before the benchmark, so that the reads find them.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case (*addr == "") == (*dir == ""):
+		return errors.New("either --addr or --dir is required")
+	case *requests < 1 || *clients < 1 || *keys < 1:
+		return errors.New("requests, clients and keys must be positive")
+	case *readRatio < 0 || *readRatio > 1:
+		return errors.New("read ratio must be between 0 and 1")
+	}
+
+	// Open a target for each client.
+	var targets []benchTarget
+	if *dir != "" {
+		brl, err := barrel.Init(barrel.WithDir(*dir))
+		if err != nil {
+			return err
+		}
+		defer brl.Shutdown()
+		for i := 0; i < *clients; i++ {
+			targets = append(targets, dirTarget{brl: brl})
+		}
+	} else {
+		for i := 0; i < *clients; i++ {
+			c, err := dialRESP(*addr, benchTimeout)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, serverTarget{c: c})
+		}
+	}
+	defer func() {
+		for _, t := range targets {
+			t.Close()
+		}
+	}()
+
+	// Keys are the numbers padded with zeros after a prefix upto the key size.
+	var (
+		val   = []byte(strings.Repeat("x", *valSize))
+		width = *keySize - len("bench:")
+	)
+	if width < 1 {
+		width = 1
+	}
+	key := func(i int) string {
+		return fmt.Sprintf("bench:%0*d", width, i)
+	}
+
+	if *populate {
+		fmt.Printf("populating %d keys\n", *keys)
+		for i := 0; i < *keys; i++ {
+			if err := targets[0].Set(key(i), val); err != nil {
+				return fmt.Errorf("error populating keys: %w", err)
+			}
+		}
+	}
+
+	var (
+		reads  = make([][]time.Duration, *clients)
+		writes = make([][]time.Duration, *clients)
+		errs   = make([]error, *clients)
+		wg     sync.WaitGroup
+		start  = time.Now()
+	)
+	for c := 0; c < *clients; c++ {
+		// Split the requests evenly among the clients.
+		n := *requests / *clients
+		if c < *requests%*clients {
+			n++
+		}
+
+		wg.Add(1)
+		go func(c, n int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(c)))
+			for i := 0; i < n; i++ {
+				var (
+					k     = key(rnd.Intn(*keys))
+					read  = rnd.Float64() < *readRatio
+					begin = time.Now()
+					err   error
+				)
+				if read {
+					err = targets[c].Get(k)
+				} else {
+					err = targets[c].Set(k, val)
+				}
+				if err != nil {
+					errs[c] = err
+					return
+				}
+				if read {
+					reads[c] = append(reads[c], time.Since(begin))
+				} else {
+					writes[c] = append(writes[c], time.Since(begin))
+				}
+			}
+		}(c, n)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d requests by %d clients in %s: %.0f requests/sec\n", *requests, *clients, elapsed.Round(time.Millisecond), float64(*requests)/elapsed.Seconds())
+	printLatencies("GET", reads, elapsed)
+	printLatencies("SET", writes, elapsed)
+
+	return nil
+}
+
+// printLatencies prints the throughput and the latency percentiles of the requests of all the clients.
+func printLatencies(name string, perClient [][]time.Duration, elapsed time.Duration) {
+	var all []time.Duration
+	for _, l := range perClient {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	pct := func(p float64) time.Duration {
+		return all[int(p*float64(len(all)-1))]
+	}
+	fmt.Printf("%s: %d requests, %.0f requests/sec, latency p50=%s p90=%s p99=%s p99.9=%s max=%s\n",
+		name, len(all), float64(len(all))/elapsed.Seconds(), pct(0.5), pct(0.9), pct(0.99), pct(0.999), all[len(all)-1])
+}
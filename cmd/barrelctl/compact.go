@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// runCompact merges all the datafiles of a directory which isn't in use by a server
+// in a single datafile and regenerates its hints file.
+func runCompact(args []string) error {
+	var (
+		f      = flag.NewFlagSet("compact", flag.ContinueOnError)
+		dryRun = f.Bool("dry-run", false, "Report the expected space savings without compacting.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() != 1 {
+		return errors.New("usage: barrelctl compact [--dry-run] <dir>")
+	}
+	dir := f.Arg(0)
+
+	if *dryRun {
+		brl, err := barrel.Init(barrel.WithDir(dir), barrel.WithReadOnly())
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", dir, err)
+		}
+		defer brl.Shutdown()
+
+		st := brl.Stats()
+		fmt.Printf("datafiles:         %d\n", st.DataFiles)
+		fmt.Printf("keys:              %d\n", st.Keys)
+		fmt.Printf("disk bytes:        %d\n", st.DiskBytes)
+		fmt.Printf("live bytes:        %d\n", st.LiveBytes)
+		fmt.Printf("expected savings:  %d bytes\n", st.DiskBytes-st.LiveBytes)
+		return nil
+	}
+
+	brl, err := openDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Stop the merge on an interrupt, which leaves the datafiles as they are.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	before := brl.Stats()
+	if err := brl.CompactContext(ctx); err != nil {
+		brl.Shutdown()
+		return err
+	}
+	after := brl.Stats()
+	if err := brl.Shutdown(); err != nil {
+		return err
+	}
+
+	fmt.Printf("compacted %d datafiles to %d: %d bytes -> %d bytes, saved %d bytes\n",
+		before.DataFiles, after.DataFiles, before.DiskBytes, after.DiskBytes, before.DiskBytes-after.DiskBytes)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// exportRow is a key exported along with its value and timestamps.
+type exportRow struct {
+	Key     string
+	Value   []byte
+	Written time.Time
+	Expiry  time.Time // Zero if the key doesn't expire.
+}
+
+// exporter writes the exported keys to a file.
+type exporter interface {
+	Write(r exportRow) error
+	Close() error
+}
+
+// exporters create the exporters for the file at the given path by the names of their formats.
+var exporters = map[string]func(path string) (exporter, error){}
+
+// runExport writes a snapshot of all the keys of a directory to a file which can be queried
+// by the analytics tools, without going through the server.
+func runExport(args []string) (err error) {
+	var (
+		f      = flag.NewFlagSet("export", flag.ContinueOnError)
+		dir    = f.String("dir", "", "Directory of the barrel, which is opened read-only.")
+		format = f.String("format", "sqlite", "Format of the snapshot: sqlite or parquet.")
+		out    = f.String("out", "", "Path of the snapshot file, which must not exist.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || *out == "" {
+		return errors.New("--dir and --out are required")
+	}
+	newExporter, ok := exporters[*format]
+	if !ok {
+		if *format == "sqlite" {
+			return errors.New("sqlite export needs barrelctl to be built with cgo")
+		}
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+
+	brl, err := barrel.Init(barrel.WithDir(*dir), barrel.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	defer brl.Shutdown()
+
+	ex, err := newExporter(*out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := ex.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	// The records are read sequentially from the datafiles rather than by the keys.
+	var n int
+	err = brl.FoldRecords(func(r barrel.Record) error {
+		row := exportRow{Key: r.Key, Value: r.Value, Written: time.Unix(int64(r.Header.Timestamp), 0)}
+		if r.Header.Expiry != 0 {
+			row.Expiry = time.Unix(int64(r.Header.Expiry), 0)
+		}
+		if err := ex.Write(row); err != nil {
+			return fmt.Errorf("error exporting key %q: %w", r.Key, err)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d keys to %s\n", n, *out)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func init() {
+	exporters["parquet"] = newParquetExporter
+}
+
+// parquetRow is a row of the Parquet file, with the same columns as the `kv` table of the SQLite export.
+type parquetRow struct {
+	Key       string `parquet:"name=key, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value     string `parquet:"name=value, type=BYTE_ARRAY"`
+	Timestamp int64  `parquet:"name=timestamp, type=INT64"`
+	Expiry    *int64 `parquet:"name=expiry, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+// parquetExporter writes the keys to a Parquet file compressed with snappy. The timestamps are stored
+// as unix seconds and the expiry is null for the keys which don't expire, like the SQLite export.
+type parquetExporter struct {
+	f *os.File
+	w *writer.ParquetWriter
+}
+
+func newParquetExporter(path string) (exporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w, err := writer.NewParquetWriterFromWriter(f, new(parquetRow), 1)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetExporter{f: f, w: w}, nil
+}
+
+func (e *parquetExporter) Write(r exportRow) error {
+	row := parquetRow{Key: r.Key, Value: string(r.Value), Timestamp: r.Written.Unix()}
+	if !r.Expiry.IsZero() {
+		expiry := r.Expiry.Unix()
+		row.Expiry = &expiry
+	}
+	return e.w.Write(row)
+}
+
+func (e *parquetExporter) Close() error {
+	if err := e.w.WriteStop(); err != nil {
+		e.f.Close()
+		return err
+	}
+	return e.f.Close()
+}
@@ -0,0 +1,64 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	exporters["sqlite"] = newSQLiteExporter
+}
+
+// sqliteExporter writes the keys to the `kv` table of a SQLite database in a single transaction.
+// The timestamps are stored as unix seconds and the expiry is NULL for the keys which don't expire.
+type sqliteExporter struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+func newSQLiteExporter(path string) (exporter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE kv (key TEXT PRIMARY KEY, value BLOB NOT NULL, timestamp INTEGER NOT NULL, expiry INTEGER)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO kv (key, value, timestamp, expiry) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteExporter{db: db, tx: tx, stmt: stmt}, nil
+}
+
+func (e *sqliteExporter) Write(r exportRow) error {
+	var expiry any
+	if !r.Expiry.IsZero() {
+		expiry = r.Expiry.Unix()
+	}
+	_, err := e.stmt.Exec(r.Key, r.Value, r.Written.Unix(), expiry)
+	return err
+}
+
+func (e *sqliteExporter) Close() error {
+	e.stmt.Close()
+	if err := e.tx.Commit(); err != nil {
+		e.db.Close()
+		return err
+	}
+	return e.db.Close()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// formatDump is the JSON printed by `format-dump`.
+type formatDump struct {
+	File       string              `json:"file,omitempty"`
+	Size       int64               `json:"size,omitempty"`
+	HeaderSize int                 `json:"header_size,omitempty"`
+	Format     barrel.RecordFormat `json:"format"`
+
+	// Records decoded from the byte range of the file, along with the bytes left undecoded at
+	// the end of the range and the error which stopped the decoding, if any.
+	Offset    int             `json:"offset,omitempty"`
+	Records   []segmentRecord `json:"records,omitempty"`
+	Remaining int             `json:"remaining,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// runFormatDump prints the layout of the records as JSON. Given a datafile, it prints the layout of
+// the version in its segment header, along with the records decoded from a byte range of the file.
+func runFormatDump(args []string) error {
+	var (
+		f        = flag.NewFlagSet("format-dump", flag.ContinueOnError)
+		version  = f.Int("version", barrel.RecordVersion, "Version of the record format to describe, if no file is given. -1 is the Bitcask format.")
+		all      = f.Bool("all", false, "Describe all the versions of the record format.")
+		offset   = f.Int("offset", 0, "Offset of the start of a record in the file, from which the records are decoded. Defaults to the first record.")
+		length   = f.Int("length", 0, "Number of bytes of the file to decode. 0 decodes till the end of the file.")
+		withVals = f.BoolP("values", "x", false, "Include the values of the records (base64).")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() > 1 {
+		return errors.New("usage: barrelctl format-dump [--version n | --all] [--offset n] [--length n] [--values] [file]")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if f.NArg() == 0 {
+		if *all {
+			return enc.Encode(barrel.RecordFormats())
+		}
+		format, err := barrel.GetRecordFormat(*version)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(format)
+	}
+
+	// The version of the records is read from the segment header of the file.
+	path := f.Arg(0)
+	info, err := barrel.ReadSegmentHeader(path)
+	if err != nil {
+		return err
+	}
+	format, err := barrel.GetRecordFormat(info.Version)
+	if err != nil {
+		return err
+	}
+
+	start := *offset
+	if start < info.HeaderSize {
+		start = info.HeaderSize
+	}
+	end := info.Size
+	if *length > 0 && int64(start+*length) < end {
+		end = int64(start + *length)
+	}
+	if int64(start) > end {
+		return fmt.Errorf("offset %d is beyond the size of the file: %d", start, info.Size)
+	}
+
+	data, err := readRange(path, start, int(end)-start)
+	if err != nil {
+		return err
+	}
+
+	out := formatDump{
+		File:       path,
+		Size:       info.Size,
+		HeaderSize: info.HeaderSize,
+		Format:     format,
+		Offset:     start,
+	}
+	for pos := 0; pos < len(data); {
+		r, err := barrel.DecodeRecord(data[pos:], info.Version)
+		if err != nil {
+			out.Remaining = len(data) - pos
+			out.Error = err.Error()
+			break
+		}
+
+		rec := segmentRecord{
+			Offset:    start + pos,
+			Size:      r.Size,
+			Checksum:  r.Header.Checksum,
+			Valid:     r.Valid,
+			Flags:     r.Header.Flags,
+			Timestamp: r.Header.Timestamp,
+			Expiry:    r.Header.Expiry,
+			KeySize:   r.Header.KeySize,
+			ValSize:   r.Header.ValSize,
+			MetaSize:  r.Header.MetaSize,
+			Key:       r.Key,
+			Tags:      r.Tags,
+		}
+		if *withVals {
+			rec.Value = r.Value
+		}
+		out.Records = append(out.Records, rec)
+		pos += r.Size
+	}
+
+	return enc.Encode(out)
+}
+
+// readRange reads the given number of bytes of the file starting at the offset.
+func readRange(path string, offset, size int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, int64(offset)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// runRebuildHints regenerates the hints files of a directory which isn't in use by a server
+// from its datafiles.
+func runRebuildHints(args []string) error {
+	f := flag.NewFlagSet("rebuild-hints", flag.ContinueOnError)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() != 1 {
+		return errors.New("usage: barrelctl rebuild-hints <dir>")
+	}
+	dir := f.Arg(0)
+
+	n, err := barrel.RebuildHints(dir)
+	if err != nil {
+		if errors.Is(err, barrel.ErrLocked) {
+			return fmt.Errorf("%s is in use by another process", dir)
+		}
+		return err
+	}
+
+	fmt.Printf("rebuilt %d hints files\n", n)
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const hotkeysTimeout = time.Second * 10
+
+// runHotKeys prints the most read and the most written keys of a server which tracks them.
+func runHotKeys(args []string) error {
+	var (
+		f     = flag.NewFlagSet("hotkeys", flag.ContinueOnError)
+		addr  = f.String("addr", "", "Address of the server.")
+		count = f.Int("count", 10, "Number of keys to list for each of the reads and the writes.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *addr == "" {
+		return errors.New("usage: barrelctl hotkeys --addr <addr> [--count n]")
+	}
+
+	c, err := dialRESP(*addr, hotkeysTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	reply, err := c.Do([]byte("ADMIN"), []byte("HOTKEYS"), []byte(strconv.Itoa(*count)))
+	if err != nil {
+		return err
+	}
+
+	// The reply has the name of each list followed by its keys and counts.
+	lists, ok := reply.([]any)
+	if !ok || len(lists)%2 != 0 {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	for i := 0; i < len(lists); i += 2 {
+		keys, ok := lists[i+1].([]any)
+		if !ok || len(keys)%2 != 0 {
+			return fmt.Errorf("unexpected reply: %v", reply)
+		}
+		fmt.Printf("%s:\n", lists[i])
+		for j := 0; j < len(keys); j += 2 {
+			fmt.Printf("  %2d. %-40v %v\n", j/2+1, keys[j], keys[j+1])
+		}
+	}
+	return nil
+}
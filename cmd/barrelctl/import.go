@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	flag "github.com/spf13/pflag"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	bolt "go.etcd.io/bbolt"
+)
+
+// importers read all the keys of the other embedded stores by their names.
+var importers = map[string]func(path, sep string, fn func(k, val []byte) error) error{
+	"bolt":    importBolt,
+	"badger":  importBadger,
+	"leveldb": importLevelDB,
+}
+
+// runImportKV bulk loads all the keys of another embedded store into a directory
+// which isn't in use by a server, writing them in batches with a single append each.
+func runImportKV(args []string) (err error) {
+	var (
+		f     = flag.NewFlagSet("import-kv", flag.ContinueOnError)
+		from  = f.String("from", "", "Type of the source store: bolt, badger or leveldb.")
+		path  = f.String("path", "", "Path of the source store, which is opened read-only.")
+		dir   = f.String("dir", "", "Directory of the barrel to import into.")
+		sep   = f.String("separator", ":", "Separator between the names of the nested bolt buckets and the keys.")
+		batch = f.Int("batch", 1000, "Number of keys written with a single append.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("--path is required")
+	}
+	scan, ok := importers[*from]
+	if !ok {
+		return fmt.Errorf("unknown source store '%s', expected bolt, badger or leveldb", *from)
+	}
+	if *batch < 1 {
+		return errors.New("--batch must be at least 1")
+	}
+
+	brl, err := openDir(*dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := brl.Shutdown(); err == nil {
+			err = e
+		}
+	}()
+
+	var (
+		start   = time.Now()
+		keys    int
+		size    int
+		pending = make(map[string][]byte, *batch)
+	)
+	flush := func() error {
+		if err := brl.PutMulti(pending); err != nil {
+			return fmt.Errorf("error importing batch of %d keys: %w", len(pending), err)
+		}
+		keys += len(pending)
+		pending = make(map[string][]byte, *batch)
+		return nil
+	}
+	err = scan(*path, *sep, func(k, val []byte) error {
+		// The keys and values are only valid till fn returns, so they're copied for the batch.
+		pending[string(k)] = append([]byte(nil), val...)
+		size += len(val)
+		if len(pending) < *batch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("error after importing %d keys: %w", keys, err)
+	}
+	if err := brl.Sync(); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d keys (%d bytes of values) in %s\n", keys, size, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// importBolt calls fn for every key of all the buckets of a BoltDB file. The keys are prefixed
+// with the names of their buckets, including the parent buckets, joined by the separator.
+func importBolt(path, sep string, fn func(k, val []byte) error) error {
+	db, err := bolt.Open(path, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var walk func(b *bolt.Bucket, prefix []byte) error
+	walk = func(b *bolt.Bucket, prefix []byte) error {
+		return b.ForEach(func(k, v []byte) error {
+			key := append(append([]byte{}, prefix...), k...)
+			// The value of a nested bucket is nil.
+			if v == nil {
+				return walk(b.Bucket(k), append(key, sep...))
+			}
+			return fn(key, v)
+		})
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walk(b, append(append([]byte{}, name...), sep...))
+		})
+	})
+}
+
+// importBadger calls fn for every key of a Badger directory. The deleted and expired keys are skipped.
+func importBadger(path, _ string, fn func(k, val []byte) error) error {
+	db, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(true).WithLogger(nil))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(val []byte) error {
+				return fn(item.Key(), val)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importLevelDB calls fn for every key of a LevelDB directory.
+func importLevelDB(path, _ string, fn func(k, val []byte) error) error {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
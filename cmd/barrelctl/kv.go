@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// openDir opens the barrel on the directory for a one-off operation while the server is down.
+// It fails if a server holds the lockfile of the directory.
+func openDir(dir string) (*barrel.Barrel, error) {
+	if dir == "" {
+		return nil, errors.New("--dir is required")
+	}
+	brl, err := barrel.Init(barrel.WithDir(dir))
+	if errors.Is(err, barrel.ErrLocked) {
+		return nil, fmt.Errorf("%s is in use by another process", dir)
+	}
+	return brl, err
+}
+
+// runGet prints the value of a key of a directory as is.
+func runGet(args []string) (err error) {
+	var (
+		f   = flag.NewFlagSet("get", flag.ContinueOnError)
+		dir = f.String("dir", "", "Directory of the barrel.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() != 1 {
+		return errors.New("usage: barrelctl get --dir <dir> <key>")
+	}
+
+	brl, err := openDir(*dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := brl.Shutdown(); err == nil {
+			err = e
+		}
+	}()
+
+	val, err := brl.Get(f.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(val)
+	return err
+}
+
+// runSet sets the value of a key of a directory. The value is read from stdin if it isn't given.
+func runSet(args []string) (err error) {
+	var (
+		f   = flag.NewFlagSet("set", flag.ContinueOnError)
+		dir = f.String("dir", "", "Directory of the barrel.")
+		ttl = f.Duration("ttl", 0, "Expire the key after the given duration. 0 means no expiry.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() < 1 || f.NArg() > 2 {
+		return errors.New("usage: barrelctl set --dir <dir> [--ttl <duration>] <key> [value]")
+	}
+
+	var val []byte
+	if f.NArg() == 2 {
+		val = []byte(f.Arg(1))
+	} else if val, err = io.ReadAll(os.Stdin); err != nil {
+		return fmt.Errorf("error reading value from stdin: %w", err)
+	}
+
+	brl, err := openDir(*dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := brl.Shutdown(); err == nil {
+			err = e
+		}
+	}()
+
+	if *ttl > 0 {
+		return brl.PutEx(f.Arg(0), val, *ttl)
+	}
+	return brl.Put(f.Arg(0), val)
+}
+
+// runDel deletes the keys of a directory.
+func runDel(args []string) (err error) {
+	var (
+		f   = flag.NewFlagSet("del", flag.ContinueOnError)
+		dir = f.String("dir", "", "Directory of the barrel.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if f.NArg() < 1 {
+		return errors.New("usage: barrelctl del --dir <dir> <key>...")
+	}
+
+	brl, err := openDir(*dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := brl.Shutdown(); err == nil {
+			err = e
+		}
+	}()
+
+	_, err = brl.DeleteMulti(f.Args())
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+var (
+	// Version of the build. This is injected at build-time.
+	buildString = "unknown"
+)
+
+// command is a subcommand of barrelctl.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+// commands returns all the subcommands by their names.
+func commands() map[string]command {
+	return map[string]command{
+		"bench":         {"Benchmark a server or a directory with a mix of reads and writes.", runBench},
+		"compact":       {"Merge the datafiles of a directory which isn't in use by a server.", runCompact},
+		"del":           {"Delete keys of a directory which isn't in use by a server.", runDel},
+		"export":        {"Export a snapshot of all the keys of a directory to a SQLite database or a Parquet file.", runExport},
+		"format-dump":   {"Print the layout of the records as JSON, and decode the records of a datafile.", runFormatDump},
+		"get":           {"Print the value of a key of a directory which isn't in use by a server.", runGet},
+		"hotkeys":       {"List the most read and the most written keys of a server.", runHotKeys},
+		"import-kv":     {"Import all the keys of a BoltDB, Badger or LevelDB store into a directory.", runImportKV},
+		"rebuild-hints": {"Regenerate the hints files of a directory from its datafiles.", runRebuildHints},
+		"repl":          {"Run an interactive prompt on a server or a directory.", runREPL},
+		"segment":       {"Inspect the records of a datafile with `segment cat`.", runSegment},
+		"set":           {"Set the value of a key of a directory which isn't in use by a server.", runSet},
+	}
+}
+
+func main() {
+	cmds := commands()
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "--help" || os.Args[1] == "-h" {
+		usage(cmds)
+		os.Exit(0)
+	}
+	if os.Args[1] == "version" {
+		fmt.Println(buildString)
+		os.Exit(0)
+	}
+
+	cmd, ok := cmds[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
+		usage(cmds)
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the summary of all the subcommands.
+func usage(cmds map[string]command) {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("barrelctl is a tool for managing barreldb servers and directories.")
+	fmt.Println()
+	fmt.Println("Usage: barrelctl <command> [flags]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, name := range names {
+		fmt.Printf("  %-16s %s\n", name, cmds[name].summary)
+	}
+	fmt.Printf("  %-16s %s\n", "version", "Print the version.")
+	fmt.Println()
+	fmt.Println("Run `barrelctl <command> --help` for the flags of a command.")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInterrupt is returned by readLine when the line is discarded with Ctrl-C.
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads the lines typed in the terminal with basic editing and a history which
+// is recalled with the arrow keys. If the input isn't a terminal, the lines are read as is.
+type lineReader struct {
+	in      *bufio.Reader
+	out     io.Writer
+	fd      int
+	history []string
+	path    string
+}
+
+// newLineReader returns a line reader for stdin, whose history is persisted in the file at
+// the given path if it isn't empty.
+func newLineReader(path string) *lineReader {
+	l := &lineReader{
+		in:   bufio.NewReader(os.Stdin),
+		out:  os.Stdout,
+		fd:   int(os.Stdin.Fd()),
+		path: path,
+	}
+	if path != "" {
+		if b, err := os.ReadFile(path); err == nil {
+			l.history = strings.FieldsFunc(string(b), func(r rune) bool { return r == '\n' })
+		}
+	}
+	return l
+}
+
+// addHistory appends the line to the history unless it repeats the last one.
+func (l *lineReader) addHistory(line string) {
+	if line == "" || (len(l.history) > 0 && l.history[len(l.history)-1] == line) {
+		return
+	}
+	l.history = append(l.history, line)
+
+	if l.path == "" {
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// readLine prints the prompt and returns the next line. io.EOF is returned on Ctrl-D
+// or at the end of the input.
+func (l *lineReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(l.out, prompt)
+
+	restore, err := makeRaw(l.fd)
+	if err != nil {
+		// Not a terminal, so read the line without editing.
+		line, err := l.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer restore()
+
+	var (
+		buf  []rune
+		pos  int
+		hist = len(l.history)
+		// The line being typed before the history was browsed.
+		pending []rune
+	)
+	redraw := func() {
+		fmt.Fprintf(l.out, "\r\x1b[K%s%s", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(l.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(l.history) {
+			return
+		}
+		if hist == len(l.history) {
+			pending = buf
+		}
+		hist = i
+		if i == len(l.history) {
+			buf = pending
+		} else {
+			buf = []rune(l.history[i])
+		}
+		pos = len(buf)
+		redraw()
+	}
+
+	for {
+		r, _, err := l.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(l.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(l.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(l.out, "\r\n")
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1:pos-1], buf[pos:]...)
+				pos--
+				redraw()
+			}
+		case 1: // Ctrl-A
+			pos = 0
+			redraw()
+		case 5: // Ctrl-E
+			pos = len(buf)
+			redraw()
+		case 21: // Ctrl-U
+			buf, pos = buf[pos:], 0
+			redraw()
+		case 27: // Escape sequences of the arrow keys.
+			if b, _ := l.in.ReadByte(); b != '[' {
+				continue
+			}
+			b, _ := l.in.ReadByte()
+			switch b {
+			case 'A':
+				recall(hist - 1)
+			case 'B':
+				recall(hist + 1)
+			case 'C':
+				if pos < len(buf) {
+					pos++
+					redraw()
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+					redraw()
+				}
+			}
+		default:
+			if r < 32 {
+				continue
+			}
+			buf = append(buf[:pos:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+			redraw()
+		}
+	}
+}
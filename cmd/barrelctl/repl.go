@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	flag "github.com/spf13/pflag"
+)
+
+// replTimeout is the timeout for each command sent to the server.
+const replTimeout = time.Second * 10
+
+// replTarget is a server or a directory used by the REPL.
+type replTarget interface {
+	// Get returns the value of the key, or nil if it doesn't exist.
+	Get(k string) ([]byte, error)
+	Set(k string, val []byte) error
+	Del(k string) error
+	// Scan returns the keys with the given prefix in sorted order.
+	Scan(prefix string) ([]string, error)
+	Stats() (string, error)
+	Close() error
+}
+
+// dirREPL runs the commands on a barrel opened on a directory.
+type dirREPL struct {
+	brl *barrel.Barrel
+}
+
+func (t dirREPL) Get(k string) ([]byte, error) {
+	val, err := t.brl.Get(k)
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (t dirREPL) Set(k string, val []byte) error {
+	return t.brl.Put(k, val)
+}
+
+func (t dirREPL) Del(k string) error {
+	return t.brl.Delete(k)
+}
+
+func (t dirREPL) Scan(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	for _, k := range t.brl.List() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (t dirREPL) Stats() (string, error) {
+	st := t.brl.Stats()
+	return fmt.Sprintf("keys:%d\ndatafiles:%d\nlive_bytes:%d\ndisk_bytes:%d\nkeydir_bytes:%d\nstorage_full:%t",
+		st.Keys, st.DataFiles, st.LiveBytes, st.DiskBytes, st.KeydirBytes, st.StorageFull), nil
+}
+
+func (t dirREPL) Close() error {
+	return t.brl.Shutdown()
+}
+
+// serverREPL runs the commands on a server.
+type serverREPL struct {
+	c *respClient
+}
+
+func (t serverREPL) Get(k string) ([]byte, error) {
+	v, err := t.c.Do([]byte("GET"), []byte(k))
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return []byte(v.(string)), nil
+}
+
+func (t serverREPL) Set(k string, val []byte) error {
+	_, err := t.c.Do([]byte("SET"), []byte(k), val)
+	return err
+}
+
+func (t serverREPL) Del(k string) error {
+	_, err := t.c.Do([]byte("DEL"), []byte(k))
+	return err
+}
+
+func (t serverREPL) Scan(prefix string) ([]string, error) {
+	// Escape the special characters of the glob pattern in the prefix.
+	var pattern strings.Builder
+	for _, r := range prefix {
+		if strings.ContainsRune(`*?[]\`, r) {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	pattern.WriteByte('*')
+
+	v, err := t.c.Do([]byte("KEYS"), []byte(pattern.String()))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := v.([]any)
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if k, ok := item.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (t serverREPL) Stats() (string, error) {
+	v, err := t.c.Do([]byte("INFO"))
+	if err != nil {
+		return "", err
+	}
+	s, _ := v.(string)
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n")), nil
+}
+
+func (t serverREPL) Close() error {
+	return t.c.Close()
+}
+
+// replHelp is printed by the help command.
+const replHelp = `get <key>            Print the value of the key.
+set <key> <value>    Set the value of the key.
+del <key>            Delete the key.
+scan [prefix]        List the keys, optionally with the given prefix.
+stats                Print the statistics.
+help                 Print this help.
+quit                 Exit.
+Arguments with spaces can be double quoted, with Go escapes like "a\tb".`
+
+// runREPL runs an interactive prompt for quick checks of the data of a server or a directory.
+func runREPL(args []string) error {
+	var (
+		f    = flag.NewFlagSet("repl", flag.ContinueOnError)
+		addr = f.String("addr", "", "Address of the server.")
+		dir  = f.String("dir", "", "Directory to open in embedded mode, if no address is given.")
+	)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	if (*addr == "") == (*dir == "") {
+		return errors.New("either --addr or --dir is required")
+	}
+
+	var (
+		target replTarget
+		prompt string
+	)
+	if *dir != "" {
+		brl, err := barrel.Init(barrel.WithDir(*dir))
+		if err != nil {
+			return err
+		}
+		target, prompt = dirREPL{brl: brl}, *dir+"> "
+	} else {
+		c, err := dialRESP(*addr, replTimeout)
+		if err != nil {
+			return err
+		}
+		target, prompt = serverREPL{c: c}, *addr+"> "
+	}
+	defer target.Close()
+
+	var history string
+	if home, err := os.UserHomeDir(); err == nil {
+		history = filepath.Join(home, ".barrelctl_history")
+	}
+	lr := newLineReader(history)
+
+	for {
+		line, err := lr.readLine(prompt)
+		if errors.Is(err, errInterrupt) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lr.addHistory(line)
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println("(error)", err)
+			continue
+		}
+		if cmd := strings.ToLower(args[0]); cmd == "quit" || cmd == "exit" {
+			return nil
+		}
+		if err := runREPLCommand(target, args); err != nil {
+			fmt.Println("(error)", err)
+		}
+	}
+}
+
+// runREPLCommand runs a single command of the REPL and prints its result.
+func runREPLCommand(t replTarget, args []string) error {
+	cmd, args := strings.ToLower(args[0]), args[1:]
+
+	switch {
+	case cmd == "help":
+		fmt.Println(replHelp)
+	case cmd == "get" && len(args) == 1:
+		val, err := t.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			fmt.Println("(nil)")
+			return nil
+		}
+		fmt.Printf("%q\n", val)
+	case cmd == "set" && len(args) == 2:
+		if err := t.Set(args[0], []byte(args[1])); err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case cmd == "del" && len(args) == 1:
+		if err := t.Del(args[0]); err != nil {
+			return err
+		}
+		fmt.Println("OK")
+	case cmd == "scan" && len(args) <= 1:
+		var prefix string
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		keys, err := t.Scan(prefix)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			fmt.Println("(empty)")
+		}
+		for i, k := range keys {
+			fmt.Printf("%d) %q\n", i+1, k)
+		}
+	case cmd == "stats" && len(args) == 0:
+		s, err := t.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Println(s)
+	case cmd == "get" || cmd == "set" || cmd == "del" || cmd == "scan" || cmd == "stats":
+		return fmt.Errorf("wrong number of arguments for '%s', see help", cmd)
+	default:
+		return fmt.Errorf("unknown command '%s', see help", cmd)
+	}
+
+	return nil
+}
+
+// splitArgs splits the line into arguments separated by spaces. Double quoted arguments
+// can have spaces and the escapes of Go string literals.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		// Find the closing quote which isn't escaped.
+		end := 1
+		for ; end < len(line) && line[end] != '"'; end++ {
+			if line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return nil, errors.New("unbalanced quotes")
+		}
+		arg, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted argument: %s", line[:end+1])
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+	}
+}
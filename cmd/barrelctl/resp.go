@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respError is an error reply from the server.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respClient is a minimal client for a RESP server. It isn't safe for concurrent use.
+type respClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// dialRESP connects to the server at the given address. Each command times out after the given duration.
+func dialRESP(addr string, timeout time.Duration) (*respClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &respClient{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), timeout: timeout}, nil
+}
+
+// Do sends the command and returns its reply, which is a string, an int64, nil or a []any of them.
+// Error replies are returned as a respError.
+func (c *respClient) Do(args ...[]byte) (any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(arg))
+		c.w.Write(arg)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// Close closes the connection to the server.
+func (c *respClient) Close() error {
+	return c.conn.Close()
+}
+
+// read reads a single reply.
+func (c *respClient) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("invalid reply: %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// Error replies within an array are returned as the items themselves.
+			if items[i], err = c.read(); err != nil && !errors.As(err, new(respError)) {
+				return nil, err
+			}
+			if e, ok := err.(respError); ok {
+				items[i] = e
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("invalid reply: %q", line)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// makeRaw isn't supported on platforms other than linux, where the lines are read without editing.
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.New("raw mode is not supported")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/redcon"
+)
+
+// defaultHotKeys is the number of keys listed by `ADMIN HOTKEYS` if the count isn't given.
+const defaultHotKeys = 10
+
+// admin handles the `ADMIN` subcommands used for operating the server.
+func (app *App) admin(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	switch sub := strings.ToLower(string(cmd.Args[1])); {
+	case sub == "hotkeys" && len(cmd.Args) <= 3:
+		// ADMIN HOTKEYS [count]
+		n := defaultHotKeys
+		if len(cmd.Args) == 3 {
+			var err error
+			n, err = strconv.Atoi(string(cmd.Args[2]))
+			if err != nil || n < 1 {
+				conn.WriteError("ERR count must be a positive integer")
+				return
+			}
+		}
+		reads, writes, err := app.barrel.HotKeys(n)
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+
+		// Reply with the keys and their counts under each of the two lists.
+		conn.WriteArray(4)
+		conn.WriteBulkString("most_read")
+		writeKeyCounts(conn, reads, func(a barrel.KeyAccess) uint64 { return a.Reads })
+		conn.WriteBulkString("most_written")
+		writeKeyCounts(conn, writes, func(a barrel.KeyAccess) uint64 { return a.Writes })
+
+	case sub == "prefixstats" && len(cmd.Args) == 3:
+		// ADMIN PREFIXSTATS prefix
+		stats := app.barrel.PrefixStats(string(cmd.Args[2]))
+		conn.WriteArray(4)
+		conn.WriteBulkString("keys")
+		conn.WriteInt(stats.Keys)
+		conn.WriteBulkString("bytes")
+		conn.WriteInt(stats.Bytes)
+
+	default:
+		conn.WriteError("ERR unknown subcommand or wrong number of arguments for '" + string(cmd.Args[1]) + "'")
+	}
+}
+
+// writeKeyCounts writes the keys followed by their counts as a flat array.
+func writeKeyCounts(conn redcon.Conn, keys []barrel.KeyAccess, count func(barrel.KeyAccess) uint64) {
+	conn.WriteArray(len(keys) * 2)
+	for _, a := range keys {
+		conn.WriteBulkString(a.Key)
+		conn.WriteInt64(int64(count(a)))
+	}
+}
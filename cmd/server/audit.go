@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/zerodha/logf"
+)
+
+const (
+	// auditQueueSize is the max number of audit events waiting to be written to the sinks.
+	// Commands block while the queue is full, so that no event is lost.
+	auditQueueSize = 1024
+	// auditWebhookTimeout is the timeout for posting an audit event to the webhook.
+	auditWebhookTimeout = time.Second * 5
+	// defaultUser is the user of all the clients since authentication isn't supported yet.
+	defaultUser = "default"
+)
+
+// auditEvent is a single entry of the audit log.
+type auditEvent struct {
+	Time    time.Time `json:"time"`
+	Addr    string    `json:"addr"`
+	User    string    `json:"user"`
+	Command string    `json:"command"`
+	Key     string    `json:"key,omitempty"`
+}
+
+// auditSink is a destination of the audit log.
+type auditSink interface {
+	Write(e auditEvent) error
+	Close() error
+}
+
+// auditor writes the audit events to all the sinks in background, in the order of the commands.
+type auditor struct {
+	lo     logf.Logger
+	sinks  []auditSink
+	events chan auditEvent
+	done   chan struct{}
+}
+
+func newAuditor(lo logf.Logger, sinks []auditSink) *auditor {
+	a := &auditor{
+		lo:     lo,
+		sinks:  sinks,
+		events: make(chan auditEvent, auditQueueSize),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+	for e := range a.events {
+		for _, s := range a.sinks {
+			if err := s.Write(e); err != nil {
+				a.lo.Error("error writing audit event", "command", e.Command, "key", e.Key, "error", err)
+			}
+		}
+	}
+}
+
+// Close writes the pending events and closes the sinks.
+func (a *auditor) Close() {
+	close(a.events)
+	<-a.done
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			a.lo.Error("error closing audit sink", "error", err)
+		}
+	}
+}
+
+// audit wraps the handler of a command which modifies the data, so that it's recorded in the audit log.
+// The handler is returned as is if the audit log isn't enabled.
+func (app *App) audit(handler redcon.HandlerFunc) redcon.HandlerFunc {
+	if app.auditor == nil {
+		return handler
+	}
+
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		e := auditEvent{
+			Time:    time.Now(),
+			Addr:    conn.RemoteAddr(),
+			User:    defaultUser,
+			Command: strings.ToLower(string(cmd.Args[0])),
+		}
+		if len(cmd.Args) > 1 {
+			e.Key = string(cmd.Args[1])
+		}
+		app.auditor.events <- e
+
+		handler(conn, cmd)
+	}
+}
+
+// fileSink appends the audit events as JSON lines to a file, which is synced after every event.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(e auditEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// webhookSink posts each audit event as JSON to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: auditWebhookTimeout}}
+}
+
+func (s *webhookSink) Write(e auditEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
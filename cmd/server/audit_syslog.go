@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink writes the audit events as JSON to the local syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(tag string) (auditSink, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(e auditEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.w.Notice(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
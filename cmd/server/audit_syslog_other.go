@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+// newSyslogSink isn't supported on the platforms without syslog.
+func newSyslogSink(tag string) (auditSink, error) {
+	return nil, errors.New("syslog isn't supported on this platform")
+}
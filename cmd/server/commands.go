@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+// commands returns the handlers of all the commands by their names.
+func (app *App) commands() map[string]redcon.HandlerFunc {
+	return map[string]redcon.HandlerFunc{
+		"ping":      app.ping,
+		"quit":      app.quit,
+		"set":       app.audit(app.set),
+		"get":       app.get,
+		"getver":    app.getver,
+		"setver":    app.audit(app.setver),
+		"getset":    app.audit(app.getset),
+		"getex":     app.audit(app.getex),
+		"expireat":  app.audit(app.expireat),
+		"pexpireat": app.audit(app.pexpireat),
+		"setrange":  app.audit(app.setrange),
+		"getbit":    app.getbit,
+		"setbit":    app.audit(app.setbit),
+		"bitcount":  app.bitcount,
+		"mget":      app.mget,
+		"del":       app.audit(app.delete),
+		"rename":    app.audit(app.rename),
+		"renamenx":  app.audit(app.renamenx),
+		"flushdb":   app.audit(app.flushdb),
+		"flushall":  app.audit(app.flushdb),
+		"keys":      app.keys,
+		"scan":      app.scan,
+		"tagscan":   app.tagscan,
+		"randomkey": app.randomkey,
+		"type":      app.typ,
+		"object":    app.object,
+		"info":      app.info,
+		"debug":     app.audit(app.debug),
+		"admin":     app.admin,
+		"latency":   app.latencyCmd,
+		"xadd":      app.audit(app.xadd),
+		"xlen":      app.xlen,
+		"xrange":    app.xrange,
+		"xread":     app.xread,
+		"json.set":  app.audit(app.jsonSet),
+		"json.get":  app.jsonGet,
+		"json.del":  app.audit(app.jsonDel),
+		"multi":     app.multi,
+		"exec":      app.exec,
+		"discard":   app.discard,
+		"watch":     app.watch,
+		"unwatch":   app.unwatch,
+	}
+}
+
+// newMux returns a mux which dispatches the commands, including those registered by the plugins, to their
+// handlers. Like `rename-command` of Redis, the commands can be renamed by mapping their names to the new
+// names, or disabled by mapping them to "".
+func (app *App) newMux(renames map[string]string) (*redcon.ServeMux, error) {
+	handlers := app.commands()
+	for name, handler := range app.plugins.commands {
+		if _, ok := handlers[name]; ok {
+			return nil, fmt.Errorf("plugin registers an existing command: %s", name)
+		}
+		handlers[name] = handler
+	}
+
+	names := make(map[string]string, len(handlers))
+	for name := range handlers {
+		names[name] = name
+	}
+	for name, renamed := range renames {
+		name = strings.ToLower(name)
+		if _, ok := handlers[name]; !ok {
+			return nil, fmt.Errorf("unknown command to rename: %s", name)
+		}
+		names[name] = strings.ToLower(renamed)
+	}
+
+	mux := redcon.NewServeMux()
+	registered := make(map[string]bool, len(handlers))
+	app.latency = make(map[string]*histogram, len(handlers))
+	for name, handler := range handlers {
+		renamed := names[name]
+		if renamed == "" {
+			app.lo.Info("disabled command", "command", name)
+			continue
+		}
+		if registered[renamed] {
+			return nil, fmt.Errorf("command %s is renamed to an existing command: %s", name, renamed)
+		}
+		registered[renamed] = true
+
+		// Queue the commands in the transactions, except those controlling the transactions,
+		// once they're accepted by the validators of the plugins.
+		if !txnCommands[name] {
+			handler = app.queueable(handler)
+		}
+		handler = app.validated(handler)
+
+		// Limit the commands accessing the barrel, and record the latency including the wait
+		// for the limit under the name of the command it's called with.
+		if !unlimitedCommands[name] {
+			handler = app.admission.admit(handler)
+		}
+		app.latency[renamed] = &histogram{}
+		mux.HandleFunc(renamed, timed(app.latency[renamed], handler))
+	}
+	app.registered = registered
+
+	return mux, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/redcon"
+)
+
+// debug handles the `DEBUG` subcommands used for testing and troubleshooting the server.
+func (app *App) debug(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	switch sub := strings.ToLower(string(cmd.Args[1])); {
+	case sub == "sleep" && len(cmd.Args) == 3:
+		// DEBUG SLEEP seconds
+		secs, err := strconv.ParseFloat(string(cmd.Args[2]), 64)
+		if err != nil {
+			conn.WriteError("ERR value is not a valid float")
+			return
+		}
+		time.Sleep(time.Duration(secs * float64(time.Second)))
+		conn.WriteString("OK")
+
+	case sub == "reload" && len(cmd.Args) == 2:
+		// DEBUG RELOAD
+		if err := app.barrel.Reload(); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "compact" && len(cmd.Args) == 2:
+		// DEBUG COMPACT
+		if err := app.barrel.Compact(); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "object" && len(cmd.Args) == 3:
+		// DEBUG OBJECT key
+		info, err := app.barrel.Inspect(string(cmd.Args[2]))
+		if errors.Is(err, barrel.ErrKeyNotFound) {
+			conn.WriteError("ERR no such key")
+			return
+		}
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		conn.WriteString(fmt.Sprintf("type:%s encoding:%s file_id:%d offset:%d size:%d checksum:%08x idle:%d",
+			info.Type, info.Encoding, info.FileID, info.Offset, info.Size, info.Checksum, int64(info.Idle.Seconds())))
+
+	default:
+		conn.WriteError("ERR unknown subcommand or wrong number of arguments for '" + string(cmd.Args[1]) + "'")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zerodha/logf"
+)
+
+// consulTimeout is the timeout for each request to the Consul agent.
+const consulTimeout = time.Second * 5
+
+// consulRegistry registers the server as a service in the local Consul agent, so that clients
+// and failover agents can discover it. The service has a TTL check which is refreshed in background,
+// so the service turns critical and is deregistered by Consul if the server dies without deregistering.
+type consulRegistry struct {
+	lo     logf.Logger
+	url    string
+	id     string
+	ttl    time.Duration
+	client *http.Client
+	done   chan struct{}
+}
+
+// consulService is the definition of a service registered in the Consul agent.
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Tags    []string          `json:"Tags"`
+	Meta    map[string]string `json:"Meta"`
+	Check   consulCheck       `json:"Check"`
+}
+
+type consulCheck struct {
+	CheckID                        string `json:"CheckID"`
+	TTL                            string `json:"TTL"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+}
+
+// newConsulRegistry registers the server with the given address as the given service in the
+// Consul agent at the given URL and starts refreshing its TTL check.
+func newConsulRegistry(lo logf.Logger, url, service, addr string, ttl time.Duration) (*consulRegistry, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid advertised address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid advertised port: %w", err)
+	}
+
+	r := &consulRegistry{
+		lo:     lo,
+		url:    strings.TrimSuffix(url, "/"),
+		id:     fmt.Sprintf("%s-%s-%d", service, host, port),
+		ttl:    ttl,
+		client: &http.Client{Timeout: consulTimeout},
+		done:   make(chan struct{}),
+	}
+
+	// Replication is not implemented yet, so the server is always registered as a primary.
+	svc := consulService{
+		ID:      r.id,
+		Name:    service,
+		Address: host,
+		Port:    port,
+		Tags:    []string{"master"},
+		Meta:    map[string]string{"role": "master", "version": buildString},
+		Check: consulCheck{
+			CheckID:                        r.checkID(),
+			TTL:                            ttl.String(),
+			DeregisterCriticalServiceAfter: (ttl * 10).String(),
+		},
+	}
+	if err := r.put("/v1/agent/service/register", svc); err != nil {
+		return nil, fmt.Errorf("error registering service: %w", err)
+	}
+	if err := r.pass(); err != nil {
+		return nil, fmt.Errorf("error passing service check: %w", err)
+	}
+
+	go r.refresh()
+	return r, nil
+}
+
+// refresh passes the TTL check of the service at a third of the TTL, so that a failed request can be retried.
+func (r *consulRegistry) refresh() {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.pass(); err != nil {
+				r.lo.Error("error passing service check", "id", r.id, "error", err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Close stops refreshing the TTL check and deregisters the service.
+func (r *consulRegistry) Close() error {
+	close(r.done)
+	return r.put("/v1/agent/service/deregister/"+r.id, nil)
+}
+
+func (r *consulRegistry) checkID() string {
+	return "service:" + r.id
+}
+
+func (r *consulRegistry) pass() error {
+	return r.put("/v1/agent/check/pass/"+r.checkID(), nil)
+}
+
+// put sends a PUT request to the Consul agent with the given body encoded as JSON, if any.
+func (r *consulRegistry) put(path string, body any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPut, r.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from consul: %s", resp.Status)
+	}
+	return nil
+}
+
+// advertiseAddr returns the address of the server registered for the clients. If the host of the
+// listen address is empty or unspecified, it's replaced with the hostname of the machine.
+func advertiseAddr(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
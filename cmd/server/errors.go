@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+// errorPrefixes maps the errors of barrel to the error prefixes used by Redis for
+// similar errors, so that clients can handle them without parsing the message.
+// Errors not present here use the generic `ERR` prefix.
+var errorPrefixes = []struct {
+	err    error
+	prefix string
+}{
+	{barrel.ErrReadOnly, "READONLY"},
+	{barrel.ErrMaxDataSize, "OOM"},
+	{barrel.ErrStorageFull, "MISCONF"},
+	{barrel.ErrWriteStall, "TRYAGAIN"},
+}
+
+// respError returns the RESP error string for the given error.
+func respError(err error) string {
+	for _, e := range errorPrefixes {
+		if errors.Is(err, e.err) {
+			return e.prefix + " " + err.Error()
+		}
+	}
+	return "ERR " + err.Error()
+}
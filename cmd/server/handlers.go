@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/match"
 	"github.com/tidwall/redcon"
 )
 
@@ -17,6 +23,16 @@ func (app *App) quit(conn redcon.Conn, cmd redcon.Command) {
 }
 
 func (app *App) set(conn redcon.Conn, cmd redcon.Command) {
+	// SET key value [expiry] [ID token]
+	var (
+		token     string
+		withToken bool
+	)
+	if n := len(cmd.Args); n >= 5 && strings.EqualFold(string(cmd.Args[n-2]), "id") {
+		token, withToken = string(cmd.Args[n-1]), true
+		cmd.Args = cmd.Args[:n-2]
+	}
+
 	var (
 		withExpiry bool
 	)
@@ -34,19 +50,33 @@ func (app *App) set(conn redcon.Conn, cmd redcon.Command) {
 		key = string(cmd.Args[1])
 		val = cmd.Args[2]
 	)
-	if withExpiry {
+	if withToken {
+		// Replays of a write with the same token are acknowledged without writing again.
+		var ex time.Duration
+		if withExpiry {
+			var err error
+			if ex, err = time.ParseDuration(string(cmd.Args[3])); err != nil {
+				conn.WriteError("ERR invalid duration" + string(cmd.Args[3]))
+				return
+			}
+		}
+		if _, err := app.barrel.PutIdempotent(key, val, ex, token); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+	} else if withExpiry {
 		expiry, err := time.ParseDuration(string(cmd.Args[3]))
 		if err != nil {
 			conn.WriteError("ERR invalid duration" + string(cmd.Args[3]))
 			return
 		}
 		if err := app.barrel.PutEx(key, val, expiry); err != nil {
-			conn.WriteString(fmt.Sprintf("ERR: %s", err))
+			conn.WriteError(respError(err))
 			return
 		}
 	} else {
 		if err := app.barrel.Put(key, val); err != nil {
-			conn.WriteString(fmt.Sprintf("ERR: %s", err))
+			conn.WriteError(respError(err))
 			return
 		}
 	}
@@ -62,28 +92,588 @@ func (app *App) get(conn redcon.Conn, cmd redcon.Command) {
 	var (
 		key = string(cmd.Args[1])
 	)
-	val, err := app.barrel.Get(key)
+	// The value is released once it's copied to the output buffer of the connection.
+	ref, err := app.barrel.GetRef(key)
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteNull()
+		return
+	}
 	if err != nil {
-		conn.WriteString(fmt.Sprintf("ERR: %s", err))
+		conn.WriteError(respError(err))
 		return
 	}
+	defer ref.Release()
 
-	conn.WriteBulk(val)
+	conn.WriteBulk(ref.Value)
+}
+
+func (app *App) setver(conn redcon.Conn, cmd redcon.Command) {
+	// SETVER key value
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	v, err := app.barrel.PutVersioned(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteUint64(uint64(v))
+}
+
+func (app *App) getver(conn redcon.Conn, cmd redcon.Command) {
+	// GETVER key [version]
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	var (
+		key = string(cmd.Args[1])
+		val []byte
+		v   barrel.Version
+		err error
+	)
+	if len(cmd.Args) == 3 {
+		n, perr := strconv.ParseUint(string(cmd.Args[2]), 10, 64)
+		if perr != nil {
+			conn.WriteError("ERR invalid version")
+			return
+		}
+		val, v, err = app.barrel.GetIfModified(key, barrel.Version(n))
+	} else {
+		val, v, err = app.barrel.GetVersioned(key)
+	}
+
+	// Reply with the version and the value, which is null if it isn't modified since the given version.
+	switch {
+	case errors.Is(err, barrel.ErrNotModified):
+		conn.WriteArray(2)
+		conn.WriteUint64(uint64(v))
+		conn.WriteNull()
+	case errors.Is(err, barrel.ErrKeyNotFound):
+		conn.WriteNull()
+	case err != nil:
+		conn.WriteError(respError(err))
+	default:
+		conn.WriteArray(2)
+		conn.WriteUint64(uint64(v))
+		conn.WriteBulk(val)
+	}
+}
+
+func (app *App) mget(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	keys := make([]string, 0, len(cmd.Args)-1)
+	for _, arg := range cmd.Args[1:] {
+		keys = append(keys, string(arg))
+	}
+
+	vals, err := app.barrel.GetMulti(keys)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteArray(len(vals))
+	for _, val := range vals {
+		if val == nil {
+			conn.WriteNull()
+			continue
+		}
+		conn.WriteBulk(val)
+	}
 }
 
 func (app *App) delete(conn redcon.Conn, cmd redcon.Command) {
+	// DEL key [key ...]
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	keys := make([]string, 0, len(cmd.Args)-1)
+	for _, k := range cmd.Args[1:] {
+		keys = append(keys, string(k))
+	}
+	n, err := app.barrel.DeleteMulti(keys)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(n)
+}
+
+func (app *App) keys(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	var (
+		pattern = string(cmd.Args[1])
+		keys    = make([]string, 0)
+	)
+	for _, k := range app.barrel.List() {
+		if match.Match(k, pattern) {
+			keys = append(keys, k)
+		}
+	}
+
+	conn.WriteArray(len(keys))
+	for _, k := range keys {
+		conn.WriteBulkString(k)
+	}
+}
+
+// defaultScanCount is the number of keys listed by each call of SCAN if the count isn't given.
+const defaultScanCount = 10
+
+func (app *App) scan(conn redcon.Conn, cmd redcon.Command) {
+	// SCAN cursor [MATCH pattern] [COUNT count]
+	if len(cmd.Args) < 2 || len(cmd.Args)%2 != 0 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	// The cursor is the hex encoded last key of the previous page, and "0" to start or once done.
+	var cursor []byte
+	if c := string(cmd.Args[1]); c != "0" {
+		var err error
+		if cursor, err = hex.DecodeString(c); err != nil || len(cursor) == 0 {
+			conn.WriteError("ERR invalid cursor")
+			return
+		}
+	}
+
+	var (
+		pattern = "*"
+		count   = defaultScanCount
+	)
+	for i := 2; i < len(cmd.Args); i += 2 {
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "match":
+			pattern = string(cmd.Args[i+1])
+		case "count":
+			n, err := strconv.Atoi(string(cmd.Args[i+1]))
+			if err != nil || n <= 0 {
+				conn.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			conn.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	// Like Redis, the pattern is matched after listing the page, so a page may have fewer keys than the count.
+	page, next := app.barrel.ListPage(string(cursor), count)
+	keys := make([]string, 0, len(page))
+	for _, k := range page {
+		if match.Match(k, pattern) {
+			keys = append(keys, k)
+		}
+	}
+
+	conn.WriteArray(2)
+	if next == "" {
+		conn.WriteBulkString("0")
+	} else {
+		conn.WriteBulkString(hex.EncodeToString([]byte(next)))
+	}
+	conn.WriteArray(len(keys))
+	for _, k := range keys {
+		conn.WriteBulkString(k)
+	}
+}
+
+func (app *App) tagscan(conn redcon.Conn, cmd redcon.Command) {
 	if len(cmd.Args) != 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return
 	}
+
+	keys := app.barrel.KeysByTag(string(cmd.Args[1]))
+	conn.WriteArray(len(keys))
+	for _, k := range keys {
+		conn.WriteBulkString(k)
+	}
+}
+
+func (app *App) info(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) > 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	// Return all sections unless a specific one is asked for.
+	section := "all"
+	if len(cmd.Args) == 2 {
+		section = strings.ToLower(string(cmd.Args[1]))
+	}
+
+	var (
+		sb    strings.Builder
+		found bool
+	)
+	for _, s := range app.infoSections() {
+		if section != "all" && section != s.name {
+			continue
+		}
+		found = true
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + s.title + "\r\n")
+		for _, f := range s.fields {
+			sb.WriteString(fmt.Sprintf("%s:%v\r\n", f[0], f[1]))
+		}
+	}
+	if !found {
+		conn.WriteBulkString("")
+		return
+	}
+
+	conn.WriteBulkString(sb.String())
+}
+
+func (app *App) flushdb(conn redcon.Conn, cmd redcon.Command) {
+	// FLUSHDB [ASYNC|SYNC]
+	if len(cmd.Args) > 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	// The keys are always dropped right away and the datafiles are deleted in background,
+	// so both the modes are accepted for compatibility with the Redis clients.
+	if len(cmd.Args) == 2 {
+		switch strings.ToLower(string(cmd.Args[1])) {
+		case "async", "sync":
+		default:
+			conn.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	if err := app.barrel.DropAll(); err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteString("OK")
+}
+
+func (app *App) randomkey(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	k, err := app.barrel.RandomKey()
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteBulkString(k)
+}
+
+func (app *App) rename(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	err := app.barrel.Rename(string(cmd.Args[1]), string(cmd.Args[2]))
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteError("ERR no such key")
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteString("OK")
+}
+
+func (app *App) renamenx(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	renamed, err := app.barrel.RenameNX(string(cmd.Args[1]), string(cmd.Args[2]))
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteError("ERR no such key")
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(boolToInt(renamed))
+}
+
+func (app *App) getset(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	old, err := app.barrel.GetSet(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+	if old == nil {
+		conn.WriteNull()
+		return
+	}
+
+	conn.WriteBulk(old)
+}
+
+func (app *App) getex(conn redcon.Conn, cmd redcon.Command) {
+	// GETEX key [EX seconds | PX milliseconds | PERSIST]
+	if len(cmd.Args) < 2 || len(cmd.Args) > 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
 	var (
 		key = string(cmd.Args[1])
+		ex  time.Duration
 	)
-	err := app.barrel.Delete(key)
+	switch len(cmd.Args) {
+	case 2:
+		// Without any option, it's the same as GET.
+		app.get(conn, cmd)
+		return
+	case 3:
+		// The expiry is removed if it's 0.
+		if strings.ToLower(string(cmd.Args[2])) != "persist" {
+			conn.WriteError("ERR syntax error")
+			return
+		}
+	case 4:
+		n, err := strconv.ParseInt(string(cmd.Args[3]), 10, 64)
+		if err != nil || n <= 0 {
+			conn.WriteError("ERR invalid expire time in '" + string(cmd.Args[0]) + "' command")
+			return
+		}
+		switch strings.ToLower(string(cmd.Args[2])) {
+		case "ex":
+			ex = time.Duration(n) * time.Second
+		case "px":
+			ex = time.Duration(n) * time.Millisecond
+		default:
+			conn.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	val, err := app.barrel.GetEx(key, ex)
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteBulk(val)
+}
+
+func (app *App) expireat(conn redcon.Conn, cmd redcon.Command) {
+	// EXPIREAT key unix-time-seconds
+	app.expireAt(conn, cmd, func(n int64) time.Time { return time.Unix(n, 0) })
+}
+
+func (app *App) pexpireat(conn redcon.Conn, cmd redcon.Command) {
+	// PEXPIREAT key unix-time-milliseconds
+	app.expireAt(conn, cmd, time.UnixMilli)
+}
+
+// expireAt sets the expiry of the key to the absolute time parsed from the integer argument.
+func (app *App) expireAt(conn redcon.Conn, cmd redcon.Command, parse func(int64) time.Time) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	n, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	// Reply with 1 if the expiry is set, and 0 if the key doesn't exist.
+	err = app.barrel.ExpireAt(string(cmd.Args[1]), parse(n))
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteInt(0)
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(1)
+}
+
+func (app *App) typ(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	info, err := app.barrel.Inspect(string(cmd.Args[1]))
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteString("none")
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteString(info.Type)
+}
+
+func (app *App) object(conn redcon.Conn, cmd redcon.Command) {
+	// OBJECT ENCODING|IDLETIME key
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	sub := strings.ToLower(string(cmd.Args[1]))
+	if sub != "encoding" && sub != "idletime" {
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'")
+		return
+	}
+
+	info, err := app.barrel.Inspect(string(cmd.Args[2]))
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	if sub == "encoding" {
+		conn.WriteBulkString(info.Encoding)
+		return
+	}
+	conn.WriteInt64(int64(info.Idle.Seconds()))
+}
+
+func (app *App) setrange(conn redcon.Conn, cmd redcon.Command) {
+	// SETRANGE key offset value
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	offset, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	n, err := app.barrel.SetRange(string(cmd.Args[1]), offset, cmd.Args[3])
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(n)
+}
+
+func (app *App) getbit(conn redcon.Conn, cmd redcon.Command) {
+	// GETBIT key offset
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	offset, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError("ERR bit offset is not an integer or out of range")
+		return
+	}
+	bit, err := app.barrel.GetBit(string(cmd.Args[1]), offset)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(bit)
+}
+
+func (app *App) setbit(conn redcon.Conn, cmd redcon.Command) {
+	// SETBIT key offset value
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	offset, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError("ERR bit offset is not an integer or out of range")
+		return
+	}
+	bit, err := strconv.Atoi(string(cmd.Args[3]))
+	if err != nil || (bit != 0 && bit != 1) {
+		conn.WriteError("ERR bit is not an integer or out of range")
+		return
+	}
+	old, err := app.barrel.SetBit(string(cmd.Args[1]), offset, bit)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(old)
+}
+
+func (app *App) bitcount(conn redcon.Conn, cmd redcon.Command) {
+	// BITCOUNT key [start end]
+	if len(cmd.Args) != 2 && len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	start, end := 0, -1
+	if len(cmd.Args) == 4 {
+		var err1, err2 error
+		start, err1 = strconv.Atoi(string(cmd.Args[2]))
+		end, err2 = strconv.Atoi(string(cmd.Args[3]))
+		if err1 != nil || err2 != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	}
+	n, err := app.barrel.BitCount(string(cmd.Args[1]), start, end)
 	if err != nil {
-		conn.WriteString(fmt.Sprintf("ERR: %s", err))
+		conn.WriteError(respError(err))
 		return
 	}
 
-	conn.WriteNull()
+	conn.WriteInt(n)
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+// infoSection represents a group of fields reported by the `INFO` command.
+type infoSection struct {
+	name   string
+	title  string
+	fields [][2]any
+}
+
+// infoSections returns the sections reported by the `INFO` command.
+func (app *App) infoSections() []infoSection {
+	stats := app.barrel.Stats()
+
+	// Report the utilization of each quota like the keyspace of each db is reported by Redis.
+	quotas := make([][2]any, 0, len(stats.Quotas))
+	for _, q := range stats.Quotas {
+		quotas = append(quotas, [2]any{q.Prefix, fmt.Sprintf("keys=%d,bytes=%d,max_keys=%d,max_bytes=%d", q.Keys, q.Bytes, q.MaxKeys, q.MaxBytes)})
+	}
+
+	return []infoSection{
+		{
+			name:  "server",
+			title: "Server",
+			fields: [][2]any{
+				{"barreldb_version", buildString},
+			},
+		},
+		{
+			name:   "replication",
+			title:  "Replication",
+			fields: replicationFields(stats),
+		},
+		{
+			name:  "stats",
+			title: "Stats",
+			fields: [][2]any{
+				{"datafiles", stats.DataFiles},
+				{"keyspace_misses", stats.KeyMisses},
+				{"rejected_oversized", stats.Oversized},
+				{"keydir_bytes", stats.KeydirBytes},
+				{"keydir_key_probes", stats.KeyProbes},
+				{"keydir_bloom_skips", stats.BloomSkips},
+				{"keydir_spilled_keys", stats.SpilledKeys},
+				{"live_data_bytes", stats.LiveBytes},
+				{"disk_bytes", stats.DiskBytes},
+				{"evicted_keys", stats.EvictedKeys},
+				{"storage_full", boolToInt(stats.StorageFull)},
+				{"rejected_quota", stats.QuotaRejected},
+				{"corrupt_records", stats.CorruptRecords},
+				{"quarantined_keys", stats.Quarantined},
+				{"healed_keys", stats.Healed},
+				{"mirror_queued", stats.MirrorQueued},
+				{"mirror_writes", stats.MirrorWrites},
+				{"mirror_failed", stats.MirrorFailed},
+				{"mirror_dropped", stats.MirrorDropped},
+				{"cdc_published", stats.ChangesPublished},
+				{"cdc_failed", stats.ChangesFailed},
+				{"value_cache_hits", stats.CacheHits},
+				{"value_cache_misses", stats.CacheMisses},
+				{"value_cache_bytes", stats.CacheBytes},
+				{"clock_skew_ms", stats.ClockSkew.Milliseconds()},
+				{"clock_jumps", stats.ClockJumps},
+				{"clock_skewed_keys", stats.SkewedKeys},
+				{"rejected_busy", app.admission.rejected.Load()},
+				{"timed_out_commands", app.admission.timedOut.Load()},
+			},
+		},
+		{
+			name:  "compaction",
+			title: "Compaction",
+			fields: [][2]any{
+				{"compaction_in_progress", boolToInt(stats.Compaction.Running)},
+				{"compaction_segments_done", stats.Compaction.SegmentsDone},
+				{"compaction_segments_total", stats.Compaction.SegmentsTotal},
+				{"compaction_bytes_processed", stats.Compaction.BytesProcessed},
+				{"compaction_bytes_total", stats.Compaction.BytesTotal},
+				{"compaction_eta_seconds", int64(stats.Compaction.ETA.Seconds())},
+			},
+		},
+		{
+			name:   "latencystats",
+			title:  "Latencystats",
+			fields: app.latencyStats(),
+		},
+		{
+			name:   "quotas",
+			title:  "Quotas",
+			fields: quotas,
+		},
+		{
+			name:  "keyspace",
+			title: "Keyspace",
+			fields: [][2]any{
+				{"keys", stats.Keys},
+			},
+		},
+	}
+}
+
+// replicationFields returns the fields of the replication section. There's no replication yet, so the
+// server is always a primary without replicas. The mirror, the change feed and the tails following the log
+// copy the writes asynchronously without acknowledging them, so they're reported under their own fields.
+func replicationFields(stats barrel.Stats) [][2]any {
+	fields := [][2]any{
+		{"role", "master"},
+		{"connected_slaves", 0},
+		{"master_repl_offset", stats.Offset},
+	}
+	if stats.Mirroring {
+		state := "online"
+		if stats.MirrorFailing {
+			state = "failing"
+		}
+		fields = append(fields,
+			[2]any{"mirror_state", state},
+			[2]any{"mirror_offset", stats.MirrorOffset},
+			[2]any{"mirror_lag_seconds", int64(stats.MirrorLag.Seconds())},
+		)
+	}
+	if stats.ChangeFeed {
+		state := "online"
+		if stats.ChangeFailing {
+			state = "failing"
+		}
+		fields = append(fields,
+			[2]any{"cdc_state", state},
+			[2]any{"cdc_offset", stats.ChangeOffset},
+		)
+	}
+	return append(fields,
+		[2]any{"tail_followers", stats.Tails},
+		[2]any{"tail_offset", stats.TailOffset},
+	)
+}
+
+// writeReplicationMetrics writes the replication fields as gauges in the Prometheus text format.
+func writeReplicationMetrics(w io.Writer, stats barrel.Stats) {
+	gauge := func(name, help string, val any) {
+		fmt.Fprintf(w, "# HELP barreldb_%s %s\n# TYPE barreldb_%s gauge\nbarreldb_%s %v\n", name, help, name, name, val)
+	}
+
+	gauge("replication_offset", "Offset of the log, which is the sequence number of the next write.", stats.Offset)
+	if stats.Mirroring {
+		gauge("mirror_up", "Whether the last write to the mirror target succeeded.", boolToInt(!stats.MirrorFailing))
+		gauge("mirror_offset", "Offset of the log upto which the writes are processed by the mirror.", stats.MirrorOffset)
+		gauge("mirror_lag_seconds", "Time since the write being mirrored was queued.", stats.MirrorLag.Seconds())
+		gauge("mirror_queued", "Number of writes waiting to be mirrored.", stats.MirrorQueued)
+	}
+	if stats.ChangeFeed {
+		gauge("cdc_up", "Whether the last batch of changes was acknowledged by the change sink.", boolToInt(!stats.ChangeFailing))
+		gauge("cdc_offset", "Offset of the log upto which the changes are acknowledged by the change sink.", stats.ChangeOffset)
+		gauge("cdc_lag_writes", "Number of writes not yet acknowledged by the change sink.", stats.Offset-stats.ChangeOffset)
+	}
+	gauge("tail_followers", "Number of tails following the log.", stats.Tails)
+	var lag uint64
+	if stats.Tails > 0 {
+		lag = stats.Offset - stats.TailOffset
+	}
+	gauge("tail_lag_writes", "Number of writes not yet sent by the slowest tail.", lag)
+}
+
+// boolToInt returns 1 for true and 0 for false, which is how `INFO` reports flags.
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/redcon"
+)
+
+func (app *App) jsonSet(conn redcon.Conn, cmd redcon.Command) {
+	// JSON.SET key path value
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	if err := app.barrel.JSONSet(string(cmd.Args[1]), string(cmd.Args[2]), cmd.Args[3]); err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteString("OK")
+}
+
+func (app *App) jsonGet(conn redcon.Conn, cmd redcon.Command) {
+	// JSON.GET key [path]
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	path := "$"
+	if len(cmd.Args) == 3 {
+		path = string(cmd.Args[2])
+	}
+	val, err := app.barrel.JSONGet(string(cmd.Args[1]), path)
+	if errors.Is(err, barrel.ErrKeyNotFound) || errors.Is(err, barrel.ErrJSONPathNotFound) {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteBulk(val)
+}
+
+func (app *App) jsonDel(conn redcon.Conn, cmd redcon.Command) {
+	// JSON.DEL key [path]
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	path := "$"
+	if len(cmd.Args) == 3 {
+		path = string(cmd.Args[2])
+	}
+	n, err := app.barrel.JSONDel(string(cmd.Args[1]), path)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteInt(n)
+}
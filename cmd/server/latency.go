@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// latencyBuckets is the number of buckets of the latency histograms. The upper bound of each bucket
+// is twice the previous one, starting at 1µs, and the last one covers everything above.
+const latencyBuckets = 25
+
+// latencyPercentiles are the percentiles of the latency reported by `INFO latencystats`.
+var latencyPercentiles = []float64{50, 95, 99}
+
+// histogram counts the latencies of a command in buckets of exponentially increasing size.
+// It's updated concurrently by the connections without locking.
+type histogram struct {
+	buckets [latencyBuckets]atomic.Uint64
+	sum     atomic.Int64 // Total latency in nanoseconds.
+}
+
+// bucketBound returns the upper bound of the bucket with the given index.
+func bucketBound(i int) time.Duration {
+	if i == latencyBuckets-1 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Microsecond << i
+}
+
+// observe counts a latency in its bucket.
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < latencyBuckets-1 && d > bucketBound(i) {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// counts returns the count of each bucket and the total count.
+func (h *histogram) counts() ([latencyBuckets]uint64, uint64) {
+	var (
+		counts [latencyBuckets]uint64
+		total  uint64
+	)
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// percentile returns the upper bound of the bucket in which the given percentile of the latencies falls.
+func (h *histogram) percentile(p float64) time.Duration {
+	counts, total := h.counts()
+	if total == 0 {
+		return 0
+	}
+
+	var (
+		rank = uint64(math.Ceil(p / 100 * float64(total)))
+		seen uint64
+	)
+	for i, n := range counts {
+		seen += n
+		if seen >= rank {
+			return bucketBound(i)
+		}
+	}
+	return bucketBound(latencyBuckets - 1)
+}
+
+// reset clears the histogram.
+func (h *histogram) reset() {
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+	h.sum.Store(0)
+}
+
+// timed records the latency of each call of the handler in the histogram.
+func timed(h *histogram, handler redcon.HandlerFunc) redcon.HandlerFunc {
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		start := time.Now()
+		handler(conn, cmd)
+		h.observe(time.Since(start))
+	}
+}
+
+// latencyCommands returns the names of the commands which have been called, sorted.
+func (app *App) latencyCommands() []string {
+	names := make([]string, 0, len(app.latency))
+	for name, h := range app.latency {
+		if _, total := h.counts(); total > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latencyStats returns the fields of `INFO latencystats`, which are the percentiles of the latency
+// of each command called so far in microseconds, like Redis reports them.
+func (app *App) latencyStats() [][2]any {
+	fields := make([][2]any, 0, len(app.latency))
+	for _, name := range app.latencyCommands() {
+		h := app.latency[name]
+		vals := make([]string, 0, len(latencyPercentiles))
+		for _, p := range latencyPercentiles {
+			vals = append(vals, fmt.Sprintf("p%g=%.3f", p, float64(h.percentile(p))/float64(time.Microsecond)))
+		}
+		fields = append(fields, [2]any{"latency_percentiles_usec_" + name, strings.Join(vals, ",")})
+	}
+	return fields
+}
+
+// latencyCmd handles the `LATENCY HISTOGRAM` and `LATENCY RESET` subcommands of Redis, for the commands
+// given or all the commands. The histogram buckets are cumulative, keyed by their upper bound in microseconds.
+func (app *App) latencyCmd(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	names := app.latencyCommands()
+	if len(cmd.Args) > 2 {
+		names = names[:0]
+		for _, arg := range cmd.Args[2:] {
+			if name := strings.ToLower(string(arg)); app.latency[name] != nil {
+				names = append(names, name)
+			}
+		}
+	}
+
+	switch strings.ToLower(string(cmd.Args[1])) {
+	case "histogram":
+		// LATENCY HISTOGRAM [command ...]
+		conn.WriteArray(len(names) * 2)
+		for _, name := range names {
+			counts, total := app.latency[name].counts()
+			conn.WriteBulkString(name)
+			conn.WriteArray(4)
+			conn.WriteBulkString("calls")
+			conn.WriteInt64(int64(total))
+			conn.WriteBulkString("histogram_usec")
+
+			// Only the buckets up to the largest latency are reported.
+			last := 0
+			for i, n := range counts {
+				if n > 0 {
+					last = i
+				}
+			}
+			conn.WriteArray((last + 1) * 2)
+			var seen uint64
+			for i := 0; i <= last; i++ {
+				seen += counts[i]
+				conn.WriteInt64(int64(bucketBound(i) / time.Microsecond))
+				conn.WriteInt64(int64(seen))
+			}
+		}
+
+	case "reset":
+		// LATENCY RESET [command ...]
+		for _, name := range names {
+			app.latency[name].reset()
+		}
+		conn.WriteInt(len(names))
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'")
+	}
+}
+
+// serveMetrics writes the latency histograms and the replication gauges in the Prometheus text format.
+func (app *App) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP barreldb_command_duration_seconds Latency of the commands.")
+	fmt.Fprintln(w, "# TYPE barreldb_command_duration_seconds histogram")
+	for _, name := range app.latencyCommands() {
+		h := app.latency[name]
+		counts, total := h.counts()
+
+		var seen uint64
+		for i := 0; i < latencyBuckets-1; i++ {
+			seen += counts[i]
+			fmt.Fprintf(w, "barreldb_command_duration_seconds_bucket{command=%q,le=\"%g\"} %d\n", name, bucketBound(i).Seconds(), seen)
+		}
+		fmt.Fprintf(w, "barreldb_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", name, total)
+		fmt.Fprintf(w, "barreldb_command_duration_seconds_sum{command=%q} %g\n", name, time.Duration(h.sum.Load()).Seconds())
+		fmt.Fprintf(w, "barreldb_command_duration_seconds_count{command=%q} %d\n", name, total)
+	}
+
+	writeReplicationMetrics(w, app.barrel.Stats())
+}
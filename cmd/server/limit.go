@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// unlimitedCommands aren't subject to the admission control, since they don't access the barrel.
+var unlimitedCommands = map[string]bool{
+	"quit":    true,
+	"ping":    true,
+	"multi":   true,
+	"discard": true,
+	"unwatch": true,
+}
+
+// admission caps the number of commands executing concurrently and the time each of them takes,
+// so that a slow disk returns errors to the clients instead of stalling every connection behind the
+// lock of the barrel.
+type admission struct {
+	slots   chan struct{} // Held by each executing command, if the concurrency is capped.
+	timeout time.Duration // Max time to wait for a slot and execute the command, if set.
+
+	rejected atomic.Uint64 // Number of commands rejected since no slot was available in time.
+	timedOut atomic.Uint64 // Number of commands which didn't complete in time.
+}
+
+// newAdmission returns the admission control for the given max concurrent commands and timeout,
+// either of which is disabled if it's 0.
+func newAdmission(maxConcurrent int, timeout time.Duration) *admission {
+	a := &admission{timeout: timeout}
+	if maxConcurrent > 0 {
+		a.slots = make(chan struct{}, maxConcurrent)
+	}
+	return a
+}
+
+// admit runs the handler once a slot is available, and replies with an error if it doesn't complete
+// within the timeout. The command isn't cancelled then, so its changes may still be applied. Since it's
+// unknown whether the command has changed the transaction of the connection, the transaction is discarded.
+func (a *admission) admit(handler redcon.HandlerFunc) redcon.HandlerFunc {
+	if a.slots == nil && a.timeout == 0 {
+		return handler
+	}
+
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		var deadline <-chan time.Time
+		if a.timeout > 0 {
+			t := time.NewTimer(a.timeout)
+			defer t.Stop()
+			deadline = t.C
+		}
+
+		if a.slots != nil {
+			select {
+			case a.slots <- struct{}{}:
+			case <-deadline:
+				a.rejected.Add(1)
+				conn.WriteError("BUSY too many commands in progress, try again later")
+				return
+			}
+		}
+		release := func() {
+			if a.slots != nil {
+				<-a.slots
+			}
+		}
+
+		if a.timeout == 0 {
+			defer release()
+			handler(conn, cmd)
+			return
+		}
+
+		// Run the handler on a buffered connection, so that its reply and its changes to the context
+		// of the connection are dropped if it completes after the timeout, while the connection serves
+		// the next commands. The arguments are copied since the connection reuses their buffer for the next command.
+		var (
+			bc   = &bufferedConn{Conn: conn, ctx: copyContext(conn.Context())}
+			done = make(chan struct{})
+		)
+		cmd = copyCommand(cmd)
+		go func() {
+			defer close(done)
+			defer release()
+			handler(bc, cmd)
+		}()
+
+		select {
+		case <-done:
+			conn.SetContext(bc.ctx)
+			conn.WriteRaw(bc.buf)
+		case <-deadline:
+			a.timedOut.Add(1)
+			if t, ok := conn.Context().(*txn); ok {
+				t.reset()
+			}
+			conn.WriteError("TIMEOUT command didn't complete in time, its changes may still be applied")
+		}
+	}
+}
+
+// copyCommand returns a copy of the command which doesn't share the buffer of the connection.
+func copyCommand(cmd redcon.Command) redcon.Command {
+	c := redcon.Command{
+		Raw:  append([]byte(nil), cmd.Raw...),
+		Args: make([][]byte, len(cmd.Args)),
+	}
+	for i, arg := range cmd.Args {
+		c.Args[i] = append([]byte(nil), arg...)
+	}
+	return c
+}
+
+// copyContext returns a copy of the context of a connection, which can be modified without
+// modifying the context of the connection.
+func copyContext(ctx interface{}) interface{} {
+	if t, ok := ctx.(*txn); ok {
+		return t.clone()
+	}
+	return ctx
+}
+
+// bufferedConn buffers the reply written to the connection, which is written by the caller.
+// It holds a copy of the context of the connection, which is set on it by the caller as well.
+type bufferedConn struct {
+	redcon.Conn
+	buf []byte
+	ctx interface{}
+}
+
+func (c *bufferedConn) Context() interface{}     { return c.ctx }
+func (c *bufferedConn) SetContext(v interface{}) { c.ctx = v }
+
+func (c *bufferedConn) WriteError(msg string)       { c.buf = redcon.AppendError(c.buf, msg) }
+func (c *bufferedConn) WriteString(str string)      { c.buf = redcon.AppendString(c.buf, str) }
+func (c *bufferedConn) WriteBulk(bulk []byte)       { c.buf = redcon.AppendBulk(c.buf, bulk) }
+func (c *bufferedConn) WriteBulkString(bulk string) { c.buf = redcon.AppendBulkString(c.buf, bulk) }
+func (c *bufferedConn) WriteInt(num int)            { c.buf = redcon.AppendInt(c.buf, int64(num)) }
+func (c *bufferedConn) WriteInt64(num int64)        { c.buf = redcon.AppendInt(c.buf, num) }
+func (c *bufferedConn) WriteUint64(num uint64)      { c.buf = redcon.AppendUint(c.buf, num) }
+func (c *bufferedConn) WriteArray(count int)        { c.buf = redcon.AppendArray(c.buf, count) }
+func (c *bufferedConn) WriteNull()                  { c.buf = redcon.AppendNull(c.buf) }
+func (c *bufferedConn) WriteRaw(data []byte)        { c.buf = append(c.buf, data...) }
+func (c *bufferedConn) WriteAny(v interface{})      { c.buf = redcon.AppendAny(c.buf, v) }
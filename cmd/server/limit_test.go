@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/redcon"
+)
+
+// testConn records the replies written to it.
+type testConn struct {
+	redcon.Conn
+	ctx     interface{}
+	replies []string
+}
+
+func (c *testConn) Context() interface{}     { return c.ctx }
+func (c *testConn) SetContext(v interface{}) { c.ctx = v }
+func (c *testConn) WriteError(msg string)    { c.replies = append(c.replies, msg) }
+func (c *testConn) WriteString(str string)   { c.replies = append(c.replies, str) }
+func (c *testConn) WriteRaw(data []byte)     { c.replies = append(c.replies, string(data)) }
+
+func command(args ...string) redcon.Command {
+	cmd := redcon.Command{}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	return cmd
+}
+
+func TestAdmitTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		app     = &App{}
+		a       = newAdmission(0, 50*time.Millisecond)
+		conn    = &testConn{}
+		release = make(chan struct{})
+		done    = make(chan struct{})
+	)
+
+	// Watch a key the way WATCH does, once the handler is released.
+	watch := a.admit(func(conn redcon.Conn, cmd redcon.Command) {
+		defer close(done)
+		t := connTxn(conn)
+		<-release
+		t.watched = map[string]watchedState{string(cmd.Args[1]): {exists: true}}
+		conn.WriteString("OK")
+	})
+
+	// The changes of a handler completing in time are applied to the connection.
+	close(release)
+	watch(conn, command("watch", "k1"))
+	assert.Equal([]string{"+OK\r\n"}, conn.replies)
+	assert.Contains(connTxn(conn).watched, "k1")
+
+	// The handler completing after the timeout doesn't modify the transaction opened meanwhile.
+	release, done = make(chan struct{}), make(chan struct{})
+	conn.replies = nil
+	watch(conn, command("watch", "k2"))
+	app.multi(conn, command("multi"))
+	close(release)
+	<-done
+
+	assert.Equal([]string{"TIMEOUT command didn't complete in time, its changes may still be applied", "OK"}, conn.replies)
+	txn := connTxn(conn)
+	assert.True(txn.open)
+	assert.Nil(txn.watched, "the transaction is discarded on a timeout")
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/sinks/nats"
 	"github.com/tidwall/redcon"
 	"github.com/zerodha/logf"
 )
@@ -17,9 +24,56 @@ var (
 	buildString = "unknown"
 )
 
+const (
+	// mirrorTimeout is the timeout for each write mirrored to a remote server.
+	mirrorTimeout = time.Second * 5
+	// changesTimeout is the timeout for each batch of changes published to NATS.
+	changesTimeout = time.Second * 5
+
+	// defaultSyncInterval is the interval of syncing the active datafile with the interval sync policy.
+	defaultSyncInterval = time.Minute
+)
+
+// evictionPolicies maps the names of the eviction policies in the config to the barrel policies.
+var evictionPolicies = map[string]barrel.EvictionPolicy{
+	"":             barrel.NoEviction,
+	"noeviction":   barrel.NoEviction,
+	"allkeys-lru":  barrel.AllKeysLRU,
+	"volatile-ttl": barrel.VolatileTTL,
+}
+
+// quotaPolicies maps the names of the quota policies in the config to the barrel policies.
+var quotaPolicies = map[string]barrel.QuotaPolicy{
+	"":             barrel.QuotaReject,
+	"reject":       barrel.QuotaReject,
+	"evict-oldest": barrel.QuotaEvictOldest,
+}
+
+// checksumAlgos maps the names of the checksum algorithms in the config to the barrel algorithms.
+var checksumAlgos = map[string]barrel.ChecksumAlgo{
+	"":         barrel.ChecksumCRC32,
+	"crc32":    barrel.ChecksumCRC32,
+	"crc32c":   barrel.ChecksumCRC32C,
+	"xxhash64": barrel.ChecksumXXHash64,
+}
+
 type App struct {
-	lo     logf.Logger
-	barrel *barrel.Barrel
+	lo      logf.Logger
+	barrel  *barrel.Barrel
+	auditor *auditor // Writes the audit log of the commands which modify the data, if enabled.
+
+	registry *consulRegistry // Registers the server in Consul for service discovery, if enabled.
+	mirror   io.Closer       // Target of the mirrored writes, which is closed after the barrel, if enabled.
+	changes  io.Closer       // Sink of the changes, which is closed after the barrel, if enabled.
+
+	latency    map[string]*histogram // Latency of each command, by the name it's called with.
+	admission  *admission            // Limits the concurrency and the duration of the commands.
+	registered map[string]bool       // Names of the commands, as they're called with.
+	plugins    *plugins              // Commands and validators registered by the plugins.
+
+	// Held for reading by each command, and for writing by EXEC, so that the commands
+	// of a transaction are run without any other command in between.
+	txnMu sync.RWMutex
 }
 
 func main() {
@@ -36,13 +90,135 @@ func main() {
 	app.lo.Info("booting barreldb server", "version", buildString)
 
 	// Set config options for barrel.
-	cfg := []barrel.Config{barrel.WithDir(ko.MustString("app.dir")), barrel.WithAutoSync()}
+	cfg := []barrel.Config{barrel.WithDir(ko.MustString("app.dir"))}
+	switch policy := ko.String("app.sync_policy"); policy {
+	case "", "interval":
+		interval := ko.Duration("app.sync_interval")
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		cfg = append(cfg, barrel.WithSyncPolicy(barrel.SyncInterval(interval)))
+	case "never":
+		cfg = append(cfg, barrel.WithSyncPolicy(barrel.SyncNever))
+	case "every-write":
+		cfg = append(cfg, barrel.WithSyncPolicy(barrel.SyncEveryWrite))
+	case "every-n-bytes":
+		cfg = append(cfg, barrel.WithSyncPolicy(barrel.SyncEveryNBytes(ko.Int("app.sync_bytes"))))
+	default:
+		app.lo.Fatal("invalid sync policy", "policy", policy)
+	}
 	if ko.Bool("app.read_only") {
 		cfg = append(cfg, barrel.WithReadOnly())
 	}
 	if ko.Bool("app.debug") {
 		cfg = append(cfg, barrel.WithDebug())
 	}
+	if size := ko.Int("app.max_data_size"); size > 0 {
+		policy, ok := evictionPolicies[ko.String("app.eviction_policy")]
+		if !ok {
+			app.lo.Fatal("invalid eviction policy", "policy", ko.String("app.eviction_policy"))
+		}
+		cfg = append(cfg, barrel.WithMaxDataSize(size, policy))
+	}
+	algo, ok := checksumAlgos[ko.String("app.checksum")]
+	if !ok {
+		app.lo.Fatal("invalid checksum algorithm", "checksum", ko.String("app.checksum"))
+	}
+	cfg = append(cfg, barrel.WithChecksum(algo))
+	if ko.Bool("app.bitcask_compat") {
+		cfg = append(cfg, barrel.WithBitcaskCompat())
+	}
+	if ko.Bool("app.auto_heal") {
+		cfg = append(cfg, barrel.WithAutoHeal())
+	}
+	if ko.Bool("app.verify_on_startup") {
+		cfg = append(cfg, barrel.WithVerifyOnStartup())
+	}
+	if interval := ko.Duration("app.scrub_interval"); interval > 0 {
+		cfg = append(cfg, barrel.WithScrubber(interval, ko.Int("app.scrub_rate")))
+	}
+	if size := ko.Int("app.max_key_size"); size > 0 {
+		cfg = append(cfg, barrel.WithMaxKeySize(size))
+	}
+	if size := ko.Int("app.max_value_size"); size > 0 {
+		cfg = append(cfg, barrel.WithMaxValueSize(size))
+	}
+	if size := ko.Int64("app.min_free_disk"); size > 0 {
+		cfg = append(cfg, barrel.WithMinFreeDisk(size))
+	}
+	for _, w := range ko.Strings("app.compaction_windows") {
+		start, end, _ := strings.Cut(w, "-")
+		cfg = append(cfg, barrel.WithCompactionWindow(start, end))
+	}
+	if ratio := ko.Float64("app.compaction_amplification"); ratio > 0 {
+		cfg = append(cfg, barrel.WithCompactionAmplification(ratio))
+	}
+	if workers := ko.Int("app.compaction_concurrency"); workers > 0 {
+		cfg = append(cfg, barrel.WithCompactionConcurrency(workers))
+	}
+	if rate := ko.Int("app.compaction_rate"); rate > 0 {
+		cfg = append(cfg, barrel.WithCompactionRate(rate))
+	}
+	if ratio := ko.Float64("app.compaction_dead_ratio"); ratio > 0 {
+		cfg = append(cfg, barrel.WithCompactionDeadRatio(ratio))
+	}
+	if sample := ko.Int("app.hotkeys_sample"); sample > 0 {
+		cfg = append(cfg, barrel.WithHotKeys(sample, ko.Int("app.hotkeys_capacity")))
+	}
+	if ko.Exists("app.max_clock_skew") {
+		cfg = append(cfg, barrel.WithMaxClockSkew(ko.Duration("app.max_clock_skew")))
+	}
+	if window := ko.Duration("app.idempotency_window"); window > 0 {
+		cfg = append(cfg, barrel.WithIdempotencyWindow(window))
+	}
+	if ko.Bool("app.sorted_keys") {
+		cfg = append(cfg, barrel.WithSortedKeys())
+	}
+	if ko.Bool("app.compact_keydir") {
+		cfg = append(cfg, barrel.WithCompactKeydir())
+	}
+	if size := ko.Int("app.max_index_memory"); size > 0 {
+		cfg = append(cfg, barrel.WithMaxIndexMemory(size))
+	}
+	if ko.Bool("app.io_uring") {
+		cfg = append(cfg, barrel.WithIOUring())
+	}
+	if ko.Bool("app.compact_on_startup") {
+		cfg = append(cfg, barrel.WithCompactOnStartup(ko.Float64("app.startup_stale_ratio")))
+	}
+	for _, q := range ko.Slices("quotas") {
+		policy, ok := quotaPolicies[q.String("policy")]
+		if !ok {
+			app.lo.Fatal("invalid quota policy", "policy", q.String("policy"))
+		}
+		cfg = append(cfg, barrel.WithQuota(barrel.Quota{
+			Prefix:   q.String("prefix"),
+			MaxKeys:  q.Int("max_keys"),
+			MaxBytes: q.Int("max_bytes"),
+			Policy:   policy,
+		}))
+	}
+	switch {
+	case ko.String("app.mirror_addr") != "":
+		target := barrel.NewRemoteTarget(ko.String("app.mirror_addr"), mirrorTimeout)
+		cfg = append(cfg, barrel.WithMirror(target, ko.Int("app.mirror_queue_size")))
+		app.mirror = target
+	case ko.String("app.mirror_dir") != "":
+		target, err := barrel.Init(barrel.WithDir(ko.String("app.mirror_dir")))
+		if err != nil {
+			app.lo.Fatal("error opening mirror db", "error", err)
+		}
+		cfg = append(cfg, barrel.WithMirror(target, ko.Int("app.mirror_queue_size")))
+		app.mirror = closerFunc(target.Shutdown)
+	}
+	if addr := ko.String("app.cdc_nats_addr"); addr != "" {
+		if ko.String("app.cdc_nats_subject") == "" {
+			app.lo.Fatal("cdc_nats_subject is required to publish the changes to NATS")
+		}
+		sink := nats.New(addr, ko.String("app.cdc_nats_subject"), changesTimeout)
+		cfg = append(cfg, barrel.WithChangeSink(sink))
+		app.changes = sink
+	}
 
 	// Initialise barrel.
 	barrel, err := barrel.Init(cfg...)
@@ -51,20 +227,46 @@ func main() {
 	}
 	app.barrel = barrel
 
+	// Initialise the audit log.
+	var sinks []auditSink
+	if path := ko.String("app.audit_file"); path != "" {
+		s, err := newFileSink(path)
+		if err != nil {
+			app.lo.Fatal("error opening audit file", "error", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if ko.Bool("app.audit_syslog") {
+		s, err := newSyslogSink("barreldb")
+		if err != nil {
+			app.lo.Fatal("error connecting to syslog", "error", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if url := ko.String("app.audit_webhook"); url != "" {
+		sinks = append(sinks, newWebhookSink(url))
+	}
+	if len(sinks) > 0 {
+		app.auditor = newAuditor(app.lo, sinks)
+	}
+
 	// Initialise server.
-	mux := redcon.NewServeMux()
-	mux.HandleFunc("ping", app.ping)
-	mux.HandleFunc("quit", app.quit)
-	mux.HandleFunc("set", app.set)
-	mux.HandleFunc("get", app.get)
-	mux.HandleFunc("del", app.delete)
+	app.admission = newAdmission(ko.Int("server.max_concurrent_commands"), ko.Duration("server.command_timeout"))
+	app.plugins, err = loadPlugins(ko.Strings("server.plugins"))
+	if err != nil {
+		app.lo.Fatal("error loading plugins", "error", err)
+	}
+	mux, err := app.newMux(ko.StringMap("rename_commands"))
+	if err != nil {
+		app.lo.Fatal("error registering commands", "error", err)
+	}
 
 	// Create a channel to listen for cancellation signals.
 	// Create a new context which is cancelled when `SIGINT`/`SIGTERM` is received.
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	srvr := redcon.NewServer(ko.MustString("server.address"),
-		mux.ServeRESP,
+		app.serve(mux),
 		func(conn redcon.Conn) bool {
 			// use this function to accept or deny the connection.
 			return true
@@ -75,12 +277,43 @@ func main() {
 	)
 
 	// Sart the server in a goroutine.
+	listening := make(chan error, 1)
 	go func() {
-		if err := srvr.ListenAndServe(); err != nil {
+		if err := srvr.ListenServeAndSignal(listening); err != nil {
 			app.lo.Fatal("failed to listen and serve", "error", err)
 		}
 	}()
 
+	// Serve the metrics for Prometheus.
+	var metrics *http.Server
+	if addr := ko.String("server.metrics_address"); addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", app.serveMetrics)
+		metrics = &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			if err := metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.lo.Fatal("failed to serve metrics", "error", err)
+			}
+		}()
+	}
+
+	// Register the server for service discovery once it's listening.
+	if url := ko.String("discovery.consul_addr"); url != "" && <-listening == nil {
+		addr := ko.String("discovery.advertise_addr")
+		if addr == "" {
+			if addr, err = advertiseAddr(ko.MustString("server.address")); err != nil {
+				app.lo.Fatal("error finding advertised address", "error", err)
+			}
+		}
+		ttl := ko.Duration("discovery.ttl")
+		if ttl <= 0 {
+			app.lo.Fatal("invalid discovery ttl", "ttl", ko.String("discovery.ttl"))
+		}
+		if app.registry, err = newConsulRegistry(app.lo, url, ko.String("discovery.service"), addr, ttl); err != nil {
+			app.lo.Fatal("error registering in consul", "error", err)
+		}
+	}
+
 	// Listen on the close channel indefinitely until a
 	// `SIGINT` or `SIGTERM` is received.
 	<-ctx.Done()
@@ -88,6 +321,36 @@ func main() {
 	// Cancel the context to gracefully shutdown and perform
 	// any cleanup tasks.
 	cancel()
-	app.barrel.Shutdown()
+	if app.registry != nil {
+		if err := app.registry.Close(); err != nil {
+			app.lo.Error("error deregistering from consul", "error", err)
+		}
+	}
 	srvr.Close()
+	if metrics != nil {
+		metrics.Close()
+	}
+	if app.auditor != nil {
+		app.auditor.Close()
+	}
+	app.barrel.Shutdown()
+
+	// The mirror is closed once the barrel has stopped mirroring the writes to it.
+	if app.mirror != nil {
+		if err := app.mirror.Close(); err != nil {
+			app.lo.Error("error closing mirror", "error", err)
+		}
+	}
+	if app.changes != nil {
+		if err := app.changes.Close(); err != nil {
+			app.lo.Error("error closing change sink", "error", err)
+		}
+	}
+}
+
+// closerFunc adapts a function to an io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+// registerSymbol is the name of the function exported by the plugins, which registers their commands
+// and validators. Plugins are built with `go build -buildmode=plugin` against the same versions of
+// the dependencies as the server, and export a function of the type registerFunc, e.g.
+//
+//	func Register(handle func(string, redcon.HandlerFunc), validate func(func(redcon.Command) error)) error {
+//		validate(func(cmd redcon.Command) error {
+//			if strings.EqualFold(string(cmd.Args[0]), "set") && !bytes.HasPrefix(cmd.Args[1], []byte("app:")) {
+//				return errors.New("keys must start with app:")
+//			}
+//			return nil
+//		})
+//		return nil
+//	}
+const registerSymbol = "Register"
+
+// registerFunc is the type of the function exported by the plugins. It's called with the functions
+// to register the handler of a command, and a validator which is called before every command.
+type registerFunc = func(handle func(string, redcon.HandlerFunc), validate func(func(redcon.Command) error)) error
+
+// plugins are the commands and the validators registered by the plugins.
+type plugins struct {
+	commands   map[string]redcon.HandlerFunc
+	validators []func(redcon.Command) error
+}
+
+// loadPlugins opens the plugins at the given paths and registers their commands and validators.
+func loadPlugins(paths []string) (*plugins, error) {
+	p := &plugins{commands: make(map[string]redcon.HandlerFunc)}
+	for _, path := range paths {
+		pl, err := plugin.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening plugin %s: %w", path, err)
+		}
+		sym, err := pl.Lookup(registerSymbol)
+		if err != nil {
+			return nil, fmt.Errorf("error loading plugin %s: %w", path, err)
+		}
+		register, ok := sym.(registerFunc)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s exports %s of the wrong type: %T", path, registerSymbol, sym)
+		}
+
+		var dup string
+		handle := func(name string, handler redcon.HandlerFunc) {
+			name = strings.ToLower(name)
+			if _, ok := p.commands[name]; ok {
+				dup = name
+			}
+			p.commands[name] = handler
+		}
+		validate := func(v func(redcon.Command) error) {
+			p.validators = append(p.validators, v)
+		}
+		if err := register(handle, validate); err != nil {
+			return nil, fmt.Errorf("error registering plugin %s: %w", path, err)
+		}
+		if dup != "" {
+			return nil, fmt.Errorf("plugin %s registers an existing command: %s", path, dup)
+		}
+	}
+
+	return p, nil
+}
+
+// validated wraps the handler of a command, so that the command is rejected with the error of the
+// first validator failing it. A command rejected in a transaction aborts it, like an unknown command.
+func (app *App) validated(handler redcon.HandlerFunc) redcon.HandlerFunc {
+	if len(app.plugins.validators) == 0 {
+		return handler
+	}
+
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		for _, v := range app.plugins.validators {
+			if err := v(cmd); err != nil {
+				if t := connTxn(conn); t.open {
+					t.failed = true
+				}
+				conn.WriteError(respError(err))
+				return
+			}
+		}
+		handler(conn, cmd)
+	}
+}
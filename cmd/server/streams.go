@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/redcon"
+)
+
+func (app *App) xadd(conn redcon.Conn, cmd redcon.Command) {
+	// XADD key <* | id> field value [field value ...]
+	if len(cmd.Args) < 5 || len(cmd.Args)%2 != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	var (
+		stream = string(cmd.Args[1])
+		id     *barrel.StreamID
+		fields = make([]string, 0, len(cmd.Args)-3)
+	)
+	if string(cmd.Args[2]) != "*" {
+		parsed, err := barrel.ParseStreamID(string(cmd.Args[2]), 0)
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		id = &parsed
+	}
+	for _, arg := range cmd.Args[3:] {
+		fields = append(fields, string(arg))
+	}
+
+	newID, err := app.barrel.StreamAdd(stream, id, fields)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	conn.WriteBulkString(newID.String())
+}
+
+func (app *App) xlen(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	conn.WriteInt(app.barrel.StreamLen(string(cmd.Args[1])))
+}
+
+func (app *App) xrange(conn redcon.Conn, cmd redcon.Command) {
+	// XRANGE key start end [COUNT count]
+	if len(cmd.Args) != 4 && len(cmd.Args) != 6 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	var (
+		stream = string(cmd.Args[1])
+		start  = barrel.MinStreamID
+		end    = barrel.MaxStreamID
+		count  = 0
+		err    error
+	)
+	if s := string(cmd.Args[2]); s != "-" {
+		if start, err = barrel.ParseStreamID(s, 0); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+	}
+	if s := string(cmd.Args[3]); s != "+" {
+		if end, err = barrel.ParseStreamID(s, barrel.MaxStreamID.Seq); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+	}
+	if len(cmd.Args) == 6 {
+		if !strings.EqualFold(string(cmd.Args[4]), "count") {
+			conn.WriteError("ERR syntax error")
+			return
+		}
+		if count, err = strconv.Atoi(string(cmd.Args[5])); err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	entries, err := app.barrel.StreamRange(stream, start, end, count)
+	if err != nil {
+		conn.WriteError(respError(err))
+		return
+	}
+
+	writeStreamEntries(conn, entries)
+}
+
+func (app *App) xread(conn redcon.Conn, cmd redcon.Command) {
+	// XREAD [COUNT count] STREAMS key [key ...] id [id ...]
+	var (
+		args  = cmd.Args[1:]
+		count = 0
+		err   error
+	)
+	if len(args) >= 2 && strings.EqualFold(string(args[0]), "count") {
+		if count, err = strconv.Atoi(string(args[1])); err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		args = args[2:]
+	}
+	if len(args) < 3 || !strings.EqualFold(string(args[0]), "streams") || len(args)%2 != 1 {
+		conn.WriteError("ERR syntax error")
+		return
+	}
+
+	var (
+		n       = (len(args) - 1) / 2
+		streams = args[1 : 1+n]
+		ids     = args[1+n:]
+		results = make(map[string][]barrel.StreamEntry)
+		order   = make([]string, 0, n)
+	)
+	for i, s := range streams {
+		stream := string(s)
+
+		// Return the entries after the given ID. `$` refers to the last entry in the stream.
+		var after barrel.StreamID
+		if string(ids[i]) == "$" {
+			after = app.barrel.StreamLastID(stream)
+		} else if after, err = barrel.ParseStreamID(string(ids[i]), 0); err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+
+		entries, err := app.barrel.StreamRange(stream, after.Next(), barrel.MaxStreamID, count)
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		if len(entries) > 0 {
+			results[stream] = entries
+			order = append(order, stream)
+		}
+	}
+
+	if len(order) == 0 {
+		conn.WriteNull()
+		return
+	}
+
+	conn.WriteArray(len(order))
+	for _, stream := range order {
+		conn.WriteArray(2)
+		conn.WriteBulkString(stream)
+		writeStreamEntries(conn, results[stream])
+	}
+}
+
+// writeStreamEntries writes the stream entries as an array of [id, [field, value, ...]].
+func writeStreamEntries(conn redcon.Conn, entries []barrel.StreamEntry) {
+	conn.WriteArray(len(entries))
+	for _, e := range entries {
+		conn.WriteArray(2)
+		conn.WriteBulkString(e.ID.String())
+		conn.WriteArray(len(e.Fields))
+		for _, f := range e.Fields {
+			conn.WriteBulkString(f)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+	"github.com/tidwall/redcon"
+)
+
+// txnCommands control the transactions, so they're run right away instead of being queued in a transaction.
+var txnCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+	"quit":    true,
+}
+
+// txn is the state of the transaction of a connection, stored in the context of the connection.
+type txn struct {
+	open    bool                    // Whether MULTI is called and the commands are being queued.
+	failed  bool                    // Whether a command failed to be queued, which aborts EXEC.
+	queued  []queuedCommand         // Commands to run on EXEC.
+	watched map[string]watchedState // State of the watched keys when they were watched.
+}
+
+type queuedCommand struct {
+	handler redcon.HandlerFunc
+	cmd     redcon.Command
+}
+
+// watchedState is the version of a watched key, or whether it's missing. Since a missing key
+// doesn't have a version, a key which is missing when watched and when EXEC is called isn't
+// considered modified, even if it's written and deleted in between.
+type watchedState struct {
+	exists  bool
+	version barrel.Version
+}
+
+// connTxn returns the transaction state of the connection.
+func connTxn(conn redcon.Conn) *txn {
+	t, ok := conn.Context().(*txn)
+	if !ok {
+		t = &txn{}
+		conn.SetContext(t)
+	}
+	return t
+}
+
+// reset discards the queued commands and unwatches the keys, once the transaction is over.
+func (t *txn) reset() {
+	*t = txn{}
+}
+
+// clone returns a copy of the transaction which doesn't share the queued commands or the watched keys.
+func (t *txn) clone() *txn {
+	c := *t
+	c.queued = append([]queuedCommand(nil), t.queued...)
+	if t.watched != nil {
+		c.watched = make(map[string]watchedState, len(t.watched))
+		for k, s := range t.watched {
+			c.watched[k] = s
+		}
+	}
+	return &c
+}
+
+// keyState returns the current state of the key to compare with its state when watched.
+func (app *App) keyState(k string) (watchedState, error) {
+	v, err := app.barrel.KeyVersion(k)
+	if errors.Is(err, barrel.ErrKeyNotFound) {
+		return watchedState{}, nil
+	}
+	if err != nil {
+		return watchedState{}, err
+	}
+	return watchedState{exists: true, version: v}, nil
+}
+
+// serve dispatches the commands to the mux, except that unknown commands fail the transaction
+// of the connection, if one is open.
+func (app *App) serve(mux *redcon.ServeMux) redcon.HandlerFunc {
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		if t := connTxn(conn); t.open && !app.registered[strings.ToLower(string(cmd.Args[0]))] {
+			t.failed = true
+		}
+		mux.ServeRESP(conn, cmd)
+	}
+}
+
+// queueable wraps the handler of a command, so that it's queued if a transaction is open. Otherwise
+// it's run right away, but not in the middle of the commands of another transaction.
+func (app *App) queueable(handler redcon.HandlerFunc) redcon.HandlerFunc {
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		if t := connTxn(conn); t.open {
+			// The arguments are copied since the connection reuses their buffer for the next command.
+			t.queued = append(t.queued, queuedCommand{handler: handler, cmd: copyCommand(cmd)})
+			conn.WriteString("QUEUED")
+			return
+		}
+
+		app.txnMu.RLock()
+		defer app.txnMu.RUnlock()
+		handler(conn, cmd)
+	}
+}
+
+func (app *App) multi(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	t := connTxn(conn)
+	if t.open {
+		conn.WriteError("ERR MULTI calls can not be nested")
+		return
+	}
+	t.open = true
+
+	conn.WriteString("OK")
+}
+
+func (app *App) exec(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	t := connTxn(conn)
+	if !t.open {
+		conn.WriteError("ERR EXEC without MULTI")
+		return
+	}
+	defer t.reset()
+
+	if t.failed {
+		conn.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	// Check the watched keys and run the commands without any other command in between,
+	// so that none of the watched keys is modified before the commands are run.
+	app.txnMu.Lock()
+	defer app.txnMu.Unlock()
+
+	for k, watched := range t.watched {
+		state, err := app.keyState(k)
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		if state != watched {
+			// Abort the transaction with a null reply, since a watched key is modified.
+			conn.WriteArray(-1)
+			return
+		}
+	}
+
+	conn.WriteArray(len(t.queued))
+	for _, q := range t.queued {
+		q.handler(conn, q.cmd)
+	}
+}
+
+func (app *App) discard(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	t := connTxn(conn)
+	if !t.open {
+		conn.WriteError("ERR DISCARD without MULTI")
+		return
+	}
+	t.reset()
+
+	conn.WriteString("OK")
+}
+
+func (app *App) watch(conn redcon.Conn, cmd redcon.Command) {
+	// WATCH key [key ...]
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	t := connTxn(conn)
+	if t.open {
+		conn.WriteError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+
+	if t.watched == nil {
+		t.watched = make(map[string]watchedState, len(cmd.Args)-1)
+	}
+	for _, arg := range cmd.Args[1:] {
+		k := string(arg)
+		// A key watched again keeps its state from when it was first watched.
+		if _, ok := t.watched[k]; ok {
+			continue
+		}
+		state, err := app.keyState(k)
+		if err != nil {
+			conn.WriteError(respError(err))
+			return
+		}
+		t.watched[k] = state
+	}
+
+	conn.WriteString("OK")
+}
+
+func (app *App) unwatch(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+
+	connTxn(conn).watched = nil
+
+	conn.WriteString("OK")
+}
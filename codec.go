@@ -0,0 +1,103 @@
+package barrel
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// Codec encodes the values of a Store to bytes and decodes them back.
+// Other formats like msgpack or protobuf can be used by implementing it
+// with their Marshal and Unmarshal functions.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes the values as JSON. It's the default codec of a Store.
+type JSONCodec struct{}
+
+// Marshal encodes the value as JSON.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON data into the value.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes the values with encoding/gob, which is more compact than JSON for Go types.
+type GobCodec struct{}
+
+// Marshal encodes the value with gob.
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the gob data into the value.
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Store is a typed wrapper over the barrel which encodes the values of type T with a codec,
+// so that they don't have to be marshaled to bytes by hand.
+type Store[T any] struct {
+	barrel *Barrel
+	codec  Codec
+}
+
+// NewStore returns a Store of values of type T over the barrel.
+// The values are encoded as JSON if the codec is nil.
+func NewStore[T any](b *Barrel, codec Codec) *Store[T] {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &Store[T]{barrel: b, codec: codec}
+}
+
+// Barrel returns the underlying barrel of the store.
+func (s *Store[T]) Barrel() *Barrel {
+	return s.barrel
+}
+
+// Put encodes the value and stores it for the key.
+func (s *Store[T]) Put(k string, v T) error {
+	val, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.barrel.Put(k, val)
+}
+
+// PutEx is same as Put but also sets an expiry for the key.
+func (s *Store[T]) PutEx(k string, v T, ex time.Duration) error {
+	val, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.barrel.PutEx(k, val, ex)
+}
+
+// Get returns the decoded value of the key.
+func (s *Store[T]) Get(k string) (T, error) {
+	var v T
+	val, err := s.barrel.Get(k)
+	if err != nil {
+		return v, err
+	}
+	if err := s.codec.Unmarshal(val, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// Delete removes the key.
+func (s *Store[T]) Delete(k string) error {
+	return s.barrel.Delete(k)
+}
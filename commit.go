@@ -0,0 +1,118 @@
+package barrel
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the writes are synced to disk, trading the throughput of the writes
+// for the writes which may be lost on a crash. It's set with WithSyncPolicy.
+type SyncPolicy struct {
+	kind     syncKind
+	interval time.Duration
+	bytes    int
+}
+
+type syncKind int
+
+const (
+	syncNever syncKind = iota
+	syncInterval
+	syncEveryWrite
+	syncEveryNBytes
+)
+
+var (
+	// SyncNever leaves the syncing to the OS, besides the rotation and the shutdown. It's the default.
+	// The writes since the last sync by the OS are lost on a crash of the machine, but not of the process.
+	SyncNever = SyncPolicy{kind: syncNever}
+
+	// SyncEveryWrite syncs every write before it returns, so that no acknowledged write is lost.
+	// Concurrent writers are batched into a single fsync (group commit).
+	SyncEveryWrite = SyncPolicy{kind: syncEveryWrite}
+)
+
+// SyncInterval syncs the active datafile in background at the given interval, which
+// bounds the writes lost on a crash to those of the last interval.
+func SyncInterval(interval time.Duration) SyncPolicy {
+	return SyncPolicy{kind: syncInterval, interval: interval}
+}
+
+// SyncEveryNBytes syncs the active datafile once the given number of bytes are written to it
+// since the last sync, which bounds the writes lost on a crash to as many bytes.
+func SyncEveryNBytes(n int) SyncPolicy {
+	return SyncPolicy{kind: syncEveryNBytes, bytes: n}
+}
+
+// groupCommit batches the fsync calls of concurrent writers. Instead of calling
+// fsync for each record, a writer waits for an fsync which covers its write.
+// If no fsync is in progress, the writer becomes the leader and syncs all the
+// records written so far on behalf of the other waiting writers.
+type groupCommit struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	synced  uint64 // Number of writes flushed to disk.
+	syncing bool   // Whether an fsync is in progress.
+
+	err    error  // Error of the last failed fsync.
+	errSeq uint64 // Number of writes covered by the last failed fsync.
+}
+
+// newGroupCommit returns a new group commit.
+func newGroupCommit() *groupCommit {
+	g := &groupCommit{}
+	g.cond = sync.NewCond(g)
+	return g
+}
+
+// waitForSync blocks until the writes done so far are flushed to disk.
+// It's deferred by the write methods after acquiring the lock,
+// so that it runs once the lock is released.
+func (b *Barrel) waitForSync(err *error) {
+	if *err != nil || !b.opts.fsyncOnPut {
+		return
+	}
+
+	var (
+		g   = b.commit
+		seq = b.written.Load()
+	)
+
+	g.Lock()
+	defer g.Unlock()
+
+	for g.synced < seq {
+		if g.errSeq >= seq {
+			*err = g.err
+			return
+		}
+
+		// Wait for the fsync in progress to finish.
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+
+		// Become the leader and sync all the writes done so far.
+		g.syncing = true
+		g.Unlock()
+
+		b.Lock()
+		var (
+			df     = b.df
+			target = b.written.Load()
+		)
+		b.Unlock()
+		syncErr := df.Sync()
+
+		g.Lock()
+		g.syncing = false
+		if syncErr != nil {
+			g.err, g.errSeq = syncErr, target
+		} else if target > g.synced {
+			g.synced = target
+		}
+		g.cond.Broadcast()
+	}
+}
@@ -1,12 +1,18 @@
 package barrel
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/deepgolani4/LogVaultDB/internal/datafile/internal/datafile"
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
 )
 
 // ExamineFileSize checks for file size at a periodic interval.
@@ -14,12 +20,25 @@ import (
 // if the file size exceeds the configured size.
 func (b *Barrel) ExamineFileSize(evalInterval time.Duration) {
 	var (
-		evalTicker = time.NewTicker(evalInterval).C
+		evalTicker = time.NewTicker(evalInterval)
 	)
-	for range evalTicker {
+	defer evalTicker.Stop()
+	for {
+		select {
+		case <-evalTicker.C:
+		case <-b.done:
+			return
+		}
+
 		if err := b.rotateDF(); err != nil {
 			b.lo.Error("error rotating db file", "error", err)
 		}
+
+		b.Lock()
+		if err := b.checkDiskSpace(); err != nil {
+			b.lo.Error("error checking free disk space", "error", err)
+		}
+		b.Unlock()
 	}
 }
 
@@ -27,25 +46,131 @@ func (b *Barrel) ExamineFileSize(evalInterval time.Duration) {
 // dead/expired keys at a periodic interval. This helps to save disk space
 // and merge old inactive db files in a single file. It also generates a hints file
 // which helps in caching all the keys during a cold start.
+// The compaction at the interval is skipped outside the compaction windows and below the min
+// amplification of the disk usage, if they're set.
 func (b *Barrel) RunCompaction(evalInterval time.Duration) {
 	var (
-		evalTicker = time.NewTicker(evalInterval).C
+		evalTicker = time.NewTicker(evalInterval)
 	)
-	for range evalTicker {
-		b.Lock()
+	defer evalTicker.Stop()
+	for {
+		// Compact at the interval or when the writes are stalled.
+		select {
+		case <-evalTicker.C:
+			if !b.compactionDue() {
+				continue
+			}
+		case <-b.compactNow:
+		case <-b.done:
+			return
+		}
 
-		if err := b.cleanupExpired(); err != nil {
-			b.lo.Error("error removing expired keys", "error", err)
+		// The merge interrupted by the shutdown is started over on the next run.
+		if err := b.CompactOnce(); err != nil && !errors.Is(err, errInterrupted) {
+			b.lo.Error("error compacting db files", "error", err)
 		}
-		if err := b.merge(); err != nil {
-			b.lo.Error("error merging old files", "error", err)
+	}
+}
+
+// CompactOnce runs a single round of the compaction routine, which removes the expired keys
+// and merges the older datafiles if there are enough of them.
+func (b *Barrel) CompactOnce() error {
+	return b.compactOnce(context.Background())
+}
+
+// compactOnce runs a single round of the compaction routine, which is stopped if the context is done.
+func (b *Barrel) compactOnce(ctx context.Context) error {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.compact(ctx, false)
+}
+
+// Maintain runs a single round of the maintenance otherwise run by the background goroutines:
+// the active datafile is rotated if it's full, it's flushed and synced to the disk,
+// and the datafiles are compacted. It returns early if the context is done between the steps,
+// and the merge of the datafiles is stopped if it's done while merging.
+func (b *Barrel) Maintain(ctx context.Context) error {
+	if b.opts.readOnly {
+		return ErrReadOnly
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"rotating db file", b.rotateDF},
+		{"syncing db file to disk", b.Sync},
+		{"compacting db files", func() error { return b.compactOnce(ctx) }},
+	}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if err := b.generateHints(); err != nil {
-			b.lo.Error("error generating hints file", "error", err)
+		if err := step.run(); err != nil {
+			return fmt.Errorf("error %s: %w", step.name, err)
 		}
+	}
 
-		b.Unlock()
+	return nil
+}
+
+// Compact removes the expired keys and merges the older datafiles right away,
+// which is otherwise done by the compaction routine at the compaction interval.
+// Unlike the compaction routine, it merges even a single older datafile to reclaim its stale records.
+func (b *Barrel) Compact() error {
+	return b.CompactContext(context.Background())
+}
+
+// CompactContext is like Compact, but the merge is stopped if the context is done before the merged
+// datafiles are swapped in. The older datafiles are left as they are, and merged again by the next compaction.
+func (b *Barrel) CompactContext(ctx context.Context) error {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.compact(ctx, true)
+}
+
+// compactOnStartup merges the datafiles if the stale data is more than the startup stale ratio
+// of the size of the datafiles.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) compactOnStartup() error {
+	stale := b.diskBytes - b.liveBytes
+	if len(b.stale) == 0 || stale <= 0 || float64(stale) <= b.opts.startupStaleRatio*float64(b.diskBytes) {
+		return nil
+	}
+
+	b.lo.Info("compacting on startup", "stale_bytes", stale, "disk_bytes", b.diskBytes)
+	return b.compact(context.Background(), true)
+}
+
+// compact removes the expired keys, merges the older datafiles and generates the hints file.
+// If all is set, a single older datafile is merged as well.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) compact(ctx context.Context, all bool) error {
+	if b.opts.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := b.cleanupExpired(); err != nil {
+		return fmt.Errorf("error removing expired keys: %w", err)
+	}
+	merge := b.merge
+	if all && len(b.stale) > 0 {
+		merge = b.mergeFiles
+	}
+	if err := merge(ctx); err != nil {
+		return fmt.Errorf("error merging old files: %w", err)
 	}
+	if err := b.generateHints(); err != nil {
+		return fmt.Errorf("error generating hints file: %w", err)
+	}
+	// Resume the writes if the merge has reclaimed enough space.
+	if err := b.checkDiskSpace(); err != nil {
+		return fmt.Errorf("error checking free disk space: %w", err)
+	}
+
+	return nil
 }
 
 // SyncFile checks for file size at a periodic interval.
@@ -53,15 +178,51 @@ func (b *Barrel) RunCompaction(evalInterval time.Duration) {
 // if the file size exceeds the configured size.
 func (b *Barrel) SyncFile(evalInterval time.Duration) {
 	var (
-		evalTicker = time.NewTicker(evalInterval).C
+		evalTicker = time.NewTicker(evalInterval)
 	)
-	for range evalTicker {
+	defer evalTicker.Stop()
+	for {
+		select {
+		case <-evalTicker.C:
+		case <-b.done:
+			return
+		}
+
 		if err := b.Sync(); err != nil {
 			b.lo.Error("error syncing db file to disk", "error", err)
 		}
 	}
 }
 
+// FlushBuffer flushes the write buffer of the active db file at a periodic interval.
+func (b *Barrel) FlushBuffer(evalInterval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(evalInterval)
+	)
+	defer evalTicker.Stop()
+	for {
+		select {
+		case <-evalTicker.C:
+		case <-b.done:
+			return
+		}
+
+		b.Lock()
+		df := b.df
+		b.Unlock()
+
+		// Flush without holding the lock, so that the writes aren't blocked.
+		if err := df.Flush(); err != nil {
+			b.lo.Error("error flushing write buffer", "error", err)
+			if errors.Is(err, syscall.ENOSPC) {
+				b.Lock()
+				b.pauseWrites(err)
+				b.Unlock()
+			}
+		}
+	}
+}
+
 // rotateDF checks if the active file size has crossed the threshold
 // of max allowed file size. If it has, it replaces the open file descriptors
 // pointing to that file with a new file and adds the current file to list of
@@ -76,33 +237,78 @@ func (b *Barrel) rotateDF() error {
 	}
 
 	// If the file is below the threshold of max size, do no action.
+	// It's rotated at half the max size if enough of its records are dead, so that they're merged sooner.
 	b.lo.Debug("checking if db file has exceeded max_size", "current_size", size, "max_size", b.opts.maxActiveFileSize)
-	if size < b.opts.maxActiveFileSize {
+	if size < b.opts.maxActiveFileSize && !b.rotateEarly(size) {
 		return nil
 	}
 
 	oldID := b.df.ID()
 
-	// Add this datafile to list of stale files.
-	b.stale[oldID] = b.df
-
-	// Create a new datafile.
-	df, err := datafile.New(b.opts.dir, oldID+1)
+	// Create a new datafile, and checkpoint the current one before it's sealed: its records are synced,
+	// its hints are written and the manifest records its final sequence range along with the new datafile.
+	// Since the barrel is locked, no write is acknowledged till the checkpoint is durable, so that
+	// the index lags the log after a crash by the records of the new active datafile at most.
+	// The current datafile stays active if any of these fail.
+	df, err := b.opts.backend.Create(b.opts.dir, oldID+1)
 	if err != nil {
 		return err
 	}
+	discard := func(err error) error {
+		df.Close()
+		os.Remove(df.Path())
+		return err
+	}
+	if err := df.WriteSegmentHeader(recordVersion); err != nil {
+		return discard(err)
+	}
+	if err := b.df.Sync(); err != nil {
+		return discard(err)
+	}
+	if err := b.generateHints(); err != nil {
+		return discard(fmt.Errorf("error generating hints file: %w", err))
+	}
+	if err := b.saveManifest(df.ID()); err != nil {
+		return discard(err)
+	}
+
+	df.SetWriteBuffer(b.opts.writeBufferSize)
+	if b.opts.preallocate {
+		if err := df.Preallocate(b.opts.maxActiveFileSize); err != nil {
+			return err
+		}
+	}
+
+	// Seal the datafile and add it to list of stale files.
+	if err := b.df.Seal(b.pool); err != nil {
+		return err
+	}
+	b.stale[oldID] = b.df
 
 	// Replace with a new instance of datafile.
 	b.df = df
+	b.activeHints = newHints(df.ID())
+	b.unsynced = 0
+	b.spillKeydir()
 
 	return nil
 }
 
-// generateHints encodes the contents of the in-memory hashtable
-// as `gob` and writes the data to a hints file.
+// generateHints encodes the hints of the active datafile
+// as `gob` and writes the data to its hints file.
 func (b *Barrel) generateHints() error {
-	path := filepath.Join(b.opts.dir, HINTS_FILE)
-	if err := b.keydir.Encode(path); err != nil {
+	// Hints aren't needed since the datafiles of the in-memory mode aren't loaded again,
+	// and the directory isn't written to in the read-only mode.
+	if b.opts.inMemory || b.opts.readOnly {
+		return nil
+	}
+
+	// Flush the write buffer so that the hints don't refer to records missing in the file.
+	if err := b.df.Flush(); err != nil {
+		return err
+	}
+
+	if err := b.activeHints.Encode(hintsPath(b.opts.dir, b.df.ID())); err != nil {
 		return err
 	}
 
@@ -112,21 +318,20 @@ func (b *Barrel) generateHints() error {
 // cleanupExpired removes the expired keys.
 func (b *Barrel) cleanupExpired() error {
 	// Iterate over all keys and delete all keys which are expired.
-	for k := range b.keydir {
+	b.keydir.each(func(k string, _ Meta) bool {
 		record, err := b.get(k)
 		if err != nil {
 			b.lo.Error("error fetching key", "key", k, "error", err)
-			continue
+			return true
 		}
-		if record.isExpired() {
-			b.lo.Debug("deleting key since it's expired", "key", k)
+		if record.isExpired(b.now()) {
 			// Delete the key.
-			if err := b.delete(k); err != nil {
+			if err := b.purgeExpired(k, record.Value); err != nil {
 				b.lo.Error("error deleting key", "key", k, "error", err)
-				continue
 			}
 		}
-	}
+		return true
+	})
 
 	return nil
 }
@@ -134,91 +339,475 @@ func (b *Barrel) cleanupExpired() error {
 // Merge is the process of merging all datafiles in a single file.
 // In this process, all the expired/deleted keys are cleaned up and old files
 // are removed from the disk.
-func (b *Barrel) merge() error {
-	var (
-		mergefsync bool
-	)
+func (b *Barrel) merge(ctx context.Context) error {
+	// Merge once an old file has enough dead records, if set.
+	if b.opts.compactDeadRatio > 0 {
+		if !b.garbageDue() {
+			return nil
+		}
+		return b.mergeFiles(ctx)
+	}
 
 	// There should be atleast 2 old files to merge.
 	if len(b.stale) < 2 {
 		return nil
 	}
 
-	// Create a new datafile for storing the output of merged files.
-	// Use a temp directory to store the file and move to main directory after merge is over.
-	tmpMergeDir, err := os.MkdirTemp("", "merged")
+	return b.mergeFiles(ctx)
+}
+
+// mergeDirPrefix is the prefix of the temporary directories in which the merged datafiles are written.
+const mergeDirPrefix = "merge-"
+
+// removeMergeDirs removes the temporary directories left over by an interrupted merge.
+func removeMergeDirs(dir string) error {
+	dirs, err := filepath.Glob(filepath.Join(dir, mergeDirPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := os.RemoveAll(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeTask is the latest record of a key to be rewritten by a merge worker.
+type mergeTask struct {
+	key  string
+	meta Meta
+	df   datafile.Storage
+}
+
+// mergeResult is the record of a key rewritten by a merge worker.
+type mergeResult struct {
+	key  string
+	meta Meta
+	val  []byte // Value of the key, only if it's required to rebuild the secondary indexes.
+}
+
+// mergeFiles merges the older datafiles and the active datafile in new datafiles, one for each
+// of the merge workers. The last of the merged datafiles becomes the active datafile.
+// The merged datafiles are swapped in for the older ones by replacing the manifest. The merge is
+// stopped if the context is done or the barrel is shut down before then.
+// Caller of this function should ensure that there's atleast one old file.
+func (b *Barrel) mergeFiles(ctx context.Context) error {
+	var (
+		mergefsync bool
+	)
+
+	// Create new datafiles for storing the output of merged files, with IDs after the existing datafiles.
+	// Use a temp directory to store the files and move to main directory after merge is over.
+	// It's created in the main directory so that the files can be renamed to it.
+	tmpMergeDir, err := os.MkdirTemp(b.opts.dir, mergeDirPrefix)
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tmpMergeDir)
 
-	mergeDF, err := datafile.New(tmpMergeDir, 0)
+	// Complete the pending writes of the active DF, so that the workers only read it.
+	if err := b.df.Flush(); err != nil {
+		return err
+	}
+
+	tasks, err := b.mergeTasks()
 	if err != nil {
 		return err
 	}
 
+	// Track the progress by the datafiles with the latest records of the keys, the others are merged right away.
+	var (
+		live  = make(map[int]bool)
+		total int64
+	)
+	for _, t := range tasks {
+		for _, task := range t {
+			live[task.meta.FileID] = true
+			total += int64(task.meta.RecordSize)
+		}
+	}
+	merging := len(b.stale) + 1
+	b.progress.start(b.now(), merging-len(live), merging, total)
+	defer b.progress.running.Store(false)
+
+	ctx, cancel := b.withShutdown(ctx)
+	defer cancel()
+	var (
+		nextID = b.df.ID() + 1
+		outs   = make([]datafile.Storage, 0, len(tasks))
+	)
+	// abort discards the merged datafiles if the merge fails before they're swapped in.
+	abort := func(err error) error {
+		if mergefsync {
+			b.opts.alwaysFSync = true
+		}
+		for _, out := range outs {
+			out.Close()
+			os.Remove(out.Path())
+		}
+		return err
+	}
+	for i := range tasks {
+		out, err := b.opts.backend.Create(tmpMergeDir, nextID+i)
+		if err != nil {
+			return abort(err)
+		}
+		outs = append(outs, out)
+
+		// Bypass the page cache for writing the merged datafile. If the filesystem
+		// doesn't support direct I/O, continue with the regular writes.
+		if b.opts.directIOCompaction {
+			if err := out.EnableDirectIO(); err != nil {
+				b.lo.Error("error enabling direct I/O for merge", "error", err)
+			}
+		}
+
+		// Write the records in the latest version of the record format, which upgrades the older records.
+		if err := out.WriteSegmentHeader(recordVersion); err != nil {
+			return abort(err)
+		}
+	}
+
 	// Disable fsync for merge process and manually fsync at the end of merge.
 	if b.opts.alwaysFSync {
 		mergefsync = true
 		b.opts.alwaysFSync = false
 	}
 
-	// Loop over all active keys in the hashmap and write the updated values to merged database.
-	// Since the keydir has updated values of all keys, all the old keys which are expired/deleted/overwritten
-	// will be cleaned up in the merged database.
+	// Hint the kernel to read ahead the old datafiles while they're being merged.
+	for _, df := range b.stale {
+		if err := df.Advise(datafile.AdviceSequential); err != nil {
+			b.lo.Error("error advising sequential reads", "id", df.ID(), "error", err)
+		}
+	}
 
-	for k := range b.keydir {
-		record, err := b.get(k)
+	// Rewrite the latest records of all the keys in the keydir to the merged datafiles concurrently.
+	// Since the keydir has updated values of all keys, all the old keys which are expired/deleted/overwritten
+	// will be cleaned up in the merged database. The expiry of the keys is retained.
+	var (
+		indexes = b.newIndexes()
+		limiter = newRateLimiter(b.opts.compactRate)
+		results = make([][]mergeResult, len(outs))
+		errs    = make([]error, len(outs))
+		wg      sync.WaitGroup
+	)
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = b.mergeWorker(ctx, outs[i], tasks[i], limiter, len(indexes) > 0)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			return err
+			return abort(b.interrupted(err))
 		}
-		if err := b.put(mergeDF, k, record.Value, nil); err != nil {
-			return err
+	}
+
+	// Flush the merged datafiles to disk and move them to the main directory.
+	// Then drop their pages from the page cache so that the merge doesn't evict the hot pages.
+	ids := make([]int, 0, len(outs))
+	for _, out := range outs {
+		if err := out.Sync(); err != nil {
+			return abort(err)
+		}
+		if err := out.Advise(datafile.AdviceDontNeed); err != nil {
+			b.lo.Error("error advising to drop cached pages", "error", err)
+		}
+		if err := out.Move(b.opts.dir); err != nil {
+			return abort(err)
+		}
+		ids = append(ids, out.ID())
+	}
+
+	// The merged datafiles cover the sequence numbers of all the merged records, and their
+	// time and key ranges are of the rewritten records.
+	var (
+		merged = seqRange{first: b.seq, end: b.seq}
+		times  = make(map[int]timeRange, len(outs))
+		stats  = make(map[int]segmentStats, len(outs))
+	)
+	for _, r := range b.seqRanges {
+		if r.first < merged.first {
+			merged.first = r.first
+		}
+	}
+	for _, res := range results {
+		for _, r := range res {
+			ts := uint32(r.meta.Timestamp)
+			tr, ok := times[r.meta.FileID]
+			if !ok {
+				tr = timeRange{min: ts, max: ts}
+			}
+			times[r.meta.FileID] = tr.extend(ts)
+			stats[r.meta.FileID] = stats[r.meta.FileID].add(r.key)
+		}
+	}
+	man := b.newManifest(ids)
+	for i, seg := range man.Segments {
+		man.Segments[i].FirstSeq, man.Segments[i].EndSeq = merged.first, merged.end
+		man.Segments[i].Merged, man.Segments[i].AppendSeq = uint64(len(results[seg.ID-nextID])), merged.end
+		if tr, ok := times[seg.ID]; ok {
+			man.Segments[i].MinTime, man.Segments[i].MaxTime = int64(tr.min), int64(tr.max)
+		}
+		if st, ok := stats[seg.ID]; ok {
+			man.Segments[i].Records, man.Segments[i].MinKey, man.Segments[i].MaxKey = st.records, st.minKey, st.maxKey
+		}
+	}
+
+	// Swap in the merged datafiles by replacing the manifest. Until then the old datafiles are the live ones,
+	// so a crash at any point of the merge leaves either the old or the merged datafiles, never a mix of them.
+	if err := writeManifest(b.opts.dir, man); err != nil {
+		return abort(err)
+	}
+
+	// Point the keys to their merged records. The ranges are replaced since the old datafiles
+	// are removed and the records are rewritten in the merged datafiles.
+	// The secondary indexes are rebuilt from the merged records, which drops any stale entries.
+	b.timeRanges = times
+	b.stats = stats
+	b.seqRanges = make(map[int]seqRange, len(outs))
+	for i, id := range ids {
+		b.seqRanges[id] = seqRange{first: merged.first, end: merged.end, merged: uint64(len(results[i])), base: merged.end}
+	}
+	// The keys pointing to the merged datafiles are read from them by the compact keydir till they're swapped in.
+	b.merged = make(map[int]datafile.Storage, len(outs))
+	for _, out := range outs {
+		b.merged[out.ID()] = out
+	}
+	defer func() { b.merged = nil }()
+	for _, res := range results {
+		for _, r := range res {
+			old := b.keydir.meta(r.key)
+			if _, err := b.keydir.set(r.key, r.meta); err != nil {
+				b.keydirError(err)
+				continue
+			}
+			b.liveBytes += r.meta.RecordSize - old.RecordSize
+			b.account(r.key, 0, r.meta.RecordSize-old.RecordSize)
+			if !isReserved(r.key) {
+				for _, idx := range indexes {
+					idx.add(r.key, r.val)
+				}
+			}
 		}
 	}
 
-	// Now close all the existing datafile handlers.
+	// Now close all the existing datafile handlers and delete their files along with their hints.
+	// The files which can't be deleted aren't listed in the manifest anymore, so they're deleted on startup.
+	old := append(make([]datafile.Storage, 0, len(b.stale)+1), b.df)
 	for _, df := range b.stale {
+		old = append(old, df)
+	}
+	// Wait for the reads outside the barrel lock before closing the datafiles.
+	b.readers.Lock()
+	for _, df := range old {
+		b.keydir.forget(df.ID())
 		if err := df.Close(); err != nil {
 			b.lo.Error("error closing df", "id", df.ID(), "error", err)
-			continue
 		}
+		paths := []string{df.Path(), hintsPath(b.opts.dir, df.ID())}
+		if df.Version() == datafile.VersionBitcask {
+			paths = append(paths, bitcaskHintsPath(b.opts.dir, df.ID()))
+		}
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				b.lo.Error("error removing merged file", "path", path, "error", err)
+			}
+		}
+	}
+	b.readers.Unlock()
+	// The merged files are unlisted from the manifest already, so they're deleted on startup if their removal is lost.
+	if err := syncDir(b.opts.dir); err != nil {
+		b.lo.Error("error syncing dir after removing merged files", "error", err)
 	}
 
 	// Reset the old map.
-	b.stale = make(map[int]*datafile.DataFile, 0)
+	b.stale = make(map[int]datafile.Storage, 0)
+	b.indexes = indexes
 
-	// Delete the existing .db files
-	err = filepath.Walk(b.opts.dir, func(path string, info os.FileInfo, err error) error {
+	// Reset the cache since the old datafiles are removed.
+	if b.cache != nil {
+		b.cache.reset()
+	}
+
+	// Since all the keys now point to the merged DFs, the hints for them are the same as the keydir.
+	hints := make([]*Hints, len(outs))
+	for i, out := range outs {
+		hints[i] = newHints(out.ID())
+	}
+	b.keydir.each(func(k string, meta Meta) bool {
+		hints[meta.FileID-nextID].add(k, meta, b.tags.values[k], false)
+		return true
+	})
+	b.diskBytes = 0
+	for i, out := range outs {
+		size, err := out.Size()
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
-		}
-		if filepath.Ext(path) == ".db" {
-			err := os.Remove(path)
-			if err != nil {
+		hints[i].Offset = int(size)
+		b.diskBytes += int(size)
+	}
+
+	// Seal all the merged DFs but the last one, which becomes the active DF.
+	last := len(outs) - 1
+	for i, out := range outs[:last] {
+		if !b.opts.inMemory {
+			if err := hints[i].Encode(hintsPath(b.opts.dir, out.ID())); err != nil {
 				return err
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		return err
+		if err := out.Seal(b.pool); err != nil {
+			return err
+		}
+		b.stale[out.ID()] = out
 	}
 
-	// Move the merged file to the main directory.
-	os.Rename(filepath.Join(tmpMergeDir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, 0)),
-		filepath.Join(b.opts.dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, 0)))
+	// Enable the write buffer since it becomes the active DF.
+	outs[last].SetWriteBuffer(b.opts.writeBufferSize)
 
 	// Set the merged DF as the active DF.
-	b.df = mergeDF
+	b.df = outs[last]
+	b.activeHints = hints[last]
+	if err := b.loadUsage(); err != nil {
+		return err
+	}
 
 	if mergefsync {
 		b.opts.alwaysFSync = true
 		b.df.Sync()
 	}
 
+	// The keys are set in memory by the merge, so the merged datafiles are spilled again if needed.
+	b.spillKeydir()
+
 	return nil
 }
+
+// mergeTasks splits the latest records of the keys among the merge workers by their datafiles,
+// so that each worker reads its datafiles sequentially. The datafiles are assigned to the worker
+// with the least live bytes so far, starting with the datafiles with the most live bytes.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) mergeTasks() ([][]mergeTask, error) {
+	// Record the accesses by the reads, so that they're retained by the merge.
+	b.applyTouches()
+
+	var (
+		byFile = make(map[int][]mergeTask)
+		live   = make(map[int]int)
+	)
+	var err error
+	b.keydir.each(func(k string, meta Meta) bool {
+		var df datafile.Storage
+		if df, err = b.reader(meta.FileID); err != nil {
+			return false
+		}
+		byFile[meta.FileID] = append(byFile[meta.FileID], mergeTask{key: k, meta: meta, df: df})
+		live[meta.FileID] += meta.RecordSize
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(byFile))
+	for id := range byFile {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return live[ids[i]] > live[ids[j]] || (live[ids[i]] == live[ids[j]] && ids[i] < ids[j])
+	})
+
+	workers := b.opts.compactConcurrency
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		tasks = make([][]mergeTask, workers)
+		load  = make([]int, workers)
+	)
+	for _, id := range ids {
+		w := 0
+		for i := range load {
+			if load[i] < load[w] {
+				w = i
+			}
+		}
+		recs := byFile[id]
+		sort.Slice(recs, func(i, j int) bool {
+			return recs[i].meta.RecordPos < recs[j].meta.RecordPos
+		})
+		tasks[w] = append(tasks[w], recs...)
+		load[w] += live[id]
+	}
+
+	return tasks, nil
+}
+
+// mergeWorker rewrites the latest records of the keys to the merged datafile. It doesn't modify
+// the barrel, so that the workers can run concurrently while the barrel is locked.
+// It stops if the context is done.
+func (b *Barrel) mergeWorker(ctx context.Context, out datafile.Storage, tasks []mergeTask, limiter *rateLimiter, withVals bool) ([]mergeResult, error) {
+	var (
+		buf     = &bytes.Buffer{}
+		results = make([]mergeResult, 0, len(tasks))
+	)
+	for i, t := range tasks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := limiter.wait(ctx, t.meta.RecordSize); err != nil {
+			return nil, err
+		}
+
+		data, err := t.df.Read(t.meta.RecordPos, t.meta.RecordSize)
+		if err != nil {
+			return nil, fmt.Errorf("error reading data from file: %w", err)
+		}
+		record, err := decodeRecord(t.key, data, t.df.Version())
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Reset()
+		header := b.encodeRecordAt(buf, t.key, record.Value, record.rawMeta, record.Header.expiry(), record.Header.Timestamp)
+		offset, err := out.Write(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("error writing data to file: %w", err)
+		}
+
+		// Retain the write time, the access time and the version of the key for the records rewritten by a merge.
+		res := mergeResult{
+			key: t.key,
+			meta: Meta{
+				Timestamp:  int(header.Timestamp),
+				RecordSize: buf.Len(),
+				RecordPos:  offset + buf.Len(),
+				FileID:     out.ID(),
+				Expiry:     int(header.Expiry),
+				Accessed:   t.meta.Accessed,
+				Version:    t.meta.Version,
+			},
+		}
+		if withVals {
+			// Copy the value since it may point to the mapped memory of the datafile.
+			res.val = append([]byte(nil), record.Value...)
+		}
+		results = append(results, res)
+
+		// The tasks of each datafile are consecutive, so it's merged with its last task.
+		b.progress.bytesDone.Add(int64(t.meta.RecordSize))
+		if i == len(tasks)-1 || tasks[i+1].meta.FileID != t.meta.FileID {
+			b.progress.segmentsDone.Add(1)
+		}
+	}
+
+	return results, nil
+}
@@ -1,7 +1,12 @@
 package barrel
 
 import (
+	"errors"
+	"fmt"
+	"runtime"
 	"time"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
 )
 
 const (
@@ -9,18 +14,73 @@ const (
 	defaultCompactInterval   = time.Hour * 6
 	defaultFileSizeInterval  = time.Minute * 1
 	defaultMaxActiveFileSize = int64(1 << 32) // 4GB.
+	defaultMaxOpenFiles      = 256
+	defaultFlushInterval     = time.Millisecond * 100
+	defaultReadConcurrency   = 16
+	defaultIOUringEntries    = 64
 )
 
 // Options represents configuration options for managing a datastore.
 type Options struct {
-	debug                 bool           // Enable debug logging.
-	dir                   string         // Path for storing data files.
-	readOnly              bool           // Whether this datastore should be opened in a read-only mode. Only one process at a time can open it in R-W mode.
-	alwaysFSync           bool           // Should flush filesystem buffer after every right.
-	syncInterval          *time.Duration // Interval to sync the active file on disk.
-	compactInterval       time.Duration  // Interval to compact old files.
-	checkFileSizeInterval time.Duration  // Interval to check the file size of the active DB.
-	maxActiveFileSize     int64          // Max size of active file in bytes. On exceeding this size it's rotated.
+	debug                 bool                       // Enable debug logging.
+	dir                   string                     // Path for storing data files.
+	readOnly              bool                       // Whether this datastore should be opened in a read-only mode. Only one process at a time can open it in R-W mode.
+	alwaysFSync           bool                       // Should flush filesystem buffer after every right.
+	fsyncOnPut            bool                       // Should flush filesystem buffer before returning from every write, batching concurrent writers.
+	syncInterval          *time.Duration             // Interval to sync the active file on disk.
+	syncBytes             int                        // Number of bytes written to the active file after which it's synced, if set.
+	compactInterval       time.Duration              // Interval to compact old files.
+	checkFileSizeInterval time.Duration              // Interval to check the file size of the active DB.
+	maxActiveFileSize     int64                      // Max size of active file in bytes. On exceeding this size it's rotated.
+	maxKeySize            int                        // Max size of a key in bytes.
+	maxValueSize          int                        // Max size of a value in bytes.
+	checksumAlgo          ChecksumAlgo               // Algorithm used for the checksum of the new records.
+	verifyOnStartup       bool                       // Whether the checksums of all the records are validated on startup.
+	autoHeal              bool                       // Whether the keys with a corrupt record are recovered from an older record.
+	scrubInterval         time.Duration              // Interval to validate the checksums of the older records. Disabled if it's 0.
+	scrubRate             int                        // Max rate of reading the datafiles while scrubbing in bytes per second. Unlimited if it's 0.
+	loadConcurrency       int                        // Number of datafiles whose hints are loaded concurrently on startup.
+	maxOpenFiles          int                        // Max number of older datafiles which are kept open for reading.
+	mmapReads             bool                       // Whether older datafiles are memory-mapped for reading.
+	ioUring               bool                       // Whether the reads of GetMulti are batched with io_uring.
+	writeBufferSize       int                        // Size of the in-memory buffer for writes in bytes. Writes are unbuffered if it's 0.
+	preallocate           bool                       // Whether disk space for the active file is reserved upto the max active file size.
+	directIOCompaction    bool                       // Whether the merged datafile is written bypassing the page cache.
+	valueCacheSize        int                        // Max size of the records in the value cache in bytes. Caching is disabled if it's 0.
+	maxDataSize           int                        // Max size of the live data in bytes. Unlimited if it's 0.
+	evictionPolicy        EvictionPolicy             // Policy for evicting keys when the max data size is reached.
+	minFreeDisk           int64                      // Min free disk space in bytes, below which the writes are paused.
+	maxStaleRatio         float64                    // Max ratio of the stale data to the size of the datafiles, above which the writes are stalled.
+	minStaleBytes         int                        // Min size of the stale data in bytes for stalling the writes.
+	indexes               map[string]Extractor       // Extractors of the values of the secondary indexes, by their names.
+	expiryCallback        func(k string, val []byte) // Called with the keys purged since they expired.
+	hooks                 []Hook                     // Hooks called around the writes, in order.
+	mirrorTarget          MirrorTarget               // Target to which the writes are mirrored, if any.
+	mirrorQueueSize       int                        // Max number of writes queued to be mirrored.
+	changeSink            ChangeSink                 // Sink to which the changes are published, if any.
+	quotas                []Quota                    // Quotas of the namespaces.
+	bitcaskCompat         bool                       // Whether the datafiles written by Bitcask are loaded as well.
+	inMemory              bool                       // Whether the datafiles are kept in a temporary directory in memory.
+	clock                 Clock                      // Source of the current time for the timestamps and the expiry.
+	maxClockSkew          time.Duration              // Largest jump of the system clock which is followed. Disabled if it's 0.
+	idempotencyWindow     time.Duration              // Time for which the idempotency tokens of the writes are remembered.
+	manualMaintenance     bool                       // Whether the maintenance is run by Maintain instead of the background goroutines.
+	backend               datafile.Backend           // Backend which creates and opens the datafiles.
+
+	compactWindows          []compactionWindow // Daily time windows to which the automatic compaction is restricted, if any.
+	compactMinAmplification float64            // Min ratio of the size of the datafiles to the live data for the automatic compaction.
+	compactConcurrency      int                // Number of workers merging the datafiles concurrently.
+	compactRate             int                // Max rate of reading the datafiles while merging in bytes per second. Unlimited if it's 0.
+	compactDeadRatio        float64            // Min fraction of dead records in an older datafile for merging the datafiles, if set.
+	compactOnStartup        bool               // Whether the datafiles are merged on startup if enough of the data is stale.
+	startupStaleRatio       float64            // Min ratio of the stale data to the size of the datafiles for merging them on startup.
+
+	hotKeysSample   int // Tracks one of every these many accesses of the keys for reporting the hot keys, if set.
+	hotKeysCapacity int // Max number of keys tracked for reporting the hot keys.
+
+	sortedKeys     bool // Whether the keys are maintained in lexicographic order as well.
+	compactKeydir  bool // Whether the keydir keeps only the hashes of the keys, reading the keys from the records.
+	maxIndexMemory int  // Max memory of the keydir in bytes, beyond which the older entries are spilled. Unlimited if it's 0.
 }
 
 // Config is a function on the Options for barreldb.
@@ -34,8 +94,17 @@ func DefaultOptions() *Options {
 		readOnly:              false,
 		alwaysFSync:           false,
 		maxActiveFileSize:     defaultMaxActiveFileSize,
+		maxKeySize:            MaxKeySize,
+		maxValueSize:          MaxValueSize,
 		compactInterval:       defaultCompactInterval,
 		checkFileSizeInterval: defaultFileSizeInterval,
+		loadConcurrency:       runtime.NumCPU(),
+		maxOpenFiles:          defaultMaxOpenFiles,
+		clock:                 systemClock{},
+		maxClockSkew:          defaultMaxClockSkew,
+		idempotencyWindow:     defaultIdempotencyWindow,
+		compactConcurrency:    1,
+		backend:               datafile.Files,
 	}
 }
 
@@ -53,6 +122,9 @@ func WithDir(dir string) Config {
 	}
 }
 
+// WithReadOnly opens the datafiles for reading only and rejects the writes with ErrReadOnly.
+// No file is created or written to in the directory, so it can be on a read-only filesystem,
+// e.g. a mounted backup, and the background compaction and rotation of the datafiles don't run.
 func WithReadOnly() Config {
 	return func(o *Options) error {
 		o.readOnly = true
@@ -67,19 +139,52 @@ func WithAlwaysSync() Config {
 	}
 }
 
+// WithFsyncOnPut ensures that every write is flushed to disk before it returns.
+// Unlike WithAlwaysSync, concurrent writers are batched into a single fsync (group commit).
+//
+// Deprecated: Use WithSyncPolicy(SyncEveryWrite).
+func WithFsyncOnPut() Config {
+	return WithSyncPolicy(SyncEveryWrite)
+}
+
+// WithAutoSync syncs the active datafile in background every minute.
+//
+// Deprecated: Use WithSyncPolicy(SyncInterval(d)).
 func WithAutoSync() Config {
-	return func(o *Options) error {
-		o.alwaysFSync = false
-		d := defaultSyncInterval
-		o.syncInterval = &d
-		return nil
-	}
+	return WithSyncPolicy(SyncInterval(defaultSyncInterval))
 }
 
+// WithBackgrondSync syncs the active datafile in background at the given interval.
+//
+// Deprecated: Use WithSyncPolicy(SyncInterval(d)).
 func WithBackgrondSync(interval time.Duration) Config {
+	return WithSyncPolicy(SyncInterval(interval))
+}
+
+// WithSyncPolicy sets when the writes are synced to disk, replacing the policy set before.
+// See SyncNever, SyncInterval, SyncEveryWrite and SyncEveryNBytes.
+func WithSyncPolicy(policy SyncPolicy) Config {
 	return func(o *Options) error {
-		o.alwaysFSync = false
-		o.syncInterval = &interval
+		o.alwaysFSync, o.fsyncOnPut, o.syncInterval, o.syncBytes = false, false, nil, 0
+
+		switch policy.kind {
+		case syncNever:
+		case syncInterval:
+			if policy.interval <= 0 {
+				return errors.New("sync interval must be positive")
+			}
+			interval := policy.interval
+			o.syncInterval = &interval
+		case syncEveryWrite:
+			o.fsyncOnPut = true
+		case syncEveryNBytes:
+			if policy.bytes <= 0 {
+				return errors.New("sync bytes must be positive")
+			}
+			o.syncBytes = policy.bytes
+		default:
+			return fmt.Errorf("unknown sync policy: %d", policy.kind)
+		}
 		return nil
 	}
 }
@@ -104,3 +209,553 @@ func WithMaxActiveFileSize(size int64) Config {
 		return nil
 	}
 }
+
+func WithLoadConcurrency(n int) Config {
+	return func(o *Options) error {
+		if n < 1 {
+			return errors.New("load concurrency must be at least 1")
+		}
+		o.loadConcurrency = n
+		return nil
+	}
+}
+
+func WithMaxOpenFiles(n int) Config {
+	return func(o *Options) error {
+		if n < 1 {
+			return errors.New("max open files must be at least 1")
+		}
+		o.maxOpenFiles = n
+		return nil
+	}
+}
+
+// WithMmapReads memory-maps the older datafiles and serves the reads from them without
+// a read syscall. Reads from the active datafile are unaffected. The values returned by Get are
+// copied out of the mapping, while GetRef returns them without copying and keeps the datafile
+// mapped till the ref is released, even if it's merged or the barrel is shut down meanwhile.
+func WithMmapReads() Config {
+	return func(o *Options) error {
+		o.mmapReads = true
+		return nil
+	}
+}
+
+// WithIOUring submits the reads of the older datafiles by GetMulti to an io_uring (only on linux) at once,
+// instead of reading them in parallel with a read syscall per record by a pool of goroutines.
+// If io_uring isn't available, e.g. on the older kernels or if it's disabled, the reads fall back to the pool.
+func WithIOUring() Config {
+	return func(o *Options) error {
+		o.ioUring = true
+		return nil
+	}
+}
+
+// WithWriteBuffer appends the writes to an in-memory buffer of the given size in bytes,
+// which is flushed to the active datafile in background. Writers are blocked only when the buffer is full.
+// Reads of the records which aren't flushed yet flush the buffer first.
+// Since the buffered records are lost on a crash, use WithFsyncOnPut for durable writes.
+func WithWriteBuffer(size int) Config {
+	return func(o *Options) error {
+		if size < 0 {
+			return errors.New("write buffer size cannot be negative")
+		}
+		o.writeBufferSize = size
+		return nil
+	}
+}
+
+// WithPreallocate reserves the disk space for the active datafile upto the max active file size
+// (using fallocate on linux), which reduces fragmentation and filesystem metadata updates as it grows.
+// The space which isn't used is released when the datafile is rotated.
+func WithPreallocate() Config {
+	return func(o *Options) error {
+		o.preallocate = true
+		return nil
+	}
+}
+
+// WithDirectIOCompaction writes the output of merges using direct I/O (only on linux),
+// so that compaction doesn't evict the pages cached for serving reads.
+func WithDirectIOCompaction() Config {
+	return func(o *Options) error {
+		o.directIOCompaction = true
+		return nil
+	}
+}
+
+// WithValueCache caches the recently read records in memory upto the given size in bytes,
+// so that repeated reads of hot keys don't hit the disk.
+func WithValueCache(size int) Config {
+	return func(o *Options) error {
+		if size < 0 {
+			return errors.New("value cache size cannot be negative")
+		}
+		o.valueCacheSize = size
+		return nil
+	}
+}
+
+// WithMaxDataSize limits the size of the live data, i.e. the latest records of all the keys,
+// to the given size in bytes, similar to `maxmemory` of Redis. When a write would exceed the limit,
+// keys are evicted as per the given policy to make room for it. If no key can be evicted,
+// the write fails with ErrMaxDataSize. Deletes are always allowed.
+func WithMaxDataSize(size int, policy EvictionPolicy) Config {
+	return func(o *Options) error {
+		if size < 0 {
+			return errors.New("max data size cannot be negative")
+		}
+		if policy < NoEviction || policy > VolatileTTL {
+			return errors.New("invalid eviction policy")
+		}
+		o.maxDataSize = size
+		o.evictionPolicy = policy
+		return nil
+	}
+}
+
+// WithMinFreeDisk pauses the writes with ErrStorageFull when the free space on the disk
+// of the data directory falls below the given size in bytes, instead of running
+// into write errors once the disk is full. The free space is checked along with the
+// size of the active file, and the writes are resumed once a merge reclaims enough space.
+// Writes are paused even without this option if the disk runs out of space.
+func WithMinFreeDisk(size int64) Config {
+	return func(o *Options) error {
+		if size < 0 {
+			return errors.New("min free disk space cannot be negative")
+		}
+		o.minFreeDisk = size
+		return nil
+	}
+}
+
+// WithWriteStall rejects the writes with ErrWriteStall when the stale data (overwritten,
+// deleted or expired records) is more than the given ratio of the size of the datafiles and
+// at least minStaleBytes, so that the disk isn't filled faster than the merges reclaim it.
+// A merge is triggered right away when the writes are stalled. Since only the older datafiles
+// are merged, the limits should leave room for the stale data in the active datafile. Deletes are stalled as well,
+// since their tombstones take up disk space like any other write.
+func WithWriteStall(maxStaleRatio float64, minStaleBytes int) Config {
+	return func(o *Options) error {
+		if maxStaleRatio <= 0 || maxStaleRatio >= 1 {
+			return errors.New("max stale ratio must be between 0 and 1")
+		}
+		if minStaleBytes < 0 {
+			return errors.New("min stale bytes cannot be negative")
+		}
+		o.maxStaleRatio = maxStaleRatio
+		o.minStaleBytes = minStaleBytes
+		return nil
+	}
+}
+
+// WithMaxKeySize rejects the writes of the keys larger than the given size in bytes with ErrLargeKey.
+// The size can't be more than MaxKeySize, which is also the default.
+func WithMaxKeySize(size int) Config {
+	return func(o *Options) error {
+		if size < 1 || size > MaxKeySize {
+			return fmt.Errorf("max key size must be between 1 and %d", MaxKeySize)
+		}
+		o.maxKeySize = size
+		return nil
+	}
+}
+
+// WithMaxValueSize rejects the writes of the values larger than the given size in bytes with ErrLargeValue.
+// The size can't be more than MaxValueSize, which is also the default.
+func WithMaxValueSize(size int) Config {
+	return func(o *Options) error {
+		if size < 1 || size > MaxValueSize {
+			return fmt.Errorf("max value size must be between 1 and %d", MaxValueSize)
+		}
+		o.maxValueSize = size
+		return nil
+	}
+}
+
+// WithChecksum sets the algorithm used for the checksum of the new records. Since the algorithm
+// is stored in each record, the records written earlier with other algorithms remain readable.
+func WithChecksum(algo ChecksumAlgo) Config {
+	return func(o *Options) error {
+		if algo > ChecksumXXHash64 {
+			return errors.New("invalid checksum algorithm")
+		}
+		o.checksumAlgo = algo
+		return nil
+	}
+}
+
+// WithVerifyOnStartup validates the checksums of all the records in the older datafiles on startup.
+// The keys whose latest record is corrupt are quarantined: they're removed from the keydir and
+// their reads fail with ErrChecksumMismatch until they're written again.
+func WithVerifyOnStartup() Config {
+	return func(o *Options) error {
+		o.verifyOnStartup = true
+		return nil
+	}
+}
+
+// WithScrubber validates the checksums of all the records in the older datafiles at the given interval
+// in background, reading them at most at the given rate in bytes per second (unlimited if it's 0).
+// Like WithVerifyOnStartup, the keys whose latest record is corrupt are quarantined.
+func WithScrubber(interval time.Duration, rate int) Config {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return errors.New("scrub interval must be positive")
+		}
+		if rate < 0 {
+			return errors.New("scrub rate cannot be negative")
+		}
+		o.scrubInterval = interval
+		o.scrubRate = rate
+		return nil
+	}
+}
+
+// WithAutoHeal recovers the keys whose latest record is found to be corrupt while reading them,
+// from the latest valid record of the key written before it. The recovered value is written again
+// as the latest record. Since merges remove the older records, a key can't be healed once its
+// datafiles are merged, in which case the read fails with ErrChecksumMismatch.
+func WithAutoHeal() Config {
+	return func(o *Options) error {
+		o.autoHeal = true
+		return nil
+	}
+}
+
+// WithIndex maintains a secondary index with the given name on the values returned by the extractor
+// for each record, so that the keys can be queried by those values with Lookup. The index is kept
+// in memory: it's built from all the values on startup and updated on every write.
+func WithIndex(name string, extract Extractor) Config {
+	return func(o *Options) error {
+		if name == "" {
+			return errors.New("index name cannot be empty")
+		}
+		if extract == nil {
+			return errors.New("index extractor cannot be nil")
+		}
+		if o.indexes == nil {
+			o.indexes = make(map[string]Extractor)
+		}
+		o.indexes[name] = extract
+		return nil
+	}
+}
+
+// WithJSONIndex is same as WithIndex with a JSONExtractor for the given path.
+func WithJSONIndex(name, path string) Config {
+	return func(o *Options) error {
+		extract, err := JSONExtractor(path)
+		if err != nil {
+			return fmt.Errorf("invalid path for index %s: %w", name, err)
+		}
+		return WithIndex(name, extract)(o)
+	}
+}
+
+// WithExpiryCallback calls the given function with the key and the last value of every key purged
+// since it expired, either by the compaction or by a read of the key. It's called in background
+// in the order the keys are purged, without holding the lock, so it can use the barrel.
+func WithExpiryCallback(fn func(k string, val []byte)) Config {
+	return func(o *Options) error {
+		if fn == nil {
+			return errors.New("expiry callback cannot be nil")
+		}
+		o.expiryCallback = fn
+		return nil
+	}
+}
+
+// WithHook adds a hook which is called around the writes of the keys. Hooks are called
+// in the order they're added and any of them can reject a write by returning an error.
+func WithHook(h Hook) Config {
+	return func(o *Options) error {
+		if h == nil {
+			return errors.New("hook cannot be nil")
+		}
+		o.hooks = append(o.hooks, h)
+		return nil
+	}
+}
+
+// WithMirror mirrors every write and delete to the given target asynchronously, which helps to
+// migrate to another directory or server while serving the writes. Upto queueSize writes are queued
+// and the writes are dropped while the queue is full. The failed and dropped writes are counted in Stats.
+// Only the keys, the values and the expiry are mirrored, not the metadata or the tags.
+func WithMirror(target MirrorTarget, queueSize int) Config {
+	return func(o *Options) error {
+		if target == nil {
+			return errors.New("mirror target cannot be nil")
+		}
+		if queueSize <= 0 {
+			return errors.New("mirror queue size must be positive")
+		}
+		o.mirrorTarget = target
+		o.mirrorQueueSize = queueSize
+		return nil
+	}
+}
+
+// WithChangeSink publishes every write and delete to the given sink, e.g. the sink of the sinks/nats package,
+// in the order they're written, along with their sequence numbers. The changes are delivered to the sink at
+// least once: the offset of the log upto which they're acknowledged is persisted in the directory, and the
+// publishing resumes from it on startup, so the changes since the last acknowledged batch are published again
+// after a crash. The delivery past the sink is only as reliable as its acknowledgement, e.g. the NATS sink
+// is acknowledged once the server receives the changes, so they're delivered at most once past the server.
+// The changes are read from the log like Tail, so the writes aren't held up while the sink is down.
+func WithChangeSink(sink ChangeSink) Config {
+	return func(o *Options) error {
+		if sink == nil {
+			return errors.New("change sink cannot be nil")
+		}
+		o.changeSink = sink
+		return nil
+	}
+}
+
+// WithQuota limits the number of keys and the size of the live data of the namespace made up of
+// all the keys starting with the prefix of the quota. Writes exceeding the quota are either rejected
+// with ErrQuotaExceeded or the oldest keys of the namespace are evicted, as per its policy.
+// If a key is in the namespaces of multiple quotas, only the one with the longest prefix applies.
+func WithQuota(q Quota) Config {
+	return func(o *Options) error {
+		if q.Prefix == "" {
+			return errors.New("quota prefix cannot be empty")
+		}
+		if q.MaxKeys < 0 || q.MaxBytes < 0 {
+			return errors.New("quota limits cannot be negative")
+		}
+		if q.MaxKeys == 0 && q.MaxBytes == 0 {
+			return errors.New("quota must limit either the keys or the bytes")
+		}
+		for _, existing := range o.quotas {
+			if existing.Prefix == q.Prefix {
+				return fmt.Errorf("duplicate quota for prefix %s", q.Prefix)
+			}
+		}
+		o.quotas = append(o.quotas, q)
+		return nil
+	}
+}
+
+// WithBitcaskCompat loads the datafiles written by the Erlang Bitcask (`N.bitcask.data`) in the directory
+// along with the datafiles of barrel, so that the existing Bitcask datasets can be served without
+// converting them first. The Bitcask hints files are ignored, so the hints are rebuilt from the
+// Bitcask datafiles on the first startup. The writes go to the datafiles of barrel and the
+// Bitcask datafiles are removed once they're merged by a compaction.
+func WithBitcaskCompat() Config {
+	return func(o *Options) error {
+		o.bitcaskCompat = true
+		return nil
+	}
+}
+
+// WithInMemory keeps the datafiles in a temporary directory on tmpfs (`/dev/shm`) instead of the
+// configured directory, for tests and ephemeral caches. Neither a lockfile nor hints are written
+// and the directory is removed on shutdown, so the keys don't outlive the barrel. The temporary
+// directory is created in the default directory for temporary files if tmpfs isn't available.
+func WithInMemory() Config {
+	return func(o *Options) error {
+		o.inMemory = true
+		return nil
+	}
+}
+
+// WithClock sets the source of the current time used for the timestamps of the records, the expiry
+// of the keys and the purging of the expired keys, so that the expiry can be tested without sleeping.
+func WithClock(clock Clock) Config {
+	return func(o *Options) error {
+		if clock == nil {
+			return errors.New("clock cannot be nil")
+		}
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithMaxClockSkew sets the largest jump of the system clock which is followed for the timestamps and the
+// expiry of the records. Larger jumps, like a step correction by NTP, are ignored by deriving the time from
+// a monotonic clock instead, so that the keys neither expire early nor live longer than their TTL. It also
+// warns on startup if the clock is behind the newest record by more than the skew. The default is a minute
+// and 0 follows the system clock as is. It doesn't apply to a clock set with WithClock.
+func WithMaxClockSkew(skew time.Duration) Config {
+	return func(o *Options) error {
+		if skew < 0 {
+			return errors.New("max clock skew cannot be negative")
+		}
+		o.maxClockSkew = skew
+		return nil
+	}
+}
+
+// WithIdempotencyWindow sets the time for which the tokens of the writes by PutIdempotent are remembered,
+// within which the writes with the same token are skipped. It should be longer than the time for which the
+// producers retry a write. The default is an hour.
+func WithIdempotencyWindow(window time.Duration) Config {
+	return func(o *Options) error {
+		if window <= 0 {
+			return errors.New("idempotency window must be positive")
+		}
+		o.idempotencyWindow = window
+		return nil
+	}
+}
+
+// WithManualMaintenance doesn't spawn the background goroutines which compact the datafiles,
+// rotate the active datafile, and flush and sync it at the intervals. The embedder runs the
+// maintenance instead with Maintain or CompactOnce, e.g. in the tests or in serverless environments.
+func WithManualMaintenance() Config {
+	return func(o *Options) error {
+		o.manualMaintenance = true
+		return nil
+	}
+}
+
+// WithCompactionWindow restricts the automatic compaction to the daily time window between start and end
+// in the local time, in the "15:04" format, e.g. "01:00" and "05:00" so that the merges don't coincide with
+// the peak traffic. The window wraps around midnight if the end is before the start. It can be set more
+// than once to allow multiple windows. Compact, CompactOnce and the compaction triggered when the writes
+// are stalled aren't restricted.
+func WithCompactionWindow(start, end string) Config {
+	return func(o *Options) error {
+		w, err := parseCompactionWindow(start, end)
+		if err != nil {
+			return err
+		}
+		o.compactWindows = append(o.compactWindows, w)
+		return nil
+	}
+}
+
+// WithCompactionAmplification runs the automatic compaction only once the size of the datafiles is at least
+// the given multiple of the size of the live data, e.g. 2 once half of the disk usage is stale records.
+func WithCompactionAmplification(ratio float64) Config {
+	return func(o *Options) error {
+		if ratio < 1 {
+			return errors.New("compaction amplification must be at least 1")
+		}
+		o.compactMinAmplification = ratio
+		return nil
+	}
+}
+
+// WithCompactionConcurrency merges the datafiles with the given number of workers. The older datafiles are
+// split among the workers by the size of their live records, and each worker writes the latest records
+// of the keys in its datafiles to a merged datafile of its own, so a merge results in as many datafiles.
+func WithCompactionConcurrency(workers int) Config {
+	return func(o *Options) error {
+		if workers < 1 {
+			return errors.New("compaction concurrency must be at least 1")
+		}
+		o.compactConcurrency = workers
+		return nil
+	}
+}
+
+// WithCompactionRate limits the rate at which all the workers of a merge read the datafiles
+// to the given bytes per second, to limit the impact of the merge on the other reads.
+func WithCompactionRate(rate int) Config {
+	return func(o *Options) error {
+		if rate < 0 {
+			return errors.New("compaction rate cannot be negative")
+		}
+		o.compactRate = rate
+		return nil
+	}
+}
+
+// WithHotKeys tracks the reads and the writes of the most accessed keys, which are reported by HotKeys.
+// Only one of every sample accesses is tracked to bound the overhead, and at most capacity keys are tracked
+// to bound the memory, replacing the least accessed key with a new one. The counts are hence estimates.
+func WithHotKeys(sample, capacity int) Config {
+	return func(o *Options) error {
+		if sample < 1 {
+			return errors.New("hot keys sample must be at least 1")
+		}
+		if capacity < 1 {
+			return errors.New("hot keys capacity must be at least 1")
+		}
+		o.hotKeysSample = sample
+		o.hotKeysCapacity = capacity
+		return nil
+	}
+}
+
+// WithSortedKeys maintains the keys in lexicographic order in a btree alongside the keydir, which allows
+// RangeScan and makes each page of ListPage take time proportional to its size instead of the number of keys.
+// It costs the memory of the btree and a logarithmic update on adding and deleting each key.
+func WithSortedKeys() Config {
+	return func(o *Options) error {
+		o.sortedKeys = true
+		return nil
+	}
+}
+
+// WithCompactKeydir keeps only the hashes of the keys in the keydir along with their metadata, instead of the keys,
+// for the keyspaces whose keys don't fit in memory. A lookup whose hash matches a key reads the key from its record
+// to verify it, unless the bloom filter of the datafile of the record rules it out, so a hit costs a small read and
+// a miss rarely does. Iterating the keys, e.g. by Keys, Fold and the merges, reads each key from the disk.
+func WithCompactKeydir() Config {
+	return func(o *Options) error {
+		o.compactKeydir = true
+		return nil
+	}
+}
+
+// WithMaxIndexMemory bounds the memory of the keydir to the given number of bytes, by spilling the entries of the keys
+// whose latest record is in the oldest datafiles to an index file per datafile, sorted by the hashes of the keys, once
+// the keydir exceeds it. A lookup of a spilled key reads a block of the index file and the key from its record, while
+// a bloom filter per index file skips the reads for the other keys. The spilled keys which are read or written again
+// move back to memory. It's checked whenever a datafile is sealed, so the keydir exceeds it by the keys of the active
+// datafile at most, and by all the keys during a merge. It's ignored in the read-only and the in-memory modes.
+func WithMaxIndexMemory(size int) Config {
+	return func(o *Options) error {
+		if size <= 0 {
+			return errors.New("max index memory must be positive")
+		}
+		o.maxIndexMemory = size
+		return nil
+	}
+}
+
+// WithCompactOnStartup merges the datafiles in Init before it returns, if the stale data (overwritten,
+// deleted or expired records) is more than the given ratio of the size of the datafiles. It's useful after
+// restoring a backup or a bulk import which leaves many redundant records. A ratio of 0 merges any stale data.
+func WithCompactOnStartup(staleRatio float64) Config {
+	return func(o *Options) error {
+		if staleRatio < 0 || staleRatio >= 1 {
+			return errors.New("startup stale ratio must be at least 0 and below 1")
+		}
+		o.compactOnStartup = true
+		o.startupStaleRatio = staleRatio
+		return nil
+	}
+}
+
+// WithCompactionDeadRatio bases the compaction and the rotation on the dead records in each datafile, i.e. the
+// records which are overwritten or deleted since. The datafiles are merged once any of the older datafiles
+// has at least the given fraction of dead records, instead of once there are two older datafiles.
+// The active datafile is rotated at half the max size if it has as many dead records, so that they're merged sooner.
+func WithCompactionDeadRatio(ratio float64) Config {
+	return func(o *Options) error {
+		if ratio <= 0 || ratio > 1 {
+			return errors.New("compaction dead ratio must be between 0 and 1")
+		}
+		o.compactDeadRatio = ratio
+		return nil
+	}
+}
+
+// WithBackend stores the datafiles with the given backend instead of the files in the directory,
+// e.g. to keep them in memory or in an object store. The hints, the manifest and the lock are still
+// kept in the directory, and the backend is expected to keep the datafiles under the same names,
+// so that they're found on startup.
+func WithBackend(backend datafile.Backend) Config {
+	return func(o *Options) error {
+		if backend == nil {
+			return errors.New("backend cannot be nil")
+		}
+		o.backend = backend
+		return nil
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package datafile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate reserves the disk blocks for the given range without changing the file size.
+func fallocate(f *os.File, offset, size int64) error {
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, offset, size)
+}
+
+// deallocate releases the disk blocks for the given range without changing the file size.
+func deallocate(f *os.File, offset, size int64) error {
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, size)
+}
+
+// fadvise declares the access pattern for the entire file to the kernel.
+func fadvise(f *os.File, advice Advice) error {
+	var a int
+	switch advice {
+	case AdviceSequential:
+		a = unix.FADV_SEQUENTIAL
+	case AdviceDontNeed:
+		a = unix.FADV_DONTNEED
+	default:
+		return nil
+	}
+	return unix.Fadvise(int(f.Fd()), 0, 0, a)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package datafile
+
+import (
+	"os"
+)
+
+// fallocate is a no-op on platforms other than linux.
+func fallocate(f *os.File, offset, size int64) error {
+	return nil
+}
+
+// deallocate is a no-op on platforms other than linux.
+func deallocate(f *os.File, offset, size int64) error {
+	return nil
+}
+
+// fadvise is a no-op on platforms other than linux.
+func fadvise(f *os.File, advice Advice) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package datafile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// BITCASK_DATAFILE is the name of the datafiles written by the Erlang Bitcask.
+	BITCASK_DATAFILE = "%d.bitcask.data"
+
+	// VersionBitcask is the version of the datafiles written by Bitcask. They don't have a segment
+	// header and their records are in the Bitcask format, whose header is (big endian)
+	// crc (4) | timestamp (4) | key_size (2) | value_size (4).
+	VersionBitcask = -1
+)
+
+// OpenBitcask initialises a datafile written by Bitcask for reading. Like the sealed datafiles,
+// the file is opened lazily on the first read and its file descriptor is managed by the given pool.
+func OpenBitcask(dir string, index int, pool *Pool) (*DataFile, error) {
+	path := filepath.Join(dir, fmt.Sprintf(BITCASK_DATAFILE, index))
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching file stats: %v", err)
+	}
+
+	df := &DataFile{
+		id:      index,
+		path:    path,
+		offset:  int(stat.Size()),
+		pool:    pool,
+		version: VersionBitcask,
+	}
+
+	return df, nil
+}
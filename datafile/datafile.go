@@ -0,0 +1,640 @@
+package datafile
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	ACTIVE_DATAFILE = "barrel_%d.db"
+)
+
+var (
+	ErrSealed               = errors.New("datafile is sealed and cannot be written to")
+	ErrDirectIOUnsupported  = errors.New("direct I/O is not supported on this platform")
+	ErrFreeSpaceUnsupported = errors.New("checking free disk space is not supported on this platform")
+	ErrIOURingUnsupported   = errors.New("io_uring is not supported")
+)
+
+// Advice represents the expected access pattern of a datafile.
+type Advice int
+
+const (
+	// AdviceSequential hints that the datafile will be read sequentially.
+	AdviceSequential Advice = iota
+	// AdviceDontNeed hints that the cached pages of the datafile won't be accessed soon.
+	AdviceDontNeed
+)
+
+type DataFile struct {
+	sync.RWMutex
+
+	writer *os.File
+	reader *os.File
+	id     int
+	path   string
+
+	offset  int
+	version int // Version of the record format from the segment header, 0 if there's none.
+
+	// Sealed datafiles don't have a writer and their reader is managed by the pool.
+	pool *Pool
+	elem *list.Element // Position in the pool.
+	refs int           // Number of reads in progress.
+	mmap []byte        // Memory-mapped contents of the sealed datafile.
+	pins int           // Number of records of the mapping pinned by the readers.
+	held []byte        // Mapping of the closed datafile, which is unmapped once it's unpinned.
+
+	// Writes are appended to an in-memory buffer if a buffer size is set.
+	bufSize int
+	bufMu   sync.Mutex // Protects the buffers and the flushed offset.
+	flushMu sync.Mutex // Ensures the buffers are flushed in order.
+	buf     []byte     // Records which aren't written to the file yet.
+	spare   []byte     // Buffer reused after it's flushed.
+	flushed int        // Offset upto which the records are written to the file.
+
+	preallocated int64 // Size upto which the disk blocks are reserved for the file.
+
+	direct *directWriter // Writes bypass the page cache until the datafile is flushed, if set.
+}
+
+// New initialises a db store for storing/reading an active db file.
+// At a given time only one file can be active.
+func New(dir string, index int) (*DataFile, error) {
+	// If the file doesn't exist, create it, or append to the file.
+	path := filepath.Join(dir, fmt.Sprintf(ACTIVE_DATAFILE, index))
+	writer, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for writing db: %w", err)
+	}
+
+	// Create a reader for reading the db file.
+	reader, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for reading db: %w", err)
+	}
+
+	// Get the offset for the current file.
+	stat, err := writer.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching file stats: %v", err)
+	}
+
+	version, err := readSegmentHeader(reader, int(stat.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("error reading segment header: %w", err)
+	}
+
+	df := &DataFile{
+		writer:  writer,
+		reader:  reader,
+		id:      index,
+		path:    path,
+		offset:  int(stat.Size()),
+		version: version,
+	}
+
+	return df, nil
+}
+
+// Open initialises a sealed db file for reading. The file is opened lazily
+// on the first read and its file descriptor is managed by the given pool.
+func Open(dir string, index int, pool *Pool) (*DataFile, error) {
+	path := filepath.Join(dir, fmt.Sprintf(ACTIVE_DATAFILE, index))
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching file stats: %v", err)
+	}
+
+	// Read the segment header without keeping the file open.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for reading db: %w", err)
+	}
+	defer f.Close()
+
+	version, err := readSegmentHeader(f, int(stat.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("error reading segment header: %w", err)
+	}
+
+	df := &DataFile{
+		id:      index,
+		path:    path,
+		offset:  int(stat.Size()),
+		pool:    pool,
+		version: version,
+	}
+
+	return df, nil
+}
+
+// Empty returns a datafile without any records which isn't backed by a file, for the read-only mode
+// where a new datafile can't be created in the directory. It can't be written to.
+func Empty(dir string, index int) *DataFile {
+	return &DataFile{
+		id:   index,
+		path: filepath.Join(dir, fmt.Sprintf(ACTIVE_DATAFILE, index)),
+	}
+}
+
+// Preallocate reserves the disk blocks for the file upto the given size, which reduces
+// fragmentation and filesystem metadata updates as the file grows. The size of the file is unchanged.
+func (d *DataFile) Preallocate(size int64) error {
+	if d.writer == nil || size <= int64(d.offset) {
+		return nil
+	}
+
+	if err := fallocate(d.writer, 0, size); err != nil {
+		return fmt.Errorf("error preallocating file: %w", err)
+	}
+	d.preallocated = size
+
+	return nil
+}
+
+// releasePreallocated releases the disk blocks reserved beyond the end of the file.
+func (d *DataFile) releasePreallocated() error {
+	if d.preallocated <= int64(d.offset) {
+		return nil
+	}
+
+	if err := deallocate(d.writer, int64(d.offset), d.preallocated-int64(d.offset)); err != nil {
+		return fmt.Errorf("error releasing preallocated space: %w", err)
+	}
+	d.preallocated = 0
+
+	return nil
+}
+
+// Advise declares the expected access pattern of the file to the kernel.
+func (d *DataFile) Advise(advice Advice) error {
+	f := d.writer
+	if f == nil {
+		if d.pool == nil || d.pool.mmap {
+			return nil
+		}
+
+		reader, err := d.pool.acquire(d)
+		if err != nil {
+			return err
+		}
+		defer d.pool.release(d)
+		f = reader
+	}
+
+	return fadvise(f, advice)
+}
+
+// SetWriteBuffer enables buffering of writes in memory upto the given size in bytes.
+// The buffer is flushed to the file when it's full, when Flush or Sync is called
+// or when a record which isn't flushed yet is read.
+func (d *DataFile) SetWriteBuffer(size int) {
+	d.bufMu.Lock()
+	defer d.bufMu.Unlock()
+
+	d.bufSize = size
+	d.flushed = d.offset
+}
+
+// EnableDirectIO makes the writes bypass the page cache until the datafile is flushed.
+// It can only be enabled for an empty datafile.
+func (d *DataFile) EnableDirectIO() error {
+	if d.offset != 0 {
+		return fmt.Errorf("direct I/O can only be enabled for an empty datafile")
+	}
+
+	direct, err := newDirectWriter(d.path)
+	if err != nil {
+		return fmt.Errorf("error opening file for direct I/O: %w", err)
+	}
+	d.direct = direct
+
+	return nil
+}
+
+// Flush writes the buffered records to the file.
+// If direct I/O is enabled, the pending writes are completed and direct I/O is disabled.
+func (d *DataFile) Flush() error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	if d.direct != nil {
+		err := d.direct.finish(d.writer)
+		d.direct = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	// Swap the buffer so that the writes can continue while the records are being flushed.
+	d.bufMu.Lock()
+	data := d.buf
+	d.buf, d.spare = d.spare, nil
+	d.bufMu.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if _, err := writeFile(d.writer, data); err != nil {
+		// Discard the partially written records and put them back in the buffer,
+		// so that they're written again by the next flush.
+		d.writer.Truncate(int64(d.flushed))
+		d.bufMu.Lock()
+		d.buf = append(data, d.buf...)
+		d.bufMu.Unlock()
+		return err
+	}
+
+	d.bufMu.Lock()
+	d.flushed += len(data)
+	d.spare = data[:0]
+	d.bufMu.Unlock()
+
+	return nil
+}
+
+// Seal closes the writer of the datafile so that it can't be written to anymore.
+// The reader of the datafile is handed over to the given pool.
+func (d *DataFile) Seal(pool *Pool) error {
+	if d.writer == nil {
+		return nil
+	}
+
+	if err := d.Flush(); err != nil {
+		return err
+	}
+
+	if err := d.releasePreallocated(); err != nil {
+		return err
+	}
+
+	d.Lock()
+	err := d.writer.Close()
+	d.writer = nil
+	d.Unlock()
+	if err != nil {
+		return err
+	}
+	d.pool = pool
+
+	// The reader isn't required since the datafile is mapped on the first read.
+	if pool.mmap {
+		err := d.reader.Close()
+		d.reader = nil
+		return err
+	}
+	pool.add(d)
+
+	return nil
+}
+
+// mapped returns the memory-mapped contents of the datafile, mapping it if required.
+func (d *DataFile) mapped() ([]byte, error) {
+	d.RLock()
+	data := d.mmap
+	d.RUnlock()
+	if data != nil || d.offset == 0 {
+		return data, nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.mmap != nil {
+		return d.mmap, nil
+	}
+
+	file, err := os.Open(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for reading db: %w", err)
+	}
+	defer file.Close()
+
+	d.mmap, err = mmap(file, d.offset)
+	if err != nil {
+		return nil, fmt.Errorf("error memory-mapping db file: %w", err)
+	}
+
+	return d.mmap, nil
+}
+
+// ID returns the ID of the datafile.
+func (d *DataFile) ID() int {
+	return d.id
+}
+
+// Path returns the path of the file.
+func (d *DataFile) Path() string {
+	return d.path
+}
+
+// Move moves the file of the datafile to the given directory, keeping its name.
+func (d *DataFile) Move(dir string) error {
+	path := filepath.Join(dir, filepath.Base(d.path))
+	if err := os.Rename(d.path, path); err != nil {
+		return fmt.Errorf("error moving db file: %w", err)
+	}
+	d.path = path
+
+	return nil
+}
+
+// SyncDir calls fsync(2) on the directory, so that the files created, renamed or removed in it
+// survive a power loss along with their contents.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := syncDirFile(d); err != nil {
+		return fmt.Errorf("error syncing directory: %w", err)
+	}
+	return nil
+}
+
+// Size returns the size of DB file in bytes.
+func (d *DataFile) Size() (int64, error) {
+	// Sealed datafiles don't change anymore and buffered records aren't present in the file yet.
+	if d.writer == nil || d.bufSize > 0 || d.direct != nil {
+		return int64(d.offset), nil
+	}
+
+	// Use stat to get file syze in bytes.
+	stat, err := d.writer.Stat()
+	if err != nil {
+		return -1, fmt.Errorf("error fetching file stats: %v", err)
+	}
+
+	return stat.Size(), nil
+}
+
+// Sync flushes the in-memory buffers to the disk.
+func (d *DataFile) Sync() error {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.writer == nil {
+		return nil
+	}
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	return syncFile(d.writer)
+}
+
+// Read reads the record of the given size ending at the given position.
+func (d *DataFile) Read(pos int, size int) ([]byte, error) {
+	return d.ReadInto(make([]byte, size), pos)
+}
+
+// ReadInto reads the record ending at the given position into the buffer, whose length is the size
+// of the record. The records of the memory-mapped datafiles are copied from the mapping, since it's
+// unmapped once the datafile is closed.
+func (d *DataFile) ReadInto(buf []byte, pos int) ([]byte, error) {
+	size := len(buf)
+	if d.memoryMapped() {
+		data, err := d.readMapped(pos, size)
+		if err != nil {
+			return nil, err
+		}
+		copy(buf, data)
+		return buf, nil
+	}
+
+	reader, err := d.acquireReader(pos)
+	if err != nil {
+		return nil, err
+	}
+	defer d.releaseReader()
+
+	// Read the file with the given offset.
+	n, err := reader.ReadAt(buf, int64(pos-size))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the size of bytes read matches the record size.
+	if n != int(size) {
+		return nil, fmt.Errorf("error fetching record, invalid size")
+	}
+
+	return buf, nil
+}
+
+// memoryMapped returns true if the records are read from the mapping of the datafile instead of its file.
+func (d *DataFile) memoryMapped() bool {
+	return d.pool != nil && d.pool.mmap
+}
+
+// acquireReader returns the file which the record ending at the given position is read from, once the
+// buffered writes upto it are written to the file. The file isn't closed by the pool till it's released.
+func (d *DataFile) acquireReader(pos int) (*os.File, error) {
+	if err := readFault(); err != nil {
+		return nil, err
+	}
+
+	// Complete the pending direct I/O writes. Concurrent reads may complete them at the same time.
+	d.flushMu.Lock()
+	direct := d.direct != nil
+	d.flushMu.Unlock()
+	if direct {
+		if err := d.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Flush the buffer if the record isn't written to the file yet.
+	if d.bufSize > 0 {
+		d.bufMu.Lock()
+		flushed := d.flushed
+		d.bufMu.Unlock()
+		if pos > flushed {
+			if err := d.Flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Open the reader of sealed datafiles if required.
+	if d.pool != nil {
+		return d.pool.acquire(d)
+	}
+	return d.reader, nil
+}
+
+// releaseReader releases the file returned by acquireReader.
+func (d *DataFile) releaseReader() {
+	if d.pool != nil {
+		d.pool.release(d)
+	}
+}
+
+// readMapped returns the record of the given size ending at the given position from the mapping of the datafile.
+func (d *DataFile) readMapped(pos int, size int) ([]byte, error) {
+	if err := readFault(); err != nil {
+		return nil, err
+	}
+
+	data, err := d.mapped()
+	if err != nil {
+		return nil, err
+	}
+	start := pos - size
+	if start < 0 || start+size > len(data) {
+		return nil, fmt.Errorf("error fetching record, invalid size")
+	}
+	return data[start : start+size : start+size], nil
+}
+
+// Pin returns the record of the given size ending at the given position from the mapping of the
+// datafile without copying it. The mapping isn't unmapped by Close till the record is unpinned.
+// It returns false if the datafile isn't memory-mapped, in which case the record isn't pinned.
+func (d *DataFile) Pin(pos int, size int) ([]byte, bool, error) {
+	if d.pool == nil || !d.pool.mmap {
+		return nil, false, nil
+	}
+	if _, err := d.mapped(); err != nil {
+		return nil, false, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	// Closed datafiles are read by copying the record instead.
+	if d.mmap == nil {
+		return nil, false, nil
+	}
+	start := pos - size
+	if start < 0 || pos > len(d.mmap) {
+		return nil, false, fmt.Errorf("error fetching record, invalid size")
+	}
+	d.pins++
+	return d.mmap[start:pos:pos], true, nil
+}
+
+// Unpin releases a record returned by Pin, unmapping the datafile if it's closed and this was
+// the last pinned record.
+func (d *DataFile) Unpin() error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.pins--
+	if d.pins > 0 || d.held == nil {
+		return nil
+	}
+	held := d.held
+	d.held = nil
+	return munmap(held)
+}
+
+// Write writes the record to the underlying db file.
+func (d *DataFile) Write(data []byte) (int, error) {
+	if d.writer == nil {
+		return -1, ErrSealed
+	}
+
+	if d.bufSize > 0 {
+		return d.writeBuffered(data)
+	}
+
+	if d.direct != nil {
+		if err := d.direct.write(data); err != nil {
+			return -1, err
+		}
+
+		offset := d.offset
+		d.offset += len(data)
+		return offset, nil
+	}
+
+	if _, err := writeFile(d.writer, data); err != nil {
+		// Discard the partially written record, so that the next record is written at the right offset.
+		d.writer.Truncate(int64(d.offset))
+		return -1, err
+	}
+
+	// Store the current size of the file.
+	offset := d.offset
+
+	// Increase the offset of the current active file.
+	d.offset += len(data)
+
+	return offset, nil
+}
+
+// writeBuffered appends the record to the buffer. If the buffer is full,
+// it's flushed first which blocks the writer until the records are written to the file.
+func (d *DataFile) writeBuffered(data []byte) (int, error) {
+	d.bufMu.Lock()
+	full := len(d.buf) > 0 && len(d.buf)+len(data) > d.bufSize
+	d.bufMu.Unlock()
+
+	if full {
+		if err := d.Flush(); err != nil {
+			return -1, err
+		}
+	}
+
+	d.bufMu.Lock()
+	defer d.bufMu.Unlock()
+
+	d.buf = append(d.buf, data...)
+
+	// Store the current size of the file.
+	offset := d.offset
+
+	// Increase the offset of the current active file.
+	d.offset += len(data)
+
+	return offset, nil
+}
+
+// Close closes the file descriptors of the underlying db file.
+func (d *DataFile) Close() error {
+	d.Lock()
+	switch {
+	case d.mmap != nil && d.pins > 0:
+		// The pinned records are still read, so the mapping is unmapped once they're unpinned.
+		d.held = d.mmap
+		d.mmap = nil
+	case d.mmap != nil:
+		if err := munmap(d.mmap); err != nil {
+			d.Unlock()
+			return err
+		}
+		d.mmap = nil
+	}
+	d.Unlock()
+
+	if d.pool != nil {
+		return d.pool.remove(d)
+	}
+
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	if err := d.releasePreallocated(); err != nil {
+		return err
+	}
+	// Empty datafiles aren't backed by a file.
+	if d.writer == nil {
+		return nil
+	}
+	if err := d.writer.Close(); err != nil {
+		return err
+	}
+
+	if err := d.reader.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package datafile
+
+import (
+	"os"
+	"unsafe"
+)
+
+const (
+	// directAlign is the alignment required for the buffers, offsets and sizes of direct I/O writes.
+	directAlign = 4096
+	// directBufferSize is the size of the buffer used for batching direct I/O writes.
+	directBufferSize = 1 << 20
+)
+
+// directWriter writes to a file bypassing the page cache. Since direct I/O requires
+// aligned writes, the records are collected in an aligned buffer which is written
+// once it's full. The unaligned tail is written through a regular file descriptor when it's finished.
+type directWriter struct {
+	f   *os.File
+	buf []byte
+	n   int
+}
+
+// newDirectWriter returns a writer for the file at the given path, opened for direct I/O.
+func newDirectWriter(path string) (*directWriter, error) {
+	f, err := openDirect(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &directWriter{
+		f:   f,
+		buf: alignedBuffer(directBufferSize),
+	}, nil
+}
+
+// write appends the data to the buffer and writes the buffer to the file whenever it's full.
+func (w *directWriter) write(data []byte) error {
+	for len(data) > 0 {
+		n := copy(w.buf[w.n:], data)
+		w.n += n
+		data = data[n:]
+
+		if w.n == len(w.buf) {
+			if _, err := w.f.Write(w.buf); err != nil {
+				return err
+			}
+			w.n = 0
+		}
+	}
+
+	return nil
+}
+
+// finish writes the aligned part of the buffer using direct I/O and the remaining tail
+// through the given regular writer, and closes the direct I/O file descriptor.
+func (w *directWriter) finish(tail *os.File) error {
+	full := w.n &^ (directAlign - 1)
+	if full > 0 {
+		if _, err := w.f.Write(w.buf[:full]); err != nil {
+			return err
+		}
+	}
+	if w.n > full {
+		if _, err := tail.Write(w.buf[full:w.n]); err != nil {
+			return err
+		}
+	}
+	w.n = 0
+
+	return w.f.Close()
+}
+
+// alignedBuffer returns a buffer of the given size whose address is aligned for direct I/O.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directAlign - 1)); rem != 0 {
+		off = directAlign - rem
+	}
+	return buf[off : off+size : off+size]
+}
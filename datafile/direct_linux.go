@@ -0,0 +1,14 @@
+//go:build linux
+
+package datafile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDirect opens the file for writing with direct I/O.
+func openDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|unix.O_DIRECT, 0644)
+}
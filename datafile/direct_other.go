@@ -0,0 +1,12 @@
+//go:build !linux
+
+package datafile
+
+import (
+	"os"
+)
+
+// openDirect returns an error since direct I/O is only supported on linux.
+func openDirect(path string) (*os.File, error) {
+	return nil, ErrDirectIOUnsupported
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package datafile
+
+// FreeSpace isn't supported on platforms other than unix.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
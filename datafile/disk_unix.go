@@ -0,0 +1,17 @@
+//go:build unix
+
+package datafile
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// FreeSpace returns the disk space (in bytes) available to unprivileged users
+// on the filesystem of the given directory.
+func FreeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
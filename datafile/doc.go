@@ -0,0 +1,11 @@
+// Package datafile reads and writes the datafiles of barrel, also called segments, to which the records
+// are appended. It's public so that external tools, e.g. exporters, analyzers or replication receivers,
+// can read the segments without copying the code, and so that alternative backends can implement Storage.
+//
+// A datafile starts with a segment header (magic "BRLSEG" | version (2, little endian)) followed by the
+// records, except the datafiles written before the segment headers and those written by Bitcask. This
+// package treats the records as opaque bytes read by their position. They're decoded by barrel.ScanSegment.
+//
+// The exported API is kept backward compatible. The fault injection is only built with the `faultinject`
+// build tag for the tests.
+package datafile
@@ -0,0 +1,108 @@
+//go:build faultinject
+
+package datafile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Fault is a failure injected into the I/O of the datafiles, to test the recovery of the records.
+// Faults are only available in the builds with the faultinject tag.
+type Fault int
+
+const (
+	// FaultShortWrite writes only the first half of the record and fails the write.
+	FaultShortWrite Fault = iota
+	// FaultCorruptWrite flips the last byte of the record, the write itself succeeds.
+	FaultCorruptWrite
+	// FaultSync fails the fsync of the datafile.
+	FaultSync
+	// FaultRead fails the read of a record.
+	FaultRead
+	// FaultSyncDir fails the fsync of the directory of the datafiles.
+	FaultSyncDir
+)
+
+// ErrInjected is returned by the operations failed by an injected fault.
+var ErrInjected = errors.New("injected fault")
+
+var faults struct {
+	sync.Mutex
+	pending map[Fault]int
+}
+
+// InjectFault makes the next given number of operations of all the datafiles fail with the fault.
+// The faults are global to the process, so the tests injecting them shouldn't run in parallel.
+func InjectFault(fault Fault, count int) {
+	faults.Lock()
+	defer faults.Unlock()
+
+	if faults.pending == nil {
+		faults.pending = make(map[Fault]int)
+	}
+	faults.pending[fault] += count
+}
+
+// ResetFaults clears the faults which haven't been triggered yet.
+func ResetFaults() {
+	faults.Lock()
+	faults.pending = nil
+	faults.Unlock()
+}
+
+// trigger returns true if the fault is pending and consumes it.
+func trigger(fault Fault) bool {
+	faults.Lock()
+	defer faults.Unlock()
+
+	if faults.pending[fault] == 0 {
+		return false
+	}
+	faults.pending[fault]--
+	return true
+}
+
+// writeFile writes the data to the file unless a write fault is pending.
+func writeFile(f *os.File, data []byte) (int, error) {
+	switch {
+	case trigger(FaultShortWrite):
+		n, err := f.Write(data[:len(data)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, fmt.Errorf("%w: %v", ErrInjected, io.ErrShortWrite)
+	case len(data) > 0 && trigger(FaultCorruptWrite):
+		corrupt := append([]byte(nil), data...)
+		corrupt[len(corrupt)-1] ^= 0xff
+		return f.Write(corrupt)
+	}
+	return f.Write(data)
+}
+
+// syncFile commits the file to the disk unless a sync fault is pending.
+func syncFile(f *os.File) error {
+	if trigger(FaultSync) {
+		return fmt.Errorf("%w: fsync failed", ErrInjected)
+	}
+	return f.Sync()
+}
+
+// syncDirFile commits the entries of the directory to the disk unless a directory sync fault is pending.
+func syncDirFile(d *os.File) error {
+	if trigger(FaultSyncDir) {
+		return fmt.Errorf("%w: directory fsync failed", ErrInjected)
+	}
+	return d.Sync()
+}
+
+// readFault returns an error if a read fault is pending.
+func readFault() error {
+	if trigger(FaultRead) {
+		return fmt.Errorf("%w: read failed", ErrInjected)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !faultinject
+
+package datafile
+
+import (
+	"os"
+)
+
+// writeFile writes the data to the file. Faults are only injected with the faultinject tag.
+func writeFile(f *os.File, data []byte) (int, error) {
+	return f.Write(data)
+}
+
+// syncFile commits the file to the disk.
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
+
+// syncDirFile commits the entries of the directory to the disk.
+func syncDirFile(d *os.File) error {
+	return d.Sync()
+}
+
+// readFault never fails without the faultinject tag.
+func readFault() error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package datafile
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the first size bytes of the file into memory on platforms other than unix, which
+// stands in for the mapping since the sealed datafiles being mapped aren't written anymore.
+func mmap(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, int64(size)), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// munmap is a no-op on platforms other than unix, since the contents read by mmap are released
+// by the garbage collector.
+func munmap(data []byte) error {
+	return nil
+}
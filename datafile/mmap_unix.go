@@ -0,0 +1,19 @@
+//go:build unix
+
+package datafile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmap maps the first size bytes of the file into memory as read-only.
+func mmap(f *os.File, size int) ([]byte, error) {
+	return unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+}
+
+// munmap unmaps the memory mapped by mmap.
+func munmap(data []byte) error {
+	return unix.Munmap(data)
+}
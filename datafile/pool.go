@@ -0,0 +1,112 @@
+package datafile
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Pool limits the number of sealed datafiles which have an open file descriptor.
+// The file descriptors are opened on the first read and the least recently used
+// ones are closed when the limit is exceeded. Datafiles being read at the moment
+// are never closed, so the limit may be exceeded temporarily.
+// If mmap is enabled, the datafiles are memory-mapped on the first read instead
+// and their file descriptors are closed right after mapping.
+type Pool struct {
+	sync.Mutex
+
+	max  int
+	mmap bool
+	lru  *list.List // Datafiles with an open file descriptor, most recently used first.
+}
+
+// NewPool returns a pool which keeps at most max file descriptors open.
+func NewPool(max int, mmap bool) *Pool {
+	return &Pool{
+		max:  max,
+		mmap: mmap,
+		lru:  list.New(),
+	}
+}
+
+// Len returns the number of open file descriptors in the pool.
+func (p *Pool) Len() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.lru.Len()
+}
+
+// acquire returns the reader of the datafile, opening it if required.
+// The datafile isn't closed by the pool until it's released.
+func (p *Pool) acquire(d *DataFile) (*os.File, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if d.reader == nil {
+		reader, err := os.Open(d.path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file for reading db: %w", err)
+		}
+		d.reader = reader
+		d.elem = p.lru.PushFront(d)
+		p.evict()
+	} else {
+		p.lru.MoveToFront(d.elem)
+	}
+
+	d.refs++
+
+	return d.reader, nil
+}
+
+// release marks the datafile as no longer being read.
+func (p *Pool) release(d *DataFile) {
+	p.Lock()
+	defer p.Unlock()
+
+	d.refs--
+}
+
+// add adds a datafile which already has an open reader to the pool.
+func (p *Pool) add(d *DataFile) {
+	p.Lock()
+	defer p.Unlock()
+
+	d.elem = p.lru.PushFront(d)
+	p.evict()
+}
+
+// remove closes the reader of the datafile and removes it from the pool.
+func (p *Pool) remove(d *DataFile) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if d.reader == nil {
+		return nil
+	}
+
+	p.lru.Remove(d.elem)
+	reader := d.reader
+	d.reader, d.elem = nil, nil
+
+	return reader.Close()
+}
+
+// evict closes the least recently used readers until the pool is within its limit.
+// Caller of this function should ensure to lock/unlock the pool.
+func (p *Pool) evict() {
+	for e := p.lru.Back(); e != nil && p.lru.Len() > p.max; {
+		var (
+			d    = e.Value.(*DataFile)
+			prev = e.Prev()
+		)
+		if d.refs == 0 {
+			p.lru.Remove(e)
+			d.reader.Close()
+			d.reader, d.elem = nil, nil
+		}
+		e = prev
+	}
+}
@@ -0,0 +1,73 @@
+package datafile
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+const (
+	// SegmentMagic is present at the start of the datafiles having a segment header.
+	SegmentMagic = "BRLSEG"
+	// SegmentHeaderSize is the size of the segment header: magic (6) | version (2, little endian).
+	SegmentHeaderSize = len(SegmentMagic) + 2
+)
+
+// Version returns the version of the format of the records in the datafile, which is stored
+// in its segment header. Datafiles written before segment headers were introduced have the version 1.
+func (d *DataFile) Version() int {
+	if d.version == 0 {
+		return 1
+	}
+	return d.version
+}
+
+// HeaderSize returns the size of the segment header, which is 0 for datafiles without one.
+// The first record of the datafile starts at this offset.
+func (d *DataFile) HeaderSize() int {
+	if d.version == 0 || d.version == VersionBitcask {
+		return 0
+	}
+	return SegmentHeaderSize
+}
+
+// WriteSegmentHeader writes the segment header with the given version at the start of an empty datafile.
+// It's a no-op if the datafile isn't empty.
+func (d *DataFile) WriteSegmentHeader(version int) error {
+	if d.offset != 0 {
+		return nil
+	}
+
+	header := make([]byte, SegmentHeaderSize)
+	copy(header, SegmentMagic)
+	binary.LittleEndian.PutUint16(header[len(SegmentMagic):], uint16(version))
+	if _, err := d.Write(header); err != nil {
+		return err
+	}
+	d.version = version
+
+	return nil
+}
+
+// readSegmentHeader returns the version stored in the segment header of the file,
+// or 0 if the file doesn't have a segment header.
+func readSegmentHeader(f *os.File, size int) (int, error) {
+	if size < SegmentHeaderSize {
+		return 0, nil
+	}
+
+	header := make([]byte, SegmentHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:len(SegmentMagic)]) != SegmentMagic {
+		return 0, nil
+	}
+
+	version := int(binary.LittleEndian.Uint16(header[len(SegmentMagic):]))
+	if version < 2 {
+		return 0, errors.New("invalid version in segment header")
+	}
+
+	return version, nil
+}
@@ -0,0 +1,84 @@
+package datafile
+
+// Storage is a datafile to which the records are appended and from which they're read by their
+// position. The datafiles are kept in files by default, but alternative backends, e.g. keeping them
+// in memory or in an object store, implement it to be used in place of the files.
+type Storage interface {
+	// ID returns the ID of the datafile, which orders the datafiles by the time they're created.
+	ID() int
+	// Path returns the path of the file of the datafile, which names it in the hints and the logs.
+	Path() string
+	// Version returns the version of the record format, 0 if there's no segment header.
+	Version() int
+	// HeaderSize returns the size of the segment header, after which the records start.
+	HeaderSize() int
+	// WriteSegmentHeader writes the segment header with the given version to the new datafile.
+	WriteSegmentHeader(version int) error
+
+	// Read reads the record of the given size ending at the given position.
+	Read(pos int, size int) ([]byte, error)
+	// ReadInto is same as Read but reads the record into the buffer, whose length is the size of the
+	// record, instead of allocating one. The record returned is the buffer unless it's read from memory.
+	ReadInto(buf []byte, pos int) ([]byte, error)
+	// Pin returns the record without copying it if the datafile is memory-mapped, keeping the memory
+	// valid till Unpin is called. It returns false if it isn't, in which case the record isn't pinned.
+	Pin(pos int, size int) ([]byte, bool, error)
+	// Unpin releases a record returned by Pin.
+	Unpin() error
+	// Write appends the record and returns the offset at which it's written.
+	Write(data []byte) (int, error)
+	// Size returns the size of the datafile in bytes.
+	Size() (int64, error)
+	// Flush writes the buffered records, so that they're read from the datafile.
+	Flush() error
+	// Sync flushes the buffered records and persists them.
+	Sync() error
+	// Seal makes the datafile read-only once it's rotated, with its reader managed by the pool.
+	Seal(pool *Pool) error
+	// Move moves the datafile to the given directory, keeping its name.
+	Move(dir string) error
+	// Close closes the datafile.
+	Close() error
+
+	// Preallocate reserves the space for the datafile upto the given size.
+	Preallocate(size int64) error
+	// Advise hints the expected access pattern of the datafile.
+	Advise(advice Advice) error
+	// SetWriteBuffer sets the size of the buffer of the writes, which are unbuffered if it's 0.
+	SetWriteBuffer(size int)
+	// EnableDirectIO makes the writes bypass the page cache until the datafile is flushed.
+	EnableDirectIO() error
+}
+
+// Backend creates and opens the datafiles in a directory.
+type Backend interface {
+	// Create creates the datafile with the given ID for writing.
+	Create(dir string, index int) (Storage, error)
+	// Open opens the sealed datafile with the given ID for reading.
+	Open(dir string, index int, pool *Pool) (Storage, error)
+}
+
+// Files is the default backend, which keeps the datafiles in files.
+var Files Backend = fileBackend{}
+
+var _ Storage = (*DataFile)(nil)
+
+// fileBackend keeps the datafiles in files. It returns the datafiles only if there's no error,
+// so that a nil datafile isn't returned as a non-nil Storage.
+type fileBackend struct{}
+
+func (fileBackend) Create(dir string, index int) (Storage, error) {
+	df, err := New(dir, index)
+	if err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+func (fileBackend) Open(dir string, index int, pool *Pool) (Storage, error) {
+	df, err := Open(dir, index, pool)
+	if err != nil {
+		return nil, err
+	}
+	return df, nil
+}
@@ -0,0 +1,11 @@
+package datafile
+
+// BatchRead is a read of a record by Ring.ReadBatch.
+type BatchRead struct {
+	DF  Storage // Datafile to read the record from.
+	Buf []byte  // Buffer to read the record into, whose length is the size of the record.
+	Pos int     // Position of the end of the record.
+
+	Data []byte // Record read, which is the buffer unless it's read from memory, as by ReadInto.
+	Err  error  // Error reading the record.
+}
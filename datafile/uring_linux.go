@@ -0,0 +1,304 @@
+//go:build linux
+
+package datafile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of the mappings of the rings and the submission queue entries, and the flags of io_uring.
+const (
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringFeatSingleMmap = 1 << 0
+	uringEnterGetEvents = 1 << 0
+	uringOpReadv        = 1
+)
+
+// uringParams is the struct io_uring_params passed to io_uring_setup(2).
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+// uringSQOffsets is the struct io_sqring_offsets, the offsets of the fields in the submission ring.
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringCQOffsets is the struct io_cqring_offsets, the offsets of the fields in the completion ring.
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringSQE is the struct io_uring_sqe, an entry of the submission queue.
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+// uringCQE is the struct io_uring_cqe, an entry of the completion queue.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// Ring is an io_uring instance, which submits the reads of a batch to the kernel at once instead of
+// a read syscall per record, so that they're executed concurrently without a goroutine per read.
+// The batches are read one at a time.
+type Ring struct {
+	sync.Mutex
+
+	fd      int
+	entries int
+	sq, cq  []byte // Mappings of the submission and the completion rings, which are the same with a single mapping.
+	sqes    []byte // Mapping of the submission queue entries.
+
+	sqTail  *uint32
+	sqMask  uint32
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqesOff uint32 // Offset of the completion queue entries in the completion ring.
+}
+
+// NewRing sets up an io_uring with the given number of entries, which is the max number of reads in flight.
+// It returns ErrIOURingUnsupported if io_uring isn't available, e.g. on the older kernels or if it's disabled.
+func NewRing(entries int) (*Ring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("%w: %v", ErrIOURingUnsupported, errno)
+	}
+
+	r := &Ring{fd: int(fd), entries: int(p.sqEntries)}
+	if err := r.mmap(&p); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// mmap maps the rings and the submission queue entries of the io_uring.
+func (r *Ring) mmap(p *uringParams) error {
+	var (
+		sqSize   = int(p.sqOff.array + p.sqEntries*4)
+		cqSize   = int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+		sqesSize = int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+		single   = p.features&uringFeatSingleMmap != 0
+		prot     = unix.PROT_READ | unix.PROT_WRITE
+		flags    = unix.MAP_SHARED | unix.MAP_POPULATE
+		err      error
+	)
+	if single && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	if r.sq, err = unix.Mmap(r.fd, uringOffSQRing, sqSize, prot, flags); err != nil {
+		return fmt.Errorf("error mapping submission ring: %w", err)
+	}
+	r.cq = r.sq
+	if !single {
+		if r.cq, err = unix.Mmap(r.fd, uringOffCQRing, cqSize, prot, flags); err != nil {
+			r.cq = nil
+			return fmt.Errorf("error mapping completion ring: %w", err)
+		}
+	}
+	if r.sqes, err = unix.Mmap(r.fd, uringOffSQEs, sqesSize, prot, flags); err != nil {
+		return fmt.Errorf("error mapping submission queue entries: %w", err)
+	}
+
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sq[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sq[p.sqOff.ringMask]))
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cq[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cq[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cq[p.cqOff.ringMask]))
+	r.cqesOff = p.cqOff.cqes
+
+	// Each slot of the submission ring always refers to the entry at the same index.
+	for i := uint32(0); i < p.sqEntries; i++ {
+		*(*uint32)(unsafe.Pointer(&r.sq[p.sqOff.array+i*4])) = i
+	}
+
+	return nil
+}
+
+// Close unmaps the rings and closes the io_uring.
+func (r *Ring) Close() error {
+	if r.sqes != nil {
+		unix.Munmap(r.sqes)
+	}
+	if r.cq != nil && len(r.sq) > 0 && &r.cq[0] != &r.sq[0] {
+		unix.Munmap(r.cq)
+	}
+	if r.sq != nil {
+		unix.Munmap(r.sq)
+	}
+	return unix.Close(r.fd)
+}
+
+// uringRead is a read of a file submitted to the ring.
+type uringRead struct {
+	read *BatchRead
+	file *os.File
+	iov  unix.Iovec // Buffer of the read, which is read into by a vectored read.
+	off  int64      // Offset in the file at which the record starts.
+}
+
+// ReadBatch reads the records into their buffers. The reads of the files are submitted to the ring
+// upto its number of entries at a time, while the other reads, e.g. of the memory-mapped datafiles or
+// of the other backends, are read with ReadInto.
+func (r *Ring) ReadBatch(reads []BatchRead) {
+	var (
+		pending  = make([]uringRead, 0, len(reads))
+		acquired = make([]*DataFile, 0, len(reads)) // Datafiles whose files are kept open till the reads are over.
+	)
+	defer func() {
+		for _, df := range acquired {
+			df.releaseReader()
+		}
+	}()
+
+	for i := range reads {
+		read := &reads[i]
+		df, ok := read.DF.(*DataFile)
+		if !ok || df.memoryMapped() || len(read.Buf) == 0 {
+			read.Data, read.Err = read.DF.ReadInto(read.Buf, read.Pos)
+			continue
+		}
+
+		file, err := df.acquireReader(read.Pos)
+		if err != nil {
+			read.Err = err
+			continue
+		}
+		acquired = append(acquired, df)
+
+		u := uringRead{read: read, file: file, off: int64(read.Pos - len(read.Buf))}
+		u.iov.Base = &read.Buf[0]
+		u.iov.SetLen(len(read.Buf))
+		read.Data, read.Err = read.Buf, nil
+		pending = append(pending, u)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > r.entries {
+			n = r.entries
+		}
+		r.submit(pending[:n])
+		pending = pending[n:]
+	}
+}
+
+// submit submits the reads, which are at most the number of entries of the ring, and waits for them
+// to complete. The reads which can't be submitted are read with a pread instead.
+// Caller of this function should ensure to lock/unlock the ring.
+func (r *Ring) submit(reads []uringRead) {
+	tail := atomic.LoadUint32(r.sqTail)
+	for i := range reads {
+		sqe := (*uringSQE)(unsafe.Pointer(&r.sqes[(tail&r.sqMask)*uint32(unsafe.Sizeof(uringSQE{}))]))
+		*sqe = uringSQE{
+			opcode:   uringOpReadv,
+			fd:       int32(reads[i].file.Fd()),
+			off:      uint64(reads[i].off),
+			addr:     uint64(uintptr(unsafe.Pointer(&reads[i].iov))),
+			len:      1,
+			userData: uint64(i),
+		}
+		tail++
+	}
+	atomic.StoreUint32(r.sqTail, tail)
+
+	var submitted, completed int
+	for {
+		// Reap the completed reads before waiting for the rest.
+		head := atomic.LoadUint32(r.cqHead)
+		for ; head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := (*uringCQE)(unsafe.Pointer(&r.cq[r.cqesOff+(head&r.cqMask)*uint32(unsafe.Sizeof(uringCQE{}))]))
+			reads[cqe.userData].complete(cqe.res)
+			completed++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+		if completed == len(reads) {
+			break
+		}
+
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(len(reads)-submitted),
+			uintptr(len(reads)-completed), uringEnterGetEvents, 0, 0)
+		switch errno {
+		case 0:
+			submitted += int(n)
+		case unix.EINTR, unix.EAGAIN, unix.EBUSY:
+		default:
+			// The kernel consumes the entries only while entering, so the ones which aren't submitted are
+			// taken back from the ring and read with a pread, while the submitted ones are waited for.
+			unsubmitted := len(reads) - submitted
+			atomic.StoreUint32(r.sqTail, tail-uint32(unsubmitted))
+			for i := submitted; i < len(reads); i++ {
+				reads[i].complete(0)
+			}
+			completed += unsubmitted
+			submitted = len(reads)
+		}
+	}
+
+	// The buffers and the vectors are used by the kernel till the reads complete.
+	runtime.KeepAlive(reads)
+}
+
+// complete records the result of the read, which is the number of bytes read or a negated errno.
+// The rest of a short read is read with a pread.
+func (u *uringRead) complete(res int32) {
+	if res < 0 {
+		u.read.Err = fmt.Errorf("error reading data from file: %w", unix.Errno(-res))
+		return
+	}
+	if int(res) == len(u.read.Buf) {
+		return
+	}
+
+	n, err := u.file.ReadAt(u.read.Buf[res:], u.off+int64(res))
+	if err != nil {
+		u.read.Err = err
+		return
+	}
+	if int(res)+n != len(u.read.Buf) {
+		u.read.Err = errors.New("error fetching record, invalid size")
+	}
+}
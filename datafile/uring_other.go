@@ -0,0 +1,23 @@
+//go:build !linux
+
+package datafile
+
+// Ring batches the reads with io_uring, which is only supported on linux.
+type Ring struct{}
+
+// NewRing returns an error since io_uring is only supported on linux.
+func NewRing(entries int) (*Ring, error) {
+	return nil, ErrIOURingUnsupported
+}
+
+// ReadBatch reads the records with ReadInto.
+func (r *Ring) ReadBatch(reads []BatchRead) {
+	for i := range reads {
+		reads[i].Data, reads[i].Err = reads[i].DF.ReadInto(reads[i].Buf, reads[i].Pos)
+	}
+}
+
+// Close is a no-op.
+func (r *Ring) Close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package barrel
+
+import (
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// pauseWrites rejects the writes with ErrStorageFull until enough disk space is available.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) pauseWrites(cause error) {
+	if !b.storageFull {
+		b.lo.Error("pausing writes since disk is full", "dir", b.opts.dir, "error", cause)
+	}
+	b.storageFull = true
+}
+
+// checkDiskSpace pauses the writes if the free disk space is below the configured minimum
+// and resumes them once the free space is above it again.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) checkDiskSpace() error {
+	if b.opts.minFreeDisk == 0 && !b.storageFull {
+		return nil
+	}
+
+	free, err := datafile.FreeSpace(b.opts.dir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case free < uint64(b.opts.minFreeDisk) && !b.storageFull:
+		b.lo.Error("pausing writes since free disk space is below the minimum", "dir", b.opts.dir, "free", free, "min", b.opts.minFreeDisk)
+		b.storageFull = true
+	case free > uint64(b.opts.minFreeDisk) && b.storageFull:
+		b.lo.Info("resuming writes since disk space is available", "dir", b.opts.dir, "free", free)
+		b.storageFull = false
+	}
+
+	return nil
+}
+
+// checkWritable returns the error of the writes if they aren't allowed, i.e. in the read-only mode,
+// or while they're paused since the disk is full or stalled till the stale data is compacted.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) checkWritable() error {
+	if b.opts.readOnly {
+		return ErrReadOnly
+	}
+	if b.storageFull {
+		return ErrStorageFull
+	}
+	if b.writeStalled() {
+		return ErrWriteStall
+	}
+	return nil
+}
+
+// writeStalled returns true if the stale data is above the configured limits.
+// It also triggers a compaction to reclaim the stale data.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) writeStalled() bool {
+	if b.opts.maxStaleRatio == 0 {
+		return false
+	}
+
+	stale := b.diskBytes - b.liveBytes
+	if stale < b.opts.minStaleBytes || float64(stale) <= b.opts.maxStaleRatio*float64(b.diskBytes) {
+		return false
+	}
+
+	select {
+	case b.compactNow <- struct{}{}:
+		b.lo.Info("stalling writes until compaction", "stale_bytes", stale, "disk_bytes", b.diskBytes)
+	default:
+	}
+
+	return true
+}
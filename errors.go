@@ -2,16 +2,102 @@ package barrel
 
 import "errors"
 
+// Errors returned by the datastore. The errors returned by the API either are or wrap
+// one of these, so they should be checked with errors.Is instead of comparing them.
 var (
-	ErrLocked   = errors.New("a lockfile already exists")
+	// ErrLocked is returned by Init if another process has opened the directory for writing.
+	ErrLocked = errors.New("a lockfile already exists")
+	// ErrReadOnly is returned by the writes if the datastore is opened in read-only mode.
 	ErrReadOnly = errors.New("operation not allowed in read only mode")
 
+	// ErrChecksumMismatch is returned if a record or a hints file is corrupt.
 	ErrChecksumMismatch = errors.New("invalid data: checksum does not match")
+	// ErrInvalidRecord is returned if the header of a record can't be decoded.
+	ErrInvalidRecord = errors.New("invalid data: not a valid record header")
+	// ErrInvalidHints is returned if a hints file can't be decoded.
+	ErrInvalidHints = errors.New("invalid data: not a valid hints file")
 
-	ErrEmptyKey   = errors.New("invalid key: key cannot be empty")
-	ErrExpiredKey = errors.New("invalid key: key is already expired")
-	ErrLargeKey   = errors.New("invalid key: size cannot be more than 4294967296 bytes")
-	ErrNoKey      = errors.New("invalid key: key is either deleted or expired or unset")
+	// ErrEmptyKey is returned by the writes if the key is empty.
+	ErrEmptyKey = errors.New("invalid key: key cannot be empty")
+	// ErrEmptyTag is returned by PutWithTags if any of the tags is empty.
+	ErrEmptyTag = errors.New("invalid tag: tag cannot be empty")
+	// ErrReservedKey is returned by the writes and the deletes of the keys starting with "\x00",
+	// which are reserved for the idempotency tokens and the stream entries.
+	ErrReservedKey = errors.New("invalid key: keys starting with \\x00 are reserved")
+	// ErrEmptyToken is returned by PutIdempotent if the idempotency token is empty.
+	ErrEmptyToken = errors.New("invalid token: token cannot be empty")
+	// ErrKeyNotFound is returned by the reads if the key is either deleted or expired or unset.
+	ErrKeyNotFound = errors.New("invalid key: key is either deleted or expired or unset")
+	// ErrExpiredKey is returned by the reads if the key has expired but isn't cleaned up yet.
+	// It matches ErrKeyNotFound.
+	ErrExpiredKey = wrapError(ErrKeyNotFound, "invalid key: key is already expired")
+	// ErrNoKey is the same as ErrKeyNotFound.
+	//
+	// Deprecated: Use ErrKeyNotFound.
+	ErrNoKey = ErrKeyNotFound
 
-	ErrLargeValue = errors.New("invalid value: size cannot be more than 4294967296 bytes")
+	// ErrInvalidOffset is returned by the writes of a part of the value if the offset is negative.
+	ErrInvalidOffset = errors.New("invalid offset: offset is out of range")
+	// ErrInvalidExpiry is returned by the writes with an absolute expiry which can't be stored,
+	// i.e. before the Unix epoch or after 2106.
+	ErrInvalidExpiry = errors.New("invalid expiry: time is out of range")
+
+	// ErrTooLarge is matched by the errors returned if the key or the value is too large.
+	ErrTooLarge = errors.New("invalid record: size is too large")
+	// ErrLargeKey is returned by the writes if the key is larger than the max key size. It matches ErrTooLarge.
+	ErrLargeKey = wrapError(ErrTooLarge, "invalid key: size is more than the max key size")
+	// ErrLargeValue is returned by the writes if the value is larger than the max value size. It matches ErrTooLarge.
+	ErrLargeValue = wrapError(ErrTooLarge, "invalid value: size is more than the max value size")
+	// ErrLargeMeta is returned by the writes if the metadata or the tags are larger than MaxMetaSize. It matches ErrTooLarge.
+	ErrLargeMeta = wrapError(ErrTooLarge, "invalid metadata: size is more than the max metadata size")
+
+	// ErrMaxDataSize is returned by the writes if the max data size is reached and no key can be evicted.
+	ErrMaxDataSize = errors.New("max data size reached: no key can be evicted")
+	// ErrStorageFull is returned by the writes while they're paused since the disk is (almost) full.
+	ErrStorageFull = errors.New("storage full: writes are paused until disk space is reclaimed")
+	// ErrWriteStall is returned by the writes while there's too much stale data pending compaction.
+	ErrWriteStall = errors.New("write stall: too much stale data is pending compaction")
+	// ErrQuotaExceeded is returned by the writes if the namespace of the key has reached its quota.
+	ErrQuotaExceeded = errors.New("quota exceeded: namespace has reached its max keys or bytes")
+
+	// ErrInvalidStreamID is returned if a stream ID can't be parsed.
+	ErrInvalidStreamID = errors.New("invalid stream id: must be of the form <ms>-<seq>")
+	// ErrSmallStreamID is returned if the ID of a new stream entry isn't greater than the last one.
+	ErrSmallStreamID = errors.New("invalid stream id: must be greater than the id of the last entry")
+	// ErrInvalidStreamFields is returned if a stream entry doesn't have field-value pairs.
+	ErrInvalidStreamFields = errors.New("invalid stream entry: fields must be non-empty field-value pairs")
+
+	// ErrInvalidJSON is returned by the JSON commands if the value isn't a valid JSON document.
+	ErrInvalidJSON = errors.New("invalid json: value isn't a valid json document")
+	// ErrInvalidJSONPath is returned if a JSON path can't be parsed.
+	ErrInvalidJSONPath = errors.New("invalid json path: must be of the form $.field[index]")
+	// ErrJSONPathNotFound is returned if a JSON path doesn't exist in the document.
+	ErrJSONPathNotFound = errors.New("json path not found")
+	// ErrUnknownIndex is returned by Lookup if there's no secondary index with the given name.
+	ErrUnknownIndex = errors.New("unknown index")
+	// ErrHotKeysDisabled is returned by HotKeys if the accesses of the keys aren't tracked.
+	ErrHotKeysDisabled = errors.New("hot keys aren't tracked: enable them with WithHotKeys")
+	// ErrNotModified is returned by GetIfModified if the key hasn't changed since the given version.
+	ErrNotModified = errors.New("not modified: key is unchanged since the given version")
+	// ErrSortedKeysDisabled is returned by RangeScan if the keys aren't maintained in order.
+	ErrSortedKeysDisabled = errors.New("keys aren't sorted: enable them with WithSortedKeys")
 )
+
+// wrappedError is an error with its own message, which also matches its parent error.
+type wrappedError struct {
+	msg    string
+	parent error
+}
+
+// wrapError returns an error with the given message, which matches the parent error.
+func wrapError(parent error, msg string) error {
+	return &wrappedError{msg: msg, parent: parent}
+}
+
+func (e *wrappedError) Error() string {
+	return e.msg
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.parent
+}
@@ -0,0 +1,144 @@
+package barrel
+
+// EvictionPolicy decides which keys are evicted when the live data reaches the max data size.
+type EvictionPolicy int
+
+const (
+	// NoEviction rejects the writes with ErrMaxDataSize.
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU evicts the least recently read or written keys.
+	AllKeysLRU
+	// VolatileTTL evicts the keys having an expiry, the ones expiring soonest first.
+	// Writes are rejected if none of the keys have an expiry.
+	VolatileTTL
+)
+
+// evictionSamples is the number of keys sampled for picking each key to evict.
+// Like Redis, eviction is approximate since ordering all the keys on every write is too expensive.
+const evictionSamples = 5
+
+// maxPendingTouches is the number of accesses by the reads after which the reader takes the write lock
+// to record them, so that they don't pile up if there are no writes.
+const maxPendingTouches = 4096
+
+// pendingTouch is an access of a key by a read under the read lock, which can't modify the keydir.
+type pendingTouch struct {
+	key string
+	at  int // Unix timestamp of the access.
+}
+
+// evict deletes keys as per the eviction policy until n more bytes of live data
+// fit within the max data size. The given key, which is being written, is never evicted.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) evict(k string, n int) error {
+	if n > b.opts.maxDataSize {
+		return ErrMaxDataSize
+	}
+	b.applyTouches()
+
+	for b.liveBytes+n > b.opts.maxDataSize {
+		victim, ok := b.evictionCandidate(k)
+		if !ok {
+			return ErrMaxDataSize
+		}
+
+		b.lo.Debug("evicting key", "key", victim, "live_bytes", b.liveBytes)
+		if err := b.delete(victim); err != nil {
+			return err
+		}
+		b.evicted.Add(1)
+	}
+
+	return nil
+}
+
+// evictionCandidate returns the best key to evict among a sample of the keys.
+// Iteration over the keydir starts at a random position, which makes for a random sample.
+func (b *Barrel) evictionCandidate(skip string) (string, bool) {
+	if b.opts.evictionPolicy == NoEviction {
+		return "", false
+	}
+
+	var (
+		victim  string
+		best    uint64
+		found   bool
+		sampled int
+	)
+	b.eachKey(func(k string, meta Meta) bool {
+		if k == skip {
+			return true
+		}
+
+		var score uint64
+		switch b.opts.evictionPolicy {
+		case AllKeysLRU:
+			score = b.accessed[k]
+		case VolatileTTL:
+			if meta.Expiry == 0 {
+				return true
+			}
+			score = uint64(meta.Expiry)
+		}
+
+		if !found || score < best {
+			victim, best, found = k, score, true
+		}
+		sampled++
+		return sampled < evictionSamples
+	})
+
+	return victim, found
+}
+
+// touch records an access of the key in the keydir and for the LRU eviction policy.
+// Keys which haven't been accessed since the startup are the first to be evicted.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) touch(k string) {
+	// Record the earlier accesses by the reads first, to keep the order of the accesses.
+	b.applyTouches()
+	b.setAccessed(k, int(b.now().Unix()))
+}
+
+// setAccessed records the access of the key at the given time.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) setAccessed(k string, at int) {
+	if meta, ok := b.keydir.get(k); ok {
+		meta.Accessed = at
+		if _, err := b.keydir.set(k, meta); err != nil {
+			b.keydirError(err)
+		}
+	}
+
+	if b.accessed == nil {
+		return
+	}
+	b.clock++
+	b.accessed[k] = b.clock
+}
+
+// touchLater queues an access of the key by a read which only holds the read lock of the barrel,
+// to be recorded by the next touch. It returns true if the accesses should be recorded right away.
+func (b *Barrel) touchLater(k string) bool {
+	b.touchMu.Lock()
+	defer b.touchMu.Unlock()
+
+	b.touched = append(b.touched, pendingTouch{key: k, at: int(b.now().Unix())})
+	return len(b.touched) >= maxPendingTouches
+}
+
+// applyTouches records the accesses queued by the reads, in the order of the reads.
+// The keys which are deleted since their read are skipped.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) applyTouches() {
+	b.touchMu.Lock()
+	touched := b.touched
+	b.touched = nil
+	b.touchMu.Unlock()
+
+	for _, t := range touched {
+		if _, ok := b.keydir.get(t.key); ok {
+			b.setAccessed(t.key, t.at)
+		}
+	}
+}
@@ -19,7 +19,7 @@ func main() {
 		lo.Fatalf("error creating data dir: %v", err)
 	} // Creating data dir.
 
-	barrel, err := barrel.Init(barrel.WithDir("data/"), barrel.WithAutoSync())
+	barrel, err := barrel.Init(barrel.WithDir("data/"), barrel.WithSyncPolicy(barrel.SyncInterval(time.Minute)))
 	if err != nil {
 		lo.Fatalf("error initialising barrel: %v", err)
 	}
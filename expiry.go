@@ -0,0 +1,48 @@
+package barrel
+
+// expiredKey is a key purged since it expired, along with its last value.
+type expiredKey struct {
+	key string
+	val []byte
+}
+
+// purgeExpired deletes the expired key and queues it for the expiry callback, if any.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) purgeExpired(k string, val []byte) error {
+	b.lo.Debug("deleting key since it's expired", "key", k)
+	if err := b.delete(k); err != nil {
+		return err
+	}
+
+	if b.opts.expiryCallback != nil && !isReserved(k) {
+		// Copy the value since it may point to the mapped memory of the datafile.
+		b.expired = append(b.expired, expiredKey{key: k, val: append([]byte(nil), val...)})
+		select {
+		case b.expiredReady <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// RunExpiryCallbacks calls the expiry callback for the keys in the order they're purged.
+// The callback is called without holding the lock, so it can use the barrel.
+func (b *Barrel) RunExpiryCallbacks() {
+	for {
+		select {
+		case <-b.expiredReady:
+		case <-b.done:
+			return
+		}
+
+		b.Lock()
+		expired := b.expired
+		b.expired = nil
+		b.Unlock()
+
+		for _, e := range expired {
+			b.opts.expiryCallback(e.key, e.val)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package barrel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// droppedSuffix is appended to the datafiles and the hints files dropped by DropAll,
+// which are deleted in background. The dropped files are ignored on startup.
+const droppedSuffix = ".dropped"
+
+// DropAll removes all the keys, like `FLUSHDB` of Redis. A new empty active datafile and an
+// empty keydir are swapped in atomically, so the keys are gone once it returns, while the older
+// datafiles are deleted in background. Tombstones aren't written for the keys, so the hooks,
+// the mirror and the expiry callback aren't called for them.
+func (b *Barrel) DropAll() error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.opts.readOnly {
+		return ErrReadOnly
+	}
+
+	// Create the new active datafile first, so that nothing is dropped if it fails.
+	df, err := b.opts.backend.Create(b.opts.dir, b.df.ID()+1)
+	if err != nil {
+		return err
+	}
+	if err := df.WriteSegmentHeader(recordVersion); err != nil {
+		return err
+	}
+	df.SetWriteBuffer(b.opts.writeBufferSize)
+	if b.opts.preallocate {
+		if err := df.Preallocate(b.opts.maxActiveFileSize); err != nil {
+			return err
+		}
+	}
+
+	// Replace the manifest with the new datafile, which drops all the older datafiles at once.
+	if err := writeManifest(b.opts.dir, b.newManifest([]int{df.ID()})); err != nil {
+		df.Close()
+		os.Remove(df.Path())
+		return err
+	}
+
+	dropped := append(make([]datafile.Storage, 0, len(b.stale)+1), b.df)
+	for _, d := range b.stale {
+		dropped = append(dropped, d)
+	}
+
+	b.lo.Info("dropping all keys", "keys", b.keydir.len(), "datafiles", len(dropped))
+
+	// Swap in the new datafile and reset everything derived from the dropped records.
+	b.df = df
+	b.stale = make(map[int]datafile.Storage)
+	b.activeHints = newHints(df.ID())
+	b.keydir.close()
+	b.keydir = b.newKeyDir()
+	b.buildSorted()
+	b.quarantined = make(map[string]Meta)
+	b.tags = newIndex(nil)
+	b.indexes = b.newIndexes()
+	b.streams = make(map[string][]StreamID)
+	b.timeRanges = make(map[int]timeRange)
+	b.seqRanges = make(map[int]seqRange)
+	b.stats = make(map[int]segmentStats)
+	b.liveBytes = 0
+	b.diskBytes = df.HeaderSize()
+	b.usage = map[int]*segmentUsage{df.ID(): {}}
+	for _, u := range b.quotas {
+		u.Keys, u.Bytes = 0, 0
+	}
+	if b.accessed != nil {
+		b.accessed = make(map[string]uint64)
+	}
+	if b.cache != nil {
+		b.cache.reset()
+	}
+
+	// Rename the dropped files before returning, so that they aren't loaded again
+	// on startup even if the deletion in background is interrupted.
+	var paths []string
+	for _, d := range dropped {
+		for _, path := range []string{
+			d.Path(),
+			hintsPath(b.opts.dir, d.ID()),
+		} {
+			if !exists(path) {
+				continue
+			}
+			if err := os.Rename(path, path+droppedSuffix); err != nil {
+				return fmt.Errorf("error renaming dropped file: %w", err)
+			}
+			paths = append(paths, path+droppedSuffix)
+		}
+	}
+
+	b.spawn(func() { b.removeDropped(dropped, paths) })
+
+	return nil
+}
+
+// removeDropped closes the dropped datafiles and deletes the dropped files.
+func (b *Barrel) removeDropped(dfs []datafile.Storage, paths []string) {
+	b.readers.Lock()
+	for _, d := range dfs {
+		if err := d.Close(); err != nil {
+			b.lo.Error("error closing dropped datafile", "id", d.ID(), "error", err)
+		}
+	}
+	b.readers.Unlock()
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			b.lo.Error("error removing dropped file", "path", path, "error", err)
+		}
+	}
+	if err := syncDir(b.opts.dir); err != nil {
+		b.lo.Error("error syncing dir after removing dropped files", "error", err)
+	}
+	b.lo.Info("removed dropped datafiles", "count", len(dfs))
+}
+
+// removeDroppedFiles deletes the files left over by a DropAll which was interrupted.
+func removeDroppedFiles(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+droppedSuffix))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package barrel
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// foldReadAhead is the size of the reads of the datafiles by FoldRecords, so that they're read
+// sequentially in large chunks instead of with a read per record.
+const foldReadAhead = 4 << 20
+
+// readAhead reads the records of a datafile in the increasing order of their positions
+// through a window of the datafile, which is refilled once a record is past it.
+type readAhead struct {
+	df   datafile.Storage
+	end  int    // Position of the end of the last record to be read from the datafile.
+	buf  []byte // Buffer which the window is read into.
+	data []byte // Window of the datafile, which is the buffer unless the datafile is memory-mapped.
+	pos  int    // Position of the start of the window in the datafile.
+}
+
+// read returns the record of the given size ending at the given position, which aliases the window
+// and is only valid till the next read. The records larger than the window are read on their own.
+func (r *readAhead) read(pos int, size int) ([]byte, error) {
+	start := pos - size
+	if start >= r.pos && pos <= r.pos+len(r.data) {
+		return r.data[start-r.pos : pos-r.pos], nil
+	}
+	if size > len(r.buf) {
+		return r.df.Read(pos, size)
+	}
+
+	// Read the window from the start of the record, without reading past the last record.
+	n := len(r.buf)
+	if start+n > r.end {
+		n = r.end - start
+	}
+	data, err := r.df.ReadInto(r.buf[:n], start+n)
+	if err != nil {
+		return nil, err
+	}
+	r.data, r.pos = data, start
+
+	return r.data[:size], nil
+}
+
+// foldEntry is a key to be read by FoldRecords.
+type foldEntry struct {
+	key  string
+	meta Meta
+}
+
+// FoldKV calls fn with each key and its value, which is only valid till fn returns,
+// reading the records sequentially as FoldRecords does.
+func (b *Barrel) FoldKV(fn func(k string, val []byte) error) error {
+	return b.FoldRecords(func(r Record) error {
+		return fn(r.Key, r.Value)
+	})
+}
+
+// FoldRecords calls fn with the record of each key, except the expired ones, which helps to export
+// all the keys. The records are read in the order of their positions in the datafiles, through a large
+// read-ahead buffer per datafile instead of a read per record, so that a full scan is read mostly
+// sequentially. The record aliases the buffer and is only valid till fn returns. The order of the keys
+// isn't defined. The barrel is locked for reading during the fold, so fn mustn't write to it.
+func (b *Barrel) FoldRecords(fn func(r Record) error) error {
+	b.RLock()
+	defer b.RUnlock()
+
+	// Collect the live keys and sort them by their positions in the datafiles.
+	var (
+		entries = make([]foldEntry, 0, b.keydir.keyspaceLen())
+		now     = b.now().Unix()
+	)
+	b.eachKey(func(k string, meta Meta) bool {
+		if meta.Expiry == 0 || now <= int64(meta.Expiry) {
+			entries = append(entries, foldEntry{key: k, meta: meta})
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].meta.FileID != entries[j].meta.FileID {
+			return entries[i].meta.FileID < entries[j].meta.FileID
+		}
+		return entries[i].meta.RecordPos < entries[j].meta.RecordPos
+	})
+
+	var (
+		buf = make([]byte, foldReadAhead)
+		r   *readAhead
+	)
+	for i, e := range entries {
+		if r == nil || r.df.ID() != e.meta.FileID {
+			df, err := b.reader(e.meta.FileID)
+			if err != nil {
+				return err
+			}
+			// Entries are sorted, so the last entry of the datafile has the last record to be read.
+			end := i
+			for end+1 < len(entries) && entries[end+1].meta.FileID == e.meta.FileID {
+				end++
+			}
+			r = &readAhead{df: df, end: entries[end].meta.RecordPos, buf: buf}
+		}
+
+		data, err := r.read(e.meta.RecordPos, e.meta.RecordSize)
+		if err != nil {
+			return fmt.Errorf("error reading data from file: %w", err)
+		}
+		record, err := decodeRecord(e.key, data, r.df.Version())
+		if err != nil {
+			return err
+		}
+		if !record.isValidChecksum() {
+			return fmt.Errorf("error reading key %s: %w", e.key, ErrChecksumMismatch)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
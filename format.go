@@ -0,0 +1,171 @@
+package barrel
+
+import (
+	"fmt"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// RecordVersion is the version of the record format used for writing the new datafiles.
+const RecordVersion = recordVersion
+
+// FieldFormat describes a field of the records or the segment header.
+type FieldFormat struct {
+	Name string `json:"name"`
+	// Encoding is one of uint8, uint16be, uint16le, uint32be, uint32le, uvarint (LEB128) or bytes.
+	Encoding string `json:"encoding"`
+	// Size is the size of the field in bytes, or 0 if it's variable.
+	Size int `json:"size,omitempty"`
+	// SizeField is the field holding the size of a field encoded as bytes.
+	SizeField string `json:"size_field,omitempty"`
+	// Flag is the flag which has to be set for the field to be present, if any.
+	Flag        string `json:"flag,omitempty"`
+	Description string `json:"description"`
+}
+
+// FlagFormat describes a bit field of the flags of the records.
+type FlagFormat struct {
+	Name        string `json:"name"`
+	Mask        uint8  `json:"mask"`
+	Description string `json:"description"`
+}
+
+// RecordFormat describes the on-disk layout of the records of a version of the record format,
+// so that the datafiles can be decoded without this package. The version of a datafile is read
+// from its segment header.
+type RecordFormat struct {
+	Version       int           `json:"version"`
+	SegmentHeader []FieldFormat `json:"segment_header,omitempty"` // Fields at the start of the datafiles, if any.
+	Fields        []FieldFormat `json:"fields"`                   // Fields of each record, in order.
+	Flags         []FlagFormat  `json:"flags,omitempty"`
+	MetaSection   []FieldFormat `json:"meta_section,omitempty"` // Fields of the meta field, if any.
+	Checksums     []string      `json:"checksums"`              // Checksum algorithms by their IDs in the flags.
+	Checksum      string        `json:"checksum"`               // Bytes covered by the checksum.
+	Tombstone     string        `json:"tombstone"`              // How a deleted key is recorded.
+}
+
+var (
+	checksumNames = []string{"crc32", "crc32c", "xxhash64"}
+
+	segmentHeaderFormat = []FieldFormat{
+		{Name: "magic", Encoding: "bytes", Size: len(datafile.SegmentMagic), Description: fmt.Sprintf("%q", datafile.SegmentMagic)},
+		{Name: "version", Encoding: "uint16le", Size: 2, Description: "Version of the record format of all the records in the datafile."},
+	}
+
+	recordFormats = []RecordFormat{
+		{
+			Version: datafile.VersionBitcask,
+			Fields: []FieldFormat{
+				{Name: "crc", Encoding: "uint32be", Size: 4, Description: "CRC-32 (IEEE) of the rest of the record."},
+				{Name: "time", Encoding: "uint32be", Size: 4, Description: "Time of the write in seconds since the epoch."},
+				{Name: "key_size", Encoding: "uint16be", Size: 2, Description: "Size of the key in bytes."},
+				{Name: "val_size", Encoding: "uint32be", Size: 4, Description: "Size of the value in bytes."},
+				{Name: "key", Encoding: "bytes", SizeField: "key_size", Description: "Key."},
+				{Name: "val", Encoding: "bytes", SizeField: "val_size", Description: "Value."},
+			},
+			Checksums: []string{"crc32"},
+			Checksum:  "time, key_size, val_size, key and val",
+			Tombstone: fmt.Sprintf("val starting with %q", bitcaskTombstone),
+		},
+		{
+			Version: 1,
+			Fields: []FieldFormat{
+				{Name: "crc", Encoding: "uint32le", Size: 4, Description: "Checksum of the value."},
+				{Name: "time", Encoding: "uint32le", Size: 4, Description: "Time of the write in seconds since the epoch."},
+				{Name: "expiry", Encoding: "uint32le", Size: 4, Description: "Expiry in seconds since the epoch, 0 if the key doesn't expire."},
+				{Name: "key_size", Encoding: "uint32le", Size: 4, Description: "Size of the key in bytes in the lower 30 bits, and the ID of the checksum algorithm in the upper 2 bits."},
+				{Name: "val_size", Encoding: "uint32le", Size: 4, Description: "Size of the value in bytes."},
+				{Name: "key", Encoding: "bytes", SizeField: "key_size", Description: "Key."},
+				{Name: "val", Encoding: "bytes", SizeField: "val_size", Description: "Value."},
+			},
+			Checksums: checksumNames,
+			Checksum:  "val",
+			Tombstone: "val_size of 0",
+		},
+		{
+			Version:       2,
+			SegmentHeader: segmentHeaderFormat,
+			Fields: []FieldFormat{
+				{Name: "crc", Encoding: "uint32le", Size: 4, Description: "Checksum of the meta and the value."},
+				{Name: "flags", Encoding: "uint8", Size: 1, Description: "Flags of the record."},
+				{Name: "time", Encoding: "uvarint", Description: "Time of the write in seconds since the epoch."},
+				{Name: "expiry", Encoding: "uvarint", Description: "Expiry in seconds since the epoch, 0 if the key doesn't expire."},
+				{Name: "key_size", Encoding: "uvarint", Description: "Size of the key in bytes."},
+				{Name: "val_size", Encoding: "uvarint", Description: "Size of the value in bytes."},
+				{Name: "meta_size", Encoding: "uvarint", Flag: "metadata", Description: "Size of the meta in bytes."},
+				{Name: "key", Encoding: "bytes", SizeField: "key_size", Description: "Key."},
+				{Name: "meta", Encoding: "bytes", SizeField: "meta_size", Flag: "metadata", Description: "User-defined metadata and tags of the key."},
+				{Name: "val", Encoding: "bytes", SizeField: "val_size", Description: "Value."},
+			},
+			Flags: []FlagFormat{
+				{Name: "checksum", Mask: flagChecksumMask, Description: "ID of the checksum algorithm."},
+				{Name: "compressed", Mask: flagCompressed, Description: "Reserved for compressed values."},
+				{Name: "encrypted", Mask: flagEncrypted, Description: "Reserved for encrypted values."},
+				{Name: "metadata", Mask: flagMetadata, Description: "Record has the meta_size and meta fields."},
+				{Name: "type", Mask: 0b111 << 5, Description: "Reserved for the type of the value."},
+			},
+			MetaSection: []FieldFormat{
+				{Name: "meta_size", Encoding: "uvarint", Description: "Size of the user-defined metadata in bytes."},
+				{Name: "meta", Encoding: "bytes", SizeField: "meta_size", Description: "User-defined metadata."},
+				{Name: "tags_count", Encoding: "uvarint", Description: "Number of tags."},
+				{Name: "tag_size", Encoding: "uvarint", Description: "Size of the tag in bytes, repeated tags_count times along with the tag."},
+				{Name: "tag", Encoding: "bytes", SizeField: "tag_size", Description: "Tag of the key."},
+			},
+			Checksums: checksumNames,
+			Checksum:  "meta and val",
+			Tombstone: "val_size of 0",
+		},
+	}
+)
+
+// RecordFormats returns the formats of all the versions of the records which can be read,
+// including those written by Bitcask (version -1).
+func RecordFormats() []RecordFormat {
+	return append([]RecordFormat(nil), recordFormats...)
+}
+
+// GetRecordFormat returns the format of the given version of the records.
+func GetRecordFormat(version int) (RecordFormat, error) {
+	for _, f := range recordFormats {
+		if f.Version == version {
+			return f, nil
+		}
+	}
+	return RecordFormat{}, fmt.Errorf("unknown record version: %d", version)
+}
+
+// DecodeRecord decodes the record at the start of the data in the given version of the record format,
+// e.g. of a byte range of a datafile starting at a record. It's the reference decoder of the format
+// described by GetRecordFormat. The Offset of the returned record is 0.
+func DecodeRecord(data []byte, version int) (SegmentRecord, error) {
+	if _, err := GetRecordFormat(version); err != nil {
+		return SegmentRecord{}, err
+	}
+
+	var header Header
+	n, err := header.decode(data, version)
+	if err != nil {
+		return SegmentRecord{}, err
+	}
+	size := n + int(header.KeySize) + int(header.MetaSize) + int(header.ValSize)
+	if size > len(data) {
+		return SegmentRecord{}, fmt.Errorf("%w: record of %d bytes is truncated to %d bytes", ErrInvalidRecord, size, len(data))
+	}
+
+	var (
+		metaPos = n + int(header.KeySize)
+		valPos  = metaPos + int(header.MetaSize)
+	)
+	record := Record{
+		Header: header,
+		Key:    string(data[n:metaPos]),
+		Value:  data[valPos:size],
+	}
+	record.setMeta(data[metaPos:valPos])
+
+	return SegmentRecord{
+		Record: record,
+		Size:   size,
+		Valid:  record.isValidChecksum(),
+	}, nil
+}
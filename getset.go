@@ -0,0 +1,109 @@
+package barrel
+
+import (
+	"errors"
+	"time"
+)
+
+// GetSet sets the value of the key and returns its old value atomically, like `GETSET` of Redis.
+// The old value is nil if the key doesn't exist. Like Put, the expiry of the key is removed.
+func (b *Barrel) GetSet(k string, val []byte) (old []byte, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	// Validate key and value.
+	if err = b.validateKV(k, val); err != nil {
+		return nil, err
+	}
+
+	record, err := b.getRecord(k)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	b.lo.Debug("swapping data", "key", k, "val", val)
+	if err = b.hookedPut(k, val, nil, nil); err != nil {
+		return nil, err
+	}
+
+	return record.Value, nil
+}
+
+// GetEx returns the value of the key and sets its expiry to the given duration from now atomically,
+// like `GETEX` of Redis. The expiry is removed if the duration is 0. The value, the metadata
+// and the tags of the key are retained. It returns ErrKeyNotFound if the key doesn't exist.
+func (b *Barrel) GetEx(k string, ex time.Duration) (val []byte, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	var expiry *time.Time
+	if ex != 0 {
+		t := b.now().Add(ex)
+		expiry = &t
+	}
+
+	record, err := b.setExpiry(k, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// ExpireAt sets the absolute time at which the key expires, like `EXPIREAT` of Redis, retaining
+// its value, metadata and tags. The key is deleted right away if the time has already passed.
+// Expiries are stored as Unix timestamps in seconds, so the time is truncated to the second.
+// It returns ErrKeyNotFound if the key doesn't exist.
+func (b *Barrel) ExpireAt(k string, at time.Time) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	if !validExpiry(at) {
+		return ErrInvalidExpiry
+	}
+
+	if !at.After(b.now()) {
+		if _, err := b.getRecord(k); err != nil {
+			return err
+		}
+		b.lo.Debug("deleting key since its expiry has passed", "key", k, "expiry", at.String())
+		return b.hookedDelete(k)
+	}
+
+	_, err = b.setExpiry(k, &at)
+	return err
+}
+
+// setExpiry rewrites the record of the key with the given expiry, which is removed if it's nil.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) setExpiry(k string, expiry *time.Time) (Record, error) {
+	record, err := b.getRecord(k)
+	if err != nil {
+		return Record{}, err
+	}
+
+	b.lo.Debug("updating expiry", "key", k, "expiry", expiry)
+	if err = b.hookedPut(k, record.Value, record.rawMeta, expiry); err != nil {
+		return Record{}, err
+	}
+	return record, nil
+}
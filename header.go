@@ -3,29 +3,100 @@ package barrel
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"hash/crc32"
+	"math"
 	"time"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/internal/xxhash"
 )
 
 const (
 	MaxKeySize   = 1<<32 - 1
 	MaxValueSize = 1<<32 - 1
+
+	// recordVersion is the version of the record format used for writing the new datafiles.
+	recordVersion = 2
+
+	// headerSizeV1 is the size of the fixed width header of the version 1 records in bytes.
+	headerSizeV1 = 20
+	// maxHeaderSizeV2 is the max size of the header of the version 2 records in bytes.
+	maxHeaderSizeV2 = 4 + 1 + 5*binary.MaxVarintLen32
+
+	// The checksum algorithm is stored in the upper bits of the key size of the version 1 records.
+	checksumAlgoShift = 30
+	keySizeMask       = 1<<checksumAlgoShift - 1
+
+	// Bits of the flags of the version 2 records.
+	flagChecksumMask = 0b11   // Algorithm used for the checksum.
+	flagCompressed   = 1 << 2 // Reserved for compressed values.
+	flagEncrypted    = 1 << 3 // Reserved for encrypted values.
+	flagMetadata     = 1 << 4 // Record has user-defined metadata.
+	flagBitcask      = 1 << 5 // Record is read from a datafile written by Bitcask. It's never written.
+
+	// MaxMetaSize is the max size of the user-defined metadata of a record.
+	MaxMetaSize = 1<<16 - 1
+)
+
+// ChecksumAlgo is the algorithm used for the checksum of the values.
+type ChecksumAlgo uint32
+
+const (
+	// ChecksumCRC32 is CRC-32 with the IEEE polynomial. It's the default.
+	ChecksumCRC32 ChecksumAlgo = iota
+	// ChecksumCRC32C is CRC-32 with the Castagnoli polynomial, which is
+	// hardware accelerated on amd64 (SSE4.2) and arm64.
+	ChecksumCRC32C
+	// ChecksumXXHash64 is the lower 32 bits of xxHash64, which is faster than CRC-32 in software.
+	ChecksumXXHash64
 )
 
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sum returns the checksum of the metadata and the value.
+func (a ChecksumAlgo) sum(meta, val []byte) uint32 {
+	if len(meta) > 0 {
+		val = append(append(make([]byte, 0, len(meta)+len(val)), meta...), val...)
+	}
+
+	switch a {
+	case ChecksumCRC32C:
+		return crc32.Checksum(val, castagnoli)
+	case ChecksumXXHash64:
+		return uint32(xxhash.Sum64(val))
+	default:
+		return crc32.ChecksumIEEE(val)
+	}
+}
+
 /*
 Record is a binary representation of how each record is persisted in the disk.
 Header represents how the record is stored and some metadata with it.
-For storing CRC checksum hash, timestamp and expiry of record, each field uses 4 bytes. (uint32 == 32 bits).
-The next field stores the max size of the key which is also represented with uint32. So the max size of the key
-can not be more than 2^32-1 which is ~ 4.3GB.
-The next field stores the max size of the value which is also represented with unint32. Max size of value can not be more
-than 2^32-1 which is ~ 4.3GB.
 
-Each entry cannot exceed more than ~8.6GB as a theoretical limit.
-In a practical sense, this is also constrained by the memory of the underlying VM
-where this program would run.
+The format of the records is versioned. New datafiles start with a segment header
+which stores the version of the format of all the records in it. Datafiles without
+a segment header are of version 1. Merges rewrite the records in the latest version.
+
+In version 2, the checksum is followed by a flags byte. The lower 2 bits of the flags
+store the algorithm used for the checksum, the next 2 bits are reserved for compression
+and encryption, the next bit is set if the record has a metadata section and the upper 3 bits
+are reserved for the type of the value. The remaining fields are encoded as unsigned varints (LEB128),
+so the header of small records only takes ~13 bytes. The max size of the key and the value is 2^32-1
+which is ~ 4.3GB. The size of the metadata section and the section itself are only present if its flag is set.
+It holds the user-defined metadata and the tags of the key. The checksum covers both the metadata section and the value.
+
+Representation of the version 2 record stored on disk.
+------------------------------------------------------------------------------------------
+| crc(4) | flags(1) | time(uvarint) | expiry(uvarint) | key_size(uvarint) | val_size(uvarint) |
+------------------------------------------------------------------------------------------
+| meta_size(uvarint) | key | meta | val                                                    |
+------------------------------------------------------------------------------------------
 
-Representation of the record stored on disk.
+In version 1, each field of the header uses 4 bytes (uint32 == 32 bits). The upper 2 bits of
+the key size store the algorithm used for the checksum. Each entry cannot exceed ~5.3GB.
+
+Representation of the version 1 record stored on disk.
 ------------------------------------------------------------------------------
 | crc(4) | time(4) | expiry (4) | key_size(4) | val_size(4) | key | val      |
 ------------------------------------------------------------------------------
@@ -33,38 +104,130 @@ Representation of the record stored on disk.
 type Record struct {
 	Header Header
 	Key    string
+	Meta   []byte   // User-defined metadata, if any.
+	Tags   []string // Tags of the key, if any.
 	Value  []byte
+	Seq    uint64 // Sequence number of the write, only set for the records sent by Tail.
+
+	rawMeta []byte // Encoded metadata section as stored on disk, which also holds the tags.
 }
 
-// Header represents the fixed width fields present at the start of every record.
+// Header represents the fields present at the start of every record.
 type Header struct {
 	Checksum  uint32
+	Flags     uint8
 	Timestamp uint32
 	Expiry    uint32
 	KeySize   uint32
 	ValSize   uint32
+	MetaSize  uint32
 }
 
-// Encode takes a byte buffer, encodes the value of header and writes to the buffer.
-func (h *Header) encode(buf *bytes.Buffer) error {
-	return binary.Write(buf, binary.LittleEndian, h)
+// encode encodes the header in the latest version of the record format and writes it to the buffer.
+func (h *Header) encode(buf *bytes.Buffer) {
+	data := make([]byte, 0, maxHeaderSizeV2)
+	data = binary.LittleEndian.AppendUint32(data, h.Checksum)
+	data = append(data, h.Flags)
+	data = binary.AppendUvarint(data, uint64(h.Timestamp))
+	data = binary.AppendUvarint(data, uint64(h.Expiry))
+	data = binary.AppendUvarint(data, uint64(h.KeySize))
+	data = binary.AppendUvarint(data, uint64(h.ValSize))
+	if h.MetaSize > 0 {
+		data[4] |= flagMetadata
+		data = binary.AppendUvarint(data, uint64(h.MetaSize))
+	}
+	buf.Write(data)
+}
+
+// decode decodes the header of the given version of the record format
+// from the start of the data and returns the size of the header.
+func (h *Header) decode(data []byte, version int) (int, error) {
+	if version == datafile.VersionBitcask {
+		return h.decodeBitcask(data)
+	}
+	if version == 1 {
+		if len(data) < headerSizeV1 {
+			return 0, ErrInvalidRecord
+		}
+		h.Checksum = binary.LittleEndian.Uint32(data[0:4])
+		h.Timestamp = binary.LittleEndian.Uint32(data[4:8])
+		h.Expiry = binary.LittleEndian.Uint32(data[8:12])
+		keySize := binary.LittleEndian.Uint32(data[12:16])
+		h.KeySize = keySize & keySizeMask
+		h.Flags = uint8(keySize >> checksumAlgoShift)
+		h.ValSize = binary.LittleEndian.Uint32(data[16:20])
+		h.MetaSize = 0
+		return headerSizeV1, nil
+	}
+
+	if len(data) < 5 {
+		return 0, ErrInvalidRecord
+	}
+	h.Checksum = binary.LittleEndian.Uint32(data[0:4])
+	h.Flags = data[4]
+	if h.Flags&(flagCompressed|flagEncrypted) != 0 {
+		return 0, fmt.Errorf("%w: unsupported flags %08b", ErrInvalidRecord, h.Flags)
+	}
+
+	// The fields are decoded into an array instead of through the pointers to the fields of the header,
+	// which would move the header to the heap on every read.
+	var (
+		fields [5]uint32 // Timestamp, Expiry, KeySize, ValSize and MetaSize.
+		count  = 4
+	)
+	if h.Flags&flagMetadata != 0 {
+		count = 5
+	}
+
+	n := 5
+	for i := 0; i < count; i++ {
+		v, size := binary.Uvarint(data[n:])
+		if size <= 0 || v > math.MaxUint32 {
+			return 0, ErrInvalidRecord
+		}
+		fields[i] = uint32(v)
+		n += size
+	}
+	h.Timestamp, h.Expiry, h.KeySize, h.ValSize, h.MetaSize = fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	return n, nil
+}
+
+// checksumAlgo returns the algorithm used for the checksum of the value.
+func (h *Header) checksumAlgo() ChecksumAlgo {
+	return ChecksumAlgo(h.Flags & flagChecksumMask)
 }
 
-// Decode takes a record object decodes the binary value the buffer.
-func (h *Header) decode(record []byte) error {
-	return binary.Read(bytes.NewReader(record), binary.LittleEndian, h)
+// expiry returns the expiry time of the record, or nil if it doesn't expire.
+func (h *Header) expiry() *time.Time {
+	if h.Expiry == 0 {
+		return nil
+	}
+	t := time.Unix(int64(h.Expiry), 0)
+	return &t
 }
 
-// isExpired returns true if the key has already expired.
-func (r *Record) isExpired() bool {
+// isExpired returns true if the key has already expired at the given time.
+func (r *Record) isExpired(now time.Time) bool {
 	// If no expiry is set, this value will be 0.
 	if r.Header.Expiry == 0 {
 		return false
 	}
-	return time.Now().Unix() > int64(r.Header.Expiry)
+	return now.Unix() > int64(r.Header.Expiry)
+}
+
+// isTombstone returns true if the record is written for a deleted key.
+func (r *Record) isTombstone() bool {
+	if r.Header.Flags&flagBitcask != 0 {
+		return isBitcaskTombstone(r.Value)
+	}
+	return r.Header.ValSize == 0
 }
 
 // isValidChecksum returns true if the checksum of the value matches what is stored in the header.
 func (r *Record) isValidChecksum() bool {
-	return crc32.ChecksumIEEE(r.Value) == r.Header.Checksum
+	if r.Header.Flags&flagBitcask != 0 {
+		return r.bitcaskChecksum() == r.Header.Checksum
+	}
+	return r.Header.checksumAlgo().sum(r.rawMeta, r.Value) == r.Header.Checksum
 }
@@ -0,0 +1,101 @@
+package barrel
+
+import (
+	"errors"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// errNoPrevious is returned by heal if there's no valid older record of the key to recover from.
+var errNoPrevious = errors.New("no valid older record")
+
+// isCorrupt returns true if the record read for a key is corrupt.
+func isCorrupt(record Record, err error) bool {
+	if err != nil {
+		return errors.Is(err, ErrChecksumMismatch)
+	}
+	return !record.isValidChecksum()
+}
+
+// healCorrupt heals the key whose record is corrupt and returns the recovered record, if any.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) healCorrupt(k string) (Record, bool) {
+	record, err := b.heal(k)
+	if err != nil {
+		b.lo.Error("error healing key with corrupt record", "key", k, "error", err)
+		return Record{}, false
+	}
+	return record, true
+}
+
+// heal recovers a key whose latest record is corrupt from the latest valid record of the key
+// written before it, and writes it again as the latest record (unless in read-only mode).
+// It's slow since the datafiles are read sequentially, but it's only used for the rare corrupt records.
+// Older records are removed by merges, so the key can only be healed if the datafiles aren't merged yet.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) heal(k string) (Record, error) {
+	corrupt, ok := b.keydir.get(k)
+	if !ok {
+		if corrupt, ok = b.quarantined[k]; !ok {
+			return Record{}, ErrKeyNotFound
+		}
+	}
+
+	// Look for the key in the datafiles from the newest to the oldest.
+	dfs := b.dataFiles()
+	for i := len(dfs) - 1; i >= 0; i-- {
+		df := dfs[i]
+		if df.ID() > corrupt.FileID {
+			continue
+		}
+
+		record, found, err := lastRecord(df, k, corrupt)
+		if err != nil {
+			return Record{}, err
+		}
+		if !found {
+			continue
+		}
+		// The key was deleted before the corrupt record was written.
+		if record.isTombstone() {
+			return Record{}, errNoPrevious
+		}
+
+		b.lo.Error("healing key with corrupt record from an older record", "key", k, "id", corrupt.FileID, "pos", corrupt.RecordPos, "from_id", df.ID())
+		if !b.opts.readOnly {
+			if err := b.put(b.df, k, record.Value, record.rawMeta, record.Header.expiry()); err != nil {
+				b.lo.Error("error writing healed key", "key", k, "error", err)
+			}
+		}
+		b.healed.Add(1)
+
+		return record, nil
+	}
+
+	return Record{}, errNoPrevious
+}
+
+// lastRecord returns the latest valid record of the key in the datafile
+// which was written before the given corrupt record.
+func lastRecord(df datafile.Storage, k string, corrupt Meta) (Record, bool, error) {
+	var (
+		last  Record
+		found bool
+	)
+	err := scanDF(df, 0, func(r Record, offset, n int) error {
+		if r.Key != k || !r.isValidChecksum() {
+			return nil
+		}
+		if df.ID() == corrupt.FileID && offset+n >= corrupt.RecordPos {
+			return nil
+		}
+
+		// Copy the metadata and the value since they may point to the mapped memory of the datafile.
+		r.setMeta(append([]byte(nil), r.rawMeta...))
+		r.Value = append([]byte(nil), r.Value...)
+		last, found = r, true
+		return nil
+	})
+
+	return last, found, err
+}
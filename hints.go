@@ -0,0 +1,459 @@
+package barrel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+	"github.com/zerodha/logf"
+)
+
+const (
+	hintsMagic     = "BRLH"
+	hintsVersion   = 3
+	hintsTombstone = 1 << 0
+	hintsTagged    = 1 << 1
+)
+
+/*
+Hints represents the contents of the hints file of a single datafile.
+It stores the metadata of the latest record of every key written in the datafile,
+which helps to populate the keydir without reading the entire datafile during a cold start.
+
+Hints are persisted in a binary format where all the integers are encoded as
+unsigned varints (LEB128) except the checksum, which is a little endian uint32.
+Entries for tombstones only have the flags and the key. Entries for the keys with tags
+also have the number of tags followed by the size and the contents of each tag.
+Version 2 of the format, which doesn't have tags, can still be decoded.
+
+Representation of the hints file stored on disk.
+------------------------------------------------------------------------------
+| magic "BRLH" (4) | version (1) | file_id | offset | entries... | crc (4)   |
+------------------------------------------------------------------------------
+| flags (1) | key_size | key | timestamp | record_size | record_pos | expiry |
+------------------------------------------------------------------------------
+| tags_count | tag_size | tag | ... (only if tagged)                        |
+------------------------------------------------------------------------------
+*/
+type Hints struct {
+	FileID  int                 // ID of the datafile.
+	Keys    KeyDir              // Keys whose latest record in the datafile holds a value.
+	Deleted map[string]bool     // Keys whose latest record in the datafile is a tombstone.
+	Tags    map[string][]string // Tags of the keys in Keys, if any.
+	Offset  int                 // Size of the datafile (in bytes) covered by the hints.
+}
+
+// newHints returns an empty hints object for the given datafile ID.
+func newHints(id int) *Hints {
+	return &Hints{
+		FileID:  id,
+		Keys:    make(KeyDir),
+		Deleted: make(map[string]bool),
+		Tags:    make(map[string][]string),
+	}
+}
+
+// Encode encodes the hints in the binary hints format and writes them to the given path.
+// Caller of this program should ensure to lock/unlock the barrel before calling.
+func (h *Hints) Encode(fPath string) error {
+	// Write to a temporary file and rename it, so that a crash doesn't leave a partially written hints file.
+	tmpPath := fPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		buf = bufio.NewWriter(file)
+		crc = crc32.NewIEEE()
+		w   = io.MultiWriter(buf, crc)
+		tmp = make([]byte, 0, binary.MaxVarintLen64)
+	)
+
+	writeUvarint := func(v uint64) {
+		w.Write(binary.AppendUvarint(tmp[:0], v))
+	}
+
+	// Write the file header.
+	w.Write([]byte(hintsMagic))
+	w.Write([]byte{hintsVersion})
+	writeUvarint(uint64(h.FileID))
+	writeUvarint(uint64(h.Offset))
+
+	// Write an entry for every key.
+	for k, meta := range h.Keys {
+		var (
+			tags  = h.Tags[k]
+			flags byte
+		)
+		if len(tags) > 0 {
+			flags |= hintsTagged
+		}
+		w.Write([]byte{flags})
+		writeUvarint(uint64(len(k)))
+		io.WriteString(w, k)
+		writeUvarint(uint64(meta.Timestamp))
+		writeUvarint(uint64(meta.RecordSize))
+		writeUvarint(uint64(meta.RecordPos))
+		writeUvarint(uint64(meta.Expiry))
+		if len(tags) > 0 {
+			writeUvarint(uint64(len(tags)))
+			for _, tag := range tags {
+				writeUvarint(uint64(len(tag)))
+				io.WriteString(w, tag)
+			}
+		}
+	}
+	for k := range h.Deleted {
+		w.Write([]byte{hintsTombstone})
+		writeUvarint(uint64(len(k)))
+		io.WriteString(w, k)
+	}
+
+	// Write the checksum of the contents at the end.
+	if err := binary.Write(buf, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, fPath); err != nil {
+		return err
+	}
+
+	// Sync the directory so that the rename isn't lost on a power loss.
+	return syncDir(filepath.Dir(fPath))
+}
+
+// Decode reads the hints file at the given path and decodes it into the hints.
+func (h *Hints) Decode(fPath string) error {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return err
+	}
+
+	// Validate the file header and the checksum.
+	if len(data) < len(hintsMagic)+1+4 || string(data[:len(hintsMagic)]) != hintsMagic {
+		return ErrInvalidHints
+	}
+	if version := data[len(hintsMagic)]; version != 2 && version != hintsVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidHints, version)
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return ErrChecksumMismatch
+	}
+
+	var (
+		r       = bytes.NewReader(body[len(hintsMagic)+1:])
+		decoded = newHints(0)
+	)
+	fileID, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrInvalidHints
+	}
+	decoded.FileID = int(fileID)
+	offset, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrInvalidHints
+	}
+	decoded.Offset = int(offset)
+
+	for r.Len() > 0 {
+		flags, err := r.ReadByte()
+		if err != nil {
+			return ErrInvalidHints
+		}
+		keySize, err := binary.ReadUvarint(r)
+		if err != nil || keySize > uint64(r.Len()) {
+			return ErrInvalidHints
+		}
+		key := make([]byte, keySize)
+		r.Read(key)
+
+		if flags&hintsTombstone != 0 {
+			decoded.Deleted[string(key)] = true
+			continue
+		}
+
+		var fields [4]uint64
+		for i := range fields {
+			if fields[i], err = binary.ReadUvarint(r); err != nil {
+				return ErrInvalidHints
+			}
+		}
+		decoded.Keys[string(key)] = Meta{
+			Timestamp:  int(fields[0]),
+			RecordSize: int(fields[1]),
+			RecordPos:  int(fields[2]),
+			FileID:     int(fileID),
+			Expiry:     int(fields[3]),
+		}
+
+		if flags&hintsTagged != 0 {
+			count, err := binary.ReadUvarint(r)
+			if err != nil || count > uint64(r.Len()) {
+				return ErrInvalidHints
+			}
+			tags := make([]string, count)
+			for i := range tags {
+				size, err := binary.ReadUvarint(r)
+				if err != nil || size > uint64(r.Len()) {
+					return ErrInvalidHints
+				}
+				tag := make([]byte, size)
+				r.Read(tag)
+				tags[i] = string(tag)
+			}
+			decoded.Tags[string(key)] = tags
+		}
+	}
+
+	*h = *decoded
+
+	return nil
+}
+
+// apply updates the keydir and the tags of the keys with the hints.
+func (h *Hints) apply(keydir *keyDir, tags map[string][]string) error {
+	for k, meta := range h.Keys {
+		k, err := keydir.set(k, meta)
+		if err != nil {
+			return err
+		}
+		if t, ok := h.Tags[k]; ok {
+			tags[k] = t
+		} else {
+			delete(tags, k)
+		}
+	}
+	for k := range h.Deleted {
+		if err := keydir.delete(k); err != nil {
+			return err
+		}
+		delete(tags, k)
+	}
+	return nil
+}
+
+// add records the metadata and the tags of a newly written record in the hints.
+func (h *Hints) add(k string, meta Meta, tags []string, tombstone bool) {
+	delete(h.Tags, k)
+	if tombstone {
+		delete(h.Keys, k)
+		h.Deleted[k] = true
+	} else {
+		h.Keys[k] = meta
+		if len(tags) > 0 {
+			h.Tags[k] = tags
+		}
+		delete(h.Deleted, k)
+	}
+	h.Offset = meta.RecordPos
+}
+
+// hintsPath returns the path of the hints file for the given datafile ID.
+func hintsPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf(HINTS_FILE, id))
+}
+
+// loadHints returns the hints for the given datafile. If a hints file exists, it's decoded
+// and any records written after the hints were generated are read from the datafile.
+// Otherwise, (or if the hints file is corrupt) the hints are built by reading the entire datafile.
+// If persist is true, the hints file is regenerated when it's incomplete.
+func loadHints(lo logf.Logger, dir string, df datafile.Storage, persist bool) (*Hints, error) {
+	var (
+		path  = hintsPath(dir, df.ID())
+		hints = newHints(df.ID())
+	)
+
+	if exists(path) {
+		if err := hints.Decode(path); err != nil {
+			lo.Error("error decoding hints file, rebuilding from datafile", "path", path, "error", err)
+			hints = newHints(df.ID())
+		}
+	}
+
+	size, err := df.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	// Hints cover the entire datafile.
+	if hints.Offset == int(size) {
+		return hints, nil
+	}
+
+	// Read the records which aren't present in the hints.
+	lo.Debug("reading records missing in hints file", "id", df.ID(), "from", hints.Offset, "size", size)
+	if err := hints.scan(df); err != nil {
+		return nil, err
+	}
+
+	if persist {
+		if err := hints.Encode(path); err != nil {
+			lo.Error("error generating hints file", "path", path, "error", err)
+		}
+	}
+
+	return hints, nil
+}
+
+// scan adds the records of the datafile after the offset covered by the hints.
+func (h *Hints) scan(df datafile.Storage) error {
+	err := scanDF(df, h.Offset, func(r Record, offset, n int) error {
+		h.add(r.Key, Meta{
+			Timestamp:  int(r.Header.Timestamp),
+			RecordSize: n,
+			RecordPos:  offset + n,
+			FileID:     df.ID(),
+			Expiry:     int(r.Header.Expiry),
+		}, r.Tags, r.isTombstone())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading datafile %d: %w", df.ID(), err)
+	}
+	return nil
+}
+
+// RebuildHints regenerates the hints files of the live datafiles in the directory by reading
+// the entire datafiles, and returns the number of hints files written. The live datafiles are the ones
+// listed in the manifest, or all the datafiles in the directory if it doesn't have one, as on startup,
+// so the leftovers of a merge which aren't deleted yet are skipped. Unlike the startup,
+// which trusts any hints file that can be decoded, the existing hints files are ignored.
+// This recovers from hints files which are wrong but not corrupt, or missing for the datafiles
+// written by other tools. The directory must not be in use by another process.
+func RebuildHints(dir string) (int, error) {
+	if exists(filepath.Join(dir, LOCKFILE)) {
+		return 0, ErrLocked
+	}
+
+	man, err := loadManifest(dir)
+	if err != nil {
+		return 0, fmt.Errorf("error loading manifest: %w", err)
+	}
+	var ids []int
+	if man != nil {
+		for _, seg := range man.Segments {
+			ids = append(ids, seg.ID)
+		}
+	} else if ids, err = listIDs(dir); err != nil {
+		return 0, err
+	}
+
+	pool := datafile.NewPool(1, false)
+	for i, id := range ids {
+		df, err := datafile.Open(dir, id, pool)
+		if err != nil {
+			return i, err
+		}
+
+		hints := newHints(id)
+		err = hints.scan(df)
+		df.Close()
+		if err != nil {
+			return i, err
+		}
+		if err := hints.Encode(hintsPath(dir, id)); err != nil {
+			return i, fmt.Errorf("error generating hints file: %w", err)
+		}
+	}
+
+	return len(ids), nil
+}
+
+// hintsResult is the hints of a datafile loaded by loadKeyDir, or the error loading them.
+type hintsResult struct {
+	hints *Hints
+	err   error
+}
+
+// loadKeyDir populates the keydir and the tags of the keys from the hints of the given datafiles.
+// The hints are applied in the increasing order of the datafile IDs, so that the latest record of each key
+// overwrites the older ones, while the hints of the next datafiles are loaded concurrently by the given number
+// of workers. The hints of a datafile are released once they're applied, so that the hints of only as many
+// datafiles as the workers are in memory at once. In the compact mode, the keys are read from the given datafiles.
+func loadKeyDir(lo logf.Logger, dir string, dfs map[int]datafile.Storage, persist bool, workers int, compact bool) (*keyDir, map[string][]string, error) {
+	var (
+		ids     = sortedIDs(dfs)
+		results = make([]chan hintsResult, len(ids))
+		window  = make(chan struct{}, workers) // Held by each datafile from loading its hints till they're applied.
+		done    = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	for pos := range results {
+		results[pos] = make(chan hintsResult, 1)
+	}
+	// Wait for the hints being loaded on a failure, so that the datafiles aren't read once it returns.
+	defer wg.Wait()
+	defer close(done)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for pos, id := range ids {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(pos int, df datafile.Storage) {
+				defer wg.Done()
+				hints, err := loadHints(lo, dir, df, persist)
+				results[pos] <- hintsResult{hints: hints, err: err}
+			}(pos, dfs[id])
+		}
+	}()
+
+	var (
+		keydir *keyDir
+		tags   = make(map[string][]string)
+	)
+	for pos := range ids {
+		res := <-results[pos]
+		if res.err != nil {
+			return nil, nil, res.err
+		}
+		// The keydir is sized for the keys of the first datafile, and grows with the others.
+		if keydir == nil {
+			keydir = newLoadedKeyDir(dfs, len(res.hints.Keys), compact)
+		}
+		if err := res.hints.apply(keydir, tags); err != nil {
+			return nil, nil, fmt.Errorf("error applying hints of datafile %d: %w", ids[pos], err)
+		}
+		<-window
+	}
+	if keydir == nil {
+		keydir = newLoadedKeyDir(dfs, 0, compact)
+	}
+
+	return keydir, tags, nil
+}
+
+// newLoadedKeyDir returns an empty keydir for loadKeyDir with room for the given number of keys.
+// In the compact mode, the keys are read from the given datafiles.
+func newLoadedKeyDir(dfs map[int]datafile.Storage, size int, compact bool) *keyDir {
+	if !compact {
+		return newKeyDir(size)
+	}
+	return newCompactKeyDir(size, func(meta Meta, size int) (string, error) {
+		k, err := readKey(dfs[meta.FileID], meta, size)
+		if err != nil {
+			return "", fmt.Errorf("error reading key of datafile %d at %d: %w", meta.FileID, meta.RecordPos, err)
+		}
+		return k, nil
+	})
+}
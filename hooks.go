@@ -0,0 +1,135 @@
+package barrel
+
+import "time"
+
+// Hook is called around the writes of the keys, which can be used for validating,
+// auditing or mirroring the writes. A write is rejected with the error returned by
+// BeforePut or BeforeDelete, in which case the remaining hooks aren't called.
+// The After methods are only called once the write succeeds.
+// Hooks are called while the barrel is locked, so they must not use the barrel.
+// Internal writes like evictions, expiry purges and merges aren't hooked.
+type Hook interface {
+	BeforePut(k string, val []byte) error
+	AfterPut(k string, val []byte)
+	BeforeDelete(k string) error
+	AfterDelete(k string)
+}
+
+// NopHook is a Hook which does nothing. It can be embedded to implement only some of the methods.
+type NopHook struct{}
+
+func (NopHook) BeforePut(string, []byte) error { return nil }
+func (NopHook) AfterPut(string, []byte)        {}
+func (NopHook) BeforeDelete(string) error      { return nil }
+func (NopHook) AfterDelete(string)             {}
+
+// hookedPut writes the value of the key in the active datafile, calling the hooks around it.
+// Since all the writes of the users are hooked, the reserved keys are rejected here.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) hookedPut(k string, val []byte, meta []byte, expiry *time.Time) error {
+	if isReserved(k) {
+		return ErrReservedKey
+	}
+	for _, h := range b.opts.hooks {
+		if err := h.BeforePut(k, val); err != nil {
+			return err
+		}
+	}
+
+	if err := b.put(b.df, k, val, meta, expiry); err != nil {
+		return err
+	}
+	b.mirrorPut(k, val, expiry)
+	b.recordAccess(k, true)
+
+	for _, h := range b.opts.hooks {
+		h.AfterPut(k, val)
+	}
+	return nil
+}
+
+// hookedDelete deletes the key, calling the hooks around it.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) hookedDelete(k string) error {
+	if isReserved(k) {
+		return ErrReservedKey
+	}
+	for _, h := range b.opts.hooks {
+		if err := h.BeforeDelete(k); err != nil {
+			return err
+		}
+	}
+
+	if err := b.delete(k); err != nil {
+		return err
+	}
+	b.mirrorDelete(k)
+	b.recordAccess(k, true)
+
+	for _, h := range b.opts.hooks {
+		h.AfterDelete(k)
+	}
+	return nil
+}
+
+// hookedPutMulti stores the keys with a single append, calling the hooks around it.
+// The keys are stored only if none of the hooks reject any of them.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) hookedPutMulti(keys []string, vals [][]byte) error {
+	for i, k := range keys {
+		if isReserved(k) {
+			return ErrReservedKey
+		}
+		for _, h := range b.opts.hooks {
+			if err := h.BeforePut(k, vals[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := b.putMulti(keys, vals); err != nil {
+		return err
+	}
+	for i, k := range keys {
+		b.mirrorPut(k, vals[i], nil)
+		b.recordAccess(k, true)
+	}
+
+	for i, k := range keys {
+		for _, h := range b.opts.hooks {
+			h.AfterPut(k, vals[i])
+		}
+	}
+	return nil
+}
+
+// hookedDeleteMulti deletes the keys with a single append, calling the hooks around it.
+// The keys are deleted only if none of the hooks reject any of them.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) hookedDeleteMulti(keys []string) error {
+	for _, k := range keys {
+		if isReserved(k) {
+			return ErrReservedKey
+		}
+		for _, h := range b.opts.hooks {
+			if err := h.BeforeDelete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := b.deleteMulti(keys); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		b.mirrorDelete(k)
+		b.recordAccess(k, true)
+	}
+
+	for _, k := range keys {
+		for _, h := range b.opts.hooks {
+			h.AfterDelete(k)
+		}
+	}
+	return nil
+}
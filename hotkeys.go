@@ -0,0 +1,119 @@
+package barrel
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyAccess represents the accesses of a key tracked for reporting the hot keys.
+// The counts are estimates, since the accesses are sampled and a key which starts being tracked
+// takes over the counts of the key it replaces, which may overcount it but never undercounts it.
+type KeyAccess struct {
+	Key        string
+	Reads      uint64    // Estimated number of reads of the key.
+	Writes     uint64    // Estimated number of writes of the key.
+	LastAccess time.Time // Time of the last sampled access of the key.
+}
+
+// hotKeys tracks the accesses of the most accessed keys with the space-saving algorithm,
+// which bounds the number of keys tracked. It's safe for concurrent use.
+type hotKeys struct {
+	sync.Mutex
+	sample   uint64
+	capacity int
+	seen     atomic.Uint64 // Number of accesses seen, for sampling them.
+	keys     map[string]*KeyAccess
+}
+
+// newHotKeys returns a tracker which samples one of every sample accesses and tracks up to capacity keys.
+func newHotKeys(sample, capacity int) *hotKeys {
+	return &hotKeys{
+		sample:   uint64(sample),
+		capacity: capacity,
+		keys:     make(map[string]*KeyAccess, capacity),
+	}
+}
+
+// record counts an access of the key if it's sampled. A sampled access counts for all the accesses
+// in the sample, so that the counts are estimates of the actual accesses.
+func (h *hotKeys) record(k string, write bool, now time.Time) {
+	if h.sample > 1 && h.seen.Add(1)%h.sample != 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	a, ok := h.keys[k]
+	if !ok {
+		a = &KeyAccess{Key: k}
+		// Replace the least accessed key once the capacity is reached, taking over its counts.
+		if len(h.keys) >= h.capacity {
+			victim := h.leastAccessed()
+			a.Reads, a.Writes = victim.Reads, victim.Writes
+			delete(h.keys, victim.Key)
+		}
+		h.keys[k] = a
+	}
+
+	if write {
+		a.Writes += h.sample
+	} else {
+		a.Reads += h.sample
+	}
+	a.LastAccess = now
+}
+
+// leastAccessed returns the tracked key with the fewest accesses.
+// Caller of this function should ensure to lock/unlock the tracker.
+func (h *hotKeys) leastAccessed() *KeyAccess {
+	var victim *KeyAccess
+	for _, a := range h.keys {
+		if victim == nil || a.Reads+a.Writes < victim.Reads+victim.Writes {
+			victim = a
+		}
+	}
+	return victim
+}
+
+// top returns up to n of the tracked keys with the most reads, or writes if set.
+func (h *hotKeys) top(n int, writes bool) []KeyAccess {
+	h.Lock()
+	keys := make([]KeyAccess, 0, len(h.keys))
+	for _, a := range h.keys {
+		if (writes && a.Writes > 0) || (!writes && a.Reads > 0) {
+			keys = append(keys, *a)
+		}
+	}
+	h.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := keys[i].Reads, keys[j].Reads
+		if writes {
+			ci, cj = keys[i].Writes, keys[j].Writes
+		}
+		return ci > cj || (ci == cj && keys[i].Key < keys[j].Key)
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// HotKeys returns up to n of the most read and the most written keys, with the most accessed first.
+// It returns ErrHotKeysDisabled unless the accesses are tracked with WithHotKeys.
+func (b *Barrel) HotKeys(n int) (mostRead, mostWritten []KeyAccess, err error) {
+	if b.hotKeys == nil {
+		return nil, nil, ErrHotKeysDisabled
+	}
+	return b.hotKeys.top(n, false), b.hotKeys.top(n, true), nil
+}
+
+// recordAccess tracks a read or a write of the key for reporting the hot keys, if enabled.
+func (b *Barrel) recordAccess(k string, write bool) {
+	if b.hotKeys != nil {
+		b.hotKeys.record(k, write, b.now())
+	}
+}
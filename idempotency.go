@@ -0,0 +1,70 @@
+package barrel
+
+import (
+	"time"
+)
+
+const (
+	// tokenKeyPrefix is the prefix of the reserved keys used for storing the idempotency tokens.
+	// Each token is stored as a record with the key `<prefix><token>`, whose value is the key written
+	// with the token and which expires once the idempotency window is over.
+	tokenKeyPrefix = reservedPrefix + "token:"
+
+	// defaultIdempotencyWindow is the time for which the idempotency tokens are remembered by default.
+	defaultIdempotencyWindow = time.Hour
+)
+
+// PutIdempotent is same as Put, with an expiry if ex isn't 0, but skips the write if the given token
+// was used by another write within the idempotency window set by WithIdempotencyWindow. It returns
+// false if the write is skipped, so that producers delivering the writes at least once can retry
+// without applying a write twice. The tokens are persisted along with the records, so they're
+// remembered across restarts, but as reserved keys, which aren't listed, counted or evicted like the keys.
+func (b *Barrel) PutIdempotent(k string, val []byte, ex time.Duration, token string) (applied bool, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return false, err
+	}
+	if token == "" {
+		return false, ErrEmptyToken
+	}
+
+	// Validate key and value.
+	if err = b.validateKV(k, val); err != nil {
+		return false, err
+	}
+
+	now := b.now()
+	tk := tokenKeyPrefix + token
+	meta, ok, err := b.keydir.lookup(tk)
+	if err != nil {
+		return false, err
+	}
+	if ok && int64(meta.Expiry) >= now.Unix() {
+		b.lo.Debug("skipping write with a used token", "key", k, "token", token)
+		return false, nil
+	}
+
+	var expiry *time.Time
+	if ex != 0 {
+		t := now.Add(ex)
+		expiry = &t
+	}
+
+	b.lo.Debug("storing data with token", "key", k, "val", val, "token", token)
+	if err = b.hookedPut(k, val, nil, expiry); err != nil {
+		return false, err
+	}
+
+	// Record the token after the write, so that the write is retried if it fails.
+	until := now.Add(b.opts.idempotencyWindow)
+	if err = b.put(b.df, tk, []byte(k), nil, &until); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
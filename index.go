@@ -0,0 +1,187 @@
+package barrel
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Extractor returns the values of a record which are indexed by a secondary index.
+// It's called on every write, so it should be fast and must not retain the value.
+type Extractor func(k string, val []byte) []string
+
+// JSONExtractor returns an Extractor which indexes the value at the path in the JSON documents.
+// Strings are indexed as is, numbers and booleans by their JSON representation and arrays
+// by each of their elements. Objects, nulls and values which aren't JSON documents aren't indexed.
+func JSONExtractor(path string) (Extractor, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(_ string, val []byte) []string {
+		doc, err := decodeJSON(val)
+		if err != nil {
+			return nil
+		}
+		v, ok := p.lookup(doc)
+		if !ok {
+			return nil
+		}
+		if arr, ok := v.([]any); ok {
+			vals := make([]string, 0, len(arr))
+			for _, e := range arr {
+				if s, ok := jsonScalar(e); ok {
+					vals = append(vals, s)
+				}
+			}
+			return vals
+		}
+		if s, ok := jsonScalar(v); ok {
+			return []string{s}
+		}
+		return nil
+	}, nil
+}
+
+// jsonScalar returns the indexed representation of a JSON string, number or boolean.
+func jsonScalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	}
+	return "", false
+}
+
+// index is an inverted index from the extracted values to the keys.
+type index struct {
+	extract Extractor
+	keys    map[string]map[string]struct{} // Keys of each value.
+	values  map[string][]string            // Values of each key, for removing them.
+}
+
+func newIndex(extract Extractor) *index {
+	return &index{
+		extract: extract,
+		keys:    make(map[string]map[string]struct{}),
+		values:  make(map[string][]string),
+	}
+}
+
+// add indexes the value of the key, replacing its older values.
+func (idx *index) add(k string, val []byte) {
+	idx.set(k, idx.extract(k, val))
+}
+
+// set replaces the indexed values of the key.
+func (idx *index) set(k string, vals []string) {
+	idx.remove(k)
+
+	if len(vals) == 0 {
+		return
+	}
+	for _, v := range vals {
+		if idx.keys[v] == nil {
+			idx.keys[v] = make(map[string]struct{})
+		}
+		idx.keys[v][k] = struct{}{}
+	}
+	idx.values[k] = vals
+}
+
+// remove removes the key from the index.
+func (idx *index) remove(k string) {
+	for _, v := range idx.values[k] {
+		delete(idx.keys[v], k)
+		if len(idx.keys[v]) == 0 {
+			delete(idx.keys, v)
+		}
+	}
+	delete(idx.values, k)
+}
+
+// newIndexes returns empty indexes for all the configured extractors.
+func (b *Barrel) newIndexes() map[string]*index {
+	indexes := make(map[string]*index, len(b.opts.indexes))
+	for name, extract := range b.opts.indexes {
+		indexes[name] = newIndex(extract)
+	}
+	return indexes
+}
+
+// buildIndexes indexes the values of all the keys in the keydir.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) buildIndexes() {
+	b.indexes = b.newIndexes()
+	if len(b.indexes) == 0 {
+		return
+	}
+
+	b.eachKey(func(k string, _ Meta) bool {
+		record, err := b.get(k)
+		if err != nil {
+			b.lo.Error("error reading key for indexing", "key", k, "error", err)
+			return true
+		}
+		for _, idx := range b.indexes {
+			idx.add(k, record.Value)
+		}
+		return true
+	})
+}
+
+// updateIndexes indexes the new value of the key. The key is removed
+// from the indexes if the value is empty, which is the case for deletes.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) updateIndexes(k string, val []byte) {
+	if isReserved(k) {
+		return
+	}
+	for _, idx := range b.indexes {
+		if len(val) == 0 {
+			idx.remove(k)
+			continue
+		}
+		idx.add(k, val)
+	}
+}
+
+// Lookup returns the keys whose values have the given value in the secondary index, sorted by the keys.
+// Expired keys aren't returned.
+func (b *Barrel) Lookup(name, value string) ([]string, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	idx, ok := b.indexes[name]
+	if !ok {
+		return nil, ErrUnknownIndex
+	}
+
+	return b.liveKeys(idx.keys[value]), nil
+}
+
+// liveKeys returns the given keys which are present in the keydir and haven't expired, sorted by the keys.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) liveKeys(set map[string]struct{}) []string {
+	var (
+		now  = int(b.now().Unix())
+		keys = make([]string, 0, len(set))
+	)
+	for k := range set {
+		// The keys may have expired or been quarantined after they were indexed.
+		meta, ok := b.keydir.get(k)
+		if !ok || (meta.Expiry != 0 && now > meta.Expiry) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
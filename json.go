@@ -0,0 +1,315 @@
+package barrel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSON path. Each element is either a string for an object member
+// or an int for an array index, where negative indexes count from the end of the array.
+type jsonPath []any
+
+// parseJSONPath parses a subset of the JSONPath syntax: the root ($ or .) followed by
+// object members (.field or ["field"]) and array indexes ([index]), like $.user.tags[0].
+// Paths without the root, like user.name, are relative to the root.
+func parseJSONPath(path string) (jsonPath, error) {
+	var p jsonPath
+
+	switch {
+	case path == "$" || path == "." || path == "":
+		return p, nil
+	case strings.HasPrefix(path, "$"):
+		path = path[1:]
+	case !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "["):
+		path = "." + path
+	}
+
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			end := strings.IndexAny(path[1:], ".[") + 1
+			if end == 0 {
+				end = len(path)
+			}
+			if end == 1 {
+				return nil, ErrInvalidJSONPath
+			}
+			p = append(p, path[1:end])
+			path = path[end:]
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 2 {
+				return nil, ErrInvalidJSONPath
+			}
+			seg := path[1:end]
+			if unquoted, err := strconv.Unquote(seg); err == nil {
+				p = append(p, unquoted)
+			} else if idx, err := strconv.Atoi(seg); err == nil {
+				p = append(p, idx)
+			} else {
+				return nil, ErrInvalidJSONPath
+			}
+			path = path[end+1:]
+
+		default:
+			return nil, ErrInvalidJSONPath
+		}
+	}
+
+	return p, nil
+}
+
+// jsonIndex returns the index in the array for the given index, which can be negative.
+func jsonIndex(arr []any, idx int) (int, bool) {
+	if idx < 0 {
+		idx += len(arr)
+	}
+	return idx, idx >= 0 && idx < len(arr)
+}
+
+// lookup returns the value at the path in the document.
+func (p jsonPath) lookup(doc any) (any, bool) {
+	for _, seg := range p {
+		switch s := seg.(type) {
+		case string:
+			obj, ok := doc.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = obj[s]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]any)
+			if !ok {
+				return nil, false
+			}
+			idx, ok := jsonIndex(arr, s)
+			if !ok {
+				return nil, false
+			}
+			doc = arr[idx]
+		}
+	}
+	return doc, true
+}
+
+// set sets the value at the path in the document and returns the updated document.
+// An object member is added if it doesn't exist, but the parent of the path must exist.
+func (p jsonPath) set(doc, val any) (any, error) {
+	if len(p) == 0 {
+		return val, nil
+	}
+
+	parent, ok := p[:len(p)-1].lookup(doc)
+	if !ok {
+		return nil, ErrJSONPathNotFound
+	}
+	switch s := p[len(p)-1].(type) {
+	case string:
+		obj, ok := parent.(map[string]any)
+		if !ok {
+			return nil, ErrJSONPathNotFound
+		}
+		obj[s] = val
+	case int:
+		arr, ok := parent.([]any)
+		if !ok {
+			return nil, ErrJSONPathNotFound
+		}
+		idx, ok := jsonIndex(arr, s)
+		if !ok {
+			return nil, ErrJSONPathNotFound
+		}
+		arr[idx] = val
+	}
+
+	return doc, nil
+}
+
+// del removes the value at the path from the document and returns the updated document.
+// It returns false if the path doesn't exist.
+func (p jsonPath) del(doc any) (any, bool) {
+	parent, ok := p[:len(p)-1].lookup(doc)
+	if !ok {
+		return doc, false
+	}
+	switch s := p[len(p)-1].(type) {
+	case string:
+		obj, ok := parent.(map[string]any)
+		if !ok {
+			return doc, false
+		}
+		if _, ok := obj[s]; !ok {
+			return doc, false
+		}
+		delete(obj, s)
+	case int:
+		arr, ok := parent.([]any)
+		if !ok {
+			return doc, false
+		}
+		idx, ok := jsonIndex(arr, s)
+		if !ok {
+			return doc, false
+		}
+		// The array is shrunk, so replace it in its parent.
+		arr = append(arr[:idx:idx], arr[idx+1:]...)
+		doc, _ = p[:len(p)-1].set(doc, arr)
+	}
+
+	return doc, true
+}
+
+// decodeJSON decodes the JSON document. Numbers are kept as json.Number so that
+// they aren't changed when the document is encoded again.
+func decodeJSON(data []byte) (any, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	// There shouldn't be anything after the document.
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON
+	}
+	return doc, nil
+}
+
+// JSONGet returns the value at the path in the JSON document stored at the key, encoded as JSON.
+func (b *Barrel) JSONGet(k, path string) ([]byte, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.lo.Debug("fetching json", "key", k, "path", path)
+	record, err := b.getRecord(k)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSON(record.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	val, ok := p.lookup(doc)
+	if !ok {
+		return nil, ErrJSONPathNotFound
+	}
+	return json.Marshal(val)
+}
+
+// JSONSet sets the value at the path in the JSON document stored at the key.
+// The document is read, modified and written again atomically. If the key doesn't exist,
+// the path must be the root. The expiry and the metadata of the key are retained.
+func (b *Barrel) JSONSet(k, path string, val []byte) (err error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return err
+	}
+	v, err := decodeJSON(val)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+
+	var doc any
+	record, err := b.getRecord(k)
+	if errors.Is(err, ErrKeyNotFound) {
+		// Only the whole document can be set for a new key.
+		if len(p) > 0 {
+			return ErrJSONPathNotFound
+		}
+	} else if err != nil {
+		return err
+	} else if doc, err = decodeJSON(record.Value); err != nil {
+		return err
+	}
+
+	if doc, err = p.set(doc, v); err != nil {
+		return err
+	}
+
+	b.lo.Debug("storing json", "key", k, "path", path)
+	return b.putJSON(k, doc, record)
+}
+
+// JSONDel removes the value at the path in the JSON document stored at the key.
+// The key is deleted if the path is the root. It returns the number of values removed.
+func (b *Barrel) JSONDel(k, path string) (n int, err error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	record, err := b.getRecord(k)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	b.lo.Debug("deleting json", "key", k, "path", path)
+	if len(p) == 0 {
+		return 1, b.hookedDelete(k)
+	}
+
+	doc, err := decodeJSON(record.Value)
+	if err != nil {
+		return 0, err
+	}
+	doc, ok := p.del(doc)
+	if !ok {
+		return 0, nil
+	}
+
+	return 1, b.putJSON(k, doc, record)
+}
+
+// putJSON encodes and stores the updated JSON document for the key,
+// retaining the expiry and the metadata of its previous record.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) putJSON(k string, doc any, prev Record) error {
+	val, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := b.validateKV(k, val); err != nil {
+		return err
+	}
+
+	// Copy the metadata since it may point to the mapped memory of the datafile.
+	meta := append([]byte(nil), prev.rawMeta...)
+
+	return b.hookedPut(k, val, meta, prev.Header.expiry())
+}
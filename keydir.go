@@ -1,8 +1,10 @@
 package barrel
 
 import (
-	"encoding/gob"
-	"os"
+	"hash/maphash"
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
 )
 
 // KeyDir represents an in-memory hash for faster lookups of the key.
@@ -19,48 +21,502 @@ type Meta struct {
 	RecordSize int
 	RecordPos  int
 	FileID     int
+	Expiry     int    // Unix timestamp at which the key expires, 0 if it never expires.
+	Accessed   int    // Unix timestamp of the last read or write of the key since the startup, 0 if it's not accessed yet.
+	Version    uint64 // Sequence number of the last write of the key since the startup, 0 if it's not written yet.
 }
 
-// Encode encodes the map to a gob file.
-// This is typically used to generate a hints file.
-// Caller of this program should ensure to lock/unlock the map before calling.
-func (k *KeyDir) Encode(fPath string) error {
-	// Create a file for storing gob data.
-	file, err := os.Create(fPath)
+const (
+	// keyChunkSize is the size of the chunks in which the keys of the keydir are interned.
+	keyChunkSize = 64 << 10
+
+	noEntry      = -1 // Slot following the last entry of the keys with a hash.
+	freeEntry    = -2 // Marks the slot of a deleted entry.
+	spilledEntry = -3 // Marks an entry read from an index file, whose key isn't interned.
+)
+
+// keyDir is the keydir of the barrel, laid out for tens of millions of keys. The entries are kept in
+// a slice indexed by the hashes of their keys, and the keys are interned in chunks of bytes, so that
+// there's no allocation per key, and the garbage collector doesn't scan the entries or the index
+// since they don't hold any pointers.
+//
+// The chunks are append-only, so the keys returned by the keydir can be retained safely. The bytes
+// of the deleted keys are reclaimed by copying the live keys to new chunks once they're the majority.
+//
+// In the compact mode, the keys aren't kept at all but read from their records, which is needed only when
+// the hash of a key matches an entry. The bloom filter of the segment of the entry is checked first, so
+// that a key which isn't in the keydir is rarely read from the disk even if its hash collides.
+//
+// The entries of the older datafiles can be spilled to their index files to bound the memory of the keydir.
+// Each key has a single entry, either in memory or in an index file: the entry of a spilled key is removed
+// from the index file once the key is deleted, or set again, which moves it back to memory.
+type keyDir struct {
+	seed     maphash.Seed
+	index    map[uint64]int32 // Slot of the latest entry added for each hash of the keys.
+	entries  []keyEntry
+	free     []int32  // Slots of the deleted entries, which are reused by the new keys.
+	chunks   [][]byte // Interned keys, which are appended to the last chunk.
+	live     int      // Size of the keys in the keydir.
+	dead     int      // Size of the deleted keys left in the chunks.
+	reserved int      // Number of the reserved keys, which are counted by len but not by keyspaceLen.
+
+	hash func(string) uint64 // Hashes the keys, overridden by the tests to collide them.
+
+	compact   bool
+	load      func(meta Meta, size int) (string, error) // Reads the key of the record in the compact mode.
+	bloomSeed maphash.Seed                              // Seed of the hashes of the bloom filters, independent of the keydir.
+	blooms    map[int]*bloom                            // Bloom filters of the keys of each segment in the compact mode.
+	probes    atomic.Uint64                             // Number of keys read from the records to verify a lookup.
+	skipped   atomic.Uint64                             // Number of reads of the keys skipped by the bloom filters.
+
+	spilled []*spilledSegment // Entries spilled to the index files, in the order they're spilled.
+	onError func(err error)   // Reports the errors reading the keys or the index files which aren't returned.
+}
+
+// keyEntry is the metadata of a key along with the position of the key in the chunks.
+type keyEntry struct {
+	meta  Meta
+	chunk uint32
+	off   uint32
+	size  uint32
+	next  int32 // Slot of the next entry of the keys with the same hash, or noEntry, or freeEntry.
+}
+
+// newKeyDir returns an empty keydir with room for the given number of keys.
+func newKeyDir(size int) *keyDir {
+	d := &keyDir{
+		seed:      maphash.MakeSeed(),
+		bloomSeed: maphash.MakeSeed(),
+		index:     make(map[uint64]int32, size),
+		entries:   make([]keyEntry, 0, size),
+	}
+	d.hash = func(k string) uint64 {
+		return maphash.String(d.seed, k)
+	}
+	return d
+}
+
+// newCompactKeyDir returns an empty keydir in the compact mode, which reads the keys with the given function.
+func newCompactKeyDir(size int, load func(meta Meta, size int) (string, error)) *keyDir {
+	d := newKeyDir(size)
+	d.compact = true
+	d.load = load
+	d.blooms = make(map[int]*bloom)
+	return d
+}
+
+// len returns the number of keys.
+func (d *keyDir) len() int {
+	n := len(d.entries) - len(d.free)
+	for _, s := range d.spilled {
+		n += s.live
+	}
+	return n
+}
+
+// lookup returns the metadata of the key. It fails if the key of an entry which may be of the key can't be
+// read from its record or index file, since the key can't be told apart from a missing one then.
+func (d *keyDir) lookup(k string) (Meta, bool, error) {
+	h := d.hash(k)
+	i, err := d.slot(k, h, 0)
 	if err != nil {
-		return err
+		return Meta{}, false, err
+	}
+	if i != noEntry {
+		return d.entries[i].meta, true, nil
 	}
-	defer file.Close()
+	s, _, meta, err := d.findSpilled(k, h)
+	if err != nil {
+		return Meta{}, false, err
+	}
+	return meta, s != nil, nil
+}
 
-	// Create a new gob encoder.
-	encoder := gob.NewEncoder(file)
+// keyspaceLen returns the number of keys except the reserved keys.
+func (d *keyDir) keyspaceLen() int {
+	return d.len() - d.reserved
+}
 
-	// Encode the map and save it to the file.
-	err = encoder.Encode(k)
+// get is same as lookup but reports the error and treats the key as missing,
+// for the callers which only skip or account the keys.
+func (d *keyDir) get(k string) (Meta, bool) {
+	meta, ok, err := d.lookup(k)
 	if err != nil {
-		return err
+		d.report(err)
 	}
+	return meta, ok
+}
 
-	return nil
+// meta returns the metadata of the key, or the zero Meta if it's missing.
+func (d *keyDir) meta(k string) Meta {
+	meta, _ := d.get(k)
+	return meta
 }
 
-// Decode decodes the gob data in the map.
-func (k *KeyDir) Decode(fPath string) error {
-	// Open the file for decoding gob data.
-	file, err := os.Open(fPath)
+// set sets the metadata of the key and returns the key interned in the keydir.
+// In the compact mode, the key isn't interned and is returned as it is.
+// It fails like lookup without changing the keydir, so that the key isn't added twice.
+func (d *keyDir) set(k string, meta Meta) (string, error) {
+	var (
+		h  = d.hash(k)
+		bh uint64
+	)
+	if d.compact {
+		bh = d.bloomHash(k)
+		d.addBloom(meta.FileID, bh)
+	}
+	i, err := d.slot(k, h, bh)
 	if err != nil {
-		return err
+		return k, err
+	}
+	if i != noEntry {
+		d.entries[i].meta = meta
+		if d.compact {
+			return k, nil
+		}
+		return d.key(&d.entries[i])
+	}
+	s, pos, _, err := d.findSpilled(k, h)
+	if err != nil {
+		return k, err
+	}
+	if s != nil {
+		s.remove(pos)
+	} else if isReserved(k) {
+		d.reserved++
 	}
-	defer file.Close()
 
-	// Create a new gob decoder.
-	decoder := gob.NewDecoder(file)
+	e := keyEntry{meta: meta, size: uint32(len(k)), next: noEntry}
+	if !d.compact {
+		e.chunk, e.off = d.intern(k)
+		d.live += len(k)
+	}
+	if head, ok := d.index[h]; ok {
+		e.next = head
+	}
+
+	if n := len(d.free); n > 0 {
+		i, d.free = d.free[n-1], d.free[:n-1]
+		d.entries[i] = e
+	} else {
+		i = int32(len(d.entries))
+		d.entries = append(d.entries, e)
+	}
+	d.index[h] = i
+
+	if d.compact {
+		return k, nil
+	}
+	return d.key(&d.entries[i])
+}
 
-	// Decode the file to the map.
-	err = decoder.Decode(k)
+// delete removes the key. It fails like lookup without changing the keydir.
+func (d *keyDir) delete(k string) error {
+	h := d.hash(k)
+	ok, err := d.unlink(k, h)
 	if err != nil {
 		return err
 	}
-
+	if !ok {
+		s, pos, _, err := d.findSpilled(k, h)
+		if err != nil || s == nil {
+			return err
+		}
+		s.remove(pos)
+	}
+	if isReserved(k) {
+		d.reserved--
+	}
 	return nil
 }
+
+// unlink removes the entry of the key with the given hash from memory, and returns false if it's missing.
+func (d *keyDir) unlink(k string, h uint64) (bool, error) {
+	head, ok := d.index[h]
+	if !ok {
+		return false, nil
+	}
+
+	var bh uint64
+	if d.compact {
+		bh = d.bloomHash(k)
+	}
+	for i, prev := head, int32(noEntry); i != noEntry; prev, i = i, d.entries[i].next {
+		e := &d.entries[i]
+		if ok, err := d.matches(e, k, bh); err != nil || !ok {
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// Unlink the entry from the entries of the hash.
+		switch {
+		case prev != noEntry:
+			d.entries[prev].next = e.next
+		case e.next != noEntry:
+			d.index[h] = e.next
+		default:
+			delete(d.index, h)
+		}
+
+		if !d.compact {
+			d.live -= int(e.size)
+			d.dead += int(e.size)
+		}
+		*e = keyEntry{next: freeEntry}
+		d.free = append(d.free, i)
+
+		if d.dead >= keyChunkSize && d.dead > d.live {
+			d.compactKeys()
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// each calls the function with each key and its metadata till it returns false. Like the iteration
+// of a map, it starts at a random key, and the keys set during the iteration may not be visited.
+// In the compact mode, each key is read from its record, and the keys which can't be read are reported and skipped.
+func (d *keyDir) each(fn func(k string, meta Meta) bool) {
+	d.eachEntry(func(e *keyEntry) bool {
+		k, err := d.key(e)
+		if err != nil {
+			d.report(err)
+			return true
+		}
+		return fn(k, e.meta)
+	})
+}
+
+// eachMeta is same as each but only visits the metadata, which doesn't read the keys in the compact mode.
+func (d *keyDir) eachMeta(fn func(meta Meta) bool) {
+	d.eachEntry(func(e *keyEntry) bool {
+		return fn(e.meta)
+	})
+}
+
+// eachEntry calls the function with each entry till it returns false, starting at a random entry
+// in memory. The spilled entries are visited after the ones in memory.
+func (d *keyDir) eachEntry(fn func(e *keyEntry) bool) {
+	if n := len(d.entries); n > 0 {
+		start := rand.Intn(n)
+		for j := 0; j < n; j++ {
+			e := d.entries[(start+j)%n]
+			if e.next == freeEntry {
+				continue
+			}
+			if !fn(&e) {
+				return
+			}
+		}
+	}
+
+	done := false
+	for _, s := range d.spilled {
+		err := s.each(func(_ uint64, e *keyEntry) bool {
+			done = !fn(e)
+			return !done
+		})
+		if err != nil {
+			d.report(err)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// size returns the approximate memory used by the keydir.
+func (d *keyDir) size() int {
+	size := cap(d.entries)*int(unsafe.Sizeof(keyEntry{})) + cap(d.free)*int(unsafe.Sizeof(int32(0)))
+	for _, c := range d.chunks {
+		size += cap(c)
+	}
+	for _, f := range d.blooms {
+		size += f.size()
+	}
+	for _, s := range d.spilled {
+		size += s.size()
+	}
+	// The index takes the hash, the slot and about a byte of overhead of the buckets per key.
+	return size + len(d.index)*int(unsafe.Sizeof(uint64(0))+unsafe.Sizeof(int32(0))+1)
+}
+
+// slot returns the slot of the entry of the key with the given hash, or noEntry if it's missing.
+// In the compact mode, the hash of the key for the bloom filters is computed if it's 0.
+func (d *keyDir) slot(k string, h uint64, bh uint64) (int32, error) {
+	i, ok := d.index[h]
+	if !ok {
+		return noEntry, nil
+	}
+	if d.compact && bh == 0 {
+		bh = d.bloomHash(k)
+	}
+	for ; i != noEntry; i = d.entries[i].next {
+		ok, err := d.matches(&d.entries[i], k, bh)
+		if err != nil {
+			return noEntry, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return noEntry, nil
+}
+
+// matches returns true if the entry is of the key. In the compact mode, the key of the entry is read
+// from its record unless the bloom filter of its segment rules out the key with the given hash.
+func (d *keyDir) matches(e *keyEntry, k string, bh uint64) (bool, error) {
+	if e.size != uint32(len(k)) {
+		return false, nil
+	}
+	if d.compact {
+		if f, ok := d.blooms[e.meta.FileID]; ok && !f.has(bh) {
+			d.skipped.Add(1)
+			return false, nil
+		}
+	}
+	ek, err := d.key(e)
+	return ek == k, err
+}
+
+// key returns the key of the entry, which aliases the chunk it's interned in.
+// In the compact mode or if the entry is spilled, it's read from the record of the entry instead.
+func (d *keyDir) key(e *keyEntry) (string, error) {
+	if d.compact || e.next == spilledEntry {
+		d.probes.Add(1)
+		return d.load(e.meta, int(e.size))
+	}
+	b := d.chunks[e.chunk][e.off : e.off+e.size]
+	return *(*string)(unsafe.Pointer(&b)), nil
+}
+
+// bloomHash returns the hash of the key for the bloom filters.
+func (d *keyDir) bloomHash(k string) uint64 {
+	return maphash.String(d.bloomSeed, k)
+}
+
+// addBloom adds the key with the given hash to the bloom filter of the segment. The filter of a new segment
+// is sized for the keys of the largest segment so far, since the segments are rotated at the same size.
+func (d *keyDir) addBloom(id int, bh uint64) {
+	f, ok := d.blooms[id]
+	if !ok {
+		keys := 0
+		for _, other := range d.blooms {
+			if other.keys > keys {
+				keys = other.keys
+			}
+		}
+		f = newBloom(keys)
+		d.blooms[id] = f
+	}
+	f.add(bh)
+}
+
+// forget drops the bloom filter and the spilled entries of the segment once it's removed.
+func (d *keyDir) forget(id int) {
+	delete(d.blooms, id)
+	for i, s := range d.spilled {
+		if s.id == id {
+			if err := s.close(); err != nil {
+				d.report(err)
+			}
+			d.spilled = append(d.spilled[:i], d.spilled[i+1:]...)
+			return
+		}
+	}
+}
+
+// inMemory returns the number of the entries in memory of each segment.
+func (d *keyDir) inMemory() map[int]int {
+	counts := make(map[int]int)
+	for i := range d.entries {
+		if d.entries[i].next != freeEntry {
+			counts[d.entries[i].meta.FileID]++
+		}
+	}
+	return counts
+}
+
+// close removes the index files of the spilled entries, once the keydir is replaced.
+func (d *keyDir) close() {
+	for _, s := range d.spilled {
+		if err := s.close(); err != nil {
+			d.report(err)
+		}
+	}
+	d.spilled = nil
+}
+
+// report reports an error reading the keys or the index files, which isn't returned to the caller.
+func (d *keyDir) report(err error) {
+	if d.onError != nil {
+		d.onError(err)
+	}
+}
+
+// rebuild rebuilds the index and the entries with the given number of entries in memory which are retained
+// by the function, so that the memory of the others is released, which isn't with the slots of the deleted entries.
+func (d *keyDir) rebuild(size int, retain func(e *keyEntry) bool) {
+	var (
+		index   = make(map[uint64]int32, size)
+		entries = make([]keyEntry, 0, size)
+	)
+	for h, head := range d.index {
+		for i := head; i != noEntry; i = d.entries[i].next {
+			e := d.entries[i]
+			if !retain(&e) {
+				if !d.compact {
+					d.live -= int(e.size)
+					d.dead += int(e.size)
+				}
+				continue
+			}
+			e.next = noEntry
+			if prev, ok := index[h]; ok {
+				e.next = prev
+			}
+			index[h] = int32(len(entries))
+			entries = append(entries, e)
+		}
+	}
+	d.index, d.entries, d.free = index, entries, nil
+
+	if d.dead >= keyChunkSize && d.dead > d.live {
+		d.compactKeys()
+	}
+}
+
+// intern copies the key to the last chunk, or to a new chunk if it doesn't fit,
+// and returns the position of the copy.
+func (d *keyDir) intern(k string) (uint32, uint32) {
+	last := len(d.chunks) - 1
+	if last < 0 || cap(d.chunks[last])-len(d.chunks[last]) < len(k) {
+		size := keyChunkSize
+		if len(k) > size {
+			size = len(k)
+		}
+		d.chunks = append(d.chunks, make([]byte, 0, size))
+		last++
+	}
+
+	off := len(d.chunks[last])
+	d.chunks[last] = append(d.chunks[last], k...)
+	return uint32(last), uint32(off)
+}
+
+// compactKeys copies the live keys to new chunks, so that the chunks holding the deleted keys are
+// released once the keys returned from them aren't referenced anymore.
+func (d *keyDir) compactKeys() {
+	old := d.chunks
+	d.chunks, d.dead = nil, 0
+	for i := range d.entries {
+		e := &d.entries[i]
+		if e.next == freeEntry {
+			continue
+		}
+		k := old[e.chunk][e.off : e.off+e.size]
+		e.chunk, e.off = d.intern(*(*string)(unsafe.Pointer(&k)))
+	}
+}
@@ -0,0 +1,352 @@
+package barrel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+const (
+	MANIFEST_FILE = "barrel.manifest"
+
+	manifestVersion = 2
+)
+
+// SegmentInfo describes a live datafile as recorded in the manifest.
+type SegmentInfo struct {
+	ID       int    `json:"id"`
+	FirstSeq uint64 `json:"first_seq"`          // Sequence number of the first record written to the datafile.
+	EndSeq   uint64 `json:"end_seq"`            // Sequence number after the last record, equal to FirstSeq if it's empty.
+	MinTime  int64  `json:"min_time,omitempty"` // Unix timestamp of the oldest record, 0 if unknown.
+	MaxTime  int64  `json:"max_time,omitempty"` // Unix timestamp of the newest record, 0 if unknown.
+	Records  uint64 `json:"records,omitempty"`  // Number of records in the datafile, 0 if unknown.
+	MinKey   string `json:"min_key,omitempty"`  // Smallest key of the records, empty if unknown.
+	MaxKey   string `json:"max_key,omitempty"`  // Largest key of the records, empty if unknown.
+	// Number of records at the start of the datafile rewritten by a merge, which replace the records from FirstSeq
+	// upto AppendSeq, the sequence number of the first record appended after them.
+	Merged    uint64 `json:"merged,omitempty"`
+	AppendSeq uint64 `json:"append_seq,omitempty"`
+
+	LiveBytes int `json:"-"` // Size of the latest records of the keys in the datafile, only reported by Segments.
+	DeadBytes int `json:"-"` // Size of the records overwritten or deleted since, only reported by Segments.
+}
+
+// seqRange represents the sequence numbers of the records written to a datafile, end exclusive.
+// The records rewritten by a merge at the start of a datafile replace the records from first upto base,
+// and the records appended after them are numbered from base.
+type seqRange struct {
+	first  uint64
+	end    uint64
+	merged uint64 // Number of records rewritten by a merge, which only retains the latest records of the keys.
+	base   uint64 // Sequence number of the first record after the merged records, which is first if there are none.
+}
+
+// segmentStats represents the number of the records in a datafile and the range of their keys.
+type segmentStats struct {
+	records uint64
+	minKey  string
+	maxKey  string
+}
+
+// add counts a record of the given key.
+func (s segmentStats) add(k string) segmentStats {
+	if s.records == 0 || k < s.minKey {
+		s.minKey = k
+	}
+	if s.records == 0 || k > s.maxKey {
+		s.maxKey = k
+	}
+	s.records++
+	return s
+}
+
+// manifest records the set of the live datafiles in the directory, which is the authoritative list of
+// the datafiles loaded on startup. It's replaced atomically whenever the datafiles are added or removed,
+// so that a crash never leaves a mix of the datafiles from before and after a merge. The datafiles which
+// aren't listed are left over by a merge or a cleanup which was interrupted, and are removed on startup.
+// Directories written before the manifest was introduced don't have one, and all their datafiles are live.
+//
+// Every record written is numbered with a sequence number, and the manifest records the range of the
+// sequence numbers, the timestamps and the keys of each datafile along with the number of its records,
+// so that the datafiles irrelevant to a scan can be skipped without being read. The ranges of the active
+// datafile are only final if the manifest is written on shutdown, otherwise they're recovered by scanning
+// the datafile on startup.
+type manifest struct {
+	Version  int           `json:"version"`
+	Segments []SegmentInfo `json:"segments"`        // Live datafiles in increasing order of their IDs.
+	NextSeq  uint64        `json:"next_seq"`        // Sequence number of the next record.
+	Clean    bool          `json:"clean"`           // Whether it's written on shutdown, after the last write.
+	Files    []int         `json:"files,omitempty"` // IDs of the live datafiles, only in version 1.
+}
+
+// loadManifest reads the manifest of the directory. It returns nil if the directory doesn't have one.
+func loadManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	// The first version only lists the IDs, so the ranges of the datafiles are unknown.
+	if m.Version < 2 {
+		for _, id := range m.Files {
+			m.Segments = append(m.Segments, SegmentInfo{ID: id})
+		}
+		m.Files = nil
+	}
+	sort.Slice(m.Segments, func(i, j int) bool { return m.Segments[i].ID < m.Segments[j].ID })
+
+	return &m, nil
+}
+
+// lists returns true if the datafile with the given ID is listed in the manifest.
+func (m *manifest) lists(id int) bool {
+	i := sort.Search(len(m.Segments), func(i int) bool { return m.Segments[i].ID >= id })
+	return i < len(m.Segments) && m.Segments[i].ID == id
+}
+
+// lastID returns the ID of the latest datafile listed in the manifest, or -1 if there's none.
+func (m *manifest) lastID() int {
+	if len(m.Segments) == 0 {
+		return -1
+	}
+	return m.Segments[len(m.Segments)-1].ID
+}
+
+// openListed opens the datafile with the given ID listed in the manifest, which is either
+// in the barrel format or written by Bitcask.
+func openListed(opts *Options, id int, pool *datafile.Pool) (datafile.Storage, error) {
+	if opts.bitcaskCompat && !exists(filepath.Join(opts.dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, id))) &&
+		exists(filepath.Join(opts.dir, fmt.Sprintf(datafile.BITCASK_DATAFILE, id))) {
+		df, err := datafile.OpenBitcask(opts.dir, id, pool)
+		if err != nil {
+			return nil, err
+		}
+		return df, nil
+	}
+
+	df, err := opts.backend.Open(opts.dir, id, pool)
+	if err != nil {
+		return nil, fmt.Errorf("error opening datafile %d listed in the manifest: %w", id, err)
+	}
+	return df, nil
+}
+
+// writeManifest replaces the manifest of the directory.
+// The new manifest is written to a temporary file, which is synced and renamed over the manifest,
+// and the directory is synced so that the rename is durable.
+func writeManifest(dir string, m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var (
+		path    = filepath.Join(dir, MANIFEST_FILE)
+		tmpPath = path + ".tmp"
+	)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating manifest: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing manifest: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing manifest: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// newManifest returns the manifest listing the datafiles with the given IDs, along with their
+// sequence and time ranges as far as they're known.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) newManifest(ids []int) *manifest {
+	ids = append([]int(nil), ids...)
+	sort.Ints(ids)
+
+	m := &manifest{Version: manifestVersion, NextSeq: b.seq, Segments: make([]SegmentInfo, 0, len(ids))}
+	for _, id := range ids {
+		m.Segments = append(m.Segments, b.segmentInfo(id))
+	}
+	return m
+}
+
+// segmentInfo returns the sequence and time ranges of the datafile with the given ID.
+// A datafile without any records written since the sequence numbers were tracked starts at the next sequence number.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) segmentInfo(id int) SegmentInfo {
+	s := SegmentInfo{ID: id, FirstSeq: b.seq, EndSeq: b.seq}
+	if r, ok := b.seqRanges[id]; ok {
+		s.FirstSeq, s.EndSeq = r.first, r.end
+		if r.merged > 0 {
+			s.Merged, s.AppendSeq = r.merged, r.base
+		}
+	}
+	if tr, ok := b.timeRanges[id]; ok {
+		s.MinTime, s.MaxTime = int64(tr.min), int64(tr.max)
+	}
+	if st, ok := b.stats[id]; ok {
+		s.Records, s.MinKey, s.MaxKey = st.records, st.minKey, st.maxKey
+	}
+	return s
+}
+
+// saveManifest replaces the manifest with the active datafile, the older datafiles
+// and the given new datafiles.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) saveManifest(added ...int) error {
+	ids := append(sortedIDs(b.stale), b.df.ID())
+	return writeManifest(b.opts.dir, b.newManifest(append(ids, added...)))
+}
+
+// seqRange returns the range of the sequence numbers of the records of the datafile.
+func (s SegmentInfo) seqRange() seqRange {
+	r := seqRange{first: s.FirstSeq, end: s.EndSeq, base: s.FirstSeq}
+	if s.Merged > 0 {
+		r.merged, r.base = s.Merged, s.AppendSeq
+	}
+	return r
+}
+
+// trackSeq numbers a newly written record of the datafile with the next sequence number.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) trackSeq(id int) {
+	r, ok := b.seqRanges[id]
+	if !ok {
+		r = seqRange{first: b.seq, base: b.seq}
+	}
+	b.seq++
+	r.end = b.seq
+	b.seqRanges[id] = r
+}
+
+// trackKey counts a newly written record of the key in the datafile.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) trackKey(id int, k string) {
+	b.stats[id] = b.stats[id].add(k)
+}
+
+// loadSegments restores the sequence, time and key ranges of the datafiles from the manifest.
+// The active datafile of the previous run is scanned unless the manifest is written on shutdown,
+// since its records written after the manifest aren't recorded in it.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) loadSegments(m *manifest) error {
+	b.seq = m.NextSeq
+	for _, s := range m.Segments {
+		if s.EndSeq > s.FirstSeq {
+			b.seqRanges[s.ID] = s.seqRange()
+		}
+		if s.MaxTime > 0 {
+			b.timeRanges[s.ID] = timeRange{min: uint32(s.MinTime), max: uint32(s.MaxTime)}
+		}
+		if s.Records > 0 {
+			b.stats[s.ID] = segmentStats{records: s.Records, minKey: s.MinKey, maxKey: s.MaxKey}
+		}
+	}
+	if m.Clean || len(m.Segments) == 0 {
+		return nil
+	}
+
+	last := m.Segments[len(m.Segments)-1]
+	df, ok := b.stale[last.ID]
+	if !ok && b.df.ID() == last.ID {
+		df = b.df
+	}
+	if df == nil {
+		return nil
+	}
+
+	var (
+		count   uint64
+		scanned timeRange
+		stats   segmentStats
+	)
+	err := scanDF(df, 0, func(r Record, _, _ int) error {
+		if count == 0 {
+			scanned = timeRange{min: r.Header.Timestamp, max: r.Header.Timestamp}
+		}
+		scanned = scanned.extend(r.Header.Timestamp)
+		stats = stats.add(r.Key)
+		count++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning datafile %d: %w", last.ID, err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	// The records are numbered from the start of the datafile, which is recorded when it's created,
+	// or from the end of the merged records.
+	r := last.seqRange()
+	if count > r.merged {
+		r.end = r.base + count - r.merged
+	}
+	b.seqRanges[last.ID] = r
+	b.timeRanges[last.ID] = scanned
+	b.stats[last.ID] = stats
+	if r.end > b.seq {
+		b.seq = r.end
+	}
+	return nil
+}
+
+// Segments returns the sequence and time ranges and the live and dead bytes of the live datafiles,
+// including the active datafile, in increasing order of their IDs.
+func (b *Barrel) Segments() []SegmentInfo {
+	b.Lock()
+	defer b.Unlock()
+
+	// In the read-only mode, the active datafile isn't backed by a file if the directory is empty.
+	ids := sortedIDs(b.stale)
+	if !b.opts.readOnly || exists(b.df.Path()) {
+		ids = append(ids, b.df.ID())
+	}
+	segments := b.newManifest(ids).Segments
+	for i, s := range segments {
+		if u, ok := b.usage[s.ID]; ok {
+			segments[i].LiveBytes, segments[i].DeadBytes = u.live, u.dead()
+		}
+	}
+	return segments
+}
+
+// removeUnlisted removes the datafiles and their hints which aren't listed in the manifest.
+func removeUnlisted(dir string, m *manifest, ids []int) error {
+	for _, id := range ids {
+		if m.lists(id) {
+			continue
+		}
+		for _, path := range []string{filepath.Join(dir, fmt.Sprintf(datafile.ACTIVE_DATAFILE, id)), hintsPath(dir, id)} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package barrel
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+The metadata section of a record stores the user-defined metadata and the tags of the key.
+The sizes and the number of tags are encoded as unsigned varints (LEB128).
+
+Representation of the metadata section of a record.
+------------------------------------------------------------------------------
+| meta_size | meta | tags_count | tag_size | tag | ... | tag_size | tag      |
+------------------------------------------------------------------------------
+*/
+
+// encodeMeta encodes the user-defined metadata and the tags in the metadata section of a record.
+// It returns nil if both are empty, in which case the record doesn't have a metadata section.
+func encodeMeta(meta []byte, tags []string) []byte {
+	if len(meta) == 0 && len(tags) == 0 {
+		return nil
+	}
+
+	data := make([]byte, 0, binary.MaxVarintLen32*(2+len(tags))+len(meta))
+	data = binary.AppendUvarint(data, uint64(len(meta)))
+	data = append(data, meta...)
+	data = binary.AppendUvarint(data, uint64(len(tags)))
+	for _, tag := range tags {
+		data = binary.AppendUvarint(data, uint64(len(tag)))
+		data = append(data, tag...)
+	}
+
+	return data
+}
+
+// decodeMeta decodes the user-defined metadata and the tags from the metadata section of a record.
+// The metadata points to the given data, so it's only valid as long as the data is.
+func decodeMeta(data []byte) ([]byte, []string, error) {
+	if len(data) == 0 {
+		return nil, nil, nil
+	}
+
+	var (
+		meta []byte
+		tags []string
+	)
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return nil, nil, ErrInvalidRecord
+	}
+	if size > 0 {
+		meta = data[n : n+int(size)]
+	}
+	data = data[n+int(size):]
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)-n) {
+		return nil, nil, ErrInvalidRecord
+	}
+	data = data[n:]
+	for i := 0; i < int(count); i++ {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, nil, ErrInvalidRecord
+		}
+		tags = append(tags, string(data[n:n+int(size)]))
+		data = data[n+int(size):]
+	}
+
+	return meta, tags, nil
+}
+
+// setMeta sets the metadata section of the record and decodes it. A malformed section is
+// left undecoded here, since it fails the checksum validation of the record.
+func (r *Record) setMeta(data []byte) {
+	r.rawMeta = data
+	r.Meta, r.Tags, _ = decodeMeta(data)
+}
+
+// PutWithTags is same as Put but also associates the given tags with the key,
+// so that it's returned by KeysByTag for any of them. The tags are replaced on every write of the key.
+func (b *Barrel) PutWithTags(k string, val []byte, tags []string) (err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate key, value and tags.
+	if err = b.validateKV(k, val); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			return ErrEmptyTag
+		}
+	}
+	meta := encodeMeta(nil, tags)
+	if len(meta) > MaxMetaSize {
+		b.oversized.Add(1)
+		return fmt.Errorf("%w: %d bytes", ErrLargeMeta, len(meta))
+	}
+
+	b.lo.Debug("storing data with tags", "key", k, "val", val, "tags", tags)
+	return b.hookedPut(k, val, meta, nil)
+}
+
+// KeysByTag returns the keys which have the given tag, sorted by the keys. Expired keys aren't returned.
+func (b *Barrel) KeysByTag(tag string) []string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.liveKeys(b.tags.keys[tag])
+}
@@ -0,0 +1,255 @@
+package barrel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MirrorTarget is the target of the mirrored writes. A Barrel opened on another directory
+// can be used as a target, or a RemoteTarget for a remote server.
+type MirrorTarget interface {
+	Put(k string, val []byte) error
+	PutEx(k string, val []byte, ex time.Duration) error
+	Delete(k string) error
+}
+
+// mirrorOp is a write queued to be mirrored.
+type mirrorOp struct {
+	key    string
+	val    []byte
+	expiry *time.Time
+	delete bool
+	seq    uint64    // Offset of the log after the write.
+	queued time.Time // Time at which the write was queued.
+}
+
+// mirror mirrors the writes to a target asynchronously.
+type mirror struct {
+	target MirrorTarget
+	queue  chan mirrorOp
+
+	mirrored atomic.Uint64 // Number of writes mirrored to the target.
+	failed   atomic.Uint64 // Number of writes which failed on the target.
+	dropped  atomic.Uint64 // Number of writes dropped since the queue was full.
+	offset   atomic.Uint64 // Offset of the log upto which the writes are processed.
+	pending  atomic.Int64  // Time in nanoseconds at which the write being processed was queued, 0 if there's none.
+	failing  atomic.Bool   // Whether the last write failed on the target.
+}
+
+// newMirror returns a mirror which is in sync with the log upto the given offset, since the
+// earlier writes aren't mirrored.
+func newMirror(target MirrorTarget, queueSize int, offset uint64) *mirror {
+	m := &mirror{
+		target: target,
+		queue:  make(chan mirrorOp, queueSize),
+	}
+	m.offset.Store(offset)
+	return m
+}
+
+// lag returns the time since the write being mirrored was queued, which is 0 if the mirror is caught up.
+func (m *mirror) lag(now time.Time) time.Duration {
+	pending := m.pending.Load()
+	if pending == 0 {
+		return 0
+	}
+	if lag := now.Sub(time.Unix(0, pending)); lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// enqueue queues the write without blocking. It's dropped if the queue is full.
+func (m *mirror) enqueue(op mirrorOp) {
+	select {
+	case m.queue <- op:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// RunMirror writes the queued writes to the mirror target in the order they're written.
+func (b *Barrel) RunMirror() {
+	for {
+		var op mirrorOp
+		select {
+		case op = <-b.mirror.queue:
+		case <-b.done:
+			return
+		}
+		b.mirror.pending.Store(op.queued.UnixNano())
+		err := b.mirrorWrite(op)
+		b.mirror.offset.Store(op.seq)
+		b.mirror.pending.Store(0)
+
+		b.mirror.failing.Store(err != nil)
+		if err != nil {
+			b.lo.Error("error mirroring write", "key", op.key, "error", err)
+			b.mirror.failed.Add(1)
+			continue
+		}
+		b.mirror.mirrored.Add(1)
+	}
+}
+
+// mirrorWrite writes the queued write to the mirror target.
+func (b *Barrel) mirrorWrite(op mirrorOp) error {
+
+	switch {
+	case op.delete:
+		return b.mirror.target.Delete(op.key)
+	case op.expiry != nil:
+		// Skip the keys which have expired while they were queued.
+		ex := op.expiry.Sub(b.now())
+		if ex <= 0 {
+			return nil
+		}
+		return b.mirror.target.PutEx(op.key, op.val, ex)
+	default:
+		return b.mirror.target.Put(op.key, op.val)
+	}
+}
+
+// mirrorPut queues the write of the key to be mirrored, if enabled.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) mirrorPut(k string, val []byte, expiry *time.Time) {
+	if b.mirror == nil {
+		return
+	}
+	// Copy the value since the caller may reuse it.
+	b.mirror.enqueue(mirrorOp{key: k, val: append([]byte(nil), val...), expiry: expiry, seq: b.seq, queued: b.now()})
+}
+
+// mirrorDelete queues the delete of the key to be mirrored, if enabled.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) mirrorDelete(k string) {
+	if b.mirror == nil {
+		return
+	}
+	b.mirror.enqueue(mirrorOp{key: k, delete: true, seq: b.seq, queued: b.now()})
+}
+
+// RemoteTarget is a MirrorTarget which writes to a remote server over RESP
+// using the `SET` and `DEL` commands. It reconnects on errors.
+type RemoteTarget struct {
+	sync.Mutex
+
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+	r       *bufio.Reader
+}
+
+// NewRemoteTarget returns a target for the server at the given address. Each command
+// including connecting to the server times out after the given duration.
+func NewRemoteTarget(addr string, timeout time.Duration) *RemoteTarget {
+	return &RemoteTarget{addr: addr, timeout: timeout}
+}
+
+// Put sets the value of the key on the remote server.
+func (t *RemoteTarget) Put(k string, val []byte) error {
+	return t.do("SET", []byte(k), val)
+}
+
+// PutEx sets the value of the key on the remote server with an expiry.
+func (t *RemoteTarget) PutEx(k string, val []byte, ex time.Duration) error {
+	return t.do("SET", []byte(k), val, []byte(ex.String()))
+}
+
+// Delete deletes the key on the remote server.
+func (t *RemoteTarget) Delete(k string) error {
+	return t.do("DEL", []byte(k))
+}
+
+// Close closes the connection to the remote server, if any.
+func (t *RemoteTarget) Close() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// do sends the command to the remote server and reads its reply.
+func (t *RemoteTarget) do(cmd string, args ...[]byte) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.conn == nil {
+		conn, err := net.DialTimeout("tcp", t.addr, t.timeout)
+		if err != nil {
+			return err
+		}
+		t.conn, t.r = conn, bufio.NewReader(conn)
+	}
+
+	err := t.roundTrip(cmd, args)
+	if err != nil && !errors.Is(err, errRemote) {
+		// Reconnect on the next command since the connection is in an unknown state.
+		t.conn.Close()
+		t.conn = nil
+	}
+	return err
+}
+
+// errRemote is the error returned by the remote server for a command.
+var errRemote = errors.New("remote error")
+
+// roundTrip writes the command as a RESP array and reads a single reply.
+func (t *RemoteTarget) roundTrip(cmd string, args [][]byte) error {
+	if err := t.conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range append([][]byte{[]byte(cmd)}, args...) {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := t.conn.Write(buf); err != nil {
+		return err
+	}
+
+	line, err := t.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return fmt.Errorf("invalid reply: %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '-':
+		return fmt.Errorf("%w: %s", errRemote, line[1:])
+	case '$':
+		// Discard the contents of the bulk string, if any.
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("invalid reply: %q", line)
+		}
+		if n >= 0 {
+			if _, err := t.r.Discard(n + 2); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
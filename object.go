@@ -0,0 +1,79 @@
+package barrel
+
+import (
+	"time"
+)
+
+// Types of the values reported by Inspect.
+const (
+	TypeString = "string"
+	TypeStream = "stream"
+)
+
+// Encodings of the values reported by Inspect.
+const (
+	EncodingRaw        = "raw"
+	EncodingCompressed = "compressed"
+)
+
+// KeyInfo describes how the value of a key is stored, like `TYPE` and `OBJECT` of Redis.
+type KeyInfo struct {
+	Type     string        // Type of the value, either TypeString or TypeStream.
+	Encoding string        // Encoding of the value on disk, either EncodingRaw or EncodingCompressed.
+	Accessed time.Time     // Time of the last read or write of the key. It's the time of the last write if the key isn't accessed since the startup.
+	Written  time.Time     // Time of the last write of the key.
+	Expiry   time.Time     // Time at which the key expires. It's zero if the key doesn't expire.
+	Idle     time.Duration // Time since the last access of the key, as per the clock of the barrel.
+
+	FileID   int    // ID of the datafile of the latest record of the key.
+	Offset   int    // Offset of the record in the datafile.
+	Size     int    // Size of the record in bytes.
+	Checksum uint32 // Checksum of the record.
+}
+
+// Inspect returns how the value of the key is stored. The key can also be the name of a stream,
+// in which case its last entry is inspected. Unlike the reads, it doesn't count as an access of the key.
+// It returns ErrKeyNotFound if the key doesn't exist.
+func (b *Barrel) Inspect(k string) (KeyInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.applyTouches()
+
+	info := KeyInfo{Type: TypeString}
+	if ids := b.streams[k]; len(ids) > 0 {
+		info.Type = TypeStream
+		k = streamKey(k, ids[len(ids)-1])
+	}
+
+	record, err := b.get(k)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if record.isExpired(b.now()) {
+		return KeyInfo{}, ErrExpiredKey
+	}
+
+	info.Encoding = EncodingRaw
+	if record.Header.Flags&flagCompressed != 0 {
+		info.Encoding = EncodingCompressed
+	}
+
+	meta := b.keydir.meta(k)
+	info.FileID = meta.FileID
+	info.Offset = meta.RecordPos - meta.RecordSize
+	info.Size = meta.RecordSize
+	info.Checksum = record.Header.Checksum
+	info.Written = time.Unix(int64(meta.Timestamp), 0)
+	info.Accessed = info.Written
+	if exp := record.Header.expiry(); exp != nil {
+		info.Expiry = *exp
+	}
+	if meta.Accessed != 0 {
+		info.Accessed = time.Unix(int64(meta.Accessed), 0)
+	}
+	if idle := b.now().Sub(info.Accessed); idle > 0 {
+		info.Idle = idle
+	}
+
+	return info, nil
+}
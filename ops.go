@@ -2,70 +2,172 @@ package barrel
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"hash/crc32"
+	"syscall"
 	"time"
 
-	"github.com/deepgolani4/LogVaultDB/internal/datafile/internal/datafile"
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
 )
 
 func (b *Barrel) get(k string) (Record, error) {
+	return b.getInto(k, nil)
+}
+
+// getInto is same as get but reads the record into the buffer of the ref, if any, as read does.
+func (b *Barrel) getInto(k string, ref *ValueRef) (Record, error) {
 	// Check for entry in KeyDir.
-	meta, ok := b.keydir[k]
+	meta, ok, err := b.keydir.lookup(k)
+	if err != nil {
+		return Record{}, err
+	}
 	if !ok {
-		return Record{}, ErrNoKey
+		if _, ok := b.quarantined[k]; ok {
+			return Record{}, ErrChecksumMismatch
+		}
+		b.keyMisses.Add(1)
+		return Record{}, ErrKeyNotFound
 	}
 
-	var (
-		// Header object for decoding the binary data into it.
-		header Header
-		reader *datafile.DataFile
-	)
+	reader, err := b.reader(meta.FileID)
+	if err != nil {
+		return Record{}, err
+	}
 
-	// Set the current file ID as the default.
-	reader = b.df
+	return b.read(k, reader, meta, ref)
+}
 
-	// Check if the ID is different from the current ID.
-	if meta.FileID != b.df.ID() {
-		reader, ok = b.stale[meta.FileID]
-		if !ok {
-			return Record{}, fmt.Errorf("error looking up for the db file for the given id: %d", meta.FileID)
+// read reads the record of the key from the datafile at the position in its metadata.
+// Given a ref, the record is read into its buffer, growing it if needed, so that the record aliases
+// the buffer instead of being allocated. The records of the memory-mapped datafiles are pinned by the
+// ref instead, and alias the mapping. The records added to the cache are allocated regardless.
+// It doesn't access the barrel except for the cache, so it's safe to call without the barrel lock.
+func (b *Barrel) read(k string, reader datafile.Storage, meta Meta, ref *ValueRef) (Record, error) {
+	// Read the record from the cache if present, otherwise read the file with the given offset.
+	data, cached := b.cachedRecord(meta)
+	if !cached {
+		var err error
+		if ref != nil && b.cache == nil {
+			data, err = ref.read(reader, meta)
+		} else {
+			data, err = reader.Read(meta.RecordPos, meta.RecordSize)
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("error reading data from file: %w", err)
+		}
+		if b.cache != nil {
+			b.cache.add(cacheKey{fileID: meta.FileID, pos: meta.RecordPos}, data)
 		}
 	}
 
-	// Read the file with the given offset.
-	data, err := reader.Read(meta.RecordPos, meta.RecordSize)
-	if err != nil {
-		return Record{}, fmt.Errorf("error reading data from file: %v", err)
-	}
+	return decodeRecord(k, data, reader.Version())
+}
+
+// decodeRecord decodes the record of the key from its bytes in a datafile of the given version.
+func decodeRecord(k string, data []byte, version int) (Record, error) {
+	var (
+		// Header object for decoding the binary data into it.
+		header Header
+	)
 
 	// Decode the header.
-	if err := header.decode(data); err != nil {
-		return Record{}, fmt.Errorf("error decoding header: %v", err)
+	if _, err := header.decode(data, version); err != nil {
+		return Record{}, fmt.Errorf("error decoding header: %w", err)
 	}
 
 	var (
 		// Get the offset position in record to start reading the value from.
-		valPos = meta.RecordSize - int(header.ValSize)
-		// Read the value from the record.
-		val = data[valPos:]
+		valPos = len(data) - int(header.ValSize)
+		// The metadata, if any, is present right before the value.
+		metaPos = valPos - int(header.MetaSize)
 	)
 
 	record := Record{
 		Header: header,
 		Key:    k,
-		Value:  val,
+		Value:  data[valPos:],
 	}
+	record.setMeta(data[metaPos:valPos])
 
 	return record, nil
 }
 
-func (b *Barrel) put(df *datafile.DataFile, k string, val []byte, expiry *time.Time) error {
+// reader returns the datafile with the given ID, which is either the active datafile or an older one.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) reader(id int) (datafile.Storage, error) {
+	if id == b.df.ID() {
+		return b.df, nil
+	}
+	df, ok := b.stale[id]
+	if !ok {
+		return nil, fmt.Errorf("error looking up for the db file for the given id: %d", id)
+	}
+	return df, nil
+}
+
+// loadKey reads the key of the record for the compact keydir from its datafile, which is either a datafile
+// of the barrel or a merged datafile which isn't swapped in yet.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) loadKey(meta Meta, size int) (string, error) {
+	df, err := b.reader(meta.FileID)
+	if merged, ok := b.merged[meta.FileID]; err != nil && ok {
+		df, err = merged, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	k, err := readKey(df, meta, size)
+	if err != nil {
+		return "", fmt.Errorf("error reading key of datafile %d at %d: %w", meta.FileID, meta.RecordPos, err)
+	}
+	return k, nil
+}
+
+// readKey reads the key of the given size from the record of the metadata, reading only the header and the key.
+func readKey(df datafile.Storage, meta Meta, size int) (string, error) {
+	n := maxHeaderSizeV2 + size
+	if n > meta.RecordSize {
+		n = meta.RecordSize
+	}
+	data, err := df.Read(meta.RecordPos-meta.RecordSize+n, n)
+	if err != nil {
+		return "", fmt.Errorf("error reading data from file: %w", err)
+	}
+
+	var header Header
+	hn, err := header.decode(data, df.Version())
+	if err != nil {
+		return "", fmt.Errorf("error decoding header: %w", err)
+	}
+	if int(header.KeySize) != size || hn+size > len(data) {
+		return "", ErrInvalidRecord
+	}
+	return string(data[hn : hn+size]), nil
+}
+
+// cachedRecord returns the record from the cache, if the cache is enabled.
+func (b *Barrel) cachedRecord(meta Meta) ([]byte, bool) {
+	if b.cache == nil {
+		return nil, false
+	}
+	return b.cache.get(cacheKey{fileID: meta.FileID, pos: meta.RecordPos})
+}
+
+// encodeRecord encodes the record of the key in the buffer and returns its header.
+func (b *Barrel) encodeRecord(buf *bytes.Buffer, k string, val []byte, meta []byte, expiry *time.Time) Header {
+	return b.encodeRecordAt(buf, k, val, meta, expiry, uint32(b.now().Unix()))
+}
+
+// encodeRecordAt is same as encodeRecord but stamps the record with the given write time,
+// which retains the time of the records rewritten by a merge.
+func (b *Barrel) encodeRecordAt(buf *bytes.Buffer, k string, val []byte, meta []byte, expiry *time.Time, ts uint32) Header {
 	// Prepare header.
 	header := Header{
-		Checksum:  crc32.ChecksumIEEE(val),
-		Timestamp: uint32(time.Now().Unix()),
+		Checksum:  b.opts.checksumAlgo.sum(meta, val),
+		Flags:     uint8(b.opts.checksumAlgo),
+		Timestamp: ts,
 		KeySize:   uint32(len(k)),
+		MetaSize:  uint32(len(meta)),
 		ValSize:   uint32(len(val)),
 	}
 
@@ -76,46 +178,153 @@ func (b *Barrel) put(df *datafile.DataFile, k string, val []byte, expiry *time.T
 		header.Expiry = 0
 	}
 
-	// Prepare the record.
-	record := Record{
-		Key:   k,
-		Value: val,
-	}
+	// Encode header.
+	header.encode(buf)
+
+	// Write key/metadata/value.
+	buf.WriteString(k)
+	buf.Write(meta)
+	buf.Write(val)
+
+	return header
+}
 
+func (b *Barrel) put(df datafile.Storage, k string, val []byte, meta []byte, expiry *time.Time) error {
 	// Get the buffer from the pool for writing data.
 	buf := b.bufPool.Get().(*bytes.Buffer)
 	defer b.bufPool.Put(buf)
 	// Resetting the buffer is important since the length of bytes written should be reset on each `set` operation.
 	defer buf.Reset()
 
-	// Encode header.
-	header.encode(buf)
+	header := b.encodeRecord(buf, k, val, meta, expiry)
 
-	// Write key/value.
-	buf.WriteString(k)
-	buf.Write(val)
+	// Keep the namespace of the key within its quota, if any.
+	// Tombstones and the writes of a merge are never rejected.
+	if len(b.quotas) > 0 && df == b.df && len(val) > 0 {
+		if err := b.enforceQuota(k, len(buf.Bytes())); err != nil {
+			return err
+		}
+	}
+
+	// Make room for the record by evicting other keys if the max data size is set.
+	if b.opts.maxDataSize > 0 && df == b.df && len(val) > 0 {
+		n := len(buf.Bytes())
+		if old, ok := b.keydir.get(k); ok {
+			n -= old.RecordSize
+		}
+		if err := b.evict(k, n); err != nil {
+			return err
+		}
+	}
 
 	// Append to underlying file.
 	offset, err := df.Write(buf.Bytes())
 	if err != nil {
-		return fmt.Errorf("error writing data to file: %v", err)
+		return b.writeFailed(df, err)
+	}
+
+	if err := b.apply(df, k, val, meta, header, offset, len(buf.Bytes())); err != nil {
+		return err
+	}
+
+	return b.commitAppend(df, 1)
+}
+
+// writeFailed returns the error of a failed append to the datafile.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) writeFailed(df datafile.Storage, err error) error {
+	// Pause the writes until space is reclaimed instead of failing every write.
+	if df == b.df && errors.Is(err, syscall.ENOSPC) {
+		b.pauseWrites(err)
+		return fmt.Errorf("%w: %v", ErrStorageFull, err)
+	}
+	return fmt.Errorf("error writing data to file: %w", err)
+}
+
+// apply updates the keydir and everything derived from the records with the record of the key
+// written in the datafile at the offset. It fails without updating the keydir if the keys which may match
+// can't be read, e.g. in the compact mode, in which case only the ranges and the size of the datafile are tracked.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) apply(df datafile.Storage, k string, val []byte, meta []byte, header Header, offset, size int) error {
+	// Track the time range, the sequence numbers and the keys of the records in the datafile.
+	b.trackTime(df.ID(), header.Timestamp)
+	b.trackSeq(df.ID())
+	b.trackKey(df.ID(), k)
+	if df == b.df {
+		b.diskBytes += size
+		b.unsynced += size
 	}
+	b.segmentUsage(df.ID()).disk += size
 
 	// Add entry to KeyDir.
 	// We just save the value of key and some metadata for faster lookups.
 	// The value is only stored in disk.
-	b.keydir[k] = Meta{
-		Timestamp:  int(record.Header.Timestamp),
-		RecordSize: len(buf.Bytes()),
-		RecordPos:  offset + len(buf.Bytes()),
+	// Drop the older record of the key from the cache since it can't be read anymore.
+	old, exists, err := b.keydir.lookup(k)
+	if err != nil {
+		return err
+	}
+	km := Meta{
+		Timestamp:  int(header.Timestamp),
+		RecordSize: size,
+		RecordPos:  offset + size,
 		FileID:     df.ID(),
+		Expiry:     int(header.Expiry),
+	}
+	// Retain the access time of the key for the records rewritten by a merge.
+	if exists {
+		km.Accessed = old.Accessed
 	}
+	km.Version = b.seq
+	// Refer to the key interned in the keydir from here on, so that the written key isn't retained.
+	if k, err = b.keydir.set(k, km); err != nil {
+		return err
+	}
+	if exists {
+		if b.cache != nil {
+			b.cache.remove(cacheKey{fileID: old.FileID, pos: old.RecordPos})
+		}
+		b.liveBytes -= old.RecordSize
+		b.segmentUsage(old.FileID).live -= old.RecordSize
+		b.account(k, -1, -old.RecordSize)
+	}
+	b.sortKey(k)
+	b.liveBytes += km.RecordSize
+	b.segmentUsage(df.ID()).live += km.RecordSize
+	b.account(k, 1, km.RecordSize)
+
+	// Record the key in the hints of the active datafile.
+	if df == b.df {
+		// The tags are in the metadata section, which was validated by the writer.
+		_, tags, _ := decodeMeta(meta)
+		b.activeHints.add(k, km, tags, len(val) == 0)
+		b.tags.set(k, tags)
+		b.updateIndexes(k, val)
+		b.touch(k)
+		delete(b.quarantined, k)
+	}
+	return nil
+}
 
-	// Ensure filesystem's in memory buffer is flushed to disk.
-	if b.opts.alwaysFSync {
+// commitAppend completes the append of the given number of records to the datafile.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) commitAppend(df datafile.Storage, n int) error {
+	// Ensure filesystem's in memory buffer is flushed to disk, on every write or once enough bytes are written.
+	if b.opts.alwaysFSync || (b.opts.syncBytes > 0 && df == b.df && b.unsynced >= b.opts.syncBytes) {
 		if err := df.Sync(); err != nil {
-			return fmt.Errorf("error syncing file to disk: %v", err)
+			return fmt.Errorf("error syncing file to disk: %w", err)
 		}
+		if df == b.df {
+			b.unsynced = 0
+		}
+	}
+
+	b.written.Add(uint64(n))
+
+	// Notify the tailers waiting for new records.
+	if b.appended != nil {
+		close(b.appended)
+		b.appended = nil
 	}
 
 	return nil
@@ -123,12 +332,93 @@ func (b *Barrel) put(df *datafile.DataFile, k string, val []byte, expiry *time.T
 
 func (b *Barrel) delete(k string) error {
 	// Store an empty tombstone value for the given key.
-	if err := b.put(b.df, k, []byte{}, nil); err != nil {
+	if err := b.put(b.df, k, []byte{}, nil, nil); err != nil {
 		return err
 	}
 
-	// Delete it from the map as well.
-	delete(b.keydir, k)
+	b.dropKey(k)
 
 	return nil
 }
+
+// deleteMulti writes the tombstones of all the keys with a single append to the active datafile.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) deleteMulti(keys []string) error {
+	buf := b.bufPool.Get().(*bytes.Buffer)
+	defer b.bufPool.Put(buf)
+	defer buf.Reset()
+
+	var (
+		headers = make([]Header, len(keys))
+		ends    = make([]int, len(keys)) // Offsets in the buffer at which the tombstones end.
+	)
+	for i, k := range keys {
+		headers[i] = b.encodeRecord(buf, k, []byte{}, nil, nil)
+		ends[i] = buf.Len()
+	}
+
+	offset, err := b.df.Write(buf.Bytes())
+	if err != nil {
+		return b.writeFailed(b.df, err)
+	}
+
+	start := 0
+	for i, k := range keys {
+		if err := b.apply(b.df, k, []byte{}, nil, headers[i], offset+start, ends[i]-start); err != nil {
+			return err
+		}
+		b.dropKey(k)
+		start = ends[i]
+	}
+
+	return b.commitAppend(b.df, len(keys))
+}
+
+// putMulti writes the records of the keys with a single append to the active datafile, like deleteMulti.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) putMulti(keys []string, vals [][]byte) error {
+	buf := b.bufPool.Get().(*bytes.Buffer)
+	defer b.bufPool.Put(buf)
+	defer buf.Reset()
+
+	var (
+		headers = make([]Header, len(keys))
+		ends    = make([]int, len(keys)) // Offsets in the buffer at which the records end.
+	)
+	for i, k := range keys {
+		headers[i] = b.encodeRecord(buf, k, vals[i], nil, nil)
+		ends[i] = buf.Len()
+	}
+
+	offset, err := b.df.Write(buf.Bytes())
+	if err != nil {
+		return b.writeFailed(b.df, err)
+	}
+
+	start := 0
+	for i, k := range keys {
+		if err := b.apply(b.df, k, vals[i], nil, headers[i], offset+start, ends[i]-start); err != nil {
+			return err
+		}
+		start = ends[i]
+	}
+
+	return b.commitAppend(b.df, len(keys))
+}
+
+// dropKey removes the key, whose tombstone is written, from the keydir.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) dropKey(k string) {
+	meta := b.keydir.meta(k)
+	if err := b.keydir.delete(k); err != nil {
+		b.keydirError(err)
+		return
+	}
+	b.liveBytes -= meta.RecordSize
+	b.segmentUsage(meta.FileID).live -= meta.RecordSize
+	b.account(k, -1, -meta.RecordSize)
+	b.unsortKey(k)
+	if b.accessed != nil {
+		delete(b.accessed, k)
+	}
+}
@@ -0,0 +1,67 @@
+package barrel
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// ListPage returns upto limit keys after the cursor in lexicographic order, along with the cursor
+// of the next page, which is empty once all the keys are listed. Listing starts with an empty cursor.
+// Unlike List, it doesn't allocate a slice of all the keys, which suits enumerating huge keyspaces.
+// Keys written or deleted between the pages may or may not be listed, but the keys present
+// throughout are listed exactly once. Unless the keys are sorted with WithSortedKeys, every key
+// is visited, so each page takes time linear in the number of keys.
+func (b *Barrel) ListPage(cursor string, limit int) ([]string, string) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if limit <= 0 {
+		return nil, ""
+	}
+	if b.sorted != nil {
+		return b.sortedPage(cursor, limit)
+	}
+
+	var (
+		// Smallest keys after the cursor, with the largest of them on the top.
+		page  = make(keyHeap, 0, limit)
+		after = 0
+	)
+	b.eachKey(func(k string, _ Meta) bool {
+		if k <= cursor {
+			return true
+		}
+		after++
+		if len(page) < limit {
+			heap.Push(&page, k)
+		} else if k < page[0] {
+			page[0] = k
+			heap.Fix(&page, 0)
+		}
+		return true
+	})
+
+	keys := []string(page)
+	sort.Strings(keys)
+
+	// The page is the last one if there aren't any more keys after it.
+	if after <= limit {
+		return keys, ""
+	}
+	return keys, keys[len(keys)-1]
+}
+
+// keyHeap is a max-heap of keys.
+type keyHeap []string
+
+func (h keyHeap) Len() int           { return len(h) }
+func (h keyHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x any)        { *h = append(*h, x.(string)) }
+
+func (h *keyHeap) Pop() any {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
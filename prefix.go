@@ -0,0 +1,44 @@
+package barrel
+
+import (
+	"strings"
+)
+
+// PrefixStats is the usage of the keys starting with a prefix, e.g. of a tenant sharing the keyspace.
+type PrefixStats struct {
+	Prefix string
+	Keys   int // Number of keys with the prefix.
+	Bytes  int // Size of the latest records of the keys, i.e. their values along with the keys and the headers.
+}
+
+// PrefixStats returns the number of keys with the prefix and the size of their records.
+// It's computed from the keydir without reading the datafiles, so the expired keys which
+// aren't cleaned up yet are counted as well, like the usage of the quotas. If the keys are
+// sorted with WithSortedKeys, only the keys with the prefix are visited.
+func (b *Barrel) PrefixStats(prefix string) PrefixStats {
+	b.RLock()
+	defer b.RUnlock()
+
+	stats := PrefixStats{Prefix: prefix}
+	if b.sorted != nil {
+		b.sorted.Ascend(prefix, func(k string) bool {
+			if !strings.HasPrefix(k, prefix) {
+				return false
+			}
+			stats.Keys++
+			stats.Bytes += b.keydir.meta(k).RecordSize
+			return true
+		})
+		return stats
+	}
+
+	b.eachKey(func(k string, meta Meta) bool {
+		if strings.HasPrefix(k, prefix) {
+			stats.Keys++
+			stats.Bytes += meta.RecordSize
+		}
+		return true
+	})
+
+	return stats
+}
@@ -0,0 +1,64 @@
+package barrel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CompactionProgress represents the progress of the merge of the datafiles.
+// After a merge, it reports the progress at which the merge ended.
+type CompactionProgress struct {
+	Running        bool          // Whether a merge is in progress.
+	SegmentsDone   int           // Number of the datafiles merged so far.
+	SegmentsTotal  int           // Number of the datafiles being merged.
+	BytesProcessed int64         // Size of the records rewritten so far.
+	BytesTotal     int64         // Size of the records to rewrite.
+	Started        time.Time     // When the merge started.
+	ETA            time.Duration // Estimated time until the merge completes, 0 if unknown.
+}
+
+// compactProgress tracks the progress of the merge. It's updated by the merge workers and read
+// without locking the barrel, which is locked for the duration of the merge.
+type compactProgress struct {
+	running       atomic.Bool
+	started       atomic.Int64 // Unix time of the start in nanoseconds.
+	segmentsDone  atomic.Int64
+	segmentsTotal atomic.Int64
+	bytesDone     atomic.Int64
+	bytesTotal    atomic.Int64
+}
+
+// start resets the progress for a new merge.
+func (p *compactProgress) start(now time.Time, segmentsDone, segmentsTotal int, bytesTotal int64) {
+	p.started.Store(now.UnixNano())
+	p.segmentsDone.Store(int64(segmentsDone))
+	p.segmentsTotal.Store(int64(segmentsTotal))
+	p.bytesDone.Store(0)
+	p.bytesTotal.Store(bytesTotal)
+	p.running.Store(true)
+}
+
+// CompactionProgress returns the progress of the merge. Unlike Stats, it doesn't wait for the merge
+// to complete, so that it can be polled while the merge is in progress.
+func (b *Barrel) CompactionProgress() CompactionProgress {
+	p := &b.progress
+	progress := CompactionProgress{
+		Running:        p.running.Load(),
+		SegmentsDone:   int(p.segmentsDone.Load()),
+		SegmentsTotal:  int(p.segmentsTotal.Load()),
+		BytesProcessed: p.bytesDone.Load(),
+		BytesTotal:     p.bytesTotal.Load(),
+	}
+	if started := p.started.Load(); started > 0 {
+		progress.Started = time.Unix(0, started)
+	}
+
+	// Estimate the time remaining from the rate at which the records are rewritten so far.
+	if progress.Running && progress.BytesProcessed > 0 {
+		elapsed := b.now().Sub(progress.Started)
+		remaining := progress.BytesTotal - progress.BytesProcessed
+		progress.ETA = time.Duration(float64(elapsed) * float64(remaining) / float64(progress.BytesProcessed))
+	}
+
+	return progress
+}
@@ -0,0 +1,134 @@
+package barrel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QuotaPolicy decides what happens to a write which exceeds the quota of its namespace.
+type QuotaPolicy int
+
+const (
+	// QuotaReject rejects the writes with ErrQuotaExceeded.
+	QuotaReject QuotaPolicy = iota
+	// QuotaEvictOldest evicts the least recently written keys of the namespace.
+	QuotaEvictOldest
+)
+
+// Quota limits the number of keys and the size of the live data of a namespace,
+// which is made up of all the keys starting with the prefix.
+type Quota struct {
+	Prefix   string
+	MaxKeys  int // Unlimited if it's 0.
+	MaxBytes int // Unlimited if it's 0.
+	Policy   QuotaPolicy
+}
+
+// QuotaUsage is the utilization of the quota of a namespace.
+type QuotaUsage struct {
+	Quota
+	Keys  int // Number of keys in the namespace.
+	Bytes int // Size of the latest records of the keys in the namespace.
+}
+
+// quotaFor returns the usage of the namespace of the key, if it has a quota.
+// If the key is in multiple namespaces, the one with the longest prefix is returned.
+// The reserved keys aren't in any namespace.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) quotaFor(k string) *QuotaUsage {
+	if isReserved(k) {
+		return nil
+	}
+	var usage *QuotaUsage
+	for _, u := range b.quotas {
+		if strings.HasPrefix(k, u.Prefix) && (usage == nil || len(u.Prefix) > len(usage.Prefix)) {
+			usage = u
+		}
+	}
+	return usage
+}
+
+// account updates the usage of the namespace of the key, if it has a quota.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) account(k string, keys, bytes int) {
+	if u := b.quotaFor(k); u != nil {
+		u.Keys += keys
+		u.Bytes += bytes
+	}
+}
+
+// enforceQuota checks that writing a record of the given size for the key stays within the quota
+// of its namespace. As per the policy of the quota, either the write is rejected or the oldest keys
+// of the namespace are evicted. The given key, which is being written, is never evicted.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) enforceQuota(k string, size int) error {
+	u := b.quotaFor(k)
+	if u == nil {
+		return nil
+	}
+
+	var (
+		keys  = 1
+		bytes = size
+	)
+	if old, ok := b.keydir.get(k); ok {
+		keys, bytes = 0, size-old.RecordSize
+	}
+	exceeds := func() bool {
+		return (u.MaxKeys > 0 && u.Keys+keys > u.MaxKeys) || (u.MaxBytes > 0 && u.Bytes+bytes > u.MaxBytes)
+	}
+
+	for exceeds() {
+		victim, ok := "", false
+		if u.Policy == QuotaEvictOldest {
+			victim, ok = b.oldestKey(u.Prefix, k)
+		}
+		if !ok {
+			b.quotaRejected.Add(1)
+			return fmt.Errorf("%w: %s", ErrQuotaExceeded, u.Prefix)
+		}
+
+		b.lo.Debug("evicting key for quota", "key", victim, "prefix", u.Prefix)
+		if err := b.delete(victim); err != nil {
+			return err
+		}
+		b.evicted.Add(1)
+	}
+
+	return nil
+}
+
+// oldestKey returns the least recently written key with the prefix among a sample of the keys.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) oldestKey(prefix, skip string) (string, bool) {
+	var (
+		victim  string
+		oldest  int
+		found   bool
+		sampled int
+	)
+	b.eachKey(func(k string, meta Meta) bool {
+		if k == skip || !strings.HasPrefix(k, prefix) || b.quotaFor(k).Prefix != prefix {
+			return true
+		}
+		if !found || meta.Timestamp < oldest {
+			victim, oldest, found = k, meta.Timestamp, true
+		}
+		sampled++
+		return sampled < evictionSamples
+	})
+
+	return victim, found
+}
+
+// quotaUsages returns the usage of all the quotas sorted by their prefixes.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) quotaUsages() []QuotaUsage {
+	usages := make([]QuotaUsage, 0, len(b.quotas))
+	for _, u := range b.quotas {
+		usages = append(usages, *u)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Prefix < usages[j].Prefix })
+	return usages
+}
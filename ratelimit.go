@@ -0,0 +1,47 @@
+package barrel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errInterrupted is returned by the operations interrupted by the shutdown of the barrel.
+var errInterrupted = errors.New("interrupted by shutdown")
+
+// rateLimiter limits the I/O shared by concurrent workers to a rate in bytes per second.
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  int
+	start time.Time
+	total int // Bytes processed since the start.
+}
+
+// newRateLimiter returns a rate limiter for the given bytes per second. It's unlimited if the rate is 0.
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// wait blocks until n more bytes can be processed without exceeding the rate.
+// It returns the error of the context if it's done while waiting.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l.rate == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.total += n
+	wait := time.Duration(float64(l.total)/float64(l.rate)*float64(time.Second)) - time.Since(l.start)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
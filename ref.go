@@ -0,0 +1,78 @@
+package barrel
+
+import (
+	"sync"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// maxPooledBuffer is the size of the largest buffer returned to the pool by ValueRef.Release,
+// so that a few large values don't keep the pool from shrinking.
+const maxPooledBuffer = 1 << 20
+
+// readBuffers are the buffers which the records read by GetRef are read into.
+var readBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, 0, 4096)
+	return &buf
+}}
+
+// ValueRef is a value returned by GetRef, which aliases the buffer its record is read into, or the
+// mapping of the datafile if it's memory-mapped. The value is only valid till the ref is released,
+// since the buffer is reused by the other reads and the mapping is unmapped once the datafile is closed.
+type ValueRef struct {
+	Value []byte
+
+	buf    *[]byte
+	pinned datafile.Storage // Datafile whose mapping the value aliases, if any.
+}
+
+// Release returns the buffer of the value to the pool and unpins the mapping. Neither the value
+// nor the slices of it can be used once it's released. Releasing it again is a no-op.
+func (v *ValueRef) Release() {
+	if v.buf == nil {
+		return
+	}
+	if cap(*v.buf) <= maxPooledBuffer {
+		readBuffers.Put(v.buf)
+	}
+	if v.pinned != nil {
+		v.pinned.Unpin()
+	}
+	v.Value, v.buf, v.pinned = nil, nil, nil
+}
+
+// read reads the record of the metadata from the datafile into the buffer, or pins it if the
+// datafile is memory-mapped. Only one record is pinned by the ref.
+func (v *ValueRef) read(df datafile.Storage, meta Meta) ([]byte, error) {
+	if v.pinned == nil {
+		data, ok, err := df.Pin(meta.RecordPos, meta.RecordSize)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			v.pinned = df
+			return data, nil
+		}
+	}
+
+	if cap(*v.buf) < meta.RecordSize {
+		*v.buf = make([]byte, meta.RecordSize)
+	}
+	return df.ReadInto((*v.buf)[:meta.RecordSize], meta.RecordPos)
+}
+
+// GetRef is same as Get but reads the record into a pooled buffer, which the value aliases instead
+// of being copied out of it, so that the reads don't allocate. The value is returned from the cache
+// or the memory-mapped datafiles without copying as well, if they're enabled. The caller must release
+// the ref once it's done with the value, which keeps the datafile mapped till then.
+func (b *Barrel) GetRef(k string) (ValueRef, error) {
+	ref := ValueRef{buf: readBuffers.Get().(*[]byte)}
+	record, err := b.readRecord(k, &ref)
+	if err != nil {
+		ref.Release()
+		return ValueRef{}, err
+	}
+
+	ref.Value = record.Value
+	return ref, nil
+}
@@ -0,0 +1,67 @@
+package barrel
+
+import "errors"
+
+// Rename renames the key src to dst atomically, overwriting dst if it exists. The value, the expiry,
+// the metadata and the tags of the key are retained. It returns ErrKeyNotFound if src doesn't exist.
+// The rename is written as a write of dst followed by a delete of src, which are hooked and mirrored as such.
+func (b *Barrel) Rename(src, dst string) (err error) {
+	// Wait for the writes to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	_, err = b.rename(src, dst, true)
+	return err
+}
+
+// RenameNX is same as Rename but only renames the key if dst doesn't exist.
+// It returns false if the key isn't renamed since dst exists.
+func (b *Barrel) RenameNX(src, dst string) (renamed bool, err error) {
+	// Wait for the writes to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	return b.rename(src, dst, false)
+}
+
+// rename renames the key src to dst. If overwrite is false, the key isn't renamed if dst exists.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) rename(src, dst string, overwrite bool) (bool, error) {
+	if err := b.checkWritable(); err != nil {
+		return false, err
+	}
+
+	record, err := b.getRecord(src)
+	if err != nil {
+		return false, err
+	}
+	if !overwrite {
+		_, err := b.getRecord(dst)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, ErrKeyNotFound) {
+			return false, err
+		}
+	}
+	if src == dst {
+		return true, nil
+	}
+	if err := b.validateKV(dst, record.Value); err != nil {
+		return false, err
+	}
+
+	b.lo.Debug("renaming key", "src", src, "dst", dst)
+	if err := b.hookedPut(dst, record.Value, record.rawMeta, record.Header.expiry()); err != nil {
+		return false, err
+	}
+	if err := b.hookedDelete(src); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
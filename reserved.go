@@ -0,0 +1,26 @@
+package barrel
+
+import "strings"
+
+// reservedPrefix is the prefix of the keys which hold the state of the barrel itself, i.e. the idempotency
+// tokens and the entries of the streams. They're written to the datafiles like the other keys, so that they're
+// persisted, merged and expired along with them, but they're kept out of the keyspace: they can't be written or
+// deleted by the users, and aren't read, listed, counted, evicted or subject to the quotas like the other keys.
+const reservedPrefix = "\x00"
+
+// isReserved returns true if the key is a reserved key.
+func isReserved(k string) bool {
+	return strings.HasPrefix(k, reservedPrefix)
+}
+
+// eachKey calls the function with each key of the keyspace and its metadata till it returns false,
+// like the each of the keydir but skipping the reserved keys.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) eachKey(fn func(k string, meta Meta) bool) {
+	b.keydir.each(func(k string, meta Meta) bool {
+		if isReserved(k) {
+			return true
+		}
+		return fn(k, meta)
+	})
+}
@@ -0,0 +1,53 @@
+package barrel
+
+import (
+	"math/rand"
+)
+
+// Sample returns a uniformly random sample of upto n distinct keys in a random order.
+// All the keys are returned if there are fewer than n. The expired keys which aren't
+// cleaned up yet are skipped. Since every key is visited, it takes time linear in the number of keys.
+func (b *Barrel) Sample(n int) []string {
+	b.Lock()
+	defer b.Unlock()
+
+	if n <= 0 {
+		return nil
+	}
+
+	var (
+		now    = int(b.now().Unix())
+		sample = make([]string, 0, n)
+		seen   = 0
+	)
+
+	// Reservoir sampling: the i-th key replaces a random key of the sample with probability n/i.
+	b.eachKey(func(k string, meta Meta) bool {
+		if meta.Expiry != 0 && now > meta.Expiry {
+			return true
+		}
+		seen++
+		if len(sample) < n {
+			sample = append(sample, k)
+			return true
+		}
+		if pos := rand.Intn(seen); pos < n {
+			sample[pos] = k
+		}
+		return true
+	})
+
+	// The keys which filled the sample are in the order of iteration, so shuffle them.
+	rand.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+
+	return sample
+}
+
+// RandomKey returns a random key, or ErrKeyNotFound if there are no keys.
+func (b *Barrel) RandomKey() (string, error) {
+	keys := b.Sample(1)
+	if len(keys) == 0 {
+		return "", ErrKeyNotFound
+	}
+	return keys[0], nil
+}
@@ -0,0 +1,243 @@
+package barrel
+
+import (
+	"sort"
+	"time"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// timeRange represents the smallest and largest timestamp of the records in a datafile.
+type timeRange struct {
+	min uint32
+	max uint32
+}
+
+// overlaps returns true if any part of the range lies between from and to (both inclusive).
+func (t timeRange) overlaps(from, to uint32) bool {
+	return t.min <= to && t.max >= from
+}
+
+// extend widens the range to include the given timestamp.
+func (t timeRange) extend(ts uint32) timeRange {
+	if ts < t.min {
+		t.min = ts
+	}
+	if ts > t.max {
+		t.max = ts
+	}
+	return t
+}
+
+// ScanTime returns all the records which were written between from and to (both inclusive),
+// in the order they were written. Deletes are returned as tombstone records with an empty value.
+// Datafiles whose records don't fall within the window are skipped without being read.
+// The records rewritten by a merge retain their write time, so they're returned in its order.
+// The datafiles are read outside the barrel lock upto their size when the scan starts, like Tail,
+// so the writes aren't blocked by the scan, and the writes made meanwhile aren't returned.
+func (b *Barrel) ScanTime(from, to time.Time) ([]Record, error) {
+	var (
+		start   = uint32(from.Unix())
+		end     = uint32(to.Unix())
+		records = make([]Record, 0)
+		scanned = make(map[int]segmentScan)
+	)
+
+	b.RLock()
+	var (
+		dfs    = b.dataFiles()
+		active = b.df
+		sizes  = make([]int64, len(dfs))
+	)
+	for i, df := range dfs {
+		// Skip the datafile if its time range is known and it doesn't overlap with the window.
+		if tr, ok := b.timeRanges[df.ID()]; ok && !tr.overlaps(start, end) {
+			dfs[i] = nil
+			continue
+		}
+		size, err := df.Size()
+		if err != nil {
+			b.RUnlock()
+			return nil, err
+		}
+		sizes[i] = size
+	}
+	// The datafiles aren't closed by a merge until the scan is over.
+	b.readers.RLock()
+	b.RUnlock()
+
+	for i, df := range dfs {
+		if df == nil {
+			continue
+		}
+
+		var (
+			scan  = segmentScan{df: df}
+			first = true
+		)
+		err := scanDFUpto(df, 0, int(sizes[i]), func(r Record, _, _ int) error {
+			if first {
+				scan.time, first = timeRange{min: r.Header.Timestamp, max: r.Header.Timestamp}, false
+			}
+			scan.time = scan.time.extend(r.Header.Timestamp)
+			scan.stats = scan.stats.add(r.Key)
+
+			if r.Header.Timestamp >= start && r.Header.Timestamp <= end {
+				records = append(records, r)
+			}
+			return nil
+		})
+		if err != nil {
+			b.readers.RUnlock()
+			return nil, err
+		}
+		if !first && df != active {
+			scanned[df.ID()] = scan
+		}
+	}
+	b.readers.RUnlock()
+
+	b.cacheScans(scanned)
+
+	// The merged datafiles are ordered by the keys, so order the records by their write time.
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Header.Timestamp < records[j].Header.Timestamp
+	})
+
+	return records, nil
+}
+
+// segmentScan is the time and key ranges of the records in a datafile, as found by scanning it.
+type segmentScan struct {
+	df    datafile.Storage
+	time  timeRange
+	stats segmentStats
+}
+
+// cacheScans caches the time and key ranges of the scanned datafiles, since they don't change anymore
+// once they're older datafiles. The datafiles replaced by a merge after the scan are skipped.
+func (b *Barrel) cacheScans(scanned map[int]segmentScan) {
+	if len(scanned) == 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for id, scan := range scanned {
+		if df, ok := b.stale[id]; !ok || df != scan.df {
+			continue
+		}
+		if _, ok := b.timeRanges[id]; !ok {
+			b.timeRanges[id] = scan.time
+		}
+		if _, ok := b.stats[id]; !ok {
+			b.stats[id] = scan.stats
+		}
+	}
+}
+
+// trackTime extends the time range of the datafile with the timestamp of a newly written record.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) trackTime(id int, ts uint32) {
+	tr, ok := b.timeRanges[id]
+	if !ok {
+		tr = timeRange{min: ts, max: ts}
+	}
+	b.timeRanges[id] = tr.extend(ts)
+}
+
+// dataFiles returns the list of all the datafiles sorted by their IDs.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) dataFiles() []datafile.Storage {
+	dfs := make([]datafile.Storage, 0, len(b.stale)+1)
+	for _, df := range b.stale {
+		dfs = append(dfs, df)
+	}
+	dfs = append(dfs, b.df)
+
+	sort.Slice(dfs, func(i, j int) bool { return dfs[i].ID() < dfs[j].ID() })
+
+	return dfs
+}
+
+// scanDF reads the records in the datafile sequentially starting from the given offset
+// and calls the given function for each record along with its offset and size.
+// The segment header is skipped if the offset is before the first record.
+func scanDF(df datafile.Storage, offset int, fn func(r Record, offset, size int) error) error {
+	size, err := df.Size()
+	if err != nil {
+		return err
+	}
+	return scanDFUpto(df, offset, int(size), fn)
+}
+
+// scanDFUpto is same as scanDF but stops at the given end offset instead of the end of the datafile.
+func scanDFUpto(df datafile.Storage, offset, end int, fn func(r Record, offset, size int) error) error {
+	if offset < df.HeaderSize() {
+		offset = df.HeaderSize()
+	}
+	for offset < end {
+		record, n, err := readRecord(df, offset)
+		if err != nil {
+			return err
+		}
+		if err := fn(record, offset, n); err != nil {
+			return err
+		}
+		offset += n
+	}
+
+	return nil
+}
+
+// readRecord reads and decodes the record present at the given offset in the datafile.
+// It returns the record along with the total size of the record in bytes.
+func readRecord(df datafile.Storage, offset int) (Record, int, error) {
+	var (
+		header  Header
+		version = df.Version()
+		n       = headerSizeV1
+	)
+
+	// Read the header to get the size of the record. Since the size of the header isn't fixed
+	// in the newer versions (and is smaller for Bitcask), read upto the max size of the header.
+	if version != 1 {
+		size, err := df.Size()
+		if err != nil {
+			return Record{}, 0, err
+		}
+		n = maxHeaderSizeV2
+		if offset+n > int(size) {
+			n = int(size) - offset
+		}
+	}
+	data, err := df.Read(offset+n, n)
+	if err != nil {
+		return Record{}, 0, err
+	}
+	n, err = header.decode(data, version)
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	// Read the key and value.
+	size := n + int(header.KeySize) + int(header.MetaSize) + int(header.ValSize)
+	data, err = df.Read(offset+size, size)
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	var (
+		metaPos = n + int(header.KeySize)
+		valPos  = metaPos + int(header.MetaSize)
+	)
+	record := Record{
+		Header: header,
+		Key:    string(data[n:metaPos]),
+		Value:  data[valPos:],
+	}
+	record.setMeta(data[metaPos:valPos])
+
+	return record, size, nil
+}
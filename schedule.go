@@ -0,0 +1,81 @@
+package barrel
+
+import (
+	"fmt"
+	"time"
+)
+
+// compactionWindow is a daily time window in which the automatic compaction runs.
+// Its bounds are the offsets from midnight, and it wraps around midnight if the end is before the start.
+type compactionWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseCompactionWindow parses the bounds of the window in the "15:04" format.
+func parseCompactionWindow(start, end string) (compactionWindow, error) {
+	var (
+		w   compactionWindow
+		err error
+	)
+	if w.start, err = parseTimeOfDay(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseTimeOfDay(end); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("compaction window %s-%s is empty", start, end)
+	}
+	return w, nil
+}
+
+// parseTimeOfDay returns the offset from midnight of the time of the day in the "15:04" format.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns true if the time of the day of t is in the window.
+func (w compactionWindow) contains(t time.Time) bool {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start < w.end {
+		return d >= w.start && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// compactionDue returns true if the automatic compaction can run now, as per the configured
+// compaction windows and the min amplification of the disk usage.
+func (b *Barrel) compactionDue() bool {
+	if len(b.opts.compactWindows) > 0 {
+		var (
+			now    = b.now()
+			inside = false
+		)
+		for _, w := range b.opts.compactWindows {
+			if w.contains(now) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+
+	if b.opts.compactMinAmplification > 0 {
+		b.Lock()
+		disk, live := b.diskBytes, b.liveBytes
+		b.Unlock()
+
+		if disk == 0 || float64(disk) < b.opts.compactMinAmplification*float64(live) {
+			return false
+		}
+	}
+
+	return true
+}
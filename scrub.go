@@ -0,0 +1,135 @@
+package barrel
+
+import (
+	"time"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// Scrub validates the checksums of all the records in the older datafiles at a periodic interval,
+// so that corrupt records are found before they're read. The datafiles are read at most at
+// the given rate in bytes per second (unlimited if it's 0), to limit the impact on the other reads.
+func (b *Barrel) Scrub(evalInterval time.Duration, rate int) {
+	var (
+		evalTicker = time.NewTicker(evalInterval)
+	)
+	defer evalTicker.Stop()
+	for {
+		select {
+		case <-evalTicker.C:
+		case <-b.done:
+			return
+		}
+
+		b.Lock()
+		dfs := make([]datafile.Storage, 0, len(b.stale))
+		for _, id := range sortedIDs(b.stale) {
+			dfs = append(dfs, b.stale[id])
+		}
+		b.Unlock()
+
+		for _, df := range dfs {
+			// Stop scrubbing the rest of the datafiles on shutdown.
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+
+			corrupt, err := b.scrubDF(df, rate)
+			if err != nil {
+				b.lo.Error("error scrubbing db file", "id", df.ID(), "error", err)
+				continue
+			}
+			b.lo.Debug("scrubbed db file", "id", df.ID(), "corrupt", corrupt)
+		}
+	}
+}
+
+// Quarantined returns the keys which are removed from the keydir since their latest record is corrupt.
+// Reads of these keys fail with ErrChecksumMismatch until they're written again.
+func (b *Barrel) Quarantined() []string {
+	b.Lock()
+	defer b.Unlock()
+
+	keys := make([]string, 0, len(b.quarantined))
+	for k := range b.quarantined {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// scrubDF validates the checksums of all the records in the older datafile and
+// quarantines the keys whose latest record is corrupt. It returns the number of corrupt records.
+// The barrel is locked only while reading each record, so that the writes aren't blocked
+// and the datafile isn't merged away while it's being read.
+func (b *Barrel) scrubDF(df datafile.Storage, rate int) (int, error) {
+	size, err := df.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		start   = time.Now()
+		corrupt = 0
+	)
+	for offset := df.HeaderSize(); offset < int(size); {
+		b.Lock()
+		// Stop if the datafile has been merged.
+		if b.stale[df.ID()] != df {
+			b.Unlock()
+			return corrupt, nil
+		}
+		record, n, err := readRecord(df, offset)
+		if err == nil && !record.isValidChecksum() {
+			corrupt++
+			b.corrupt.Add(1)
+			b.quarantine(record.Key, df, offset+n)
+		}
+		b.Unlock()
+
+		if err != nil {
+			return corrupt, err
+		}
+		offset += n
+
+		// Wait if the records are being read faster than the rate.
+		if rate > 0 {
+			if wait := time.Duration(offset)*time.Second/time.Duration(rate) - time.Since(start); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-b.done:
+					return corrupt, nil
+				}
+			}
+		}
+	}
+
+	return corrupt, nil
+}
+
+// quarantine removes the key from the keydir if its latest record is the corrupt record
+// ending at the given position in the datafile.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) quarantine(k string, df datafile.Storage, pos int) {
+	meta, ok := b.keydir.get(k)
+	if !ok || meta.FileID != df.ID() || meta.RecordPos != pos {
+		b.lo.Error("found corrupt record of an older version of key", "key", k, "id", df.ID(), "pos", pos)
+		return
+	}
+
+	b.lo.Error("quarantining key since its record is corrupt", "key", k, "id", df.ID(), "pos", pos)
+	if err := b.keydir.delete(k); err != nil {
+		b.keydirError(err)
+		return
+	}
+	b.unsortKey(k)
+	b.liveBytes -= meta.RecordSize
+	b.segmentUsage(meta.FileID).live -= meta.RecordSize
+	b.account(k, -1, -meta.RecordSize)
+	if b.accessed != nil {
+		delete(b.accessed, k)
+	}
+	b.quarantined[k] = meta
+}
@@ -0,0 +1,84 @@
+package barrel
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// SegmentRecord is a record read from a datafile by ScanSegment.
+type SegmentRecord struct {
+	Record
+
+	Offset int  // Offset of the start of the record in the datafile.
+	Size   int  // Size of the record in bytes, including the header.
+	Valid  bool // Whether the checksum of the value matches the header.
+}
+
+// ScanSegment reads the records of the datafile at the given path sequentially and calls
+// the given function for each record, for inspecting the datafile without opening its directory.
+// The datafiles written by Bitcask are read as well. It returns the version of the record format
+// used by the datafile, which is -1 for them.
+func ScanSegment(path string, fn func(r SegmentRecord) error) (int, error) {
+	df, err := openSegment(path)
+	if err != nil {
+		return 0, err
+	}
+	defer df.Close()
+
+	err = scanDF(df, 0, func(r Record, offset, size int) error {
+		return fn(SegmentRecord{
+			Record: r,
+			Offset: offset,
+			Size:   size,
+			Valid:  r.isValidChecksum(),
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return df.Version(), nil
+}
+
+// SegmentHeader is the segment header of a datafile along with its size.
+type SegmentHeader struct {
+	Version    int   // Version of the record format, whose layout is returned by GetRecordFormat.
+	HeaderSize int   // Size of the segment header, after which the first record starts.
+	Size       int64 // Size of the datafile in bytes.
+}
+
+// ReadSegmentHeader reads the segment header of the datafile at the given path, e.g. to decode
+// its records with DecodeRecord. The datafiles written by Bitcask are read as well.
+func ReadSegmentHeader(path string) (SegmentHeader, error) {
+	df, err := openSegment(path)
+	if err != nil {
+		return SegmentHeader{}, err
+	}
+	defer df.Close()
+
+	size, err := df.Size()
+	if err != nil {
+		return SegmentHeader{}, err
+	}
+	return SegmentHeader{Version: df.Version(), HeaderSize: df.HeaderSize(), Size: size}, nil
+}
+
+// openSegment opens the datafile at the given path for reading, outside of its directory.
+func openSegment(path string) (*datafile.DataFile, error) {
+	var (
+		open  = datafile.Open
+		getID = getIDs
+	)
+	if isBitcaskFile(path) {
+		open, getID = datafile.OpenBitcask, getBitcaskIDs
+	}
+
+	ids, err := getID([]string{path})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing id of datafile: %w", err)
+	}
+
+	return open(filepath.Dir(path), ids[0], datafile.NewPool(1, false))
+}
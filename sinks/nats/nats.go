@@ -0,0 +1,162 @@
+// Package nats provides a change sink which publishes the changes of a barrel to a NATS server.
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+// errServer is the error returned by the server for a message.
+var errServer = errors.New("server error")
+
+// Sink is a barrel.ChangeSink which publishes each change as a JSON message to a subject of a NATS server,
+// over the text protocol of NATS. The changes are acknowledged once the server replies to a PING sent after
+// them, which only means that the server has received them. Core NATS doesn't acknowledge the delivery,
+// so the changes are delivered at most once past the server: they're lost if there's no subscriber at
+// the time, or if the server fails before storing them in the JetStream stream of the subject, if any.
+// It reconnects on errors.
+type Sink struct {
+	sync.Mutex
+
+	addr       string
+	subject    string
+	timeout    time.Duration
+	conn       net.Conn
+	r          *bufio.Reader
+	maxPayload int // Max size of a message, as announced by the server.
+}
+
+// New returns a sink which publishes to the subject on the NATS server at the given address.
+// Each batch of the changes including connecting to the server times out after the given duration.
+func New(addr, subject string, timeout time.Duration) *Sink {
+	return &Sink{addr: addr, subject: subject, timeout: timeout}
+}
+
+// Publish publishes the changes and waits for the server to receive them.
+func (s *Sink) Publish(changes []barrel.Change) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.publish(changes)
+	if err != nil && s.conn != nil {
+		// Reconnect on the next batch since the connection is in an unknown state.
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection to the server, if any.
+func (s *Sink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// publish writes the messages of the changes followed by a PING, and waits for the PONG.
+func (s *Sink) publish(changes []barrel.Change) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if err := s.conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(s.conn)
+	for _, c := range changes {
+		msg, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if s.maxPayload > 0 && len(msg) > s.maxPayload {
+			return fmt.Errorf("change of key %q of %d bytes exceeds the max payload of %d bytes", c.Key, len(msg), s.maxPayload)
+		}
+		fmt.Fprintf(w, "PUB %s %d\r\n", s.subject, len(msg))
+		w.Write(msg)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.waitPong()
+}
+
+// connect connects to the server, reads its INFO and sends the CONNECT.
+func (s *Sink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("invalid greeting: %q", line)
+	}
+	var server struct {
+		MaxPayload int `json:"max_payload"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &server); err != nil {
+		return fmt.Errorf("invalid server info: %w", err)
+	}
+	s.maxPayload = server.MaxPayload
+
+	if _, err := conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"barrel\"}\r\n")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// waitPong reads the replies of the server till the PONG, failing on an error.
+func (s *Sink) waitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("%w: %s", errServer, strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and the updated INFO are ignored.
+	}
+}
+
+// readLine reads a line of the protocol without the CRLF.
+func (s *Sink) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,84 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	barrel "github.com/deepgolani4/LogVaultDB/internal/datafile"
+)
+
+func TestSink(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+
+	// The server acknowledges the messages with a PONG, and rejects a subject with an error.
+	msgs := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("INFO {\"max_payload\":1024}\r\n"))
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					var (
+						subject string
+						size    int
+					)
+					switch {
+					case strings.HasPrefix(line, "PUB "):
+						fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+						payload := make([]byte, size+2)
+						if _, err := io.ReadFull(r, payload); err != nil {
+							return
+						}
+						if subject == "invalid" {
+							conn.Write([]byte("-ERR 'Invalid Subject'\r\n"))
+							return
+						}
+						msgs <- subject + " " + string(payload[:size])
+					case line == "PING\r\n":
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	sink := New(ln.Addr().String(), "changes", time.Second)
+	defer sink.Close()
+	assert.NoError(sink.Publish([]barrel.Change{
+		{Op: "set", Key: "key-1", Value: []byte("val-1"), Seq: 1, Timestamp: 100},
+		{Op: "del", Key: "key-2", Seq: 2, Timestamp: 100},
+	}))
+	assert.Equal(`changes {"op":"set","key":"key-1","value":"dmFsLTE=","seq":1,"ts":100}`, <-msgs)
+	assert.Equal(`changes {"op":"del","key":"key-2","seq":2,"ts":100}`, <-msgs)
+
+	// The changes larger than the max payload of the server aren't sent.
+	assert.Error(sink.Publish([]barrel.Change{{Op: "set", Key: "key", Value: make([]byte, 1024)}}))
+
+	invalid := New(ln.Addr().String(), "invalid", time.Second)
+	defer invalid.Close()
+	assert.ErrorIs(invalid.Publish([]barrel.Change{{Op: "set", Key: "key"}}), errServer)
+
+	// The sink reconnects after a failure.
+	assert.NoError(sink.Publish([]barrel.Change{{Op: "del", Key: "key-3", Seq: 3}}))
+	assert.Equal(`changes {"op":"del","key":"key-3","seq":3,"ts":0}`, <-msgs)
+}
@@ -0,0 +1,88 @@
+package barrel
+
+import (
+	"github.com/tidwall/btree"
+)
+
+// sortKey adds the key to the sorted keys, if they're maintained.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) sortKey(k string) {
+	if b.sorted != nil && !isReserved(k) {
+		b.sorted.Insert(k)
+	}
+}
+
+// unsortKey removes the key from the sorted keys, if they're maintained.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) unsortKey(k string) {
+	if b.sorted != nil {
+		b.sorted.Delete(k)
+	}
+}
+
+// buildSorted builds the sorted keys from the keydir, if they're maintained.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) buildSorted() {
+	if !b.opts.sortedKeys {
+		return
+	}
+
+	b.sorted = new(btree.Set[string])
+	b.eachKey(func(k string, _ Meta) bool {
+		b.sorted.Insert(k)
+		return true
+	})
+}
+
+// RangeScan returns the keys from start (inclusive) to end (exclusive) in lexicographic order.
+// An empty end scans till the last key. The expired keys which aren't cleaned up yet are skipped.
+// It requires WithSortedKeys, so that only the keys in the range are visited.
+func (b *Barrel) RangeScan(start, end string) ([]string, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.sorted == nil {
+		return nil, ErrSortedKeysDisabled
+	}
+
+	var (
+		now  = int(b.now().Unix())
+		keys = make([]string, 0)
+	)
+	b.sorted.Ascend(start, func(k string) bool {
+		if end != "" && k >= end {
+			return false
+		}
+		if meta := b.keydir.meta(k); meta.Expiry == 0 || now <= meta.Expiry {
+			keys = append(keys, k)
+		}
+		return true
+	})
+
+	return keys, nil
+}
+
+// sortedPage returns upto limit keys after the cursor from the sorted keys, like ListPage.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) sortedPage(cursor string, limit int) ([]string, string) {
+	var (
+		keys = make([]string, 0, limit)
+		more bool
+	)
+	b.sorted.Ascend(cursor, func(k string) bool {
+		if k == cursor {
+			return true
+		}
+		if len(keys) == limit {
+			more = true
+			return false
+		}
+		keys = append(keys, k)
+		return true
+	})
+
+	if !more {
+		return keys, ""
+	}
+	return keys, keys[len(keys)-1]
+}
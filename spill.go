@@ -0,0 +1,339 @@
+package barrel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+const (
+	// INDEX_FILE is the name of the index file to which the entries of a datafile are spilled from the keydir.
+	INDEX_FILE = "barrel_%d.index"
+
+	// spillEntrySize is the size of an entry in the index files: the hash and the size of the key followed
+	// by the metadata except the ID of the datafile, which is the same for all the entries of an index file.
+	spillEntrySize = 8 + 4 + 6*8
+	// spillBlockSize is the number of entries in a block of the index files, which are read at once by a lookup.
+	spillBlockSize = 64
+)
+
+// spillKeydir spills the entries of the oldest datafiles from the keydir to their index files, till the
+// keydir is within the max index memory. The entries of the active datafile are always kept in memory.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) spillKeydir() {
+	if b.opts.maxIndexMemory == 0 || b.opts.readOnly || b.opts.inMemory {
+		return
+	}
+	counts := b.keydir.inMemory()
+	for _, id := range sortedIDs(b.stale) {
+		if b.keydir.size() <= b.opts.maxIndexMemory {
+			return
+		}
+		if counts[id] == 0 {
+			continue
+		}
+		if err := b.keydir.spill(id, indexPath(b.opts.dir, id)); err != nil {
+			b.lo.Error("error spilling keydir", "id", id, "error", err)
+			return
+		}
+		b.lo.Debug("spilled keydir to index file", "id", id, "keys", counts[id], "keydir_bytes", b.keydir.size())
+	}
+}
+
+// removeIndexFiles removes the index files of the keydir left over by a crash, since the keydir is loaded
+// from the hints on startup.
+func removeIndexFiles(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, strings.Replace(INDEX_FILE, "%d", "*", 1)))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexPath returns the path of the index file of the datafile with the given ID.
+func indexPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf(INDEX_FILE, id))
+}
+
+// spilledSegment is the part of the keydir spilled to the index file of a datafile, which has the entries
+// of the keys whose latest record is in the datafile, sorted by the hashes of the keys. Only the first hash
+// of each block of the entries, the bloom filter of the keys and a bitmap of the entries removed since are
+// kept in memory. A lookup reads a block of the entries, and the key of the matching entry from its record.
+type spilledSegment struct {
+	id      int
+	path    string
+	file    *os.File
+	count   int      // Number of entries in the index file.
+	live    int      // Number of entries which aren't removed.
+	fences  []uint64 // Hash of the first entry of each block.
+	bloom   *bloom   // Bloom filter of the keys of the entries.
+	removed []uint64 // Bitmap of the entries which are removed since, i.e. deleted or set in memory again.
+}
+
+// spilledKey is an entry of the keydir being spilled along with the hash of its key.
+type spilledKey struct {
+	hash  uint64
+	entry keyEntry
+}
+
+// spill moves the entries of the keys whose latest record is in the datafile with the given ID to its index
+// file at the given path, and releases their memory. If the datafile is spilled already, the index file is
+// replaced with one with the entries spilled earlier along with the ones set in memory since.
+func (d *keyDir) spill(id int, path string) error {
+	var keys []spilledKey
+	for h, head := range d.index {
+		for i := head; i != noEntry; i = d.entries[i].next {
+			if d.entries[i].meta.FileID == id {
+				keys = append(keys, spilledKey{hash: h, entry: d.entries[i]})
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	inMemory := len(keys)
+
+	// The bloom filter of the earlier entries is extended with the keys in memory. Otherwise, the bloom
+	// filter of the datafile is reused in the compact mode, since the keys aren't in memory.
+	old := -1
+	for i, s := range d.spilled {
+		if s.id == id {
+			old = i
+		}
+	}
+	f, ok := d.blooms[id]
+	if old >= 0 || !ok {
+		f = newBloom(len(keys))
+		if old >= 0 {
+			f = d.spilled[old].bloom
+		}
+		for _, sk := range keys {
+			k, err := d.key(&sk.entry)
+			if err != nil {
+				return err
+			}
+			f.add(d.bloomHash(k))
+		}
+	}
+	if old >= 0 {
+		err := d.spilled[old].each(func(h uint64, e *keyEntry) bool {
+			keys = append(keys, spilledKey{hash: h, entry: *e})
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].hash < keys[j].hash
+	})
+
+	// The earlier index file is replaced once the new one is written, so that it's intact if that fails.
+	s, err := writeSpilled(id, path+".tmp", keys)
+	if err != nil {
+		return err
+	}
+	if old >= 0 {
+		d.spilled[old].file.Close()
+		d.spilled = append(d.spilled[:old], d.spilled[old+1:]...)
+	}
+	if err := os.Rename(s.path, path); err != nil {
+		s.close()
+		return fmt.Errorf("error renaming index file: %w", err)
+	}
+	s.path = path
+	s.bloom = f
+	delete(d.blooms, id)
+	d.spilled = append(d.spilled, s)
+
+	d.rebuild(len(d.entries)-len(d.free)-inMemory, func(e *keyEntry) bool {
+		return e.meta.FileID != id
+	})
+	return nil
+}
+
+// writeSpilled writes the entries to the index file at the given path and returns the spilled segment.
+func writeSpilled(id int, path string, keys []spilledKey) (*spilledSegment, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating index file: %w", err)
+	}
+
+	s := &spilledSegment{
+		id:      id,
+		path:    path,
+		file:    file,
+		count:   len(keys),
+		live:    len(keys),
+		fences:  make([]uint64, 0, (len(keys)+spillBlockSize-1)/spillBlockSize),
+		removed: make([]uint64, (len(keys)+63)/64),
+	}
+	var (
+		w   = bufio.NewWriter(file)
+		buf [spillEntrySize]byte
+	)
+	for i, sk := range keys {
+		if i%spillBlockSize == 0 {
+			s.fences = append(s.fences, sk.hash)
+		}
+		encodeSpilled(buf[:], sk.hash, sk.entry.size, sk.entry.meta)
+		if _, err := w.Write(buf[:]); err != nil {
+			s.close()
+			return nil, fmt.Errorf("error writing index file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		s.close()
+		return nil, fmt.Errorf("error writing index file: %w", err)
+	}
+
+	return s, nil
+}
+
+// findSpilled returns the spilled segment and the position of the entry of the key with the given hash,
+// along with its metadata. The segment is nil if the key isn't spilled. It fails if an index file, or the key
+// of an entry with the hash can't be read.
+func (d *keyDir) findSpilled(k string, h uint64) (*spilledSegment, int, Meta, error) {
+	if len(d.spilled) == 0 {
+		return nil, 0, Meta{}, nil
+	}
+
+	bh := d.bloomHash(k)
+	for i := len(d.spilled) - 1; i >= 0; i-- {
+		s := d.spilled[i]
+		if !s.bloom.has(bh) {
+			d.skipped.Add(1)
+			continue
+		}
+		pos, meta, err := s.find(d, k, h)
+		if err != nil {
+			return nil, 0, Meta{}, err
+		}
+		if pos >= 0 {
+			return s, pos, meta, nil
+		}
+	}
+	return nil, 0, Meta{}, nil
+}
+
+// find returns the position and the metadata of the entry of the key with the given hash, or -1 if it's missing.
+// The blocks which may have the entries of the hash are read, which is a single block unless the hash collides.
+func (s *spilledSegment) find(d *keyDir, k string, h uint64) (int, Meta, error) {
+	// The entries of the hash start in the last block whose first hash is below it, if any.
+	block := sort.Search(len(s.fences), func(i int) bool { return s.fences[i] >= h }) - 1
+	if block < 0 {
+		block = 0
+	}
+
+	buf := make([]byte, spillBlockSize*spillEntrySize)
+	for start := block; block < len(s.fences); block++ {
+		if block > start && s.fences[block] > h {
+			break
+		}
+		n, err := s.file.ReadAt(buf, int64(block*len(buf)))
+		if err != nil && err != io.EOF {
+			return -1, Meta{}, fmt.Errorf("error reading index file of datafile %d: %w", s.id, err)
+		}
+		for off := 0; off+spillEntrySize <= n; off += spillEntrySize {
+			eh, size, meta := decodeSpilled(buf[off:], s.id)
+			if eh < h {
+				continue
+			}
+			if eh > h {
+				return -1, Meta{}, nil
+			}
+			pos := block*spillBlockSize + off/spillEntrySize
+			if s.isRemoved(pos) || size != uint32(len(k)) {
+				continue
+			}
+			e := keyEntry{meta: meta, size: size, next: spilledEntry}
+			ek, err := d.key(&e)
+			if err != nil {
+				return -1, Meta{}, err
+			}
+			if ek == k {
+				return pos, meta, nil
+			}
+		}
+	}
+	return -1, Meta{}, nil
+}
+
+// each calls the function with each entry which isn't removed, along with the hash of its key, till it returns false.
+func (s *spilledSegment) each(fn func(h uint64, e *keyEntry) bool) error {
+	r := bufio.NewReader(io.NewSectionReader(s.file, 0, int64(s.count*spillEntrySize)))
+	var buf [spillEntrySize]byte
+	for pos := 0; pos < s.count; pos++ {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return fmt.Errorf("error reading index file of datafile %d: %w", s.id, err)
+		}
+		if s.isRemoved(pos) {
+			continue
+		}
+		h, size, meta := decodeSpilled(buf[:], s.id)
+		if !fn(h, &keyEntry{meta: meta, size: size, next: spilledEntry}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// remove removes the entry at the given position, once the key is deleted or set in memory again.
+func (s *spilledSegment) remove(pos int) {
+	s.removed[pos/64] |= 1 << (pos % 64)
+	s.live--
+}
+
+// isRemoved returns true if the entry at the given position is removed.
+func (s *spilledSegment) isRemoved(pos int) bool {
+	return s.removed[pos/64]&(1<<(pos%64)) != 0
+}
+
+// size returns the memory used by the spilled segment.
+func (s *spilledSegment) size() int {
+	return (cap(s.fences)+cap(s.removed))*int(unsafe.Sizeof(uint64(0))) + s.bloom.size()
+}
+
+// close closes and removes the index file, which isn't used once the keydir is replaced or the datafile is removed.
+func (s *spilledSegment) close() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// encodeSpilled encodes an entry of the index files in the buffer.
+func encodeSpilled(buf []byte, h uint64, size uint32, meta Meta) {
+	binary.LittleEndian.PutUint64(buf[0:8], h)
+	binary.LittleEndian.PutUint32(buf[8:12], size)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(meta.Timestamp))
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(meta.RecordSize))
+	binary.LittleEndian.PutUint64(buf[28:36], uint64(meta.RecordPos))
+	binary.LittleEndian.PutUint64(buf[36:44], uint64(meta.Expiry))
+	binary.LittleEndian.PutUint64(buf[44:52], uint64(meta.Accessed))
+	binary.LittleEndian.PutUint64(buf[52:60], meta.Version)
+}
+
+// decodeSpilled decodes an entry of the index file of the datafile with the given ID from the buffer.
+func decodeSpilled(buf []byte, id int) (uint64, uint32, Meta) {
+	return binary.LittleEndian.Uint64(buf[0:8]), binary.LittleEndian.Uint32(buf[8:12]), Meta{
+		Timestamp:  int(binary.LittleEndian.Uint64(buf[12:20])),
+		RecordSize: int(binary.LittleEndian.Uint64(buf[20:28])),
+		RecordPos:  int(binary.LittleEndian.Uint64(buf[28:36])),
+		FileID:     id,
+		Expiry:     int(binary.LittleEndian.Uint64(buf[36:44])),
+		Accessed:   int(binary.LittleEndian.Uint64(buf[44:52])),
+		Version:    binary.LittleEndian.Uint64(buf[52:60]),
+	}
+}
@@ -0,0 +1,137 @@
+package barrel
+
+import "time"
+
+// Stats represents the runtime statistics of the datastore.
+type Stats struct {
+	Keys      int // Number of keys in the keydir, except the reserved keys.
+	DataFiles int // Number of datafiles including the active datafile.
+
+	KeyMisses   uint64 // Number of lookups for keys which aren't present in the keydir.
+	Oversized   uint64 // Number of writes rejected since the key or the value is too large.
+	KeydirBytes int    // Approximate memory used by the keydir.
+	KeyProbes   uint64 // Number of keys read from the records to verify the lookups, in the compact keydir mode.
+	BloomSkips  uint64 // Number of reads of the keys skipped by the bloom filters, in the compact keydir mode or of the spilled keys.
+	SpilledKeys int    // Number of keys spilled from the keydir to the index files.
+	LiveBytes   int    // Size of the latest records of all the keys.
+	DiskBytes   int    // Size of all the datafiles, including the stale records.
+	EvictedKeys uint64 // Number of keys evicted to stay within the max data size or the quotas.
+	StorageFull bool   // Whether the writes are paused since the disk is (almost) full.
+
+	Quotas        []QuotaUsage // Utilization of the quotas of the namespaces, sorted by their prefixes.
+	QuotaRejected uint64       // Number of writes rejected since they exceed the quota of their namespace.
+
+	CorruptRecords uint64 // Number of corrupt records found while scrubbing.
+	Quarantined    int    // Number of keys quarantined since their latest record is corrupt.
+	Healed         uint64 // Number of keys healed from an older record.
+
+	// Offset of the log, which is the sequence number of the next write. The offsets of the mirror
+	// and the tails are the offsets of the log upto which the writes are sent to them.
+	Offset uint64
+
+	Mirroring     bool          // Whether the writes are mirrored.
+	MirrorQueued  int           // Number of writes waiting to be mirrored.
+	MirrorWrites  uint64        // Number of writes mirrored to the target.
+	MirrorFailed  uint64        // Number of writes which failed on the mirror target.
+	MirrorDropped uint64        // Number of writes not mirrored since the queue was full.
+	MirrorOffset  uint64        // Offset of the log upto which the writes are processed by the mirror.
+	MirrorLag     time.Duration // Time since the write being mirrored was queued, 0 if the mirror is caught up.
+	MirrorFailing bool          // Whether the last write failed on the mirror target.
+
+	ChangeFeed       bool   // Whether the changes are published to a change sink.
+	ChangesPublished uint64 // Number of changes acknowledged by the change sink.
+	ChangesFailed    uint64 // Number of failed attempts to publish the changes.
+	ChangeOffset     uint64 // Offset of the log upto which the changes are acknowledged by the change sink.
+	ChangeFailing    bool   // Whether the last attempt to publish the changes failed.
+
+	Tails      int    // Number of tails following the log.
+	TailOffset uint64 // Lowest offset of the tails, 0 if there are none.
+
+	CacheHits   uint64 // Number of reads served from the value cache.
+	CacheMisses uint64 // Number of reads not found in the value cache.
+	CacheBytes  int    // Size of the records in the value cache.
+
+	Compaction CompactionProgress // Progress of the latest merge of the datafiles.
+
+	ClockSkew  time.Duration // Difference of the system clock from the time used by the barrel, after a jump of the system clock.
+	ClockJumps uint64        // Number of jumps of the system clock larger than the max clock skew, which are ignored.
+	SkewedKeys int           // Number of keys whose expiry had passed as per the newest record on startup.
+}
+
+// Stats returns the runtime statistics of the datastore.
+func (b *Barrel) Stats() Stats {
+	b.Lock()
+	defer b.Unlock()
+
+	stats := Stats{
+		Keys:      b.keydir.keyspaceLen(),
+		DataFiles: len(b.stale) + 1,
+		KeyMisses: b.keyMisses.Load(),
+		Oversized: b.oversized.Load(),
+
+		LiveBytes:   b.liveBytes,
+		DiskBytes:   b.diskBytes,
+		EvictedKeys: b.evicted.Load(),
+		StorageFull: b.storageFull,
+
+		Quotas:        b.quotaUsages(),
+		QuotaRejected: b.quotaRejected.Load(),
+
+		CorruptRecords: b.corrupt.Load(),
+		Quarantined:    len(b.quarantined),
+		Healed:         b.healed.Load(),
+
+		Compaction: b.CompactionProgress(),
+
+		Offset: b.seq,
+		Tails:  len(b.tails),
+	}
+
+	if c, ok := b.opts.clock.(*guardedClock); ok {
+		stats.ClockSkew, stats.ClockJumps = c.status()
+	}
+	stats.SkewedKeys = b.skewedKeys
+
+	stats.KeydirBytes = b.keydir.size()
+	stats.KeyProbes = b.keydir.probes.Load()
+	stats.BloomSkips = b.keydir.skipped.Load()
+	for _, s := range b.keydir.spilled {
+		stats.SpilledKeys += s.live
+	}
+
+	if b.mirror != nil {
+		stats.MirrorQueued = len(b.mirror.queue)
+		stats.MirrorWrites = b.mirror.mirrored.Load()
+		stats.MirrorFailed = b.mirror.failed.Load()
+		stats.MirrorDropped = b.mirror.dropped.Load()
+		stats.Mirroring = true
+		stats.MirrorOffset = b.mirror.offset.Load()
+		stats.MirrorLag = b.mirror.lag(b.now())
+		stats.MirrorFailing = b.mirror.failing.Load()
+	}
+
+	if b.changes != nil {
+		stats.ChangeFeed = true
+		stats.ChangesPublished = b.changes.published.Load()
+		stats.ChangesFailed = b.changes.failed.Load()
+		stats.ChangeOffset = b.changes.offset.Load()
+		stats.ChangeFailing = b.changes.failing.Load()
+	}
+
+	first := true
+	for cursor := range b.tails {
+		if offset := cursor.offset.Load(); first || offset < stats.TailOffset {
+			stats.TailOffset, first = offset, false
+		}
+	}
+
+	if b.cache != nil {
+		b.cache.Lock()
+		stats.CacheHits = b.cache.hits
+		stats.CacheMisses = b.cache.misses
+		stats.CacheBytes = b.cache.size
+		b.cache.Unlock()
+	}
+
+	return stats
+}
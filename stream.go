@@ -0,0 +1,258 @@
+package barrel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// streamKeyPrefix is the prefix of the reserved keys used for storing stream entries.
+	// Each entry is stored as a separate record with the key `<prefix><stream>\x00<id>`.
+	streamKeyPrefix = reservedPrefix + "stream:"
+)
+
+var (
+	// MinStreamID and MaxStreamID are the smallest and largest possible stream IDs.
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+// StreamID uniquely identifies an entry in a stream. Entries in a stream
+// are ordered by their IDs, which are of the form `<ms>-<seq>`.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// StreamEntry represents a single entry in the stream with its field-value pairs.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// ParseStreamID parses the ID in `<ms>-<seq>` form. If the sequence part is omitted,
+// it's set to the given default.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	var (
+		id  StreamID
+		err error
+	)
+
+	ms, seq, found := strings.Cut(s, "-")
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+
+	id.Seq = defaultSeq
+	if found {
+		if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return StreamID{}, ErrInvalidStreamID
+		}
+	}
+
+	return id, nil
+}
+
+// String returns the ID in `<ms>-<seq>` form.
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// Less returns true if the ID is smaller than the given ID.
+func (id StreamID) Less(o StreamID) bool {
+	if id.Ms != o.Ms {
+		return id.Ms < o.Ms
+	}
+	return id.Seq < o.Seq
+}
+
+// Next returns the smallest ID greater than the given ID.
+func (id StreamID) Next() StreamID {
+	if id.Seq == math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}
+	}
+	return StreamID{Ms: id.Ms, Seq: id.Seq + 1}
+}
+
+// StreamAdd appends a new entry with the given field-value pairs to the stream.
+// If the ID is nil, it's generated from the current time. Otherwise the given ID
+// must be greater than the ID of the last entry in the stream.
+func (b *Barrel) StreamAdd(stream string, id *StreamID, fields []string) (StreamID, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return StreamID{}, err
+	}
+
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return StreamID{}, ErrInvalidStreamFields
+	}
+
+	// Get the ID of the last entry, if any.
+	var last StreamID
+	if ids := b.streams[stream]; len(ids) > 0 {
+		last = ids[len(ids)-1]
+	}
+
+	var newID StreamID
+	if id != nil {
+		if !last.Less(*id) {
+			return StreamID{}, ErrSmallStreamID
+		}
+		newID = *id
+	} else {
+		newID = StreamID{Ms: uint64(b.now().UnixMilli())}
+		if !last.Less(newID) {
+			newID = last.Next()
+		}
+	}
+
+	// Store the entry as a record.
+	k, val := streamKey(stream, newID), encodeStreamFields(fields)
+	if err := b.validateKV(k, val); err != nil {
+		return StreamID{}, err
+	}
+
+	b.lo.Debug("adding stream entry", "stream", stream, "id", newID.String())
+	if err := b.put(b.df, k, val, nil, nil); err != nil {
+		return StreamID{}, err
+	}
+
+	b.streams[stream] = append(b.streams[stream], newID)
+
+	return newID, nil
+}
+
+// StreamLen returns the number of entries in the stream.
+func (b *Barrel) StreamLen(stream string) int {
+	b.RLock()
+	defer b.RUnlock()
+
+	return len(b.streams[stream])
+}
+
+// StreamRange returns the entries of the stream with IDs between start and end (both inclusive).
+// If count is greater than 0, at most count entries are returned.
+func (b *Barrel) StreamRange(stream string, start, end StreamID, count int) ([]StreamEntry, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	var (
+		ids = b.streams[stream]
+		// Find the position of the first entry in the range.
+		pos     = sort.Search(len(ids), func(i int) bool { return !ids[i].Less(start) })
+		entries = make([]StreamEntry, 0)
+	)
+
+	for _, id := range ids[pos:] {
+		if end.Less(id) || (count > 0 && len(entries) == count) {
+			break
+		}
+
+		record, err := b.get(streamKey(stream, id))
+		if err != nil {
+			// Skip the entries whose records were deleted.
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if !record.isValidChecksum() {
+			return nil, ErrChecksumMismatch
+		}
+
+		fields, err := decodeStreamFields(record.Value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, StreamEntry{ID: id, Fields: fields})
+	}
+
+	return entries, nil
+}
+
+// StreamLastID returns the ID of the last entry in the stream.
+// If the stream is empty, the smallest possible ID is returned.
+func (b *Barrel) StreamLastID(stream string) StreamID {
+	b.RLock()
+	defer b.RUnlock()
+
+	ids := b.streams[stream]
+	if len(ids) == 0 {
+		return MinStreamID
+	}
+
+	return ids[len(ids)-1]
+}
+
+// loadStreams builds the in-memory index of stream entries from the keydir.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) loadStreams() {
+	b.streams = make(map[string][]StreamID)
+
+	b.keydir.each(func(k string, _ Meta) bool {
+		if stream, id, ok := parseStreamKey(k); ok {
+			b.streams[stream] = append(b.streams[stream], id)
+		}
+		return true
+	})
+
+	// Sort the entries of each stream by their IDs.
+	for _, ids := range b.streams {
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	}
+}
+
+// streamKey returns the key of the record used for storing the stream entry.
+func streamKey(stream string, id StreamID) string {
+	return streamKeyPrefix + stream + "\x00" + id.String()
+}
+
+// parseStreamKey returns the stream and the ID of the entry from the given key.
+func parseStreamKey(k string) (string, StreamID, bool) {
+	if !strings.HasPrefix(k, streamKeyPrefix) {
+		return "", StreamID{}, false
+	}
+
+	pos := strings.LastIndexByte(k, 0)
+	if pos < len(streamKeyPrefix) {
+		return "", StreamID{}, false
+	}
+
+	id, err := ParseStreamID(k[pos+1:], 0)
+	if err != nil {
+		return "", StreamID{}, false
+	}
+
+	return k[len(streamKeyPrefix):pos], id, true
+}
+
+// encodeStreamFields encodes the field-value pairs as a list of length prefixed strings.
+func encodeStreamFields(fields []string) []byte {
+	buf := make([]byte, 0)
+	for _, f := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+// decodeStreamFields decodes the list of length prefixed strings.
+func decodeStreamFields(data []byte) ([]string, error) {
+	fields := make([]string, 0)
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, fmt.Errorf("error decoding stream entry: invalid field size")
+		}
+		fields = append(fields, string(data[n:n+int(size)]))
+		data = data[n+int(size):]
+	}
+	return fields, nil
+}
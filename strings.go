@@ -0,0 +1,77 @@
+package barrel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SetRange overwrites the value of the key starting at the given offset with val, like `SETRANGE` of Redis,
+// and returns the length of the new value. The value is padded with zero bytes if it's shorter than the offset,
+// and an empty value is used if the key doesn't exist. The expiry, the metadata and the tags of the key are retained.
+// If val is empty, the key isn't modified and the length of its current value is returned.
+func (b *Barrel) SetRange(k string, offset int, val []byte) (n int, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+	if len(val) == 0 {
+		record, err := b.getRecord(k)
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return len(record.Value), err
+	}
+	// Compare against the space left after the value, since the end of the range may overflow.
+	if offset > b.opts.maxValueSize-len(val) {
+		b.oversized.Add(1)
+		return 0, fmt.Errorf("%w: %d bytes at offset %d", ErrLargeValue, len(val), offset)
+	}
+
+	b.lo.Debug("setting range", "key", k, "offset", offset, "val", val)
+	updated, err := b.modify(k, func(old []byte) []byte {
+		size := len(old)
+		if end := offset + len(val); end > size {
+			size = end
+		}
+		// Copy the old value since it may point to the cache or the mapped memory of the datafile.
+		updated := make([]byte, size)
+		copy(updated, old)
+		copy(updated[offset:], val)
+		return updated
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(updated), nil
+}
+
+// modify replaces the value of the key with the one returned by fn for its current value,
+// which is nil if the key doesn't exist. The expiry, the metadata and the tags of the key are retained.
+// It returns the new value.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) modify(k string, fn func(old []byte) []byte) ([]byte, error) {
+	if err := b.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	record, err := b.getRecord(k)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	val := fn(record.Value)
+	if err := b.validateKV(k, val); err != nil {
+		return nil, err
+	}
+	if err := b.hookedPut(k, val, record.rawMeta, record.Header.expiry()); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
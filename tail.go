@@ -0,0 +1,175 @@
+package barrel
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
+)
+
+// tailBatch is the max number of records read by a tail at once, while the datafile is kept from being closed.
+const tailBatch = 256
+
+// Tail replays the records in the log starting from the given sequence number
+// and then follows new appends, sending each record on the returned channel.
+// The records are numbered by the sequence numbers of their writes, which are persisted across restarts
+// and set as the Seq of the records sent, so a consumer resumes from the one after the last record it has
+// processed. Deletes are sent as tombstone records with an empty value.
+// A merge only retains the latest records of the keys, without their sequence numbers. So the merged records
+// are numbered by the start of the range of the writes they replace, and they're all replayed if the tail
+// is resumed from within that range, which may send them again but never skips them.
+// Since a merge rewrites the log, the channel is closed if the datafile being read
+// is merged. It's also closed when the given context is cancelled.
+func (b *Barrel) Tail(ctx context.Context, fromSeq uint64) <-chan Record {
+	ch := make(chan Record)
+	go b.tail(ctx, fromSeq, ch)
+	return ch
+}
+
+// tailCursor is the position of a tail in the log.
+type tailCursor struct {
+	offset atomic.Uint64 // Offset of the log upto which the records are sent.
+}
+
+// tail reads the records from the datafiles and sends them on the given channel.
+func (b *Barrel) tail(ctx context.Context, fromSeq uint64, ch chan<- Record) {
+	defer close(ch)
+
+	cursor := &tailCursor{}
+	cursor.offset.Store(fromSeq)
+	b.Lock()
+	b.tails[cursor] = struct{}{}
+	b.Unlock()
+	defer func() {
+		b.Lock()
+		delete(b.tails, cursor)
+		b.Unlock()
+	}()
+
+	var (
+		df     datafile.Storage
+		offset int
+		n      uint64 // Number of records read from the datafile.
+		batch  = make([]Record, 0, tailBatch)
+	)
+
+	for ctx.Err() == nil {
+		b.Lock()
+		if df == nil {
+			df = b.nextDF(-1)
+			offset, n = df.HeaderSize(), 0
+		}
+
+		// Stop if the datafile was removed by a merge.
+		if df != b.df && b.stale[df.ID()] != df {
+			b.Unlock()
+			b.lo.Debug("stopping tail since the datafile is no longer available", "id", df.ID())
+			return
+		}
+
+		// Only read the records which are completely written.
+		end, err := df.Size()
+		if err != nil {
+			b.Unlock()
+			b.lo.Error("error fetching datafile size", "id", df.ID(), "error", err)
+			return
+		}
+
+		var (
+			next      datafile.Storage
+			appended  chan struct{}
+			r, known  = b.seqRanges[df.ID()]
+			preceding = (known && r.end <= fromSeq) || (!known && fromSeq > 0)
+		)
+		switch {
+		case offset >= int(end) && df == b.df:
+			// Wait for new appends on the active file.
+			if b.appended == nil {
+				b.appended = make(chan struct{})
+			}
+			appended = b.appended
+		case offset >= int(end) || (df != b.df && preceding):
+			// Skip the older datafiles whose records precede the sequence number without reading them.
+			next = b.nextDF(df.ID())
+		}
+		if next != nil || appended != nil {
+			b.Unlock()
+			switch {
+			case next != nil:
+				df, offset, n = next, next.HeaderSize(), 0
+			case appended != nil:
+				select {
+				case <-appended:
+				case <-ctx.Done():
+				}
+			}
+			continue
+		}
+
+		// Read a batch of the records upto the end of the file. The datafile isn't closed
+		// by a merge meanwhile, but it's released before sending the records.
+		b.readers.RLock()
+		b.Unlock()
+		batch = batch[:0]
+		for offset < int(end) && len(batch) < tailBatch {
+			record, size, err := readRecord(df, offset)
+			if err != nil {
+				b.readers.RUnlock()
+				b.lo.Error("error reading record", "id", df.ID(), "offset", offset, "error", err)
+				return
+			}
+			offset += size
+
+			seq, ok := r.tailSeq(known, n, fromSeq)
+			n++
+			if ok {
+				record.Seq = seq
+				batch = append(batch, record)
+			}
+		}
+		b.readers.RUnlock()
+
+		for _, record := range batch {
+			select {
+			case ch <- record:
+			case <-ctx.Done():
+				return
+			}
+			if record.Seq >= cursor.offset.Load() {
+				cursor.offset.Store(record.Seq + 1)
+			}
+		}
+	}
+}
+
+// tailSeq returns the sequence number of the n-th record of the datafile with the range, and whether
+// it's sent by a tail from the given sequence number. The records rewritten by a merge are numbered by
+// the start of the range they replace, and the records of a datafile written before the sequence numbers
+// were known are numbered 0.
+func (r seqRange) tailSeq(known bool, n uint64, fromSeq uint64) (uint64, bool) {
+	switch {
+	case !known:
+		return 0, fromSeq == 0
+	case n < r.merged:
+		return r.first, fromSeq < r.base
+	default:
+		seq := r.base + n - r.merged
+		return seq, seq >= fromSeq
+	}
+}
+
+// nextDF returns the datafile with the lowest ID greater than the given ID.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) nextDF(id int) datafile.Storage {
+	next := b.df
+	for idx, df := range b.stale {
+		if idx > id && idx < next.ID() {
+			next = df
+		}
+	}
+	if next.ID() <= id {
+		return nil
+	}
+
+	return next
+}
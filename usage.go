@@ -0,0 +1,73 @@
+package barrel
+
+// segmentUsage is the size of the records in a datafile, and of the latest records of the keys among them.
+// The rest of the records are dead, since they're overwritten, deleted or quarantined.
+type segmentUsage struct {
+	live int
+	disk int
+}
+
+// dead returns the size of the records which aren't the latest records of their keys.
+func (u *segmentUsage) dead() int {
+	return u.disk - u.live
+}
+
+// deadRatio returns the fraction of the records in the datafile which are dead.
+func (u *segmentUsage) deadRatio() float64 {
+	if u.disk <= 0 {
+		return 0
+	}
+	return float64(u.dead()) / float64(u.disk)
+}
+
+// segmentUsage returns the usage of the datafile with the given ID, which is added if it isn't tracked yet.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) segmentUsage(id int) *segmentUsage {
+	u, ok := b.usage[id]
+	if !ok {
+		u = &segmentUsage{}
+		b.usage[id] = u
+	}
+	return u
+}
+
+// loadUsage computes the usage of all the datafiles from their sizes and the keydir.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) loadUsage() error {
+	b.usage = make(map[int]*segmentUsage, len(b.stale)+1)
+	for _, df := range b.dataFiles() {
+		size, err := df.Size()
+		if err != nil {
+			return err
+		}
+		b.segmentUsage(df.ID()).disk = int(size) - df.HeaderSize()
+	}
+	b.keydir.each(func(_ string, meta Meta) bool {
+		b.segmentUsage(meta.FileID).live += meta.RecordSize
+		return true
+	})
+	return nil
+}
+
+// garbageDue returns true if any of the older datafiles has enough dead records to be merged,
+// as set by the min dead ratio of the compaction.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) garbageDue() bool {
+	for id := range b.stale {
+		if u, ok := b.usage[id]; ok && u.deadRatio() >= b.opts.compactDeadRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// rotateEarly returns true if the active datafile of the given size is at least half the max size
+// and has enough dead records, as set by the min dead ratio of the compaction.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) rotateEarly(size int64) bool {
+	if b.opts.compactDeadRatio == 0 || size < b.opts.maxActiveFileSize/2 {
+		return false
+	}
+	u, ok := b.usage[b.df.ID()]
+	return ok && u.deadRatio() >= b.opts.compactDeadRatio
+}
@@ -7,6 +7,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/deepgolani4/LogVaultDB/internal/datafile/datafile"
 )
 
 // Exists returns true if the given path exists on the filesystem.
@@ -17,6 +19,11 @@ func exists(path string) bool {
 	return true
 }
 
+// syncDir calls fsync(2) on the directory, so that the files created, renamed or removed in it are durable.
+func syncDir(dir string) error {
+	return datafile.SyncDir(dir)
+}
+
 // getDataFiles returns the list of db files in a given directory.
 func getDataFiles(dir string) ([]string, error) {
 	files, err := filepath.Glob(fmt.Sprintf("%s/*.db", dir))
@@ -44,19 +51,54 @@ func getIDs(files []string) ([]int, error) {
 	return ids, nil
 }
 
+// listIDs returns the sorted list of IDs of the db files in the given directory.
+func listIDs(dir string) ([]int, error) {
+	files, err := getDataFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading data files: %w", err)
+	}
+	ids, err := getIDs(files)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ids for existing files: %w", err)
+	}
+	return ids, nil
+}
+
+// sortedIDs returns the IDs of the given datafiles in increasing order.
+func sortedIDs(dfs map[int]datafile.Storage) []int {
+	ids := make([]int, 0, len(dfs))
+	for id := range dfs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
 // validateKV validates key/value before inserting.
-func validateKV(k string, val []byte) error {
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) validateKV(k string, val []byte) error {
 	if len(k) == 0 {
 		return ErrEmptyKey
 	}
 
-	if len(k) > MaxKeySize {
-		return ErrLargeKey
+	if len(k) > b.opts.maxKeySize {
+		b.oversized.Add(1)
+		return fmt.Errorf("%w: %d bytes", ErrLargeKey, len(k))
 	}
 
-	if len(val) > MaxValueSize {
-		return ErrLargeValue
+	if len(val) > b.opts.maxValueSize {
+		b.oversized.Add(1)
+		return fmt.Errorf("%w: %d bytes", ErrLargeValue, len(val))
 	}
 
 	return nil
 }
+
+// memDir returns the directory on tmpfs for the in-memory mode, or the default directory
+// for temporary files if there's none.
+func memDir() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
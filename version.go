@@ -0,0 +1,87 @@
+package barrel
+
+// Version identifies a write of a key. It changes on every write of the key, so a reader can check
+// whether the key has changed since it was last read. Versions are opaque and only comparable for
+// equality, for the same key of the same barrel. The versions of the keys not written since the barrel
+// is opened (or reloaded) are reset, so such keys appear changed once to the readers holding the
+// versions from before.
+type Version uint64
+
+// version returns the version of the key with the given metadata.
+// Caller of this function should ensure to lock/unlock the barrel.
+func (b *Barrel) version(meta Meta) Version {
+	if meta.Version == 0 {
+		return Version(b.versionBase)
+	}
+	return Version(meta.Version)
+}
+
+// PutVersioned is same as Put but also returns the version of the key written.
+func (b *Barrel) PutVersioned(k string, val []byte) (v Version, err error) {
+	// Wait for the write to be flushed to disk once the lock is released.
+	defer b.waitForSync(&err)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err := b.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Validate key and value.
+	if err = b.validateKV(k, val); err != nil {
+		return 0, err
+	}
+
+	b.lo.Debug("storing data", "key", k, "val", val)
+	if err = b.hookedPut(k, val, nil, nil); err != nil {
+		return 0, err
+	}
+	return b.version(b.keydir.meta(k)), nil
+}
+
+// GetVersioned is same as Get but also returns the version of the key.
+func (b *Barrel) GetVersioned(k string) ([]byte, Version, error) {
+	b.RLock()
+	v := b.version(b.keydir.meta(k))
+	b.RUnlock()
+
+	// Look up the version before reading the record, so that a write in the meantime
+	// makes the version stale instead of the value.
+	val, err := b.Get(k)
+	if err != nil {
+		return nil, 0, err
+	}
+	return val, v, nil
+}
+
+// GetIfModified returns the value and the version of the key, unless the given version is still
+// the current one, in which case it returns ErrNotModified without reading the record. It saves
+// reading and transferring large values which are polled frequently.
+func (b *Barrel) GetIfModified(k string, v Version) ([]byte, Version, error) {
+	b.RLock()
+	meta, ok := b.keydir.get(k)
+	current := ok && b.version(meta) == v && (meta.Expiry == 0 || int64(meta.Expiry) >= b.now().Unix())
+	b.RUnlock()
+	if current {
+		return nil, v, ErrNotModified
+	}
+
+	return b.GetVersioned(k)
+}
+
+// KeyVersion returns the version of the key without reading its value, e.g. to detect a change of
+// the key between two points in time. It returns ErrKeyNotFound if the key is missing or expired.
+func (b *Barrel) KeyVersion(k string) (Version, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	meta, ok, err := b.keydir.lookup(k)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || (meta.Expiry != 0 && int64(meta.Expiry) < b.now().Unix()) {
+		return 0, ErrKeyNotFound
+	}
+	return b.version(meta), nil
+}